@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"llm-fw/types"
+)
+
+const (
+	// keyPrefix 是所有 API 密钥明文的前缀
+	keyPrefix = "sk-llmfw-"
+	// displayPrefixLen 是保存用于识别密钥的明文前缀长度
+	displayPrefixLen = len(keyPrefix) + 6
+	// lastUsedPersistInterval 是最后使用时间写回存储的最小间隔
+	lastUsedPersistInterval = time.Minute
+)
+
+var (
+	// ErrInvalidKey 表示密钥不存在
+	ErrInvalidKey = errors.New("invalid api key")
+	// ErrRevokedKey 表示密钥已被吊销
+	ErrRevokedKey = errors.New("api key has been revoked")
+	// ErrKeyNotFound 表示按ID找不到密钥
+	ErrKeyNotFound = errors.New("api key not found")
+)
+
+// KeyStore 管理 API 密钥，密钥持久化在存储中，内存中按哈希索引
+type KeyStore struct {
+	storage   types.Storage
+	mu        sync.RWMutex
+	byHash    map[string]*types.APIKey
+	byID      map[string]*types.APIKey
+	persisted map[string]time.Time // 每个密钥最后一次写回 last_used_at 的时间
+}
+
+// NewKeyStore 创建一个新的密钥存储并从存储中加载已有密钥
+func NewKeyStore(storage types.Storage) (*KeyStore, error) {
+	ks := &KeyStore{
+		storage:   storage,
+		byHash:    make(map[string]*types.APIKey),
+		byID:      make(map[string]*types.APIKey),
+		persisted: make(map[string]time.Time),
+	}
+
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Reload 从存储中重新加载所有密钥，使其他实例或直接写入存储的新增、吊销和轮换生效
+func (ks *KeyStore) Reload() error {
+	reload, err := ks.PrepareReload()
+	if err != nil {
+		return err
+	}
+	reload.Apply()
+	return nil
+}
+
+// KeyReload 是从存储中读取、尚未生效的密钥
+type KeyReload struct {
+	store  *KeyStore
+	byHash map[string]*types.APIKey
+	byID   map[string]*types.APIKey
+}
+
+// PrepareReload 从存储中读取所有密钥，不修改内存中的密钥。调用 Apply 之后读取的密钥才生效
+func (ks *KeyStore) PrepareReload() (*KeyReload, error) {
+	keys, err := ks.storage.ListAPIKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load api keys: %v", err)
+	}
+
+	reload := &KeyReload{
+		store:  ks,
+		byHash: make(map[string]*types.APIKey, len(keys)),
+		byID:   make(map[string]*types.APIKey, len(keys)),
+	}
+	for _, key := range keys {
+		reload.byHash[key.KeyHash] = key
+		reload.byID[key.ID] = key
+	}
+	return reload, nil
+}
+
+// Apply 用读取的密钥替换内存中的密钥
+func (r *KeyReload) Apply() {
+	ks := r.store
+	ks.mu.Lock()
+	ks.byHash, ks.byID = r.byHash, r.byID
+	ks.mu.Unlock()
+}
+
+// HashKey 计算密钥明文的哈希值
+func HashKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateKey 生成一个新的随机密钥明文
+func generateKey() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return keyPrefix + hex.EncodeToString(buf), nil
+}
+
+// Authenticate 校验密钥明文并返回对应的密钥
+func (ks *KeyStore) Authenticate(plaintext string) (*types.APIKey, error) {
+	hash := HashKey(plaintext)
+
+	ks.mu.Lock()
+	key, exists := ks.byHash[hash]
+	if !exists {
+		ks.mu.Unlock()
+		return nil, ErrInvalidKey
+	}
+	if key.Revoked() {
+		ks.mu.Unlock()
+		return nil, ErrRevokedKey
+	}
+
+	now := time.Now()
+	key.LastUsedAt = &now
+	persist := now.Sub(ks.persisted[key.ID]) >= lastUsedPersistInterval
+	if persist {
+		ks.persisted[key.ID] = now
+	}
+	keyCopy := *key
+	ks.mu.Unlock()
+
+	// 最后使用时间只是展示用途，按间隔异步写回。只更新 last_used_at，
+	// 不会覆盖在此期间写入的吊销或轮换
+	if persist {
+		go func() {
+			if err := ks.storage.UpdateAPIKeyLastUsed(keyCopy.ID, now); err != nil {
+				log.Printf("Failed to update api key last used time: %v", err)
+			}
+		}()
+	}
+	return &keyCopy, nil
+}
+
+// Create 创建一个新的密钥，返回仅此一次可见的明文。priority 为空时按请求来源确定优先级
+func (ks *KeyStore) Create(name, userID, team string, admin bool, priority string) (string, *types.APIKey, error) {
+	plaintext, err := generateKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := &types.APIKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		UserID:    userID,
+		Team:      team,
+		Prefix:    plaintext[:displayPrefixLen],
+		KeyHash:   HashKey(plaintext),
+		Admin:     admin,
+		Priority:  priority,
+		CreatedAt: time.Now(),
+	}
+	if err := ks.storage.SaveAPIKey(key); err != nil {
+		return "", nil, err
+	}
+
+	ks.mu.Lock()
+	ks.byHash[key.KeyHash] = key
+	ks.byID[key.ID] = key
+	ks.mu.Unlock()
+
+	keyCopy := *key
+	return plaintext, &keyCopy, nil
+}
+
+// List 返回所有密钥
+func (ks *KeyStore) List() []*types.APIKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	keys := make([]*types.APIKey, 0, len(ks.byID))
+	for _, key := range ks.byID {
+		keyCopy := *key
+		keys = append(keys, &keyCopy)
+	}
+	return keys
+}
+
+// Get 根据ID返回密钥
+func (ks *KeyStore) Get(id string) (*types.APIKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, exists := ks.byID[id]
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+	keyCopy := *key
+	return &keyCopy, nil
+}
+
+// Revoke 吊销密钥，吊销后的密钥保留在列表中但无法再使用
+func (ks *KeyStore) Revoke(id string) (*types.APIKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, exists := ks.byID[id]
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+	if key.Revoked() {
+		keyCopy := *key
+		return &keyCopy, nil
+	}
+
+	updated := *key
+	now := time.Now()
+	updated.RevokedAt = &now
+	if err := ks.storage.SaveAPIKey(&updated); err != nil {
+		return nil, err
+	}
+	*key = updated
+
+	keyCopy := *key
+	return &keyCopy, nil
+}
+
+// Rotate 为密钥生成新的明文，旧明文立即失效，身份信息保持不变
+func (ks *KeyStore) Rotate(id string) (string, *types.APIKey, error) {
+	plaintext, err := generateKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, exists := ks.byID[id]
+	if !exists {
+		return "", nil, ErrKeyNotFound
+	}
+	if key.Revoked() {
+		return "", nil, ErrRevokedKey
+	}
+
+	updated := *key
+	updated.Prefix = plaintext[:displayPrefixLen]
+	updated.KeyHash = HashKey(plaintext)
+	if err := ks.storage.SaveAPIKey(&updated); err != nil {
+		return "", nil, err
+	}
+
+	delete(ks.byHash, key.KeyHash)
+	*key = updated
+	ks.byHash[key.KeyHash] = key
+
+	keyCopy := *key
+	return plaintext, &keyCopy, nil
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"llm-fw/storage"
+	"llm-fw/types"
+)
+
+// blockingStorage 在写回最后使用时间前等待 release，用于在写回进行中时修改密钥
+type blockingStorage struct {
+	types.Storage
+	started chan struct{}
+	release chan struct{}
+	done    chan struct{}
+}
+
+func (s *blockingStorage) UpdateAPIKeyLastUsed(id string, lastUsedAt time.Time) error {
+	close(s.started)
+	<-s.release
+	defer close(s.done)
+	return s.Storage.UpdateAPIKeyLastUsed(id, lastUsedAt)
+}
+
+// newBlockingKeyStore 创建使用文件存储的密钥存储，最后使用时间的写回会阻塞
+func newBlockingKeyStore(t *testing.T) (*KeyStore, *blockingStorage) {
+	t.Helper()
+	fs, err := storage.NewFileStorageImpl(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Close() })
+
+	s := &blockingStorage{Storage: fs, started: make(chan struct{}), release: make(chan struct{}), done: make(chan struct{})}
+	ks, err := NewKeyStore(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks, s
+}
+
+// waitClosed 等待 ch 被关闭
+func waitClosed(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func TestRevokeDuringLastUsedPersist(t *testing.T) {
+	ks, s := newBlockingKeyStore(t)
+	plaintext, key, err := ks.Create("ci", "alice", "", false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ks.Authenticate(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	waitClosed(t, s.started, "the last used time persist")
+
+	// 写回进行中时吊销密钥，写回完成后重新加载，密钥仍然是吊销状态
+	if _, err := ks.Revoke(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	close(s.release)
+	waitClosed(t, s.done, "the last used time persist to finish")
+
+	if err := ks.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Authenticate(plaintext); err != ErrRevokedKey {
+		t.Fatalf("revoked key authenticated after reload: %v", err)
+	}
+	reloaded, err := ks.Get(key.ID)
+	if err != nil || reloaded.RevokedAt == nil || reloaded.LastUsedAt == nil {
+		t.Fatalf("unexpected key after reload: %+v, %v", reloaded, err)
+	}
+}
+
+func TestRotateDuringLastUsedPersist(t *testing.T) {
+	ks, s := newBlockingKeyStore(t)
+	old, key, err := ks.Create("ci", "alice", "", false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ks.Authenticate(old); err != nil {
+		t.Fatal(err)
+	}
+	waitClosed(t, s.started, "the last used time persist")
+
+	rotated, _, err := ks.Rotate(key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(s.release)
+	waitClosed(t, s.done, "the last used time persist to finish")
+
+	// 重新加载后只有新的明文有效
+	if err := ks.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Authenticate(old); err != ErrInvalidKey {
+		t.Fatalf("old key after rotation and reload: %v", err)
+	}
+	if got, err := ks.Authenticate(rotated); err != nil || got.ID != key.ID {
+		t.Fatalf("rotated key after reload: %+v, %v", got, err)
+	}
+}
+
+func TestAuthenticateThrottlesLastUsedPersist(t *testing.T) {
+	ks, s := newBlockingKeyStore(t)
+	plaintext, _, err := ks.Create("ci", "alice", "", false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(s.release)
+
+	// 间隔内多次使用只写回一次，再次写回会因为重复关闭 started 而 panic
+	for i := 0; i < 3; i++ {
+		if _, err := ks.Authenticate(plaintext); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitClosed(t, s.done, "the last used time persist to finish")
+}
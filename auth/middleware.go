@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/types"
+)
+
+// AnonymousIdentity 是未携带密钥的请求在鉴权关闭时使用的身份
+const AnonymousIdentity = "anonymous"
+
+const (
+	contextKeyIdentity = "auth.identity"
+	contextKeyAPIKey   = "auth.api_key"
+)
+
+// extractKey 从 Authorization: Bearer 或 x-api-key（Anthropic SDK 使用）请求头中取出密钥
+func extractKey(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); header != "" {
+		if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+			return strings.TrimSpace(header[7:])
+		}
+	}
+	return strings.TrimSpace(c.GetHeader("X-Api-Key"))
+}
+
+// Policy 保存鉴权配置，重新加载配置时整体替换
+type Policy struct {
+	mu       sync.RWMutex
+	required bool
+	adminKey string
+}
+
+// NewPolicy 创建鉴权配置，required 为 true 时所有模型调用都必须携带 API 密钥
+func NewPolicy(required bool, adminKey string) *Policy {
+	return &Policy{required: required, adminKey: adminKey}
+}
+
+// Set 替换鉴权配置
+func (p *Policy) Set(required bool, adminKey string) {
+	p.mu.Lock()
+	p.required, p.adminKey = required, adminKey
+	p.mu.Unlock()
+}
+
+// Required 返回模型调用是否必须携带 API 密钥
+func (p *Policy) Required() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.required
+}
+
+// AdminKey 返回配置中的管理员密钥
+func (p *Policy) AdminKey() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.adminKey
+}
+
+// Middleware 校验请求携带的 API 密钥并把身份写入上下文
+// 配置不要求密钥时允许不带密钥的请求以匿名身份通过，但携带的密钥仍必须有效
+func Middleware(ks *KeyStore, policy *Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		plaintext := extractKey(c)
+		if plaintext == "" {
+			if policy.Required() {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing API key"})
+				return
+			}
+			c.Set(contextKeyIdentity, AnonymousIdentity)
+			c.Next()
+			return
+		}
+
+		key, err := ks.Authenticate(plaintext)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Set(contextKeyAPIKey, key)
+		c.Set(contextKeyIdentity, key.Identity())
+		c.Next()
+	}
+}
+
+// RequireAdmin 要求请求携带配置中的管理员密钥或带有 admin 标记的 API 密钥
+func RequireAdmin(ks *KeyStore, policy *Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		plaintext := extractKey(c)
+		if plaintext == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing API key"})
+			return
+		}
+
+		if adminKey := policy.AdminKey(); adminKey != "" && subtle.ConstantTimeCompare([]byte(plaintext), []byte(adminKey)) == 1 {
+			c.Set(contextKeyIdentity, "admin")
+			c.Next()
+			return
+		}
+
+		key, err := ks.Authenticate(plaintext)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if !key.Admin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin privileges required"})
+			return
+		}
+
+		c.Set(contextKeyAPIKey, key)
+		c.Set(contextKeyIdentity, key.Identity())
+		c.Next()
+	}
+}
+
+// Identity 返回中间件解析出的请求身份，未经过中间件时返回匿名身份
+func Identity(c *gin.Context) string {
+	if identity := c.GetString(contextKeyIdentity); identity != "" {
+		return identity
+	}
+	return AnonymousIdentity
+}
+
+// APIKeyFromContext 返回请求使用的 API 密钥，匿名请求返回 nil
+func APIKeyFromContext(c *gin.Context) *types.APIKey {
+	if value, exists := c.Get(contextKeyAPIKey); exists {
+		if key, ok := value.(*types.APIKey); ok {
+			return key
+		}
+	}
+	return nil
+}
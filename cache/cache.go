@@ -0,0 +1,248 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"llm-fw/config"
+)
+
+const (
+	// defaultTTL 是未配置时缓存条目的有效期
+	defaultTTL = time.Hour
+	// defaultMaxEntries 是未配置时内存缓存最多保存的条目数
+	defaultMaxEntries = 1000
+	// purgeInterval 是清理 SQLite 中过期条目的间隔
+	purgeInterval = time.Hour
+	// replayChunkRunes 是把缓存结果按流式响应返回时每段的最大字符数
+	replayChunkRunes = 16
+)
+
+// 命中缓存的层级
+const (
+	TierMemory = "memory"
+	TierSQLite = "sqlite"
+)
+
+// 缓存查询结果，用于指标和 X-Cache 响应头
+const (
+	ResultHit    = "hit"
+	ResultMiss   = "miss"
+	ResultBypass = "bypass"
+)
+
+// Entry 是一条缓存的模型响应
+type Entry struct {
+	Model            string    `json:"model"` // 生成该响应的模型，降级时与请求的模型不同
+	Response         string    `json:"response"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	DoneReason       string    `json:"done_reason,omitempty"`
+	RequestID        string    `json:"request_id,omitempty"` // 生成该响应的请求 ID
+	CreatedAt        time.Time `json:"created_at"`
+	ExpiresAt        time.Time `json:"expires_at"`
+}
+
+// Cache 是确定性请求的响应缓存，先查内存 LRU，未命中时再查 SQLite。
+// 启用语义缓存后，精确匹配未命中或不确定的请求还可以按提示词向量的相似度命中
+type Cache struct {
+	ttl      time.Duration
+	memory   *lru
+	sqlite   *sqliteTier    // 未配置路径时为空
+	semantic *semanticIndex // 未启用语义缓存时为空
+
+	done chan struct{}
+	stop sync.Once
+}
+
+// New 创建一个新的响应缓存，path 为空时只使用内存缓存
+func New(ttl time.Duration, maxEntries int, path string) (*Cache, error) {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
+
+	c := &Cache{
+		ttl:    ttl,
+		memory: newLRU(maxEntries),
+		done:   make(chan struct{}),
+	}
+	if path != "" {
+		tier, err := openSQLite(path)
+		if err != nil {
+			return nil, err
+		}
+		c.sqlite = tier
+	}
+	return c, nil
+}
+
+// TTL 返回缓存条目的有效期
+func (c *Cache) TTL() time.Duration {
+	return c.ttl
+}
+
+// Key 根据请求类型、模型、输入和生成参数计算缓存键。
+// 输入和参数按 JSON 编码，map 的键有序，相同的请求总是得到相同的键
+func Key(kind, model string, input interface{}, options map[string]interface{}) string {
+	data, _ := json.Marshal(struct {
+		Kind    string                 `json:"kind"`
+		Model   string                 `json:"model"`
+		Input   interface{}            `json:"input"`
+		Options map[string]interface{} `json:"options"`
+	}{kind, model, input, options})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Get 查找未过期的缓存条目，返回命中的层级。SQLite 中命中的条目会放入内存缓存
+func (c *Cache) Get(key string) (*Entry, string, bool) {
+	now := time.Now()
+	if entry, ok := c.memory.get(key, now); ok {
+		return entry, TierMemory, true
+	}
+	if c.sqlite == nil {
+		return nil, "", false
+	}
+
+	entry, ok, err := c.sqlite.get(key, now)
+	if err != nil {
+		log.Printf("Failed to read response cache: %v", err)
+		return nil, "", false
+	}
+	if !ok {
+		return nil, "", false
+	}
+	c.memory.set(key, entry)
+	return entry, TierSQLite, true
+}
+
+// Set 保存一条响应，有效期从现在开始计算
+func (c *Cache) Set(key string, entry *Entry) {
+	c.stamp(entry)
+	c.memory.set(key, entry)
+	if c.sqlite != nil {
+		if err := c.sqlite.set(key, entry); err != nil {
+			log.Printf("Failed to write response cache: %v", err)
+		}
+	}
+}
+
+// EnableSemantic 启用语义缓存，配置了 SQLite 时载入其中未过期的向量
+func (c *Cache) EnableSemantic(cfg config.SemanticCache) error {
+	index := newSemanticIndex(cfg)
+	if c.sqlite != nil {
+		if err := c.sqlite.loadSemantic(time.Now(), index.capacity, index.add); err != nil {
+			return fmt.Errorf("failed to load semantic cache: %v", err)
+		}
+	}
+	c.semantic = index
+	return nil
+}
+
+// SemanticModel 返回语义缓存使用的嵌入模型，未启用语义缓存时为空
+func (c *Cache) SemanticModel() string {
+	if c.semantic == nil {
+		return ""
+	}
+	return c.semantic.model
+}
+
+// SemanticEnabled 判断模型的请求是否使用语义缓存，未启用语义缓存或模型不在配置的模型列表中时为 false
+func (c *Cache) SemanticEnabled(model string) bool {
+	return c.semantic != nil && c.semantic.enabledFor(model)
+}
+
+// Match 在语义缓存中查找与 vector 最相似的响应，返回最高的相似度。
+// scope 标识必须完全相同的请求部分，相似度低于 model 的阈值时视为未命中
+func (c *Cache) Match(model, scope string, vector []float64) (*Entry, float64, bool) {
+	if c.semantic == nil {
+		return nil, 0, false
+	}
+	return c.semantic.match(model, scope, vector, time.Now())
+}
+
+// SetSemantic 以提示词的向量保存一条响应，有效期与精确匹配的条目相同
+func (c *Cache) SetSemantic(scope string, vector []float64, entry *Entry) {
+	if c.semantic == nil {
+		return
+	}
+	if entry.ExpiresAt.IsZero() {
+		c.stamp(entry)
+	}
+	c.semantic.add(scope, vector, entry)
+	if c.sqlite != nil {
+		if err := c.sqlite.setSemantic(scope, vector, entry); err != nil {
+			log.Printf("Failed to write semantic cache: %v", err)
+		}
+	}
+}
+
+// stamp 设置条目的写入时间和过期时间
+func (c *Cache) stamp(entry *Entry) {
+	entry.CreatedAt = time.Now()
+	entry.ExpiresAt = entry.CreatedAt.Add(c.ttl)
+}
+
+// Start 启动后台任务，定期清理 SQLite 中的过期条目
+func (c *Cache) Start() {
+	if c.sqlite == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			if n, err := c.sqlite.purge(time.Now()); err != nil {
+				log.Printf("Failed to purge response cache: %v", err)
+			} else if n > 0 {
+				log.Printf("Purged %d expired response cache entries", n)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止后台任务
+func (c *Cache) Stop() {
+	c.stop.Do(func() { close(c.done) })
+}
+
+// Close 停止后台任务并关闭 SQLite 缓存
+func (c *Cache) Close() error {
+	c.Stop()
+	if c.sqlite != nil {
+		return c.sqlite.close()
+	}
+	return nil
+}
+
+// Chunks 把缓存的响应切分为多段，用于按流式响应回放
+func Chunks(text string) []string {
+	var chunks []string
+	for len(text) > 0 {
+		end, runes := 0, 0
+		for end < len(text) && runes < replayChunkRunes {
+			_, size := utf8.DecodeRuneInString(text[end:])
+			end += size
+			runes++
+		}
+		chunks = append(chunks, text[:end])
+		text = text[end:]
+	}
+	return chunks
+}
@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCache 创建使用临时 SQLite 文件的缓存
+func newTestCache(t *testing.T, ttl time.Duration, maxEntries int, path string) *Cache {
+	t.Helper()
+	c, err := New(ttl, maxEntries, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// get 查询缓存，返回命中的回复和层级，未命中时层级为空
+func get(c *Cache, key string) (string, string) {
+	entry, tier, ok := c.Get(key)
+	if !ok {
+		return "", ""
+	}
+	return entry.Response, tier
+}
+
+func TestKey(t *testing.T) {
+	options := map[string]interface{}{"temperature": 0, "top_p": 1, "stop": []string{"\n"}}
+	reordered := map[string]interface{}{"stop": []string{"\n"}, "top_p": 1, "temperature": 0}
+
+	key := Key("generate", "llama3", "Hi", options)
+	if Key("generate", "llama3", "Hi", reordered) != key {
+		t.Fatal("equal options produced different keys")
+	}
+	for _, other := range []string{
+		Key("chat", "llama3", "Hi", options),
+		Key("generate", "qwen2:7b", "Hi", options),
+		Key("generate", "llama3", "Hi!", options),
+		Key("generate", "llama3", "Hi", map[string]interface{}{"temperature": 0, "top_p": 0.9, "stop": []string{"\n"}}),
+	} {
+		if other == key {
+			t.Fatal("different requests produced the same key")
+		}
+	}
+}
+
+func TestCacheTiers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c := newTestCache(t, time.Hour, 1, path)
+
+	c.Set("a", &Entry{Response: "first"})
+	// 内存缓存只能保存一条，a 被淘汰后仍然可以从 SQLite 命中
+	c.Set("b", &Entry{Response: "second"})
+	if reply, tier := get(c, "b"); reply != "second" || tier != TierMemory {
+		t.Fatalf("b: %q from %q", reply, tier)
+	}
+	if reply, tier := get(c, "a"); reply != "first" || tier != TierSQLite {
+		t.Fatalf("a after eviction: %q from %q", reply, tier)
+	}
+	// SQLite 中命中的条目放入内存缓存
+	if reply, tier := get(c, "a"); reply != "first" || tier != TierMemory {
+		t.Fatalf("a after promotion: %q from %q", reply, tier)
+	}
+	if reply, tier := get(c, "missing"); reply != "" || tier != "" {
+		t.Fatalf("missing key: %q from %q", reply, tier)
+	}
+
+	// 重启后从 SQLite 命中
+	c.Close()
+	reopened := newTestCache(t, time.Hour, 1, path)
+	if reply, tier := get(reopened, "b"); reply != "second" || tier != TierSQLite {
+		t.Fatalf("b after restart: %q from %q", reply, tier)
+	}
+}
+
+func TestCacheMemoryOnly(t *testing.T) {
+	c := newTestCache(t, time.Hour, 1, "")
+
+	c.Set("a", &Entry{Response: "first"})
+	c.Set("b", &Entry{Response: "second"})
+	if reply, tier := get(c, "a"); reply != "" || tier != "" {
+		t.Fatalf("evicted entry without SQLite: %q from %q", reply, tier)
+	}
+	if reply, tier := get(c, "b"); reply != "second" || tier != TierMemory {
+		t.Fatalf("b: %q from %q", reply, tier)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	c := newTestCache(t, time.Hour, 10, filepath.Join(t.TempDir(), "cache.db"))
+	entry := &Entry{Response: "first"}
+	c.Set("a", entry)
+	if entry.ExpiresAt.Sub(entry.CreatedAt) != time.Hour {
+		t.Fatalf("entry expires %v after creation", entry.ExpiresAt.Sub(entry.CreatedAt))
+	}
+
+	// 两个层级都不返回过期的条目，并删除它们
+	expired := entry.ExpiresAt
+	if _, ok := c.memory.get("a", expired); ok {
+		t.Fatal("memory tier returned an expired entry")
+	}
+	if _, ok := c.memory.get("a", time.Now()); ok {
+		t.Fatal("memory tier kept an expired entry")
+	}
+	if _, ok, err := c.sqlite.get("a", expired); ok || err != nil {
+		t.Fatalf("SQLite tier returned an expired entry: %v", err)
+	}
+	if _, ok, err := c.sqlite.get("a", time.Now()); ok || err != nil {
+		t.Fatalf("SQLite tier kept an expired entry: %v", err)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	c := newTestCache(t, 20*time.Millisecond, 10, filepath.Join(t.TempDir(), "cache.db"))
+	c.Set("a", &Entry{Response: "first"})
+	if reply, _ := get(c, "a"); reply != "first" {
+		t.Fatalf("fresh entry: %q", reply)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if reply, tier := get(c, "a"); reply != "" || tier != "" {
+		t.Fatalf("expired entry: %q from %q", reply, tier)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	c := newTestCache(t, time.Hour, 10, filepath.Join(t.TempDir(), "cache.db"))
+	c.Set("a", &Entry{Response: "first"})
+	c.Set("b", &Entry{Response: "second"})
+
+	if n, err := c.sqlite.purge(time.Now()); n != 0 || err != nil {
+		t.Fatalf("purged %d fresh entries: %v", n, err)
+	}
+	if n, err := c.sqlite.purge(time.Now().Add(2 * time.Hour)); n != 2 || err != nil {
+		t.Fatalf("purged %d expired entries: %v", n, err)
+	}
+}
+
+func TestLRUEviction(t *testing.T) {
+	l := newLRU(2)
+	now := time.Now()
+	expires := now.Add(time.Hour)
+
+	l.set("a", &Entry{Response: "a", ExpiresAt: expires})
+	l.set("b", &Entry{Response: "b", ExpiresAt: expires})
+	// 读取 a 后 b 成为最久未使用的条目
+	l.get("a", now)
+	l.set("c", &Entry{Response: "c", ExpiresAt: expires})
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := l.get(key, now); ok != want {
+			t.Errorf("%s cached = %v, want %v", key, ok, want)
+		}
+	}
+
+	// 覆盖已有的键不淘汰其他条目
+	l.set("a", &Entry{Response: "a2", ExpiresAt: expires})
+	if entry, ok := l.get("a", now); !ok || entry.Response != "a2" {
+		t.Fatalf("overwritten entry: %+v", entry)
+	}
+	if _, ok := l.get("c", now); !ok {
+		t.Fatal("overwriting a key evicted another entry")
+	}
+}
+
+func TestChunks(t *testing.T) {
+	text := strings.Repeat("a", replayChunkRunes) + "重置密码的链接"
+	chunks := Chunks(text)
+	if len(chunks) != 2 || chunks[0] != strings.Repeat("a", replayChunkRunes) || chunks[1] != "重置密码的链接" {
+		t.Fatalf("chunks %q", chunks)
+	}
+	if Chunks("") != nil {
+		t.Fatal("empty text produced chunks")
+	}
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru 是按最近使用顺序淘汰的内存缓存
+type lru struct {
+	capacity int
+
+	mu      sync.Mutex
+	order   *list.List // 队首为最近使用
+	entries map[string]*list.Element
+}
+
+// lruItem 是 lru 链表中的元素
+type lruItem struct {
+	key   string
+	entry *Entry
+}
+
+// newLRU 创建一个最多保存 capacity 条的内存缓存
+func newLRU(capacity int) *lru {
+	return &lru{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get 返回未过期的条目并标记为最近使用，过期的条目被删除
+func (l *lru) get(key string, now time.Time) (*Entry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem, exists := l.entries[key]
+	if !exists {
+		return nil, false
+	}
+	item := elem.Value.(*lruItem)
+	if !now.Before(item.entry.ExpiresAt) {
+		l.order.Remove(elem)
+		delete(l.entries, key)
+		return nil, false
+	}
+	l.order.MoveToFront(elem)
+	return item.entry, true
+}
+
+// set 保存条目，超过容量时淘汰最久未使用的条目
+func (l *lru) set(key string, entry *Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, exists := l.entries[key]; exists {
+		elem.Value.(*lruItem).entry = entry
+		l.order.MoveToFront(elem)
+		return
+	}
+
+	l.entries[key] = l.order.PushFront(&lruItem{key: key, entry: entry})
+	for l.order.Len() > l.capacity {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*lruItem).key)
+	}
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+
+	"llm-fw/config"
+)
+
+const (
+	// TierSemantic 是按向量相似度命中的缓存层级
+	TierSemantic = "semantic"
+
+	// defaultSemanticThreshold 是未配置时命中语义缓存所需的最低余弦相似度
+	defaultSemanticThreshold = 0.95
+	// defaultSemanticEntries 是未配置时内存中最多保存的向量数
+	defaultSemanticEntries = 10000
+)
+
+// semanticIndex 保存提示词的向量和对应的响应，按余弦相似度查找。
+// 只有 scope 相同（模型、上下文和生成参数都相同）的条目之间才会比较
+type semanticIndex struct {
+	model      string
+	threshold  float64
+	thresholds map[string]float64
+	models     map[string]bool // 为空时所有模型都使用语义缓存
+	capacity   int
+
+	mu     sync.Mutex
+	order  *list.List // 队尾为最新写入，超过容量时从队首淘汰
+	scopes map[string][]*list.Element
+}
+
+// semanticItem 是语义索引中的一条记录
+type semanticItem struct {
+	scope  string
+	vector []float64
+	norm   float64
+	entry  *Entry
+}
+
+// newSemanticIndex 按配置创建语义索引
+func newSemanticIndex(cfg config.SemanticCache) *semanticIndex {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = defaultSemanticThreshold
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = defaultSemanticEntries
+	}
+	var models map[string]bool
+	if len(cfg.Models) > 0 {
+		models = make(map[string]bool, len(cfg.Models))
+		for _, model := range cfg.Models {
+			models[model] = true
+		}
+	}
+	return &semanticIndex{
+		model:      cfg.Model,
+		threshold:  cfg.Threshold,
+		thresholds: cfg.ModelThresholds,
+		models:     models,
+		capacity:   cfg.MaxEntries,
+		order:      list.New(),
+		scopes:     make(map[string][]*list.Element),
+	}
+}
+
+// enabledFor 判断模型是否使用语义缓存
+func (s *semanticIndex) enabledFor(model string) bool {
+	return s.models == nil || s.models[model]
+}
+
+// thresholdFor 返回模型命中语义缓存所需的最低相似度
+func (s *semanticIndex) thresholdFor(model string) float64 {
+	if threshold, ok := s.thresholds[model]; ok {
+		return threshold
+	}
+	return s.threshold
+}
+
+// match 在 scope 内查找与 vector 最相似且未过期的条目，相似度低于模型的阈值时视为未命中
+func (s *semanticIndex) match(model, scope string, vector []float64, now time.Time) (*Entry, float64, bool) {
+	norm := vectorNorm(vector)
+	if norm == 0 {
+		return nil, 0, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var best *Entry
+	bestScore := 0.0
+	for _, elem := range s.scopes[scope] {
+		item := elem.Value.(*semanticItem)
+		if !now.Before(item.entry.ExpiresAt) || len(item.vector) != len(vector) {
+			continue
+		}
+		if score := dot(item.vector, vector) / (item.norm * norm); score > bestScore {
+			best, bestScore = item.entry, score
+		}
+	}
+	if best == nil || bestScore < s.thresholdFor(model) {
+		return nil, bestScore, false
+	}
+	return best, bestScore, true
+}
+
+// add 保存一条记录，超过容量时淘汰最早写入的记录
+func (s *semanticIndex) add(scope string, vector []float64, entry *Entry) {
+	norm := vectorNorm(vector)
+	if norm == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem := s.order.PushBack(&semanticItem{scope: scope, vector: vector, norm: norm, entry: entry})
+	s.scopes[scope] = append(s.scopes[scope], elem)
+	for s.order.Len() > s.capacity {
+		s.remove(s.order.Front())
+	}
+}
+
+// remove 从链表和所属 scope 中删除一条记录，调用方需持有锁
+func (s *semanticIndex) remove(elem *list.Element) {
+	scope := elem.Value.(*semanticItem).scope
+	s.order.Remove(elem)
+
+	elems := s.scopes[scope]
+	for i, e := range elems {
+		if e == elem {
+			elems = append(elems[:i], elems[i+1:]...)
+			break
+		}
+	}
+	if len(elems) == 0 {
+		delete(s.scopes, scope)
+	} else {
+		s.scopes[scope] = elems
+	}
+}
+
+// dot 计算两个等长向量的点积
+func dot(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// vectorNorm 计算向量的 L2 范数
+func vectorNorm(v []float64) float64 {
+	return math.Sqrt(dot(v, v))
+}
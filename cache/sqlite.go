@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteTier 把缓存条目持久化到 SQLite，重启后仍然可以命中
+type sqliteTier struct {
+	db *sql.DB
+}
+
+// openSQLite 打开或创建 SQLite 缓存文件
+func openSQLite(path string) (*sqliteTier, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache database: %v", err)
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS response_cache (
+			key TEXT PRIMARY KEY,
+			entry TEXT NOT NULL,
+			expires_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_response_cache_expires_at ON response_cache(expires_at);
+		CREATE TABLE IF NOT EXISTS semantic_cache (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			scope TEXT NOT NULL,
+			embedding BLOB NOT NULL,
+			entry TEXT NOT NULL,
+			expires_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_semantic_cache_expires_at ON semantic_cache(expires_at);
+	`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create cache table: %v", err)
+	}
+	return &sqliteTier{db: db}, nil
+}
+
+// get 返回未过期的条目，过期的条目被删除
+func (t *sqliteTier) get(key string, now time.Time) (*Entry, bool, error) {
+	var data string
+	var expiresAt int64
+	err := t.db.QueryRow("SELECT entry, expires_at FROM response_cache WHERE key = ?", key).Scan(&data, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if now.UnixNano() >= expiresAt {
+		_, err := t.db.Exec("DELETE FROM response_cache WHERE key = ?", key)
+		return nil, false, err
+	}
+
+	var entry Entry
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return nil, false, err
+	}
+	return &entry, true, nil
+}
+
+// set 保存条目，已存在时覆盖
+func (t *sqliteTier) set(key string, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = t.db.Exec(
+		"INSERT OR REPLACE INTO response_cache (key, entry, expires_at) VALUES (?, ?, ?)",
+		key, string(data), entry.ExpiresAt.UnixNano(),
+	)
+	return err
+}
+
+// setSemantic 保存一条语义缓存记录，向量按小端 float32 数组编码
+func (t *sqliteTier) setSemantic(scope string, vector []float64, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = t.db.Exec(
+		"INSERT INTO semantic_cache (scope, embedding, entry, expires_at) VALUES (?, ?, ?, ?)",
+		scope, encodeVector(vector), string(data), entry.ExpiresAt.UnixNano(),
+	)
+	return err
+}
+
+// loadSemantic 按写入顺序读取最近 limit 条未过期的语义缓存记录，对每条调用 fn
+func (t *sqliteTier) loadSemantic(now time.Time, limit int, fn func(scope string, vector []float64, entry *Entry)) error {
+	rows, err := t.db.Query(`
+		SELECT scope, embedding, entry FROM (
+			SELECT id, scope, embedding, entry FROM semantic_cache
+			WHERE expires_at > ?
+			ORDER BY id DESC
+			LIMIT ?
+		) ORDER BY id
+	`, now.UnixNano(), limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var scope, data string
+		var blob []byte
+		if err := rows.Scan(&scope, &blob, &data); err != nil {
+			return err
+		}
+		var entry Entry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return err
+		}
+		fn(scope, decodeVector(blob), &entry)
+	}
+	return rows.Err()
+}
+
+// purge 删除所有过期的条目，返回删除的条数
+func (t *sqliteTier) purge(now time.Time) (int64, error) {
+	var total int64
+	for _, table := range []string{"response_cache", "semantic_cache"} {
+		result, err := t.db.Exec("DELETE FROM "+table+" WHERE expires_at <= ?", now.UnixNano())
+		if err != nil {
+			return total, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// close 关闭数据库连接
+func (t *sqliteTier) close() error {
+	return t.db.Close()
+}
+
+// encodeVector 把向量编码为小端 float32 数组
+func encodeVector(vector []float64) []byte {
+	buf := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(float32(v)))
+	}
+	return buf
+}
+
+// decodeVector 解码 encodeVector 编码的向量
+func decodeVector(buf []byte) []float64 {
+	vector := make([]float64, len(buf)/4)
+	for i := range vector {
+		vector[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:])))
+	}
+	return vector
+}
@@ -12,6 +12,16 @@ type ModelStats struct {
 	LastUsed       time.Time `json:"last_used"`
 }
 
+// ServerStats 存储上游服务器的统计信息
+type ServerStats struct {
+	TotalRequests  int64     `json:"total_requests"`
+	TotalTokensIn  int64     `json:"total_tokens_in"`
+	TotalTokensOut int64     `json:"total_tokens_out"`
+	AverageLatency float64   `json:"average_latency"`
+	FailedRequests int64     `json:"failed_requests"`
+	LastUsed       time.Time `json:"last_used"`
+}
+
 // ModelInfo 表示模型的完整信息
 type ModelInfo struct {
 	Name        string      `json:"name"`
@@ -43,17 +53,22 @@ type HistoryEntry struct {
 type Request struct {
 	ID        string    `json:"id"`
 	UserID    string    `json:"user_id"`
-	Model     string    `json:"model"`
+	Model     string    `json:"model"`           // 实际转发的模型
+	Alias     string    `json:"alias,omitempty"` // 命中路由规则时客户端请求的模型名
 	Prompt    string    `json:"prompt"`
 	Response  string    `json:"response"`
 	TokensIn  int       `json:"tokens_in"`
 	TokensOut int       `json:"tokens_out"`
 	Server    string    `json:"server"`
 	LatencyMs float64   `json:"latency_ms"`
-	Status    int       `json:"status"` // 0: 成功, 1: 失败
-	Error     string    `json:"error"`  // 错误信息
+	Status    int       `json:"status"`               // 0: 成功, 1: 失败
+	Error     string    `json:"error"`                // 错误信息
+	ErrorType string    `json:"error_type,omitempty"` // 失败原因分类，见 ErrorType* 常量
 	Timestamp time.Time `json:"timestamp"`
 	Source    string    `json:"source"` // 请求来源：internal_ui, external_ui, api
+
+	CacheSource     string  `json:"cache_source,omitempty"`     // 命中缓存时生成该响应的请求 ID
+	CacheSimilarity float64 `json:"cache_similarity,omitempty"` // 命中语义缓存时的余弦相似度
 }
 
 // ModelStatsHistory 表示模型统计历史记录
@@ -67,3 +82,135 @@ type ModelStatsHistory struct {
 	AverageLatency float64   `json:"average_latency"`
 	Timestamp      time.Time `json:"timestamp"`
 }
+
+// APIKey 表示一个 API 密钥，只保存密钥的哈希值
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	UserID     string     `json:"user_id"`
+	Team       string     `json:"team,omitempty"`
+	Prefix     string     `json:"prefix"` // 密钥明文的前缀，用于识别
+	KeyHash    string     `json:"-"`
+	Admin      bool       `json:"admin"`
+	Priority   string     `json:"priority,omitempty"` // 请求在调度器队列中的优先级，为空时按请求来源确定
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Identity 返回写入请求记录的用户标识，设置了团队时为 team/user
+func (k *APIKey) Identity() string {
+	if k.Team == "" {
+		return k.UserID
+	}
+	return k.Team + "/" + k.UserID
+}
+
+// Revoked 判断密钥是否已被吊销
+func (k *APIKey) Revoked() bool {
+	return k.RevokedAt != nil
+}
+
+// UsageCounter 记录某个身份在一个周期内的用量，用于限流计数在重启后恢复
+type UsageCounter struct {
+	Subject   string    `json:"subject"`
+	Period    string    `json:"period"` // 例如 day:2006-01-02、month:2006-01
+	Requests  int64     `json:"requests"`
+	Tokens    int64     `json:"tokens"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RetentionPolicy 定义请求记录的保留策略，字段为零值时表示不限制
+type RetentionPolicy struct {
+	MaxAge       time.Duration // 超过该时长的请求记录被删除
+	MaxRows      int           // 最多保留的请求记录数，超出时删除最旧的
+	MaxSizeBytes int64         // 请求记录的最大占用空间，超出时删除最旧的
+	BodyMaxAge   time.Duration // 超过该时长的请求只保留 token、延迟等字段，清空 prompt 和 response
+}
+
+// RetentionReport 描述一次保留策略执行（或演练）的结果
+type RetentionReport struct {
+	DryRun            bool      `json:"dry_run"`
+	RunAt             time.Time `json:"run_at"`
+	TotalRequests     int64     `json:"total_requests"`     // 执行前的请求记录数
+	SizeBytes         int64     `json:"size_bytes"`         // 执行前请求记录的占用空间
+	DeletedByAge      int64     `json:"deleted_by_age"`     // 因超过 max_age 删除的记录数
+	DeletedByRows     int64     `json:"deleted_by_rows"`    // 因超过 max_rows 删除的记录数
+	DeletedBySize     int64     `json:"deleted_by_size"`    // 因超过 max_size 删除的记录数
+	BodiesStripped    int64     `json:"bodies_stripped"`    // 清空 prompt 和 response 的记录数
+	RemainingRequests int64     `json:"remaining_requests"` // 执行后的请求记录数
+}
+
+// Deleted 返回删除的记录总数
+func (r *RetentionReport) Deleted() int64 {
+	return r.DeletedByAge + r.DeletedByRows + r.DeletedBySize
+}
+
+// 请求结果，用作指标的 status 标签
+const (
+	RequestStatusSuccess = "success"
+	RequestStatusError   = "error"
+)
+
+// 失败请求的原因分类，保存在 Request.ErrorType 中
+const (
+	ErrorTypeConnection    = "connection"      // 无法连接上游服务器
+	ErrorTypeUnavailable   = "unavailable"     // 没有可用的上游服务器
+	ErrorTypeOverloaded    = "overloaded"      // 排队的请求已满或排队超时
+	ErrorTypeModelNotFound = "model_not_found" // 上游不存在该模型
+	ErrorTypeUpstream4xx   = "upstream_4xx"    // 上游返回 4xx
+	ErrorTypeUpstream5xx   = "upstream_5xx"    // 上游返回 5xx
+	ErrorTypeDecode        = "decode"          // 无法解析上游响应
+	ErrorTypeCancelled     = "cancelled"       // 客户端取消了请求
+	ErrorTypeInterrupted   = "interrupted"     // 服务关闭时请求未能在期限内完成而被中断
+	ErrorTypeTimeout       = "timeout"         // 请求超时
+	ErrorTypeInternal      = "internal"        // 其他错误
+)
+
+// RequestObservation 描述一次完成的上游调用，供指标收集器使用
+type RequestObservation struct {
+	Model        string
+	Server       string
+	Source       string // 请求来源：internal_ui, external_ui, api
+	Status       string // RequestStatusSuccess 或 RequestStatusError
+	ErrorType    string // 失败时的原因分类
+	TokensIn     int64
+	TokensOut    int64
+	LatencyMs    int64
+	FirstTokenMs int64 // 收到第一段内容的耗时，0 表示没有流式内容
+}
+
+// 统计时间桶的粒度
+const (
+	BucketMinute = "minute"
+	BucketHour   = "hour"
+	BucketDay    = "day"
+)
+
+// RequestSample 是计算时间桶统计所需的请求字段
+type RequestSample struct {
+	Model     string
+	Timestamp time.Time
+	LatencyMs float64
+	TokensIn  int
+	TokensOut int
+	Status    int
+}
+
+// ModelStatsBucket 是模型在一个时间桶内的统计信息
+type ModelStatsBucket struct {
+	Model             string    `json:"model"`
+	Granularity       string    `json:"bucket"` // minute, hour, day
+	Start             time.Time `json:"start"`
+	Requests          int64     `json:"requests"`
+	Errors            int64     `json:"errors"`
+	TokensIn          int64     `json:"tokens_in"`
+	TokensOut         int64     `json:"tokens_out"`
+	AvgLatencyMs      float64   `json:"avg_latency_ms"`
+	P50LatencyMs      float64   `json:"p50_latency_ms"`
+	P90LatencyMs      float64   `json:"p90_latency_ms"`
+	P99LatencyMs      float64   `json:"p99_latency_ms"`
+	ErrorRate         float64   `json:"error_rate"`
+	RequestsPerSecond float64   `json:"requests_per_second"`
+	TokensPerSecond   float64   `json:"tokens_per_second"` // 输出 token 的吞吐
+}
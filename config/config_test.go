@@ -0,0 +1,192 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// baseConfig 是一份最小的有效配置，测试在其后追加需要校验的配置项
+const baseConfig = `
+ollama:
+  url: "http://localhost:11434"
+storage:
+  type: file
+  path: "./data"
+`
+
+func TestSemanticCacheRequiresPath(t *testing.T) {
+	semantic := `
+cache:
+  enabled: true
+  semantic:
+    enabled: true
+    model: "nomic-embed-text"
+`
+	_, err := ParseConfig([]byte(baseConfig + semantic))
+	if err == nil || !strings.Contains(err.Error(), "cache.path is required when cache.semantic is enabled") {
+		t.Fatalf("expected a missing cache.path error, got %v", err)
+	}
+
+	cfg, err := ParseConfig([]byte(baseConfig + semantic + `  path: "data/cache.db"` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Cache.Path != "data/cache.db" || cfg.Cache.Semantic.Models != nil {
+		t.Fatalf("unexpected cache config: %+v", cfg.Cache)
+	}
+}
+
+// fileStorage 是 baseConfig 中的存储配置，用于需要改写 ollama 配置的用例
+const fileStorage = `
+storage:
+  type: file
+  path: "./data"
+`
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errs   []string // 期望错误信息中包含的内容，为空表示配置有效
+	}{
+		{name: "minimal config", config: baseConfig},
+		{
+			name:   "postgresql alias",
+			config: "ollama:\n  url: \"http://localhost:11434\"\nstorage:\n  type: postgresql\n  dsn: \"postgres://localhost/llmfw\"\n",
+		},
+		{
+			name:   "unknown field",
+			config: baseConfig + "server:\n  prot: 8080\n",
+			errs:   []string{"field prot not found"},
+		},
+		{
+			name:   "unknown nested field",
+			config: baseConfig + "cache:\n  semantic:\n    treshold: 0.9\n",
+			errs:   []string{"field treshold not found"},
+		},
+		{
+			name:   "port out of range",
+			config: baseConfig + "server:\n  port: 70000\n",
+			errs:   []string{"server.port must be between 0 and 65535"},
+		},
+		{
+			name:   "postgres without dsn",
+			config: "ollama:\n  url: \"http://localhost:11434\"\nstorage:\n  type: postgres\n",
+			errs:   []string{"storage.dsn is required for postgres storage"},
+		},
+		{
+			name:   "unsupported storage and strategy",
+			config: "ollama:\n  url: \"http://localhost:11434\"\n  strategy: random\nstorage:\n  type: mysql\n",
+			errs:   []string{"unsupported storage.type: mysql", "unsupported ollama.strategy: random"},
+		},
+		{
+			name:   "missing ollama url",
+			config: fileStorage,
+			errs:   []string{"ollama.url or ollama.servers is required"},
+		},
+		{
+			name: "duplicate server names",
+			config: fileStorage + `
+ollama:
+  servers:
+    - {name: gpu, url: "http://10.0.0.1:11434"}
+    - {name: gpu, url: "http://10.0.0.2:11434"}
+`,
+			errs: []string{"duplicate ollama server name: gpu"},
+		},
+		{
+			name: "route to unknown server",
+			config: fileStorage + `
+ollama:
+  servers:
+    - {name: gpu-1, url: "http://10.0.0.1:11434"}
+  routes:
+    - match: "gpt-*"
+      targets:
+        - {model: llama3, server: gpu-2}
+    - match: "[a-"
+      targets:
+        - {model: llama3}
+    - match: "coder"
+      targets:
+        - {model: codellama, weight: -1}
+  fallbacks:
+    llama3: ["llama3"]
+`,
+			errs: []string{
+				`ollama.routes[0]: unknown ollama server "gpu-2"`,
+				`ollama.routes[1]: invalid match pattern "[a-"`,
+				"ollama.routes[2]: target weight must not be negative",
+				`ollama.fallbacks[llama3]: invalid fallback model "llama3"`,
+			},
+		},
+		{
+			name: "negative ollama values",
+			config: fileStorage + `
+ollama:
+  url: "http://localhost:11434"
+  timeouts:
+    idle: -1s
+  model_timeouts:
+    llama3:70b:
+      total: -1m
+  retry:
+    max_attempts: -1
+  health_check:
+    failure_threshold: -3
+`,
+			errs: []string{
+				"ollama.timeouts values must not be negative",
+				"ollama.model_timeouts[llama3:70b] values must not be negative",
+				"ollama.retry values must not be negative",
+				"ollama.health_check values must not be negative",
+			},
+		},
+		{
+			name: "invalid priority",
+			config: fileStorage + `
+ollama:
+  url: "http://localhost:11434"
+  scheduler:
+    enabled: true
+    default_priority: urgent
+`,
+			errs: []string{"unsupported ollama.scheduler.default_priority: urgent"},
+		},
+		{
+			name:   "negative rate limits",
+			config: baseConfig + "rate_limits:\n  default:\n    requests_per_minute: -1\n  users:\n    alice:\n      daily_tokens: -5\n",
+			errs:   []string{"rate_limits.default values must not be negative", "rate_limits.users[alice] values must not be negative"},
+		},
+		{
+			name:   "negative retention and stats",
+			config: baseConfig + "retention:\n  max_rows: -1\nstats:\n  interval: -1m\n",
+			errs:   []string{"retention values must not be negative", "stats values must not be negative"},
+		},
+		{
+			name:   "invalid cache",
+			config: baseConfig + "cache:\n  ttl: -1h\n  semantic:\n    threshold: 1.5\n",
+			errs:   []string{"cache values must not be negative", "cache.semantic.threshold must be between 0 and 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(tt.config))
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected errors %q", tt.errs)
+			}
+			for _, want := range tt.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"crypto/sha256"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultWatchInterval 是检查配置文件是否变化的间隔
+const defaultWatchInterval = 2 * time.Second
+
+// Watcher 在收到 SIGHUP 或配置文件内容变化时重新加载配置。新配置校验失败或应用失败时保留当前配置
+type Watcher struct {
+	path     string
+	interval time.Duration
+	apply    func(*Config) error
+
+	mu      sync.Mutex
+	current *Config
+	digest  [sha256.Size]byte // 最近一次加载的文件内容的哈希
+	done    chan struct{}
+	stop    sync.Once
+}
+
+// NewWatcher 创建配置文件监视器，current 为启动时加载的配置，apply 在新配置通过校验后调用
+func NewWatcher(path string, current *Config, apply func(*Config) error) *Watcher {
+	w := &Watcher{
+		path:     path,
+		interval: defaultWatchInterval,
+		apply:    apply,
+		current:  current,
+		done:     make(chan struct{}),
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		w.digest = sha256.Sum256(data)
+	}
+	return w
+}
+
+// Start 在后台监听 SIGHUP 并定期检查配置文件
+func (w *Watcher) Start() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(signals)
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-signals:
+				log.Printf("Received SIGHUP, reloading config from %s", w.path)
+				w.Reload()
+			case <-ticker.C:
+				w.check()
+			case <-w.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止监视
+func (w *Watcher) Stop() {
+	w.stop.Do(func() { close(w.done) })
+}
+
+// check 在配置文件内容变化时重新加载
+func (w *Watcher) check() {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return
+	}
+	w.mu.Lock()
+	changed := sha256.Sum256(data) != w.digest
+	w.mu.Unlock()
+	if changed {
+		log.Printf("Config file %s changed, reloading", w.path)
+		w.Reload()
+	}
+}
+
+// Reload 重新加载并应用配置文件，失败时记录错误并保留当前配置
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		log.Printf("Failed to reload config: %v", err)
+		return err
+	}
+	// 无论成功与否都记录本次内容，无效的文件在再次修改之前不会被反复加载
+	w.digest = sha256.Sum256(data)
+
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		log.Printf("Config reload rejected, keeping current config: %v", err)
+		return err
+	}
+	if err := w.apply(cfg); err != nil {
+		log.Printf("Failed to apply reloaded config, keeping current config: %v", err)
+		return err
+	}
+
+	for _, section := range RestartRequired(w.current, cfg) {
+		log.Printf("Config section %s changed and requires a restart to take effect", section)
+	}
+	w.current = cfg
+	log.Printf("Config reloaded from %s", w.path)
+	return nil
+}
+
+// RestartRequired 返回两份配置之间有变化、但需要重启才能生效的配置项
+func RestartRequired(old, cfg *Config) []string {
+	sections := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"server", old.Server, cfg.Server},
+		{"storage", old.Storage, cfg.Storage},
+		{"ollama.health_check", old.Ollama.HealthCheck, cfg.Ollama.HealthCheck},
+		{"ollama.scheduler.enabled", old.Ollama.Scheduler.Enabled, cfg.Ollama.Scheduler.Enabled},
+		{"rate_limits.enabled", old.RateLimits.Enabled, cfg.RateLimits.Enabled},
+		{"retention", old.Retention, cfg.Retention},
+		{"stats", old.Stats, cfg.Stats},
+		{"cache", old.Cache, cfg.Cache},
+	}
+
+	var changed []string
+	for _, section := range sections {
+		if !reflect.DeepEqual(section.old, section.new) {
+			changed = append(changed, section.name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testWatcher 把 content 写入临时配置文件并创建监视器，applied 记录每次应用的配置
+func testWatcher(t *testing.T, content string, apply func(*Config) error) (*Watcher, string, *[]*Config) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	current, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var applied []*Config
+	w := NewWatcher(path, current, func(cfg *Config) error {
+		if apply != nil {
+			if err := apply(cfg); err != nil {
+				return err
+			}
+		}
+		applied = append(applied, cfg)
+		return nil
+	})
+	return w, path, &applied
+}
+
+func TestWatcherReloadsChangedFile(t *testing.T) {
+	w, path, applied := testWatcher(t, baseConfig, nil)
+
+	// 内容没有变化时不重新加载
+	w.check()
+	if len(*applied) != 0 {
+		t.Fatalf("reloaded %d times without a change", len(*applied))
+	}
+
+	if err := os.WriteFile(path, []byte(baseConfig+"rate_limits:\n  default:\n    requests_per_minute: 30\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w.check()
+	if len(*applied) != 1 || (*applied)[0].RateLimits.Default.RequestsPerMinute != 30 {
+		t.Fatalf("changed file not applied: %d reloads", len(*applied))
+	}
+	if w.current != (*applied)[0] {
+		t.Fatal("current config not replaced after a reload")
+	}
+
+	// 同样的内容只加载一次
+	w.check()
+	if len(*applied) != 1 {
+		t.Fatalf("reloaded %d times for a single change", len(*applied))
+	}
+}
+
+func TestWatcherKeepsConfigOnError(t *testing.T) {
+	w, path, applied := testWatcher(t, baseConfig, nil)
+	current := w.current
+
+	// 校验失败的配置不应用，在再次修改之前也不反复加载
+	if err := os.WriteFile(path, []byte(baseConfig+"server:\n  port: -1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reload(); err == nil || !strings.Contains(err.Error(), "server.port") {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	w.check()
+	if len(*applied) != 0 || w.current != current {
+		t.Fatalf("invalid config applied: %d reloads", len(*applied))
+	}
+
+	// 应用失败时同样保留当前配置
+	failing, path, _ := testWatcher(t, baseConfig, func(*Config) error { return errors.New("unknown ollama server") })
+	current = failing.current
+	if err := os.WriteFile(path, []byte(baseConfig+"server:\n  port: 9090\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := failing.Reload(); err == nil || failing.current != current {
+		t.Fatalf("failed apply replaced the current config: %v", err)
+	}
+}
+
+func TestWatcherStartStop(t *testing.T) {
+	w, path, _ := testWatcher(t, baseConfig, nil)
+	reloaded := make(chan *Config, 1)
+	w.apply = func(cfg *Config) error {
+		reloaded <- cfg
+		return nil
+	}
+	w.interval = 10 * time.Millisecond
+	w.Start()
+	defer w.Stop()
+
+	if err := os.WriteFile(path, []byte(baseConfig+"server:\n  port: 9090\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cfg := <-reloaded:
+		if cfg.Server.Port != 9090 {
+			t.Fatalf("reloaded port %d", cfg.Server.Port)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("changed file not reloaded by the background watcher")
+	}
+
+	// Stop 可以重复调用
+	w.Stop()
+}
+
+func TestRestartRequired(t *testing.T) {
+	base, err := ParseConfig([]byte(baseConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Config)
+		want   []string
+	}{
+		{name: "no change", change: func(*Config) {}},
+		{
+			// 可以热更新的配置项不需要重启
+			name: "reloadable sections",
+			change: func(c *Config) {
+				c.Ollama.URL = "http://10.0.0.1:11434"
+				c.Ollama.Routes = []ModelRoute{{Match: "gpt-*", Targets: []RouteTarget{{Model: "llama3"}}}}
+				c.Ollama.Scheduler.MaxConcurrent = 4
+				c.RateLimits.Default.RequestsPerMinute = 60
+				c.Auth.AdminKey = "secret"
+			},
+		},
+		{
+			name:   "server",
+			change: func(c *Config) { c.Server.Port = 9090 },
+			want:   []string{"server"},
+		},
+		{
+			name: "feature switches",
+			change: func(c *Config) {
+				c.Ollama.HealthCheck.Enabled = true
+				c.Ollama.Scheduler.Enabled = true
+				c.RateLimits.Enabled = true
+			},
+			want: []string{"ollama.health_check", "ollama.scheduler.enabled", "rate_limits.enabled"},
+		},
+		{
+			name: "background tasks and storage",
+			change: func(c *Config) {
+				c.Storage.Path = "/var/lib/llm-fw"
+				c.Retention.MaxRows = 1000
+				c.Stats.Interval = time.Minute
+				c.Cache.TTL = time.Hour
+			},
+			want: []string{"storage", "retention", "stats", "cache"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseConfig([]byte(baseConfig))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.change(cfg)
+			if got := RestartRequired(base, cfg); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("RestartRequired = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
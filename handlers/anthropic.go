@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"llm-fw/auth"
+)
+
+// AnthropicMessagesRequest 定义了 Anthropic Messages API 请求的结构
+type AnthropicMessagesRequest struct {
+	Model         string             `json:"model"`
+	System        MessageContent     `json:"system,omitempty"`
+	Messages      []AnthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	TopK          *int               `json:"top_k,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream"`
+	Metadata      *struct {
+		UserID string `json:"user_id"`
+	} `json:"metadata,omitempty"`
+}
+
+// AnthropicMessage 定义了 Anthropic 消息的结构
+type AnthropicMessage struct {
+	Role    string         `json:"role"`
+	Content MessageContent `json:"content"`
+}
+
+// AnthropicContentBlock 定义了响应中的一个内容块
+type AnthropicContentBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// AnthropicUsage 定义了 Anthropic 的用量统计
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// AnthropicMessagesResponse 定义了 Anthropic Messages API 响应的结构
+type AnthropicMessagesResponse struct {
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	Role         string                  `json:"role"`
+	Model        string                  `json:"model"`
+	Content      []AnthropicContentBlock `json:"content"`
+	StopReason   *string                 `json:"stop_reason"`
+	StopSequence *string                 `json:"stop_sequence"`
+	Usage        AnthropicUsage          `json:"usage"`
+}
+
+// anthropicError 以 Anthropic 的错误格式返回错误
+func anthropicError(c *gin.Context, status int, message, errType string) {
+	c.JSON(status, gin.H{
+		"type": "error",
+		"error": gin.H{
+			"type":    errType,
+			"message": message,
+		},
+	})
+}
+
+// ollamaOptions 将 Anthropic 的采样参数转换为 Ollama 的 options
+func (r *AnthropicMessagesRequest) ollamaOptions() map[string]interface{} {
+	options := map[string]interface{}{
+		"num_predict": r.MaxTokens,
+	}
+	if r.Temperature != nil {
+		options["temperature"] = *r.Temperature
+	}
+	if r.TopP != nil {
+		options["top_p"] = *r.TopP
+	}
+	if r.TopK != nil {
+		options["top_k"] = *r.TopK
+	}
+	if len(r.StopSequences) > 0 {
+		options["stop"] = r.StopSequences
+	}
+	return options
+}
+
+// stopReason 将 Ollama 的 done_reason 转换为 Anthropic 的 stop_reason
+func stopReason(doneReason string) string {
+	if doneReason == "length" {
+		return "max_tokens"
+	}
+	return "end_turn"
+}
+
+// Messages 处理 Anthropic 兼容的 /v1/messages 请求
+func (h *ChatHandler) Messages(c *gin.Context) {
+	var req AnthropicMessagesRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		anthropicError(c, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err), "invalid_request_error")
+		return
+	}
+
+	// 验证请求
+	if req.Model == "" {
+		anthropicError(c, http.StatusBadRequest, "model: Field required", "invalid_request_error")
+		return
+	}
+	if req.MaxTokens <= 0 {
+		anthropicError(c, http.StatusBadRequest, "max_tokens: Field required and must be greater than 0", "invalid_request_error")
+		return
+	}
+	if len(req.Messages) == 0 {
+		anthropicError(c, http.StatusBadRequest, "messages: at least one message is required", "invalid_request_error")
+		return
+	}
+	for _, msg := range req.Messages {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			anthropicError(c, http.StatusBadRequest, fmt.Sprintf("messages: unexpected role %q", msg.Role), "invalid_request_error")
+			return
+		}
+	}
+
+	userID := auth.Identity(c)
+
+	// system 作为第一条系统消息传给 Ollama
+	messages := make([]ChatMessage, 0, len(req.Messages)+1)
+	if req.System != "" {
+		messages = append(messages, ChatMessage{Role: "system", Content: string(req.System)})
+	}
+	for _, msg := range req.Messages {
+		messages = append(messages, ChatMessage{Role: msg.Role, Content: string(msg.Content)})
+	}
+	prompt := messages[len(messages)-1].Content
+	options := req.ollamaOptions()
+	id := "msg_" + uuid.New().String()
+
+	if req.Stream {
+		h.streamMessages(c, &req, id, userID, prompt, messages, options)
+		return
+	}
+
+	result, err := h.ollamaChat(c, req.Model, messages, options, nil)
+	h.recordChat(userID, req.Model, prompt, "api", result, err)
+	if err != nil {
+		log.Printf("Failed to call Ollama API: %v", err)
+		writeAnthropicError(c, err)
+		return
+	}
+
+	reason := stopReason(result.DoneReason)
+	c.JSON(http.StatusOK, AnthropicMessagesResponse{
+		ID:         id,
+		Type:       "message",
+		Role:       "assistant",
+		Model:      result.ResponseModel,
+		Content:    []AnthropicContentBlock{{Type: "text", Text: result.Content}},
+		StopReason: &reason,
+		Usage: AnthropicUsage{
+			InputTokens:  result.PromptEvalCount,
+			OutputTokens: result.EvalCount,
+		},
+	})
+}
+
+// streamMessages 以 Anthropic 的 SSE 事件格式返回流式响应
+func (h *ChatHandler) streamMessages(c *gin.Context, req *AnthropicMessagesRequest, id, userID, prompt string, messages []ChatMessage, options map[string]interface{}) {
+	started := false
+	writeEvent := func(event string, data interface{}) {
+		jsonData, _ := json.Marshal(data)
+		c.Writer.Write([]byte("event: " + event + "\ndata: " + string(jsonData) + "\n\n"))
+		c.Writer.Flush()
+	}
+	model := req.Model
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Status(http.StatusOK)
+
+		writeEvent("message_start", gin.H{
+			"type": "message_start",
+			"message": AnthropicMessagesResponse{
+				ID:      id,
+				Type:    "message",
+				Role:    "assistant",
+				Model:   model,
+				Content: []AnthropicContentBlock{},
+			},
+		})
+		writeEvent("content_block_start", gin.H{
+			"type":          "content_block_start",
+			"index":         0,
+			"content_block": AnthropicContentBlock{Type: "text", Text: ""},
+		})
+		writeEvent("ping", gin.H{"type": "ping"})
+	}
+
+	result, err := h.ollamaChat(c, req.Model, messages, options, func(responseModel, content string) {
+		model = responseModel
+		start()
+		writeEvent("content_block_delta", gin.H{
+			"type":  "content_block_delta",
+			"index": 0,
+			"delta": gin.H{"type": "text_delta", "text": content},
+		})
+	})
+	h.recordChat(userID, req.Model, prompt, "api", result, err)
+	model = result.ResponseModel
+	if err != nil {
+		log.Printf("Failed to call Ollama API: %v", err)
+		if !started {
+			writeAnthropicError(c, err)
+			return
+		}
+		upstreamErr := classifyError(err)
+		writeEvent("error", gin.H{
+			"type":  "error",
+			"error": gin.H{"type": anthropicErrorType(upstreamErr), "message": upstreamErr.ClientMessage()},
+		})
+		return
+	}
+
+	start()
+	writeEvent("content_block_stop", gin.H{"type": "content_block_stop", "index": 0})
+	writeEvent("message_delta", gin.H{
+		"type": "message_delta",
+		"delta": gin.H{
+			"stop_reason":   stopReason(result.DoneReason),
+			"stop_sequence": nil,
+		},
+		"usage": AnthropicUsage{
+			InputTokens:  result.PromptEvalCount,
+			OutputTokens: result.EvalCount,
+		},
+	})
+	writeEvent("message_stop", gin.H{"type": "message_stop"})
+}
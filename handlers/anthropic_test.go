@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// anthropicErrorEnvelope 是 Anthropic 格式的错误响应
+type anthropicErrorEnvelope struct {
+	Type  string `json:"type"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func TestMessages(t *testing.T) {
+	at := newAPITest(t, ollamaChatStream)
+
+	w := at.post("/v1/messages", `{
+		"model": "llama3",
+		"system": [{"type": "text", "text": "You are "}, {"type": "text", "text": "terse."}],
+		"messages": [
+			{"role": "user", "content": "Hi"},
+			{"role": "assistant", "content": "Hello."},
+			{"role": "user", "content": [{"type": "text", "text": "Greet me again"}]}
+		],
+		"max_tokens": 128,
+		"top_k": 40,
+		"stop_sequences": ["Human:"]
+	}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp AnthropicMessagesResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type != "message" || resp.Role != "assistant" || !strings.HasPrefix(resp.ID, "msg_") || resp.Model != "llama3" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if len(resp.Content) != 1 || resp.Content[0] != (AnthropicContentBlock{Type: "text", Text: "Hello world"}) {
+		t.Fatalf("unexpected content: %+v", resp.Content)
+	}
+	if resp.StopReason == nil || *resp.StopReason != "end_turn" || resp.Usage != (AnthropicUsage{InputTokens: 12, OutputTokens: 5}) {
+		t.Fatalf("unexpected stop reason %v or usage %+v", resp.StopReason, resp.Usage)
+	}
+
+	// system 作为第一条系统消息转发，max_tokens 转换为 num_predict
+	calls := at.upstreamCalls()
+	if len(calls) != 1 || calls[0].Path != "/api/chat" {
+		t.Fatalf("upstream calls: %+v", calls)
+	}
+	var sent struct {
+		Messages []ChatMessage          `json:"messages"`
+		Options  map[string]interface{} `json:"options"`
+	}
+	data, _ := json.Marshal(calls[0].Body)
+	json.Unmarshal(data, &sent)
+	want := []ChatMessage{
+		{Role: "system", Content: "You are terse."},
+		{Role: "user", Content: "Hi"},
+		{Role: "assistant", Content: "Hello."},
+		{Role: "user", Content: "Greet me again"},
+	}
+	if len(sent.Messages) != len(want) {
+		t.Fatalf("upstream messages %+v", sent.Messages)
+	}
+	for i := range want {
+		if sent.Messages[i] != want[i] {
+			t.Fatalf("upstream message %d = %+v, want %+v", i, sent.Messages[i], want[i])
+		}
+	}
+	if sent.Options["num_predict"] != float64(128) || sent.Options["top_k"] != float64(40) {
+		t.Fatalf("upstream options %v", sent.Options)
+	}
+
+	if rec := at.lastRecord(t); rec.Prompt != "Greet me again" || rec.Response != "Hello world" || rec.TokensIn != 12 || rec.TokensOut != 5 {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+}
+
+func TestMessagesWithoutSystem(t *testing.T) {
+	at := newAPITest(t, ollamaChatStream)
+
+	w := at.post("/v1/messages", `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"max_tokens":16}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	// 没有 system 时不添加空的系统消息
+	messages, _ := json.Marshal(at.upstreamCalls()[0].Body["messages"])
+	if string(messages) != `[{"content":"Hi","role":"user"}]` {
+		t.Fatalf("upstream messages %s", messages)
+	}
+}
+
+func TestMessagesStream(t *testing.T) {
+	at := newAPITest(t, func(w http.ResponseWriter, call upstreamCall) {
+		w.Write([]byte(`{"message":{"role":"assistant","content":"Hello"},"done":false}` + "\n"))
+		w.Write([]byte(`{"message":{"role":"assistant","content":" world"},"done":false}` + "\n"))
+		w.Write([]byte(`{"message":{"role":"assistant","content":""},"done":true,"done_reason":"length","prompt_eval_count":12,"eval_count":2}` + "\n"))
+	})
+
+	w := at.post("/v1/messages", `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"max_tokens":2,"stream":true}`)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var sequence, text []string
+	var stop struct {
+		Delta struct {
+			StopReason string `json:"stop_reason"`
+		} `json:"delta"`
+		Usage AnthropicUsage `json:"usage"`
+	}
+	for _, event := range readSSE(t, w.Body) {
+		var data struct {
+			Type    string                    `json:"type"`
+			Message AnthropicMessagesResponse `json:"message"`
+			Delta   struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"delta"`
+		}
+		if err := json.Unmarshal([]byte(event.Data), &data); err != nil {
+			t.Fatalf("invalid event %q: %v", event.Data, err)
+		}
+		// 事件名与 data 中的 type 一致
+		if data.Type != event.Event {
+			t.Fatalf("event %s carries type %s", event.Event, data.Type)
+		}
+		sequence = append(sequence, event.Event)
+
+		switch event.Event {
+		case "message_start":
+			if !strings.HasPrefix(data.Message.ID, "msg_") || data.Message.Role != "assistant" || data.Message.Model != "llama3" {
+				t.Fatalf("unexpected message_start: %s", event.Data)
+			}
+		case "content_block_delta":
+			if data.Delta.Type != "text_delta" {
+				t.Fatalf("unexpected delta: %s", event.Data)
+			}
+			text = append(text, data.Delta.Text)
+		case "message_delta":
+			json.Unmarshal([]byte(event.Data), &stop)
+		}
+	}
+
+	want := "message_start,content_block_start,ping,content_block_delta,content_block_delta,content_block_stop,message_delta,message_stop"
+	if strings.Join(sequence, ",") != want {
+		t.Fatalf("event sequence %s, want %s", strings.Join(sequence, ","), want)
+	}
+	if strings.Join(text, "") != "Hello world" {
+		t.Fatalf("streamed text %q", text)
+	}
+	if stop.Delta.StopReason != "max_tokens" || stop.Usage != (AnthropicUsage{InputTokens: 12, OutputTokens: 2}) {
+		t.Fatalf("unexpected message_delta: %+v", stop)
+	}
+}
+
+func TestMessagesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(http.ResponseWriter, upstreamCall)
+		body    string
+		status  int
+		errType string
+		message string
+	}{
+		{
+			name:    "missing max_tokens",
+			body:    `{"model":"llama3","messages":[{"role":"user","content":"Hi"}]}`,
+			status:  http.StatusBadRequest,
+			errType: "invalid_request_error",
+			message: "max_tokens",
+		},
+		{
+			name:    "system role in messages",
+			body:    `{"model":"llama3","max_tokens":16,"messages":[{"role":"system","content":"Hi"}]}`,
+			status:  http.StatusBadRequest,
+			errType: "invalid_request_error",
+			message: `unexpected role "system"`,
+		},
+		{
+			name:    "model not found",
+			respond: ollamaStatus(http.StatusNotFound, `model "claude-3" not found, try pulling it first`),
+			body:    `{"model":"claude-3","max_tokens":16,"messages":[{"role":"user","content":"Hi"}]}`,
+			status:  http.StatusNotFound,
+			errType: "not_found_error",
+			message: `model "claude-3" not found`,
+		},
+		{
+			name:    "upstream failure before the stream starts",
+			respond: ollamaStatus(http.StatusInternalServerError, "CUDA out of memory"),
+			body:    `{"model":"llama3","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"Hi"}]}`,
+			status:  http.StatusInternalServerError,
+			errType: "api_error",
+			message: "CUDA out of memory",
+		},
+		{
+			name:    "upstream overloaded",
+			respond: ollamaStatus(http.StatusServiceUnavailable, "server busy, please try again"),
+			body:    `{"model":"llama3","max_tokens":16,"messages":[{"role":"user","content":"Hi"}]}`,
+			status:  http.StatusServiceUnavailable,
+			errType: "overloaded_error",
+			message: "server busy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			respond := tt.respond
+			if respond == nil {
+				respond = ollamaChatStream
+			}
+			at := newAPITest(t, respond)
+
+			w := at.post("/v1/messages", tt.body)
+			var envelope anthropicErrorEnvelope
+			if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.status || envelope.Type != "error" || envelope.Error.Type != tt.errType || !strings.Contains(envelope.Error.Message, tt.message) {
+				t.Fatalf("status %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMessagesStreamError(t *testing.T) {
+	// 流已经开始后上游返回错误，以 error 事件结束响应
+	at := newAPITest(t, func(w http.ResponseWriter, call upstreamCall) {
+		w.Write([]byte(`{"message":{"role":"assistant","content":"Hel"},"done":false}` + "\n"))
+		w.Write([]byte(`{"error":"model runner has unexpectedly stopped"}` + "\n"))
+	})
+
+	w := at.post("/v1/messages", `{"model":"llama3","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"Hi"}]}`)
+	events := readSSE(t, w.Body)
+	var sequence []string
+	for _, event := range events {
+		sequence = append(sequence, event.Event)
+	}
+	if want := "message_start,content_block_start,ping,content_block_delta,error"; strings.Join(sequence, ",") != want {
+		t.Fatalf("event sequence %s, want %s", strings.Join(sequence, ","), want)
+	}
+
+	var envelope anthropicErrorEnvelope
+	if err := json.Unmarshal([]byte(events[len(events)-1].Data), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Type != "error" || envelope.Error.Type != "api_error" || envelope.Error.Message != "model runner has unexpectedly stopped" {
+		t.Fatalf("unexpected error event: %+v", envelope)
+	}
+}
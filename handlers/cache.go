@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/cache"
+	"llm-fw/ollama"
+)
+
+// cacheServer 是命中响应缓存的请求记录中的服务器名
+const cacheServer = "cache"
+
+// cacheRequest 描述一次可以使用响应缓存的请求
+type cacheRequest struct {
+	Kind    string // generate 或 chat
+	Model   string // 按路由规则解析后的模型
+	Input   interface{}
+	Options map[string]interface{}
+
+	// 语义缓存只比较 Text 的向量，Context 和 Options 必须完全相同。
+	// 聊天请求的 Text 为最后一条消息，Context 为之前的消息
+	Context interface{}
+	Text    string
+}
+
+// cacheLookup 是一次缓存查询的结果。未命中时用于在请求成功后写入缓存
+type cacheLookup struct {
+	Entry      *cache.Entry // 命中时不为空
+	Similarity float64      // 命中语义缓存时的相似度
+
+	key    string // 为空时不写入精确匹配的缓存
+	scope  string
+	vector []float64 // 为空时不写入语义缓存
+}
+
+// store 把上游返回的响应写入缓存，requestID 为本次请求的记录 ID
+func (l *cacheLookup) store(rc *cache.Cache, requestID string, entry *cache.Entry) {
+	if l == nil {
+		return
+	}
+	entry.RequestID = requestID
+	if l.key != "" {
+		rc.Set(l.key, entry)
+	}
+	if l.vector != nil {
+		rc.SetSemantic(l.scope, l.vector, entry)
+	}
+}
+
+// apply 把命中的缓存来源写入请求记录
+func (l *cacheLookup) apply(rec *requestRecord) {
+	rec.Server = cacheServer
+	rec.CacheSource = l.Entry.RequestID
+	rec.CacheSimilarity = l.Similarity
+}
+
+// deterministic 判断生成参数是否是确定性的，只有 temperature 为 0 的请求才使用精确匹配的缓存
+func deterministic(options map[string]interface{}) bool {
+	switch temperature := options["temperature"].(type) {
+	case float64:
+		return temperature == 0
+	case int:
+		return temperature == 0
+	}
+	return false
+}
+
+// lookupCache 查询响应缓存，并设置 X-Cache 响应头。确定性请求先按精确匹配查找；模型使用语义缓存时
+// 再用嵌入模型计算 Text 的向量按相似度查找，语义缓存不要求请求是确定性的。请求带有 Cache-Control: no-cache 时
+// 跳过查询，带有 no-store 时不写入缓存。不使用缓存时返回 nil
+func lookupCache(c *gin.Context, rc *cache.Cache, pool *ollama.Pool, collector MetricsCollector, req cacheRequest) *cacheLookup {
+	if rc == nil {
+		return nil
+	}
+	exact, semantic := deterministic(req.Options), rc.SemanticEnabled(req.Model)
+	if !exact && !semantic {
+		return nil
+	}
+
+	directives := strings.ToLower(c.GetHeader("Cache-Control"))
+	noCache := strings.Contains(directives, "no-cache")
+	noStore := strings.Contains(directives, "no-store")
+
+	lookup := &cacheLookup{}
+	result, tier := cache.ResultMiss, ""
+	if exact {
+		lookup.key = cache.Key(req.Kind, req.Model, req.Input, req.Options)
+	}
+	if noCache {
+		result = cache.ResultBypass
+	} else if exact {
+		if entry, entryTier, ok := rc.Get(lookup.key); ok {
+			lookup.Entry, result, tier = entry, cache.ResultHit, entryTier
+		}
+	}
+
+	// 精确匹配未命中时按向量相似度查找，需要写入缓存时同样计算向量
+	if lookup.Entry == nil && semantic && !(noCache && noStore) {
+		vector, err := embedText(c.Request.Context(), pool, rc.SemanticModel(), req.Text)
+		if err != nil {
+			log.Printf("Failed to embed prompt for semantic cache: %v", err)
+		} else {
+			lookup.scope = cache.Key(req.Kind, req.Model, req.Context, req.Options)
+			lookup.vector = vector
+			if !noCache {
+				if entry, similarity, ok := rc.Match(req.Model, lookup.scope, vector); ok {
+					lookup.Entry, lookup.Similarity = entry, similarity
+					result, tier = cache.ResultHit, cache.TierSemantic
+				}
+			}
+		}
+	}
+
+	c.Header("X-Cache", strings.ToUpper(result))
+	if lookup.Entry != nil {
+		if lookup.Entry.RequestID != "" {
+			c.Header("X-Cache-Source", lookup.Entry.RequestID)
+		}
+		if tier == cache.TierSemantic {
+			c.Header("X-Cache-Similarity", strconv.FormatFloat(lookup.Similarity, 'f', 4, 64))
+		}
+	}
+	collector.ObserveCache(req.Model, result, tier)
+	if lookup.Entry == nil && noStore {
+		return nil
+	}
+	return lookup
+}
+
+// embedText 通过上游的嵌入模型计算文本的向量
+func embedText(ctx context.Context, pool *ollama.Pool, model, text string) ([]float64, error) {
+	resp, err := postModel(ctx, pool, pool.Resolve(model), "/api/embed", map[string]interface{}{
+		"input": text,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var embedResp ollamaEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
+		return nil, decodeError(err)
+	}
+	if len(embedResp.Embeddings) == 0 {
+		return nil, fmt.Errorf("embedding model %s returned no embeddings", model)
+	}
+	return embedResp.Embeddings[0], nil
+}
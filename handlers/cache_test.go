@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/cache"
+	"llm-fw/config"
+	"llm-fw/ollama"
+	"llm-fw/storage"
+	"llm-fw/types"
+)
+
+// testEmbeddings 是模拟的嵌入模型为每段文本返回的向量，前两段是同一个问题的不同说法
+var testEmbeddings = map[string][]float64{
+	"How do I reset my password?": {1, 0, 0},
+	"how can I reset my password": {0.99, 0.1, 0},
+	"What is your refund policy?": {0, 1, 0},
+}
+
+// cacheCollector 记录上游请求指标的服务器和缓存查询结果，其余指标丢弃
+type cacheCollector struct {
+	types.NoopMetricsCollector
+
+	mu       sync.Mutex
+	requests []string // 每次 ObserveRequest 的服务器
+	lookups  []string // 每次 ObserveCache 的 result/tier
+}
+
+func (c *cacheCollector) ObserveRequest(obs *types.RequestObservation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, obs.Server)
+}
+
+func (c *cacheCollector) ObserveCache(model, result, tier string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lookups = append(c.lookups, result+"/"+tier)
+}
+
+// cacheTest 是挂载了响应缓存的 /api/chat 测试环境
+type cacheTest struct {
+	router    *gin.Engine
+	store     types.Storage
+	collector *cacheCollector
+	chatCalls int32 // 上游 /api/chat 的调用次数
+	generates int32 // 上游 /api/generate 的调用次数
+	embeds    int32 // 上游 /api/embed 的调用次数
+}
+
+// newCacheTest 创建测试环境，semantic 为空时不启用语义缓存
+func newCacheTest(t *testing.T, semantic *config.SemanticCache) *cacheTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	ct := &cacheTest{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/embed":
+			atomic.AddInt32(&ct.embeds, 1)
+			var req struct {
+				Input string `json:"input"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(map[string]interface{}{"embeddings": [][]float64{testEmbeddings[req.Input]}})
+		case "/api/chat":
+			atomic.AddInt32(&ct.chatCalls, 1)
+			io.Copy(io.Discard, r.Body)
+			w.Write([]byte(`{"message":{"role":"assistant","content":"Use the reset link "},"done":false}` + "\n"))
+			w.Write([]byte(`{"message":{"role":"assistant","content":"on the login page."},"done":true,"done_reason":"stop","prompt_eval_count":12,"eval_count":8}` + "\n"))
+		case "/api/generate":
+			atomic.AddInt32(&ct.generates, 1)
+			io.Copy(io.Discard, r.Body)
+			w.Write([]byte(`{"response":"Use the reset link ","done":false}` + "\n"))
+			w.Write([]byte(`{"response":"on the login page.","done":true,"prompt_eval_count":12,"eval_count":8}` + "\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	pool, err := ollama.NewPool([]config.OllamaServer{{Name: "default", URL: upstream.URL}}, config.BalanceRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewFileStorageImpl(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	rc, err := cache.New(time.Hour, 100, filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rc.Close() })
+	if semantic != nil {
+		if err := rc.EnableSemantic(*semantic); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ct.collector = &cacheCollector{}
+	handler := NewChatHandler(store, pool, ct.collector)
+	handler.Cache = rc
+	generateHandler := NewGenerateHandler(pool, store, ct.collector)
+	generateHandler.Cache = rc
+	ct.router = gin.New()
+	ct.router.POST("/api/chat", handler.Chat)
+	ct.router.POST("/api/generate", generateHandler.Generate)
+	ct.store = store
+	return ct
+}
+
+// chat 发送一条 /api/chat 请求，返回响应和最后一行的回复内容
+func (ct *cacheTest) chat(t *testing.T, content string, options map[string]interface{}) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	return ct.chatWithCacheControl(t, content, options, "")
+}
+
+// chatWithCacheControl 发送一条带有 Cache-Control 请求头的 /api/chat 请求，cacheControl 为空时不设置
+func (ct *cacheTest) chatWithCacheControl(t *testing.T, content string, options map[string]interface{}, cacheControl string) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":    "llama3",
+		"messages": []ChatMessage{{Role: "system", Content: "You are a support bot."}, {Role: "user", Content: content}},
+		"options":  options,
+	})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(string(body)))
+	if cacheControl != "" {
+		req.Header.Set("Cache-Control", cacheControl)
+	}
+	ct.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	var done struct {
+		Message ChatMessage `json:"message"`
+		Done    bool        `json:"done"`
+	}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &done); err != nil || !done.Done {
+		t.Fatalf("unexpected last line %q: %v", lines[len(lines)-1], err)
+	}
+	return w, done.Message.Content
+}
+
+// lastRecord 返回最近保存的请求记录
+func (ct *cacheTest) lastRecord(t *testing.T) *types.Request {
+	t.Helper()
+	recent, err := ct.store.GetRecentRequests(1)
+	if err != nil || len(recent) != 1 {
+		t.Fatalf("GetRecentRequests: %d records, %v", len(recent), err)
+	}
+	return recent[0]
+}
+
+func TestChatCachesDeterministicRequests(t *testing.T) {
+	ct := newCacheTest(t, nil)
+	deterministic := map[string]interface{}{"temperature": 0}
+
+	w, reply := ct.chat(t, "How do I reset my password?", deterministic)
+	if w.Header().Get("X-Cache") != "MISS" || reply != "Use the reset link on the login page." {
+		t.Fatalf("first request: X-Cache %q, reply %q", w.Header().Get("X-Cache"), reply)
+	}
+	source := ct.lastRecord(t).ID
+
+	w, reply = ct.chat(t, "How do I reset my password?", deterministic)
+	if w.Header().Get("X-Cache") != "HIT" || w.Header().Get("X-Cache-Source") != source {
+		t.Fatalf("second request: X-Cache %q, source %q, want HIT from %s", w.Header().Get("X-Cache"), w.Header().Get("X-Cache-Source"), source)
+	}
+	if reply != "Use the reset link on the login page." || atomic.LoadInt32(&ct.chatCalls) != 1 {
+		t.Fatalf("cached reply %q after %d upstream calls", reply, ct.chatCalls)
+	}
+	if rec := ct.lastRecord(t); rec.Server != cacheServer || rec.CacheSource != source || rec.TokensOut != 0 {
+		t.Fatalf("unexpected record of a cache hit: %+v", rec)
+	}
+
+	// 没有启用语义缓存时，不确定的请求不使用缓存
+	w, _ = ct.chat(t, "How do I reset my password?", map[string]interface{}{"temperature": 0.7})
+	if w.Header().Get("X-Cache") != "" || atomic.LoadInt32(&ct.chatCalls) != 2 {
+		t.Fatalf("non-deterministic request: X-Cache %q after %d upstream calls", w.Header().Get("X-Cache"), ct.chatCalls)
+	}
+}
+
+func TestChatCacheDeterministicOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		cached  bool
+	}{
+		{name: "temperature 0", options: map[string]interface{}{"temperature": 0}, cached: true},
+		{name: "temperature 0.0", options: map[string]interface{}{"temperature": 0.0, "seed": 42}, cached: true},
+		{name: "temperature 0.2", options: map[string]interface{}{"temperature": 0.2}},
+		{name: "temperature 1", options: map[string]interface{}{"temperature": 1}},
+		// 未设置 temperature 时使用模型的默认值，不是确定性的
+		{name: "no temperature", options: map[string]interface{}{"seed": 42}},
+		{name: "no options"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ct := newCacheTest(t, nil)
+			ct.chat(t, "How do I reset my password?", tt.options)
+			w, _ := ct.chat(t, "How do I reset my password?", tt.options)
+
+			calls := atomic.LoadInt32(&ct.chatCalls)
+			if tt.cached && (w.Header().Get("X-Cache") != "HIT" || calls != 1) {
+				t.Fatalf("deterministic request: X-Cache %q after %d upstream calls", w.Header().Get("X-Cache"), calls)
+			}
+			if !tt.cached && (w.Header().Get("X-Cache") != "" || calls != 2) {
+				t.Fatalf("non-deterministic request: X-Cache %q after %d upstream calls", w.Header().Get("X-Cache"), calls)
+			}
+		})
+	}
+}
+
+func TestChatCacheControl(t *testing.T) {
+	deterministic := map[string]interface{}{"temperature": 0}
+	const question = "How do I reset my password?"
+
+	t.Run("no-cache", func(t *testing.T) {
+		ct := newCacheTest(t, nil)
+		ct.chat(t, question, deterministic)
+
+		// 跳过查询但仍然写入缓存
+		w, _ := ct.chatWithCacheControl(t, question, deterministic, "no-cache")
+		if w.Header().Get("X-Cache") != "BYPASS" || atomic.LoadInt32(&ct.chatCalls) != 2 {
+			t.Fatalf("no-cache: X-Cache %q after %d upstream calls", w.Header().Get("X-Cache"), ct.chatCalls)
+		}
+		source := ct.lastRecord(t).ID
+		w, _ = ct.chat(t, question, deterministic)
+		if w.Header().Get("X-Cache") != "HIT" || w.Header().Get("X-Cache-Source") != source {
+			t.Fatalf("after no-cache: X-Cache %q from %q, want HIT from %s", w.Header().Get("X-Cache"), w.Header().Get("X-Cache-Source"), source)
+		}
+	})
+
+	t.Run("no-store", func(t *testing.T) {
+		ct := newCacheTest(t, nil)
+
+		// 查询缓存但不写入
+		w, _ := ct.chatWithCacheControl(t, question, deterministic, "No-Store")
+		if w.Header().Get("X-Cache") != "MISS" {
+			t.Fatalf("no-store: X-Cache %q", w.Header().Get("X-Cache"))
+		}
+		w, _ = ct.chat(t, question, deterministic)
+		if w.Header().Get("X-Cache") != "MISS" || atomic.LoadInt32(&ct.chatCalls) != 2 {
+			t.Fatalf("after no-store: X-Cache %q after %d upstream calls", w.Header().Get("X-Cache"), ct.chatCalls)
+		}
+
+		// 已经缓存的响应仍然可以命中
+		w, _ = ct.chatWithCacheControl(t, question, deterministic, "no-store")
+		if w.Header().Get("X-Cache") != "HIT" || atomic.LoadInt32(&ct.chatCalls) != 2 {
+			t.Fatalf("no-store on a cached request: X-Cache %q after %d upstream calls", w.Header().Get("X-Cache"), ct.chatCalls)
+		}
+	})
+
+	t.Run("no-cache, no-store", func(t *testing.T) {
+		ct := newCacheTest(t, nil)
+		ct.chat(t, question, deterministic)
+
+		w, _ := ct.chatWithCacheControl(t, question, deterministic, "no-cache, no-store")
+		if w.Header().Get("X-Cache") != "BYPASS" || atomic.LoadInt32(&ct.chatCalls) != 2 {
+			t.Fatalf("no-cache, no-store: X-Cache %q after %d upstream calls", w.Header().Get("X-Cache"), ct.chatCalls)
+		}
+	})
+}
+
+func TestGenerateCacheReplay(t *testing.T) {
+	ct := newCacheTest(t, nil)
+	const reply = "Use the reset link on the login page."
+
+	generate := func(stream bool) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{"model": "llama3", "prompt": "How do I reset my password?", "temperature": 0, "stream": stream})
+		w := httptest.NewRecorder()
+		ct.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(string(body))))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	w := generate(false)
+	if w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("first request: X-Cache %q", w.Header().Get("X-Cache"))
+	}
+	source := ct.lastRecord(t).ID
+
+	// 非流式命中返回完整结果和缓存的 token 用量
+	w = generate(false)
+	if w.Header().Get("X-Cache") != "HIT" || w.Header().Get("X-Cache-Source") != source {
+		t.Fatalf("second request: X-Cache %q from %q, want HIT from %s", w.Header().Get("X-Cache"), w.Header().Get("X-Cache-Source"), source)
+	}
+	var resp GenerateResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Choices[0].Text != reply || resp.Usage != (Usage{PromptTokens: 12, CompletionTokens: 8, TotalTokens: 20}) {
+		t.Fatalf("cached response: %+v", resp)
+	}
+
+	// 流式请求按流式格式分段回放，与非流式请求共享缓存
+	w = generate(true)
+	if w.Header().Get("X-Cache") != "HIT" || w.Header().Get("X-Cache-Source") != source {
+		t.Fatalf("streamed request: X-Cache %q from %q, want HIT from %s", w.Header().Get("X-Cache"), w.Header().Get("X-Cache-Source"), source)
+	}
+	var text strings.Builder
+	events := readSSE(t, w.Body)
+	for _, event := range events {
+		var chunk GenerateResponse
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", event.Data, err)
+		}
+		text.WriteString(chunk.Choices[0].Text)
+	}
+	if len(events) < 2 || text.String() != reply {
+		t.Fatalf("replayed %d chunks: %q", len(events), text.String())
+	}
+
+	if calls := atomic.LoadInt32(&ct.generates); calls != 1 {
+		t.Fatalf("%d upstream calls", calls)
+	}
+	if rec := ct.lastRecord(t); rec.Server != cacheServer || rec.CacheSource != source || rec.Response != reply || rec.TokensOut != 0 {
+		t.Fatalf("unexpected record of a cache hit: %+v", rec)
+	}
+}
+
+func TestCacheHitsSkipUpstreamMetrics(t *testing.T) {
+	ct := newCacheTest(t, nil)
+	deterministic := map[string]interface{}{"temperature": 0}
+
+	ct.chat(t, "How do I reset my password?", deterministic)
+	ct.chat(t, "How do I reset my password?", deterministic)
+
+	// 命中只记录一次缓存查询，不计入上游的请求和延迟指标
+	ct.collector.mu.Lock()
+	defer ct.collector.mu.Unlock()
+	if strings.Join(ct.collector.requests, ",") != "default" {
+		t.Fatalf("upstream request metrics for servers %v", ct.collector.requests)
+	}
+	if want := "miss/,hit/" + cache.TierMemory; strings.Join(ct.collector.lookups, ",") != want {
+		t.Fatalf("cache lookups %v, want %s", ct.collector.lookups, want)
+	}
+}
+
+func TestChatSemanticCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		models   []string
+		semantic bool // llama3 是否使用语义缓存
+	}{
+		{name: "all models", semantic: true},
+		{name: "model opted in", models: []string{"llama3"}, semantic: true},
+		{name: "model not opted in", models: []string{"qwen2:7b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ct := newCacheTest(t, &config.SemanticCache{Enabled: true, Model: "nomic-embed-text", Models: tt.models})
+
+			// 不确定的请求同样使用语义缓存
+			w, _ := ct.chat(t, "How do I reset my password?", nil)
+			if !tt.semantic {
+				if w.Header().Get("X-Cache") != "" || atomic.LoadInt32(&ct.embeds) != 0 {
+					t.Fatalf("model not opted in: X-Cache %q after %d embeddings", w.Header().Get("X-Cache"), ct.embeds)
+				}
+				return
+			}
+			if w.Header().Get("X-Cache") != "MISS" {
+				t.Fatalf("first request: X-Cache %q", w.Header().Get("X-Cache"))
+			}
+			source := ct.lastRecord(t).ID
+
+			w, reply := ct.chat(t, "how can I reset my password", nil)
+			if w.Header().Get("X-Cache") != "HIT" || w.Header().Get("X-Cache-Source") != source || w.Header().Get("X-Cache-Similarity") == "" {
+				t.Fatalf("paraphrase: X-Cache %q, source %q, similarity %q", w.Header().Get("X-Cache"), w.Header().Get("X-Cache-Source"), w.Header().Get("X-Cache-Similarity"))
+			}
+			if reply != "Use the reset link on the login page." || atomic.LoadInt32(&ct.chatCalls) != 1 {
+				t.Fatalf("cached reply %q after %d upstream calls", reply, ct.chatCalls)
+			}
+			if rec := ct.lastRecord(t); rec.CacheSource != source || rec.CacheSimilarity < 0.95 {
+				t.Fatalf("unexpected record of a semantic hit: %+v", rec)
+			}
+
+			// 不相似的问题转发给上游
+			w, _ = ct.chat(t, "What is your refund policy?", nil)
+			if w.Header().Get("X-Cache") != "MISS" || atomic.LoadInt32(&ct.chatCalls) != 2 {
+				t.Fatalf("different question: X-Cache %q after %d upstream calls", w.Header().Get("X-Cache"), ct.chatCalls)
+			}
+		})
+	}
+}
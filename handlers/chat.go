@@ -1,7 +1,7 @@
 package handlers
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -12,6 +12,9 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
+	"llm-fw/auth"
+	"llm-fw/cache"
+	"llm-fw/ollama"
 	"llm-fw/types"
 )
 
@@ -21,6 +24,8 @@ type ChatRequest struct {
 	Messages []ChatMessage `json:"messages" binding:"required"`
 	UserID   string        `json:"user_id"`
 	Stream   bool          `json:"stream"`
+
+	Options map[string]interface{} `json:"options"` // 原样转发给 Ollama 的生成参数
 }
 
 // ChatMessage 定义了聊天消息的结构
@@ -37,18 +42,17 @@ type ChatResponse struct {
 
 // ChatHandler 处理聊天相关的请求
 type ChatHandler struct {
-	TargetURL        string
+	Pool             *ollama.Pool
 	Storage          types.Storage
 	MetricsCollector types.MetricsCollector
-	ollamaURL        string
+	Cache            *cache.Cache // 为空时不缓存响应
 }
 
 // NewChatHandler creates a new chat handler
-func NewChatHandler(storage types.Storage, ollamaURL string, metricsCollector types.MetricsCollector) *ChatHandler {
+func NewChatHandler(storage types.Storage, pool *ollama.Pool, metricsCollector types.MetricsCollector) *ChatHandler {
 	return &ChatHandler{
 		Storage:          storage,
-		ollamaURL:        ollamaURL,
-		TargetURL:        ollamaURL,
+		Pool:             pool,
 		MetricsCollector: metricsCollector,
 	}
 }
@@ -63,7 +67,7 @@ func (h *ChatHandler) Chat(c *gin.Context) {
 	// 设置 CORS 头
 	c.Header("Access-Control-Allow-Origin", "http://127.0.0.1")
 	c.Header("Access-Control-Allow-Methods", "POST, OPTIONS")
-	c.Header("Access-Control-Allow-Headers", "Content-Type")
+	c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
 	c.Header("Vary", "Origin")
 
 	// 处理 OPTIONS 请求
@@ -101,66 +105,130 @@ func (h *ChatHandler) Chat(c *gin.Context) {
 		return
 	}
 
-	// 从请求头中获取用户ID，如果没有则生成一个
-	userID := c.GetHeader("X-User-ID")
-	if userID == "" {
-		userID = "anonymous_" + uuid.New().String()[:8]
-	}
-	req.UserID = userID
+	// 使用 API 密钥解析出的身份作为用户ID
+	req.UserID = auth.Identity(c)
 
 	startTime := time.Now()
 
+	// 按路由规则解析实际转发的模型和服务器
+	target := h.Pool.Resolve(req.Model)
+
 	// 调用Ollama API
 	ollamaReq := map[string]interface{}{
-		"model":    req.Model,
 		"messages": req.Messages,
 		"stream":   true, // 始终启用流式响应
 	}
+	if len(req.Options) > 0 {
+		ollamaReq["options"] = req.Options
+	}
 
-	ollamaReqBody, err := json.Marshal(ollamaReq)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal request"})
+	// 失败的调用同样记录到指标和存储
+	rec := &requestRecord{
+		ID:     uuid.New().String(),
+		UserID: req.UserID,
+		Source: "external_ui",
+		Prompt: req.Messages[len(req.Messages)-1].Content,
+	}
+	recordFailure := func(err error) {
+		rec.LatencyMs = time.Since(startTime).Milliseconds()
+		rec.Err = err
+		recordRequest(h.Storage, h.MetricsCollector, rec)
+	}
+
+	// 先查询响应缓存，命中时按流式格式回放缓存的结果
+	n := len(req.Messages)
+	lookup := lookupCache(c, h.Cache, h.Pool, h.MetricsCollector, cacheRequest{
+		Kind:    "chat",
+		Model:   target.Model,
+		Input:   req.Messages,
+		Options: req.Options,
+		Context: req.Messages[:n-1],
+		Text:    req.Messages[n-1].Content,
+	})
+	if lookup != nil && lookup.Entry != nil {
+		cached := lookup.Entry
+		if cached.Model != target.Model {
+			target = target.Fallback(cached.Model)
+		}
+		model := responseModel(req.Model, target)
+		writeChatHeaders(c)
+		for _, chunk := range cache.Chunks(cached.Response) {
+			writeChatLine(c, chatMessageEvent(model, chunk))
+		}
+
+		// 命中缓存的请求没有经过上游，不计 token
+		rec.Model, rec.Alias = target.Model, target.Alias
+		lookup.apply(rec)
+		rec.Response = cached.Response
+		rec.LatencyMs = time.Since(startTime).Milliseconds()
+		recordRequest(h.Storage, h.MetricsCollector, rec)
+
+		writeChatLine(c, chatDoneEvent(model, cached.Response, cached.DoneReason, float64(cached.PromptTokens), float64(cached.CompletionTokens), rec.LatencyMs))
 		return
 	}
 
-	resp, err := http.Post(fmt.Sprintf("%s/api/chat", h.TargetURL), "application/json", bytes.NewBuffer(ollamaReqBody))
+	// 从上游服务器池中选择服务器发起请求，失败时按降级链尝试下一个模型。启用调度器时按优先级和用户排队
+	ctx := queueContext(c, rec.Source)
+	resp, target, err := postWithFallback(ctx, h.Pool, h.MetricsCollector, target, "/api/chat", ollamaReq)
+	rec.Model, rec.Alias = target.Model, target.Alias
 	if err != nil {
 		log.Printf("Failed to call Ollama API: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to call Ollama API"})
+		upstreamErr := classifyError(contextError(ctx, err))
+		rec.Server = failedServer(err)
+		recordFailure(upstreamErr)
+		writeError(c, upstreamErr)
 		return
 	}
 	defer resp.Body.Close()
-
-	// 设置响应头
-	c.Header("Content-Type", "application/x-ndjson")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-	c.Header("Transfer-Encoding", "chunked")
+	rec.Server = resp.Server.Name
+	model := responseModel(req.Model, target)
+
+	writeChatHeaders(c)
+
+	// chatChunk 是上游流式响应中的一段内容，done 为 true 时携带 token 计数
+	type chatChunk struct {
+		content         string
+		done            bool
+		doneReason      string
+		promptEvalCount float64
+		evalCount       float64
+	}
 
 	// 创建响应通道
-	responseChan := make(chan string, 100)
+	responseChan := make(chan chatChunk, 100)
 	errorChan := make(chan error, 1)
 
-	// 创建共享变量
-	var fullResponse strings.Builder
-	var promptEvalCount, evalCount float64
-
-	// 启动goroutine处理响应
+	// 启动goroutine处理响应，客户端断开后不再阻塞在发送上
 	go func() {
-		decoder := json.NewDecoder(resp.Body)
+		send := func(chunk chatChunk) bool {
+			select {
+			case responseChan <- chunk:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
 
+		decoder := json.NewDecoder(resp.Body)
+		var promptEvalCount, evalCount float64
+		var doneReason string
 		for decoder.More() {
 			var chunk map[string]interface{}
 			if err := decoder.Decode(&chunk); err != nil {
-				errorChan <- fmt.Errorf("failed to decode response chunk: %v", err)
+				errorChan <- contextError(ctx, decodeError(err))
+				return
+			}
+			if message, ok := chunk["error"].(string); ok {
+				errorChan <- upstreamStreamError(message)
 				return
 			}
 
 			// 从消息中提取响应文本
 			if message, ok := chunk["message"].(map[string]interface{}); ok {
-				if content, ok := message["content"].(string); ok {
-					fullResponse.WriteString(content)
-					responseChan <- content
+				if content, ok := message["content"].(string); ok && content != "" {
+					if !send(chatChunk{content: content}) {
+						return
+					}
 				}
 			}
 
@@ -171,96 +239,278 @@ func (h *ChatHandler) Chat(c *gin.Context) {
 			if count, ok := chunk["eval_count"].(float64); ok {
 				evalCount = count
 			}
+			if reason, ok := chunk["done_reason"].(string); ok {
+				doneReason = reason
+			}
 		}
 
 		// 发送完成信号
-		responseChan <- ""
+		send(chatChunk{done: true, doneReason: doneReason, promptEvalCount: promptEvalCount, evalCount: evalCount})
 	}()
 
+	var fullResponse strings.Builder
+	var firstTokenMs int64
+
 	// 处理响应流
 	for {
 		select {
-		case content := <-responseChan:
-			if content == "" {
+		case chunk := <-responseChan:
+			if chunk.done {
 				// 响应完成
 				latency := time.Since(startTime).Milliseconds()
-				stats := types.RequestStats{
-					TokensIn:  int(promptEvalCount),
-					TokensOut: int(evalCount),
-					LatencyMs: float64(latency),
-				}
 
-				// 更新指标
-				h.MetricsCollector.RecordRequest(
-					req.Model,
-					"ollama",
-					int64(stats.TokensIn),
-					int64(stats.TokensOut),
-					latency,
-					true,
-				)
-
-				// 保存到存储
-				storageReq := &types.Request{
-					ID:        uuid.New().String(),
-					UserID:    req.UserID,
-					Model:     req.Model,
-					Prompt:    req.Messages[len(req.Messages)-1].Content,
-					Response:  fullResponse.String(),
-					TokensIn:  stats.TokensIn,
-					TokensOut: stats.TokensOut,
-					Server:    "ollama",
-					LatencyMs: float64(latency),
-					Timestamp: time.Now(),
-					Source:    "external_ui", // 标记请求来源
-				}
-
-				if err := h.Storage.SaveRequest(storageReq); err != nil {
-					log.Printf("Failed to save chat request: %v", err)
-				}
+				// 更新指标并保存到存储
+				rec.Response = fullResponse.String()
+				rec.TokensIn = int(chunk.promptEvalCount)
+				rec.TokensOut = int(chunk.evalCount)
+				rec.LatencyMs = latency
+				rec.FirstTokenMs = firstTokenMs
+				recordRequest(h.Storage, h.MetricsCollector, rec)
+
+				lookup.store(h.Cache, rec.ID, &cache.Entry{
+					Model:            target.Model,
+					Response:         rec.Response,
+					PromptTokens:     rec.TokensIn,
+					CompletionTokens: rec.TokensOut,
+					DoneReason:       chunk.doneReason,
+				})
 
 				// 发送最终统计信息
-				finalResponse := map[string]interface{}{
-					"model":      req.Model,
-					"created_at": time.Now().Format(time.RFC3339),
-					"message": map[string]interface{}{
-						"role":    "assistant",
-						"content": fullResponse.String(),
-					},
-					"done": true,
-					"stats": map[string]interface{}{
-						"prompt_eval_count": promptEvalCount,
-						"eval_count":        evalCount,
-						"eval_duration":     float64(latency) / 1000.0,
-					},
-				}
-				finalJSON, _ := json.Marshal(finalResponse)
-				c.Writer.Write(finalJSON)
-				c.Writer.Write([]byte("\n"))
-				c.Writer.Flush()
+				writeChatLine(c, chatDoneEvent(model, rec.Response, chunk.doneReason, chunk.promptEvalCount, chunk.evalCount, latency))
 				return
 			}
+			if firstTokenMs == 0 {
+				firstTokenMs = time.Since(startTime).Milliseconds()
+			}
+			fullResponse.WriteString(chunk.content)
+
 			// 发送内容块
-			messageEvent := map[string]interface{}{
-				"model":      req.Model,
-				"created_at": time.Now().Format(time.RFC3339),
-				"message": map[string]interface{}{
-					"role":    "assistant",
-					"content": content,
-				},
+			writeChatLine(c, chatMessageEvent(model, chunk.content))
+		case <-ctx.Done():
+			// 客户端已断开或服务关闭，保存已经生成的部分内容；返回时关闭响应体，上游请求随之取消
+			log.Printf("Chat request cancelled: %v", context.Cause(ctx))
+			rec.Response = fullResponse.String()
+			rec.FirstTokenMs = firstTokenMs
+			recordFailure(context.Cause(ctx))
+			return
+		case err := <-errorChan:
+			log.Printf("Error processing response: %v", err)
+			rec.Response = fullResponse.String()
+			rec.FirstTokenMs = firstTokenMs
+			recordFailure(err)
+			if !c.Writer.Written() {
+				writeError(c, err)
+				return
 			}
-			jsonData, _ := json.Marshal(messageEvent)
-			c.Writer.Write(jsonData)
+			// 流已经开始，以一行错误对象结束响应，与 Ollama 的流式错误格式一致
+			upstreamErr := classifyError(err)
+			errorJSON, _ := json.Marshal(gin.H{"error": upstreamErr.ClientMessage(), "type": upstreamErr.Type})
+			c.Writer.Write(errorJSON)
 			c.Writer.Write([]byte("\n"))
 			c.Writer.Flush()
-		case err := <-errorChan:
-			log.Printf("Error processing response: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process response"})
 			return
 		}
 	}
 }
 
+// writeChatHeaders 设置 /api/chat 流式响应的响应头
+func writeChatHeaders(c *gin.Context) {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Transfer-Encoding", "chunked")
+}
+
+// chatMessageEvent 返回 /api/chat 流式响应中的一段内容
+func chatMessageEvent(model, content string) map[string]interface{} {
+	return map[string]interface{}{
+		"model":      model,
+		"created_at": time.Now().Format(time.RFC3339),
+		"message": map[string]interface{}{
+			"role":    "assistant",
+			"content": content,
+		},
+	}
+}
+
+// chatDoneEvent 返回 /api/chat 流式响应的最后一行，包含完整的回复和统计信息
+func chatDoneEvent(model, content, doneReason string, promptEvalCount, evalCount float64, latencyMs int64) map[string]interface{} {
+	event := chatMessageEvent(model, content)
+	event["done"] = true
+	if doneReason != "" {
+		event["done_reason"] = doneReason
+	}
+	event["stats"] = map[string]interface{}{
+		"prompt_eval_count": promptEvalCount,
+		"eval_count":        evalCount,
+		"eval_duration":     float64(latencyMs) / 1000.0,
+	}
+	return event
+}
+
+// writeChatLine 以一行 JSON 输出 /api/chat 流式响应中的一个对象
+func writeChatLine(c *gin.Context, event map[string]interface{}) {
+	data, _ := json.Marshal(event)
+	c.Writer.Write(data)
+	c.Writer.Write([]byte("\n"))
+	c.Writer.Flush()
+}
+
+// chatResult 表示一次上游聊天调用的结果
+type chatResult struct {
+	Model           string       // 按路由规则和降级链解析后实际使用的模型
+	Alias           string       // 命中路由规则或降级时客户端请求的模型名
+	ResponseModel   string       // 应答中报告给客户端的模型名
+	ID              string       // 请求记录的 ID
+	Cache           *cacheLookup // 命中响应缓存时不为空
+	Server          string
+	Content         string
+	PromptEvalCount int
+	EvalCount       int
+	DoneReason      string
+	LatencyMs       int64
+	FirstTokenMs    int64 // 收到第一段内容的耗时
+}
+
+// ollamaChat 按路由规则解析模型后通过上游服务器池调用 Ollama /api/chat 的流式接口，失败时按降级链尝试下一个模型。
+// 每收到一段内容调用一次 onDelta，model 为应答中报告给客户端的模型名。确定性请求先查询响应缓存，
+// 命中时把缓存的结果分段回放给 onDelta。启用调度器时按用户公平排队，客户端断开时上游请求随之取消
+func (h *ChatHandler) ollamaChat(c *gin.Context, model string, messages []ChatMessage, options map[string]interface{}, onDelta func(model, content string)) (*chatResult, error) {
+	ctx := c.Request.Context()
+	target := h.Pool.Resolve(model)
+	ollamaReq := map[string]interface{}{
+		"messages": messages,
+		"stream":   true,
+	}
+	if len(options) > 0 {
+		ollamaReq["options"] = options
+	}
+
+	// 出错时同样返回 result，记录失败请求需要其中的服务器和耗时
+	result := &chatResult{ID: uuid.New().String()}
+	startTime := time.Now()
+	defer func() {
+		result.LatencyMs = time.Since(startTime).Milliseconds()
+	}()
+
+	cacheReq := cacheRequest{Kind: "chat", Model: target.Model, Input: messages, Options: options}
+	if n := len(messages); n > 0 {
+		cacheReq.Context, cacheReq.Text = messages[:n-1], messages[n-1].Content
+	}
+	lookup := lookupCache(c, h.Cache, h.Pool, h.MetricsCollector, cacheReq)
+	if lookup != nil && lookup.Entry != nil {
+		cached := lookup.Entry
+		if cached.Model != target.Model {
+			target = target.Fallback(cached.Model)
+		}
+		result.Model, result.Alias, result.Server = target.Model, target.Alias, cacheServer
+		result.ResponseModel = responseModel(model, target)
+		result.Cache = lookup
+		result.Content = cached.Response
+		result.PromptEvalCount = cached.PromptTokens
+		result.EvalCount = cached.CompletionTokens
+		result.DoneReason = cached.DoneReason
+		if onDelta != nil {
+			for _, chunk := range cache.Chunks(cached.Response) {
+				onDelta(result.ResponseModel, chunk)
+			}
+		}
+		return result, nil
+	}
+
+	resp, target, err := postWithFallback(queueContext(c, "api"), h.Pool, h.MetricsCollector, target, "/api/chat", ollamaReq)
+	result.Model, result.Alias = target.Model, target.Alias
+	result.ResponseModel = responseModel(model, target)
+	if err != nil {
+		result.Server = failedServer(err)
+		return result, contextError(ctx, err)
+	}
+	defer resp.Body.Close()
+	result.Server = resp.Server.Name
+
+	var fullResponse strings.Builder
+	decoder := json.NewDecoder(resp.Body)
+	for decoder.More() {
+		var chunk struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			Error           string `json:"error"`
+			Done            bool   `json:"done"`
+			DoneReason      string `json:"done_reason"`
+			PromptEvalCount int    `json:"prompt_eval_count"`
+			EvalCount       int    `json:"eval_count"`
+		}
+		if err := decoder.Decode(&chunk); err != nil {
+			result.Content = fullResponse.String()
+			return result, contextError(ctx, decodeError(err))
+		}
+		if chunk.Error != "" {
+			result.Content = fullResponse.String()
+			return result, upstreamStreamError(chunk.Error)
+		}
+
+		if chunk.Message.Content != "" {
+			if result.FirstTokenMs == 0 {
+				result.FirstTokenMs = time.Since(startTime).Milliseconds()
+			}
+			fullResponse.WriteString(chunk.Message.Content)
+			if onDelta != nil {
+				onDelta(result.ResponseModel, chunk.Message.Content)
+			}
+		}
+
+		// 更新token计数
+		if chunk.PromptEvalCount > 0 {
+			result.PromptEvalCount = chunk.PromptEvalCount
+		}
+		if chunk.EvalCount > 0 {
+			result.EvalCount = chunk.EvalCount
+		}
+		if chunk.Done {
+			result.DoneReason = chunk.DoneReason
+		}
+	}
+
+	result.Content = fullResponse.String()
+	lookup.store(h.Cache, result.ID, &cache.Entry{
+		Model:            result.Model,
+		Response:         result.Content,
+		PromptTokens:     result.PromptEvalCount,
+		CompletionTokens: result.EvalCount,
+		DoneReason:       result.DoneReason,
+	})
+	return result, nil
+}
+
+// recordChat 记录一次聊天调用的指标并保存到存储，err 不为空时记为失败
+func (h *ChatHandler) recordChat(userID, model, prompt, source string, result *chatResult, err error) {
+	if result.Model != "" {
+		model = result.Model
+	}
+	rec := &requestRecord{
+		ID:           result.ID,
+		UserID:       userID,
+		Model:        model,
+		Alias:        result.Alias,
+		Server:       result.Server,
+		Source:       source,
+		Prompt:       prompt,
+		Response:     result.Content,
+		TokensIn:     result.PromptEvalCount,
+		TokensOut:    result.EvalCount,
+		LatencyMs:    result.LatencyMs,
+		FirstTokenMs: result.FirstTokenMs,
+		Err:          err,
+	}
+	// 命中缓存的请求没有经过上游，不计 token
+	if result.Cache != nil {
+		result.Cache.apply(rec)
+		rec.TokensIn, rec.TokensOut = 0, 0
+	}
+	recordRequest(h.Storage, h.MetricsCollector, rec)
+}
+
 // HandleGetHistory handles GET /api/history requests
 func (h *ChatHandler) HandleGetHistory(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/config"
+	"llm-fw/ollama"
+	"llm-fw/storage"
+	"llm-fw/types"
+)
+
+// slowUpstream 模拟一个只返回一段内容后就不再输出的 Ollama 服务器，上游请求被取消时关闭 cancelled
+func slowUpstream(cancelled chan<- struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(`{"message":{"role":"assistant","content":"partial"},"done":false}` + "\n"))
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(10 * time.Second):
+		}
+	}))
+}
+
+// waitFor 轮询 cond 直到返回 true 或超时
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestChatClientDisconnectCancelsUpstream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cancelled := make(chan struct{})
+	upstream := slowUpstream(cancelled)
+	defer upstream.Close()
+
+	pool, err := ollama.NewPool([]config.OllamaServer{{Name: "slow", URL: upstream.URL}}, config.BalanceRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewFileStorageImpl(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	router := gin.New()
+	router.POST("/api/chat", NewChatHandler(store, pool, &types.NoopMetricsCollector{}).Chat)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	baseline := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	body := `{"model":"llama3","messages":[{"role":"user","content":"hi"}]}`
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/api/chat", strings.NewReader(body))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 收到第一段内容后断开客户端
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.Contains(line, "partial") {
+		t.Fatalf("unexpected first line %q: %v", line, err)
+	}
+	cancel()
+	resp.Body.Close()
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request was not cancelled after the client disconnected")
+	}
+
+	var saved []*types.Request
+	if !waitFor(t, 5*time.Second, func() bool {
+		saved, _ = store.GetAllRequests()
+		return len(saved) == 1
+	}) {
+		t.Fatalf("expected 1 saved request, got %d", len(saved))
+	}
+	if saved[0].Status != 1 || saved[0].ErrorType != types.ErrorTypeCancelled || saved[0].Response != "partial" {
+		t.Fatalf("unexpected saved request: status=%d error_type=%q response=%q", saved[0].Status, saved[0].ErrorType, saved[0].Response)
+	}
+
+	client.CloseIdleConnections()
+	if !waitFor(t, 5*time.Second, func() bool { return runtime.NumGoroutine() <= baseline }) {
+		buf := make([]byte, 1<<16)
+		n := runtime.Stack(buf, true)
+		t.Fatalf("goroutines leaked: %d before, %d after\n%s", baseline, runtime.NumGoroutine(), buf[:n])
+	}
+}
+
+// depthReporter 记录调度器上报的队列长度
+type depthReporter struct {
+	depth int32
+}
+
+func (r *depthReporter) UpdateQueueDepth(model string, depth int) {
+	atomic.StoreInt32(&r.depth, int32(depth))
+}
+
+func (r *depthReporter) ObserveQueueWait(model, priority string, wait time.Duration, result string) {}
+
+func TestChatQueueErrorsReturn503(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cancelled := make(chan struct{})
+	upstream := slowUpstream(cancelled)
+	defer upstream.Close()
+
+	pool, err := ollama.NewPool([]config.OllamaServer{{Name: "slow", URL: upstream.URL}}, config.BalanceRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter := &depthReporter{}
+	pool.EnableScheduler(config.Scheduler{MaxConcurrent: 1, QueueSize: 1, QueueTimeout: 300 * time.Millisecond}, reporter)
+	store, err := storage.NewFileStorageImpl(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	router := gin.New()
+	router.POST("/api/chat", NewChatHandler(store, pool, &types.NoopMetricsCollector{}).Chat)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	body := `{"model":"llama3","messages":[{"role":"user","content":"hi"}]}`
+	post := func(ctx context.Context) (*http.Response, error) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/api/chat", strings.NewReader(body))
+		return http.DefaultClient.Do(req)
+	}
+	expect503 := func(resp *http.Response, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var errResp struct {
+			Type string `json:"type"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		if resp.StatusCode != http.StatusServiceUnavailable || errResp.Type != types.ErrorTypeOverloaded {
+			t.Fatalf("status %d, error type %q, want 503 %s", resp.StatusCode, errResp.Type, types.ErrorTypeOverloaded)
+		}
+	}
+
+	// 第一个请求占用唯一的名额
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first, err := post(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Body.Close()
+	if line, err := bufio.NewReader(first.Body).ReadString('\n'); err != nil || !strings.Contains(line, "partial") {
+		t.Fatalf("unexpected first line %q: %v", line, err)
+	}
+
+	// 第二个请求排队，队列已满时第三个请求立即返回 503，第二个请求排队超时后返回 503
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	queued := make(chan result, 1)
+	go func() {
+		resp, err := post(context.Background())
+		queued <- result{resp, err}
+	}()
+	if !waitFor(t, 2*time.Second, func() bool { return atomic.LoadInt32(&reporter.depth) == 1 }) {
+		t.Fatal("second request was not queued")
+	}
+	expect503(post(context.Background()))
+	second := <-queued
+	expect503(second.resp, second.err)
+
+	cancel()
+	<-cancelled
+}
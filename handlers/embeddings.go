@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/auth"
+	"llm-fw/ollama"
+	"llm-fw/types"
+)
+
+// EmbeddingRequest 定义了 OpenAI 兼容的嵌入请求结构
+type EmbeddingRequest struct {
+	Model          string         `json:"model"`
+	Input          EmbeddingInput `json:"input"`
+	EncodingFormat string         `json:"encoding_format,omitempty"`
+	Dimensions     int            `json:"dimensions,omitempty"`
+	User           string         `json:"user,omitempty"`
+}
+
+// EmbeddingInput 表示嵌入输入，可以是单个字符串或字符串数组
+type EmbeddingInput []string
+
+// UnmarshalJSON 同时支持字符串和字符串数组两种格式
+func (e *EmbeddingInput) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*e = EmbeddingInput{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("input must be a string or an array of strings")
+	}
+	*e = multiple
+	return nil
+}
+
+// EmbeddingData 定义了一个嵌入结果
+type EmbeddingData struct {
+	Object    string      `json:"object"`
+	Index     int         `json:"index"`
+	Embedding interface{} `json:"embedding"`
+}
+
+// EmbeddingResponse 定义了 OpenAI 兼容的嵌入响应结构
+type EmbeddingResponse struct {
+	Object string          `json:"object"`
+	Data   []EmbeddingData `json:"data"`
+	Model  string          `json:"model"`
+	Usage  struct {
+		PromptTokens int `json:"prompt_tokens"`
+		TotalTokens  int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// ollamaEmbedResponse 定义了 Ollama /api/embed 的响应结构
+type ollamaEmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float64 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+// EmbeddingHandler 处理嵌入相关的请求
+type EmbeddingHandler struct {
+	Pool             *ollama.Pool
+	Storage          types.Storage
+	MetricsCollector MetricsCollector
+}
+
+// NewEmbeddingHandler 创建一个新的嵌入处理器
+func NewEmbeddingHandler(pool *ollama.Pool, storage types.Storage, metricsCollector MetricsCollector) *EmbeddingHandler {
+	return &EmbeddingHandler{
+		Pool:             pool,
+		Storage:          storage,
+		MetricsCollector: metricsCollector,
+	}
+}
+
+// Embeddings 处理 OpenAI 兼容的 /v1/embeddings 请求
+func (h *EmbeddingHandler) Embeddings(c *gin.Context) {
+	var req EmbeddingRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		openAIError(c, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err), "invalid_request_error")
+		return
+	}
+
+	// 验证请求
+	if req.Model == "" {
+		openAIError(c, http.StatusBadRequest, "model is required", "invalid_request_error")
+		return
+	}
+	if len(req.Input) == 0 {
+		openAIError(c, http.StatusBadRequest, "input must not be empty", "invalid_request_error")
+		return
+	}
+	if req.EncodingFormat != "" && req.EncodingFormat != "float" && req.EncodingFormat != "base64" {
+		openAIError(c, http.StatusBadRequest, "encoding_format must be float or base64", "invalid_request_error")
+		return
+	}
+
+	userID := auth.Identity(c)
+
+	startTime := time.Now()
+
+	// 按路由规则解析实际转发的模型和服务器
+	target := h.Pool.Resolve(req.Model)
+	ollamaReq := map[string]interface{}{
+		"input": []string(req.Input),
+	}
+	if req.Dimensions > 0 {
+		ollamaReq["dimensions"] = req.Dimensions
+	}
+
+	// 失败的调用同样记录到指标和存储
+	rec := &requestRecord{
+		UserID: userID,
+		Source: "api",
+		Prompt: strings.Join(req.Input, "\n"),
+	}
+	recordFailure := func(err error) {
+		rec.LatencyMs = time.Since(startTime).Milliseconds()
+		rec.Err = err
+		recordRequest(h.Storage, h.MetricsCollector, rec)
+	}
+
+	// 从上游服务器池中选择服务器发起请求，失败时按降级链尝试下一个模型。
+	// 上游请求与客户端请求绑定，客户端断开时取消上游生成
+	ctx := c.Request.Context()
+	resp, target, err := postWithFallback(ctx, h.Pool, h.MetricsCollector, target, "/api/embed", ollamaReq)
+	rec.Model, rec.Alias = target.Model, target.Alias
+	if err != nil {
+		log.Printf("Failed to call Ollama API: %v", err)
+		upstreamErr := classifyError(contextError(ctx, err))
+		rec.Server = failedServer(err)
+		recordFailure(upstreamErr)
+		writeOpenAIError(c, upstreamErr)
+		return
+	}
+	defer resp.Body.Close()
+	rec.Server = resp.Server.Name
+	model := responseModel(req.Model, target)
+
+	var ollamaResp ollamaEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
+		log.Printf("Failed to decode embedding response: %v", err)
+		err = contextError(ctx, decodeError(err))
+		recordFailure(err)
+		writeOpenAIError(c, err)
+		return
+	}
+
+	// 更新指标并保存到存储
+	dimensions := 0
+	if len(ollamaResp.Embeddings) > 0 {
+		dimensions = len(ollamaResp.Embeddings[0])
+	}
+	rec.Response = fmt.Sprintf("[%d embeddings, %d dimensions]", len(ollamaResp.Embeddings), dimensions)
+	rec.TokensIn = ollamaResp.PromptEvalCount
+	rec.LatencyMs = time.Since(startTime).Milliseconds()
+	recordRequest(h.Storage, h.MetricsCollector, rec)
+
+	response := EmbeddingResponse{
+		Object: "list",
+		Data:   make([]EmbeddingData, len(ollamaResp.Embeddings)),
+		Model:  model,
+	}
+	for i, embedding := range ollamaResp.Embeddings {
+		response.Data[i] = EmbeddingData{
+			Object:    "embedding",
+			Index:     i,
+			Embedding: encodeEmbedding(embedding, req.EncodingFormat),
+		}
+	}
+	response.Usage.PromptTokens = ollamaResp.PromptEvalCount
+	response.Usage.TotalTokens = ollamaResp.PromptEvalCount
+
+	c.JSON(http.StatusOK, response)
+}
+
+// encodeEmbedding 按 encoding_format 编码向量，base64 格式为小端 float32 数组
+func encodeEmbedding(embedding []float64, format string) interface{} {
+	if format != "base64" {
+		return embedding
+	}
+
+	buf := make([]byte, 4*len(embedding))
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(float32(v)))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
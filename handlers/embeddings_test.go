@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+
+	"llm-fw/types"
+)
+
+// ollamaEmbed 为每段输入返回一个以输入长度开头的三维向量，prompt_eval_count 为每段输入 4 个 token
+func ollamaEmbed(w http.ResponseWriter, call upstreamCall) {
+	input, _ := call.Body["input"].([]interface{})
+	embeddings := make([][]float64, len(input))
+	for i, text := range input {
+		embeddings[i] = []float64{float64(len(text.(string))), 0.5, -0.25}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"model":             call.Body["model"],
+		"embeddings":        embeddings,
+		"prompt_eval_count": 4 * len(input),
+	})
+}
+
+func TestEmbeddings(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		inputs []string // 上游收到的 input
+	}{
+		{name: "string input", input: `"hello"`, inputs: []string{"hello"}},
+		{name: "array input", input: `["hello", "good morning"]`, inputs: []string{"hello", "good morning"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := newAPITest(t, ollamaEmbed)
+
+			w := at.post("/v1/embeddings", `{"model":"nomic-embed-text","input":`+tt.input+`,"dimensions":3}`)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body.String())
+			}
+
+			// Ollama /api/embed 总是收到数组
+			calls := at.upstreamCalls()
+			if len(calls) != 1 || calls[0].Path != "/api/embed" || calls[0].Body["model"] != "nomic-embed-text" || calls[0].Body["dimensions"] != float64(3) {
+				t.Fatalf("upstream calls: %+v", calls)
+			}
+			input, _ := json.Marshal(calls[0].Body["input"])
+			if want, _ := json.Marshal(tt.inputs); string(input) != string(want) {
+				t.Fatalf("upstream input %s, want %s", input, want)
+			}
+
+			var resp struct {
+				Object string `json:"object"`
+				Model  string `json:"model"`
+				Data   []struct {
+					Object    string    `json:"object"`
+					Index     int       `json:"index"`
+					Embedding []float64 `json:"embedding"`
+				} `json:"data"`
+				Usage struct {
+					PromptTokens int `json:"prompt_tokens"`
+					TotalTokens  int `json:"total_tokens"`
+				} `json:"usage"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Object != "list" || resp.Model != "nomic-embed-text" || len(resp.Data) != len(tt.inputs) {
+				t.Fatalf("unexpected response: %s", w.Body.String())
+			}
+			for i, data := range resp.Data {
+				if data.Object != "embedding" || data.Index != i || len(data.Embedding) != 3 || data.Embedding[0] != float64(len(tt.inputs[i])) {
+					t.Fatalf("unexpected embedding %d: %+v", i, data)
+				}
+			}
+			tokens := 4 * len(tt.inputs)
+			if resp.Usage.PromptTokens != tokens || resp.Usage.TotalTokens != tokens {
+				t.Fatalf("unexpected usage: %+v", resp.Usage)
+			}
+
+			// 请求记录计入输入 token，没有输出 token
+			rec := at.lastRecord(t)
+			if rec.Model != "nomic-embed-text" || rec.TokensIn != tokens || rec.TokensOut != 0 || rec.Prompt != strings.Join(tt.inputs, "\n") || rec.Status != 0 {
+				t.Fatalf("unexpected record: %+v", rec)
+			}
+		})
+	}
+}
+
+func TestEmbeddingsBase64(t *testing.T) {
+	at := newAPITest(t, ollamaEmbed)
+
+	w := at.post("/v1/embeddings", `{"model":"nomic-embed-text","input":"hi","encoding_format":"base64"}`)
+	var resp struct {
+		Data []struct {
+			Embedding string `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Data) != 1 {
+		t.Fatalf("unexpected response %s: %v", w.Body.String(), err)
+	}
+
+	// 小端 float32 数组
+	raw, err := base64.StdEncoding.DecodeString(resp.Data[0].Embedding)
+	if err != nil || len(raw) != 12 {
+		t.Fatalf("decoded %d bytes: %v", len(raw), err)
+	}
+	for i, want := range []float32{2, 0.5, -0.25} {
+		if got := math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:])); got != want {
+			t.Fatalf("component %d = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestEmbeddingsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(http.ResponseWriter, upstreamCall)
+		body    string
+		status  int
+		errType string
+		record  string // 请求记录的失败原因，为空表示不调用上游也不记录
+	}{
+		{name: "missing model", body: `{"input":"hi"}`, status: http.StatusBadRequest, errType: "invalid_request_error"},
+		{name: "empty input", body: `{"model":"nomic-embed-text","input":[]}`, status: http.StatusBadRequest, errType: "invalid_request_error"},
+		{name: "invalid input", body: `{"model":"nomic-embed-text","input":42}`, status: http.StatusBadRequest, errType: "invalid_request_error"},
+		{name: "invalid encoding", body: `{"model":"nomic-embed-text","input":"hi","encoding_format":"int8"}`, status: http.StatusBadRequest, errType: "invalid_request_error"},
+		{
+			name:    "model not found",
+			respond: ollamaStatus(http.StatusNotFound, `model "nomic-embed-text" not found, try pulling it first`),
+			body:    `{"model":"nomic-embed-text","input":"hi"}`,
+			status:  http.StatusNotFound,
+			errType: "invalid_request_error",
+			record:  types.ErrorTypeModelNotFound,
+		},
+		{
+			name:    "model does not support embeddings",
+			respond: ollamaStatus(http.StatusBadRequest, `"llama3" does not support embeddings`),
+			body:    `{"model":"llama3","input":"hi"}`,
+			status:  http.StatusBadRequest,
+			errType: "invalid_request_error",
+			record:  types.ErrorTypeUpstream4xx,
+		},
+		{
+			name: "invalid upstream response",
+			respond: func(w http.ResponseWriter, call upstreamCall) {
+				w.Write([]byte(`{"embeddings":`))
+			},
+			body:    `{"model":"nomic-embed-text","input":"hi"}`,
+			status:  http.StatusBadGateway,
+			errType: "api_error",
+			record:  types.ErrorTypeDecode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			respond := tt.respond
+			if respond == nil {
+				respond = ollamaEmbed
+			}
+			at := newAPITest(t, respond)
+
+			w := at.post("/v1/embeddings", tt.body)
+			var envelope openAIErrorEnvelope
+			if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.status || envelope.Error.Type != tt.errType || envelope.Error.Message == "" {
+				t.Fatalf("status %d: %s", w.Code, w.Body.String())
+			}
+
+			recent, err := at.store.GetRecentRequests(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.record == "" {
+				if len(recent) != 0 || len(at.upstreamCalls()) != 0 {
+					t.Fatalf("invalid request reached the upstream or was recorded: %+v", recent)
+				}
+				return
+			}
+			if len(recent) != 1 || recent[0].ErrorType != tt.record || recent[0].Status != 1 {
+				t.Fatalf("unexpected records: %+v", recent)
+			}
+		})
+	}
+}
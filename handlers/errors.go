@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"llm-fw/ollama"
+	"llm-fw/types"
+)
+
+// statusClientClosedRequest 是客户端取消请求时使用的非标准状态码，与 nginx 一致
+const statusClientClosedRequest = 499
+
+// ErrInterrupted 是服务关闭时取消未完成请求的原因，作为请求 context 的取消原因（context.Cause）传入
+var ErrInterrupted = errors.New("request interrupted by server shutdown")
+
+// upstreamError 表示一次失败的上游调用及其原因分类
+type upstreamError struct {
+	Type    string // types.ErrorType* 之一
+	Status  int    // 上游返回的 HTTP 状态码，没有收到响应时为 0
+	Message string // 上游返回的错误信息
+	Err     error
+}
+
+func (e *upstreamError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *upstreamError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatus 返回应答给客户端的状态码，上游返回的状态码原样透传
+func (e *upstreamError) HTTPStatus() int {
+	switch e.Type {
+	case types.ErrorTypeModelNotFound, types.ErrorTypeUpstream4xx, types.ErrorTypeUpstream5xx:
+		if e.Status != 0 {
+			return e.Status
+		}
+		return http.StatusBadGateway
+	case types.ErrorTypeConnection, types.ErrorTypeDecode:
+		return http.StatusBadGateway
+	case types.ErrorTypeUnavailable, types.ErrorTypeOverloaded, types.ErrorTypeInterrupted:
+		return http.StatusServiceUnavailable
+	case types.ErrorTypeTimeout:
+		return http.StatusGatewayTimeout
+	case types.ErrorTypeCancelled:
+		return statusClientClosedRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// ClientMessage 返回应答给客户端的错误信息，上游的错误信息原样透传，其他错误不暴露内部细节
+func (e *upstreamError) ClientMessage() string {
+	switch e.Type {
+	case types.ErrorTypeModelNotFound, types.ErrorTypeUpstream4xx, types.ErrorTypeUpstream5xx:
+		if e.Message != "" {
+			return e.Message
+		}
+		return http.StatusText(e.HTTPStatus())
+	case types.ErrorTypeConnection:
+		return "Failed to connect to Ollama server"
+	case types.ErrorTypeUnavailable, types.ErrorTypeOverloaded:
+		return e.Err.Error()
+	case types.ErrorTypeDecode:
+		return "Failed to decode Ollama response"
+	case types.ErrorTypeTimeout:
+		return "Ollama request timed out"
+	case types.ErrorTypeCancelled:
+		return "Request cancelled"
+	case types.ErrorTypeInterrupted:
+		return "Server is shutting down"
+	}
+	return "Internal server error"
+}
+
+// classifyError 按错误原因分类，已经分类过的错误原样返回
+func classifyError(err error) *upstreamError {
+	var upstreamErr *upstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr
+	}
+
+	errorType := types.ErrorTypeInternal
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, ErrInterrupted):
+		errorType = types.ErrorTypeInterrupted
+	case errors.Is(err, context.Canceled):
+		errorType = types.ErrorTypeCancelled
+	case errors.Is(err, ollama.ErrQueueFull), errors.Is(err, ollama.ErrQueueTimeout):
+		errorType = types.ErrorTypeOverloaded
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		errorType = types.ErrorTypeTimeout
+	case errors.Is(err, ollama.ErrNoServer):
+		errorType = types.ErrorTypeUnavailable
+	case errors.Is(err, syscall.ECONNREFUSED), errors.As(err, &opErr):
+		errorType = types.ErrorTypeConnection
+	}
+	return &upstreamError{Type: errorType, Err: err}
+}
+
+// decodeError 分类读取上游响应时的错误，连接中断、超时等原因优先于解析失败
+func decodeError(err error) *upstreamError {
+	upstreamErr := classifyError(fmt.Errorf("failed to decode response chunk: %w", err))
+	if upstreamErr.Type == types.ErrorTypeInternal {
+		upstreamErr.Type = types.ErrorTypeDecode
+	}
+	return upstreamErr
+}
+
+// upstreamStatusError 根据上游返回的非 200 响应创建错误，并读取 Ollama 的 {"error": "..."} 响应体
+func upstreamStatusError(resp *http.Response) *upstreamError {
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+
+	errorType := types.ErrorTypeUpstream5xx
+	if resp.StatusCode < http.StatusInternalServerError {
+		errorType = types.ErrorTypeUpstream4xx
+		if resp.StatusCode == http.StatusNotFound && strings.Contains(body.Error, "not found") {
+			errorType = types.ErrorTypeModelNotFound
+		}
+	}
+	return &upstreamError{
+		Type:    errorType,
+		Status:  resp.StatusCode,
+		Message: body.Error,
+		Err:     fmt.Errorf("ollama returned status %d: %s", resp.StatusCode, body.Error),
+	}
+}
+
+// upstreamStreamError 根据流式响应中的 {"error": "..."} 创建错误
+func upstreamStreamError(message string) *upstreamError {
+	return &upstreamError{
+		Type:    types.ErrorTypeUpstream5xx,
+		Message: message,
+		Err:     fmt.Errorf("ollama returned error: %s", message),
+	}
+}
+
+// writeError 以原生接口的错误格式 {"error": "...", "type": "..."} 返回上游错误
+func writeError(c *gin.Context, err error) {
+	upstreamErr := classifyError(err)
+	c.JSON(upstreamErr.HTTPStatus(), gin.H{
+		"error": upstreamErr.ClientMessage(),
+		"type":  upstreamErr.Type,
+	})
+}
+
+// openAIErrorType 返回上游错误对应的 OpenAI 错误类型
+func openAIErrorType(upstreamErr *upstreamError) string {
+	if status := upstreamErr.HTTPStatus(); status >= 400 && status < 500 && status != statusClientClosedRequest {
+		return "invalid_request_error"
+	}
+	return "api_error"
+}
+
+// openAIErrorBody 以 OpenAI 的错误格式描述上游错误，code 为失败原因分类
+func openAIErrorBody(upstreamErr *upstreamError) gin.H {
+	return gin.H{
+		"error": gin.H{
+			"message": upstreamErr.ClientMessage(),
+			"type":    openAIErrorType(upstreamErr),
+			"param":   nil,
+			"code":    upstreamErr.Type,
+		},
+	}
+}
+
+// writeOpenAIError 以 OpenAI 的错误格式返回上游错误
+func writeOpenAIError(c *gin.Context, err error) {
+	upstreamErr := classifyError(err)
+	c.JSON(upstreamErr.HTTPStatus(), openAIErrorBody(upstreamErr))
+}
+
+// anthropicErrorType 返回上游错误对应的 Anthropic 错误类型
+func anthropicErrorType(upstreamErr *upstreamError) string {
+	switch status := upstreamErr.HTTPStatus(); {
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == http.StatusServiceUnavailable:
+		return "overloaded_error"
+	case status >= 400 && status < 500 && status != statusClientClosedRequest:
+		return "invalid_request_error"
+	}
+	return "api_error"
+}
+
+// writeAnthropicError 以 Anthropic 的错误格式返回上游错误
+func writeAnthropicError(c *gin.Context, err error) {
+	upstreamErr := classifyError(err)
+	anthropicError(c, upstreamErr.HTTPStatus(), upstreamErr.ClientMessage(), anthropicErrorType(upstreamErr))
+}
+
+// requestRecord 描述一次需要记录的上游调用，Err 不为空时记为失败
+type requestRecord struct {
+	ID           string // 为空时自动生成
+	UserID       string
+	Model        string
+	Alias        string // 命中路由规则时客户端请求的模型名
+	Server       string
+	Source       string
+	Prompt       string
+	Response     string
+	TokensIn     int
+	TokensOut    int
+	LatencyMs    int64
+	FirstTokenMs int64
+	Err          error
+
+	CacheSource     string  // 命中缓存时生成该响应的请求 ID
+	CacheSimilarity float64 // 命中语义缓存时的相似度
+}
+
+// recordRequest 更新指标并保存请求记录，成功和失败的调用都通过这里记录
+func recordRequest(storage types.Storage, collector MetricsCollector, rec *requestRecord) {
+	obs := &types.RequestObservation{
+		Model:        rec.Model,
+		Server:       rec.Server,
+		Source:       rec.Source,
+		Status:       types.RequestStatusSuccess,
+		TokensIn:     int64(rec.TokensIn),
+		TokensOut:    int64(rec.TokensOut),
+		LatencyMs:    rec.LatencyMs,
+		FirstTokenMs: rec.FirstTokenMs,
+	}
+	storageReq := &types.Request{
+		ID:        rec.ID,
+		UserID:    rec.UserID,
+		Model:     rec.Model,
+		Alias:     rec.Alias,
+		Prompt:    rec.Prompt,
+		Response:  rec.Response,
+		TokensIn:  rec.TokensIn,
+		TokensOut: rec.TokensOut,
+		Server:    rec.Server,
+		LatencyMs: float64(rec.LatencyMs),
+		Timestamp: time.Now(),
+		Source:    rec.Source,
+
+		CacheSource:     rec.CacheSource,
+		CacheSimilarity: rec.CacheSimilarity,
+	}
+	if storageReq.ID == "" {
+		storageReq.ID = uuid.New().String()
+	}
+
+	if rec.Err != nil {
+		upstreamErr := classifyError(rec.Err)
+		obs.Status = types.RequestStatusError
+		obs.ErrorType = upstreamErr.Type
+		storageReq.Status = 1
+		storageReq.Error = upstreamErr.Error()
+		storageReq.ErrorType = upstreamErr.Type
+	}
+
+	// 命中缓存的请求没有调用上游，只通过 ObserveCache 计数，不计入上游的请求数、延迟和 token 指标
+	if rec.Server != cacheServer {
+		collector.ObserveRequest(obs)
+	}
+	if err := storage.SaveRequest(storageReq); err != nil {
+		log.Printf("Failed to save %s request: %v", rec.Model, err)
+	}
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"llm-fw/ollama"
+	"llm-fw/types"
+)
+
+// timeoutError 是 Timeout() 为 true 的网络错误
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}
+
+	tests := []struct {
+		name    string
+		err     error
+		errType string
+		status  int
+	}{
+		{name: "interrupted", err: fmt.Errorf("request: %w", ErrInterrupted), errType: types.ErrorTypeInterrupted, status: http.StatusServiceUnavailable},
+		// 服务关闭取消请求时 context 同时是 Canceled，按关闭处理
+		{name: "interrupted while cancelled", err: errors.Join(context.Canceled, ErrInterrupted), errType: types.ErrorTypeInterrupted, status: http.StatusServiceUnavailable},
+		{name: "cancelled", err: fmt.Errorf("post: %w", context.Canceled), errType: types.ErrorTypeCancelled, status: statusClientClosedRequest},
+		{name: "queue full", err: ollama.ErrQueueFull, errType: types.ErrorTypeOverloaded, status: http.StatusServiceUnavailable},
+		{name: "queue timeout", err: fmt.Errorf("llama3: %w", ollama.ErrQueueTimeout), errType: types.ErrorTypeOverloaded, status: http.StatusServiceUnavailable},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, errType: types.ErrorTypeTimeout, status: http.StatusGatewayTimeout},
+		{name: "network timeout", err: &url.Error{Op: "Post", URL: "http://ollama:11434/api/chat", Err: timeoutError{}}, errType: types.ErrorTypeTimeout, status: http.StatusGatewayTimeout},
+		{name: "no server", err: fmt.Errorf("llama3: %w", ollama.ErrNoServer), errType: types.ErrorTypeUnavailable, status: http.StatusServiceUnavailable},
+		{name: "connection refused", err: &url.Error{Op: "Post", URL: "http://ollama:11434/api/chat", Err: refused}, errType: types.ErrorTypeConnection, status: http.StatusBadGateway},
+		{name: "connection reset", err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, errType: types.ErrorTypeConnection, status: http.StatusBadGateway},
+		{name: "other", err: errors.New("boom"), errType: types.ErrorTypeInternal, status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamErr := classifyError(tt.err)
+			if upstreamErr.Type != tt.errType || upstreamErr.HTTPStatus() != tt.status {
+				t.Fatalf("classified as %s (%d), want %s (%d)", upstreamErr.Type, upstreamErr.HTTPStatus(), tt.errType, tt.status)
+			}
+			if !errors.Is(upstreamErr, tt.err) {
+				t.Fatalf("classified error does not wrap %v", tt.err)
+			}
+		})
+	}
+}
+
+func TestClassifyErrorClassified(t *testing.T) {
+	// 已经分类过的错误即使被包装也原样返回
+	classified := &upstreamError{Type: types.ErrorTypeModelNotFound, Status: http.StatusNotFound, Err: errors.New("not found")}
+	if got := classifyError(fmt.Errorf("chat: %w", classified)); got != classified {
+		t.Fatalf("classifyError returned %+v, want the wrapped error", got)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	// 解析失败记为 decode，连接中断和超时保留原来的分类
+	tests := []struct {
+		err     error
+		errType string
+	}{
+		{err: io.ErrUnexpectedEOF, errType: types.ErrorTypeDecode},
+		{err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, errType: types.ErrorTypeConnection},
+		{err: timeoutError{}, errType: types.ErrorTypeTimeout},
+		{err: context.Canceled, errType: types.ErrorTypeCancelled},
+	}
+
+	for _, tt := range tests {
+		if got := decodeError(tt.err).Type; got != tt.errType {
+			t.Errorf("decodeError(%v) = %s, want %s", tt.err, got, tt.errType)
+		}
+	}
+}
+
+func TestUpstreamStatusError(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		errType string
+		message string // 返回给客户端的错误信息
+	}{
+		{name: "model not found", status: http.StatusNotFound, body: `{"error":"model \"llama3\" not found, try pulling it first"}`, errType: types.ErrorTypeModelNotFound, message: `model "llama3" not found, try pulling it first`},
+		// 只有 Ollama 报告模型不存在的 404 才是 model_not_found
+		{name: "other not found", status: http.StatusNotFound, body: "404 page not found", errType: types.ErrorTypeUpstream4xx, message: "Not Found"},
+		{name: "bad request", status: http.StatusBadRequest, body: `{"error":"invalid options"}`, errType: types.ErrorTypeUpstream4xx, message: "invalid options"},
+		{name: "server error", status: http.StatusInternalServerError, body: `{"error":"CUDA out of memory"}`, errType: types.ErrorTypeUpstream5xx, message: "CUDA out of memory"},
+		{name: "empty body", status: http.StatusBadGateway, errType: types.ErrorTypeUpstream5xx, message: "Bad Gateway"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamErr := upstreamStatusError(&http.Response{StatusCode: tt.status, Body: io.NopCloser(strings.NewReader(tt.body))})
+			if upstreamErr.Type != tt.errType || upstreamErr.HTTPStatus() != tt.status || upstreamErr.ClientMessage() != tt.message {
+				t.Fatalf("got %s (%d) %q, want %s (%d) %q", upstreamErr.Type, upstreamErr.HTTPStatus(), upstreamErr.ClientMessage(), tt.errType, tt.status, tt.message)
+			}
+		})
+	}
+}
+
+func TestUpstreamStatusEnvelopes(t *testing.T) {
+	upstreams := []struct {
+		name          string
+		status        int
+		message       string
+		errType       string
+		openAIType    string
+		anthropicType string
+	}{
+		{name: "model not found", status: http.StatusNotFound, message: `model "llama3" not found, try pulling it first`, errType: types.ErrorTypeModelNotFound, openAIType: "invalid_request_error", anthropicType: "not_found_error"},
+		{name: "bad request", status: http.StatusBadRequest, message: "invalid options", errType: types.ErrorTypeUpstream4xx, openAIType: "invalid_request_error", anthropicType: "invalid_request_error"},
+		{name: "server error", status: http.StatusInternalServerError, message: "CUDA out of memory", errType: types.ErrorTypeUpstream5xx, openAIType: "api_error", anthropicType: "api_error"},
+		{name: "overloaded", status: http.StatusServiceUnavailable, message: "server busy, please try again", errType: types.ErrorTypeUpstream5xx, openAIType: "api_error", anthropicType: "overloaded_error"},
+	}
+
+	// 每种接口按自己的错误格式解析出错误信息和错误类型
+	clients := []struct {
+		name  string
+		path  string
+		body  string
+		parse func(t *testing.T, body []byte) (message, errType string)
+	}{
+		{
+			name:  "native chat",
+			path:  "/api/chat",
+			body:  `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"stream":false}`,
+			parse: parseNativeError,
+		},
+		{
+			name:  "native generate",
+			path:  "/api/generate",
+			body:  `{"model":"llama3","prompt":"Hi","stream":false}`,
+			parse: parseNativeError,
+		},
+		{
+			name: "openai",
+			path: "/v1/chat/completions",
+			body: `{"model":"llama3","messages":[{"role":"user","content":"Hi"}]}`,
+			parse: func(t *testing.T, body []byte) (string, string) {
+				var envelope openAIErrorEnvelope
+				if err := json.Unmarshal(body, &envelope); err != nil {
+					t.Fatal(err)
+				}
+				return envelope.Error.Message, fmt.Sprintf("%s/%v", envelope.Error.Type, envelope.Error.Code)
+			},
+		},
+		{
+			name: "anthropic",
+			path: "/v1/messages",
+			body: `{"model":"llama3","max_tokens":16,"messages":[{"role":"user","content":"Hi"}]}`,
+			parse: func(t *testing.T, body []byte) (string, string) {
+				var envelope anthropicErrorEnvelope
+				if err := json.Unmarshal(body, &envelope); err != nil || envelope.Type != "error" {
+					t.Fatalf("invalid error envelope %s: %v", body, err)
+				}
+				return envelope.Error.Message, envelope.Error.Type
+			},
+		},
+	}
+
+	for _, upstream := range upstreams {
+		for _, client := range clients {
+			t.Run(upstream.name+"/"+client.name, func(t *testing.T) {
+				at := newAPITest(t, ollamaStatus(upstream.status, upstream.message))
+
+				w := at.post(client.path, client.body)
+				if w.Code != upstream.status {
+					t.Fatalf("status %d, want %d: %s", w.Code, upstream.status, w.Body.String())
+				}
+				want := map[string]string{
+					"native chat":     upstream.errType,
+					"native generate": upstream.errType,
+					"openai":          upstream.openAIType + "/" + upstream.errType,
+					"anthropic":       upstream.anthropicType,
+				}[client.name]
+				if message, errType := client.parse(t, w.Body.Bytes()); message != upstream.message || errType != want {
+					t.Fatalf("error %q (%s), want %q (%s)", message, errType, upstream.message, want)
+				}
+				if rec := at.lastRecord(t); rec.Status != 1 || rec.ErrorType != upstream.errType {
+					t.Fatalf("unexpected record: %+v", rec)
+				}
+			})
+		}
+	}
+}
+
+// parseNativeError 解析原生接口的 {"error": "...", "type": "..."} 错误响应
+func parseNativeError(t *testing.T, body []byte) (string, string) {
+	t.Helper()
+	var envelope struct {
+		Error string `json:"error"`
+		Type  string `json:"type"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	return envelope.Error, envelope.Type
+}
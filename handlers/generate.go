@@ -1,9 +1,7 @@
 package handlers
 
 import (
-	"bytes"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"time"
@@ -11,6 +9,9 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
+	"llm-fw/auth"
+	"llm-fw/cache"
+	"llm-fw/ollama"
 	"llm-fw/types"
 )
 
@@ -19,7 +20,7 @@ type GenerateRequest struct {
 	Model       string   `json:"model" binding:"required"`
 	Prompt      string   `json:"prompt" binding:"required"`
 	MaxTokens   int      `json:"max_tokens,omitempty"`
-	Temperature float64  `json:"temperature,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"` // 未设置时为 0.7，0 表示确定性生成
 	TopP        float64  `json:"top_p,omitempty"`
 	N           int      `json:"n,omitempty"`
 	Stream      bool     `json:"stream,omitempty"`
@@ -53,15 +54,16 @@ type Usage struct {
 
 // GenerateHandler 处理生成相关的请求
 type GenerateHandler struct {
-	TargetURL        string
+	Pool             *ollama.Pool
 	Storage          types.Storage
 	MetricsCollector MetricsCollector
+	Cache            *cache.Cache // 为空时不缓存响应
 }
 
 // NewGenerateHandler 创建一个新的生成处理器
-func NewGenerateHandler(targetURL string, storage types.Storage, metricsCollector MetricsCollector) *GenerateHandler {
+func NewGenerateHandler(pool *ollama.Pool, storage types.Storage, metricsCollector MetricsCollector) *GenerateHandler {
 	return &GenerateHandler{
-		TargetURL:        targetURL,
+		Pool:             pool,
 		Storage:          storage,
 		MetricsCollector: metricsCollector,
 	}
@@ -87,8 +89,9 @@ func (h *GenerateHandler) Generate(c *gin.Context) {
 	if req.MaxTokens == 0 {
 		req.MaxTokens = 2048
 	}
-	if req.Temperature == 0 {
-		req.Temperature = 0.7
+	if req.Temperature == nil {
+		temperature := 0.7
+		req.Temperature = &temperature
 	}
 	if req.TopP == 0 {
 		req.TopP = 1
@@ -96,32 +99,21 @@ func (h *GenerateHandler) Generate(c *gin.Context) {
 
 	startTime := time.Now()
 
+	// 按路由规则解析实际转发的模型和服务器
+	target := h.Pool.Resolve(req.Model)
+
 	// 调用Ollama API
-	ollamaReq := map[string]interface{}{
-		"model":  req.Model,
-		"prompt": req.Prompt,
-		"stream": req.Stream,
-		"options": map[string]interface{}{
-			"num_predict": req.MaxTokens,
-			"temperature": req.Temperature,
-			"top_p":       req.TopP,
-			"stop":        req.Stop,
-		},
-	}
-
-	ollamaReqBody, err := json.Marshal(ollamaReq)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal request"})
-		return
+	options := map[string]interface{}{
+		"num_predict": req.MaxTokens,
+		"temperature": *req.Temperature,
+		"top_p":       req.TopP,
+		"stop":        req.Stop,
 	}
-
-	resp, err := http.Post(fmt.Sprintf("%s/api/generate", h.TargetURL), "application/json", bytes.NewBuffer(ollamaReqBody))
-	if err != nil {
-		log.Printf("Failed to call Ollama API: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to call Ollama API"})
-		return
+	ollamaReq := map[string]interface{}{
+		"prompt":  req.Prompt,
+		"stream":  req.Stream,
+		"options": options,
 	}
-	defer resp.Body.Close()
 
 	// 创建响应
 	response := GenerateResponse{
@@ -132,38 +124,119 @@ func (h *GenerateHandler) Generate(c *gin.Context) {
 		Choices: make([]Choice, req.N),
 	}
 
+	// 失败的调用同样记录到指标和存储
+	rec := &requestRecord{
+		ID:     response.ID,
+		UserID: auth.Identity(c),
+		Source: "api",
+		Prompt: req.Prompt,
+	}
+	recordFailure := func(err error) {
+		rec.LatencyMs = time.Since(startTime).Milliseconds()
+		rec.Err = err
+		recordRequest(h.Storage, h.MetricsCollector, rec)
+	}
+
+	// 确定性请求先查询响应缓存，命中时直接返回缓存的结果
+	lookup := lookupCache(c, h.Cache, h.Pool, h.MetricsCollector, cacheRequest{
+		Kind:    "generate",
+		Model:   target.Model,
+		Input:   req.Prompt,
+		Options: options,
+		Text:    req.Prompt,
+	})
+	if lookup != nil && lookup.Entry != nil {
+		cached := lookup.Entry
+		if cached.Model != target.Model {
+			target = target.Fallback(cached.Model)
+		}
+		response.Model = responseModel(req.Model, target)
+		usage := Usage{
+			PromptTokens:     cached.PromptTokens,
+			CompletionTokens: cached.CompletionTokens,
+			TotalTokens:      cached.PromptTokens + cached.CompletionTokens,
+		}
+		if req.Stream {
+			for _, chunk := range cache.Chunks(cached.Response) {
+				writeGenerateChunk(c, &response, chunk, usage)
+			}
+		}
+
+		// 命中缓存的请求没有经过上游，不计 token
+		rec.Model, rec.Alias = target.Model, target.Alias
+		lookup.apply(rec)
+		rec.Response = cached.Response
+		rec.LatencyMs = time.Since(startTime).Milliseconds()
+		recordRequest(h.Storage, h.MetricsCollector, rec)
+
+		if !req.Stream {
+			writeGenerateResponse(c, &response, cached.Response, usage)
+		}
+		return
+	}
+
+	// 从上游服务器池中选择服务器发起请求，失败时按降级链尝试下一个模型。启用调度器时按优先级和用户排队
+	ctx := queueContext(c, rec.Source)
+	resp, target, err := postWithFallback(ctx, h.Pool, h.MetricsCollector, target, "/api/generate", ollamaReq)
+	rec.Model, rec.Alias = target.Model, target.Alias
+	if err != nil {
+		log.Printf("Failed to call Ollama API: %v", err)
+		upstreamErr := classifyError(contextError(ctx, err))
+		rec.Server = failedServer(err)
+		recordFailure(upstreamErr)
+		writeError(c, upstreamErr)
+		return
+	}
+	defer resp.Body.Close()
+	rec.Server = resp.Server.Name
+	response.Model = responseModel(req.Model, target)
+
 	// 读取流式响应
 	decoder := json.NewDecoder(resp.Body)
 	var fullResponse string
 	var promptEvalCount, evalCount float64
+	var firstTokenMs int64
+
+	fail := func(err error) {
+		log.Printf("Failed to process response: %v", err)
+		rec.Response = fullResponse
+		rec.FirstTokenMs = firstTokenMs
+		recordFailure(err)
+		if !c.Writer.Written() {
+			writeError(c, err)
+			return
+		}
+		// 流已经开始，以一个错误事件结束响应
+		upstreamErr := classifyError(err)
+		errorJSON, _ := json.Marshal(gin.H{"error": upstreamErr.ClientMessage(), "type": upstreamErr.Type})
+		c.Writer.Write([]byte("data: " + string(errorJSON) + "\n\n"))
+		c.Writer.Flush()
+	}
 
 	for decoder.More() {
 		var chunk map[string]interface{}
 		if err := decoder.Decode(&chunk); err != nil {
-			log.Printf("Failed to decode response chunk: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode response"})
+			fail(contextError(ctx, decodeError(err)))
+			return
+		}
+		if message, ok := chunk["error"].(string); ok {
+			fail(upstreamStreamError(message))
 			return
 		}
 
 		// 从响应中提取文本
 		if responseText, ok := chunk["response"].(string); ok {
+			if firstTokenMs == 0 && responseText != "" {
+				firstTokenMs = time.Since(startTime).Milliseconds()
+			}
 			fullResponse += responseText
 			if req.Stream {
 				// 发送流式响应
-				response.Choices[0] = Choice{
-					Text:         responseText,
-					Index:        0,
-					LogProbs:     nil,
-					FinishReason: "length",
-				}
-				response.Usage = Usage{
+				writeGenerateChunk(c, &response, responseText, Usage{
 					PromptTokens:     int(promptEvalCount),
 					CompletionTokens: int(evalCount),
 					TotalTokens:      int(promptEvalCount + evalCount),
-				}
-				jsonData, _ := json.Marshal(response)
-				c.Writer.Write([]byte("data: " + string(jsonData) + "\n\n"))
-				c.Writer.Flush()
+				})
 			}
 		}
 
@@ -176,50 +249,53 @@ func (h *GenerateHandler) Generate(c *gin.Context) {
 		}
 	}
 
-	latency := time.Since(startTime).Milliseconds()
+	// 更新指标并保存到存储
+	rec.Response = fullResponse
+	rec.TokensIn = int(promptEvalCount)
+	rec.TokensOut = int(evalCount)
+	rec.LatencyMs = time.Since(startTime).Milliseconds()
+	rec.FirstTokenMs = firstTokenMs
+	recordRequest(h.Storage, h.MetricsCollector, rec)
 
-	// 更新指标
-	h.MetricsCollector.RecordRequest(
-		req.Model,
-		"ollama",
-		int64(promptEvalCount),
-		int64(evalCount),
-		latency,
-		true,
-	)
-
-	// 保存到存储
-	storageReq := &types.Request{
-		ID:        response.ID,
-		UserID:    "system",
-		Model:     req.Model,
-		Prompt:    req.Prompt,
-		Response:  fullResponse,
-		TokensIn:  int(promptEvalCount),
-		TokensOut: int(evalCount),
-		Server:    "ollama",
-		LatencyMs: float64(latency),
-		Timestamp: time.Now(),
-		Source:    "api",
-	}
-
-	if err := h.Storage.SaveRequest(storageReq); err != nil {
-		log.Printf("Failed to save generate request: %v", err)
-	}
+	lookup.store(h.Cache, rec.ID, &cache.Entry{
+		Model:            target.Model,
+		Response:         fullResponse,
+		PromptTokens:     rec.TokensIn,
+		CompletionTokens: rec.TokensOut,
+	})
 
 	// 如果不是流式响应，发送完整响应
 	if !req.Stream {
-		response.Choices[0] = Choice{
-			Text:         fullResponse,
-			Index:        0,
-			LogProbs:     nil,
-			FinishReason: "length",
-		}
-		response.Usage = Usage{
+		writeGenerateResponse(c, &response, fullResponse, Usage{
 			PromptTokens:     int(promptEvalCount),
 			CompletionTokens: int(evalCount),
 			TotalTokens:      int(promptEvalCount + evalCount),
-		}
-		c.JSON(http.StatusOK, response)
+		})
+	}
+}
+
+// writeGenerateChunk 以 SSE 事件发送一段流式生成结果
+func writeGenerateChunk(c *gin.Context, response *GenerateResponse, text string, usage Usage) {
+	response.Choices[0] = Choice{
+		Text:         text,
+		Index:        0,
+		LogProbs:     nil,
+		FinishReason: "length",
+	}
+	response.Usage = usage
+	jsonData, _ := json.Marshal(response)
+	c.Writer.Write([]byte("data: " + string(jsonData) + "\n\n"))
+	c.Writer.Flush()
+}
+
+// writeGenerateResponse 发送非流式的完整生成结果
+func writeGenerateResponse(c *gin.Context, response *GenerateResponse, text string, usage Usage) {
+	response.Choices[0] = Choice{
+		Text:         text,
+		Index:        0,
+		LogProbs:     nil,
+		FinishReason: "length",
 	}
+	response.Usage = usage
+	c.JSON(http.StatusOK, response)
 }
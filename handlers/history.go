@@ -7,6 +7,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"llm-fw/auth"
 	"llm-fw/common"
 	"llm-fw/types"
 )
@@ -50,7 +51,7 @@ func (h *HistoryHandler) GetHistory(c *gin.Context) {
 		}
 	}
 
-	requests := h.historyManager.Get()
+	requests := visibleRequests(c, h.historyManager.Get())
 
 	// 确保按时间戳降序排序
 	sort.Slice(requests, func(i, j int) bool {
@@ -65,6 +66,22 @@ func (h *HistoryHandler) GetHistory(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"requests": requests})
 }
 
+// visibleRequests 返回当前身份可以查看的请求：管理员密钥可以查看所有请求，其他身份只能查看自己的请求
+func visibleRequests(c *gin.Context, requests []*types.Request) []*types.Request {
+	if key := auth.APIKeyFromContext(c); key != nil && key.Admin {
+		return requests
+	}
+
+	identity := auth.Identity(c)
+	visible := make([]*types.Request, 0, len(requests))
+	for _, req := range requests {
+		if req.UserID == identity {
+			visible = append(visible, req)
+		}
+	}
+	return visible
+}
+
 // AddEntry 添加一条历史记录
 func (h *HistoryHandler) AddEntry(entry *common.Request) error {
 	h.historyManager.Add(entry)
@@ -5,6 +5,9 @@ import "llm-fw/types"
 // MetricsCollector 定义了指标收集器的接口
 type MetricsCollector interface {
 	RecordRequest(model, server string, tokensIn, tokensOut int64, latency int64, isSuccess bool)
+	ObserveRequest(obs *types.RequestObservation)
+	ObserveFallback(from, to, reason string)
+	ObserveCache(model, result, tier string)
 	GetMetrics() *types.Metrics
 	UpdateServerHealth(server string, isHealthy bool)
 }
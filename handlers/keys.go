@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/auth"
+	"llm-fw/config"
+	"llm-fw/types"
+)
+
+// CreateKeyRequest 定义了创建 API 密钥请求的结构
+type CreateKeyRequest struct {
+	Name   string `json:"name"`
+	UserID string `json:"user_id" binding:"required"`
+	Team   string `json:"team"`
+	Admin  bool   `json:"admin"`
+
+	Priority config.Priority `json:"priority"` // 使用该密钥的请求的最高优先级，为空时按请求来源确定
+}
+
+// KeysHandler 处理 API 密钥管理相关的请求
+type KeysHandler struct {
+	keyStore *auth.KeyStore
+}
+
+// NewKeysHandler 创建一个新的密钥管理处理器
+func NewKeysHandler(keyStore *auth.KeyStore) *KeysHandler {
+	return &KeysHandler{keyStore: keyStore}
+}
+
+// keyStoreError 将密钥存储的错误转换为 HTTP 响应
+func keyStoreError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrRevokedKey):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("API key operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "API key operation failed"})
+	}
+}
+
+// CreateKey 创建一个新的 API 密钥，明文只在响应中返回一次
+func (h *KeysHandler) CreateKey(c *gin.Context) {
+	var req CreateKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: user_id is required"})
+		return
+	}
+
+	if req.Priority != "" && !req.Priority.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid priority: must be high, normal or low"})
+		return
+	}
+
+	plaintext, key, err := h.keyStore.Create(req.Name, req.UserID, req.Team, req.Admin, string(req.Priority))
+	if err != nil {
+		keyStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"key":     plaintext,
+		"api_key": key,
+	})
+}
+
+// ListKeys 列出所有 API 密钥
+func (h *KeysHandler) ListKeys(c *gin.Context) {
+	keys := h.keyStore.List()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	if keys == nil {
+		keys = []*types.APIKey{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// RevokeKey 吊销一个 API 密钥
+func (h *KeysHandler) RevokeKey(c *gin.Context) {
+	key, err := h.keyStore.Revoke(c.Param("id"))
+	if err != nil {
+		keyStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"api_key": key})
+}
+
+// RotateKey 轮换一个 API 密钥，旧明文立即失效
+func (h *KeysHandler) RotateKey(c *gin.Context) {
+	plaintext, key, err := h.keyStore.Rotate(c.Param("id"))
+	if err != nil {
+		keyStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"key":     plaintext,
+		"api_key": key,
+	})
+}
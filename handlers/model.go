@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +12,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/ollama"
 	"llm-fw/types"
 )
 
@@ -50,7 +54,7 @@ type OllamaResponse struct {
 
 // ModelHandler handles model-related requests
 type ModelHandler struct {
-	ollamaURL        string
+	pool             *ollama.Pool
 	storage          types.Storage
 	metricsCollector types.MetricsCollector
 	models           map[string]*types.ModelInfo
@@ -58,9 +62,9 @@ type ModelHandler struct {
 }
 
 // NewModelHandler creates a new model handler
-func NewModelHandler(ollamaURL string, storage types.Storage, metricsCollector types.MetricsCollector) *ModelHandler {
+func NewModelHandler(pool *ollama.Pool, storage types.Storage, metricsCollector types.MetricsCollector) *ModelHandler {
 	h := &ModelHandler{
-		ollamaURL:        ollamaURL,
+		pool:             pool,
 		storage:          storage,
 		metricsCollector: metricsCollector,
 		models:           make(map[string]*types.ModelInfo),
@@ -76,31 +80,74 @@ func NewModelHandler(ollamaURL string, storage types.Storage, metricsCollector t
 	return h
 }
 
-// refreshModels 从 Ollama 获取最新的模型列表
-func (h *ModelHandler) refreshModels() error {
-	log.Printf("Fetching models from Ollama at %s", h.ollamaURL)
-	resp, err := http.Get(fmt.Sprintf("%s/api/tags", h.ollamaURL))
+// fetchTags 从单个 Ollama 服务器获取模型列表
+func (h *ModelHandler) fetchTags(server *ollama.Server) ([]OllamaModel, error) {
+	resp, err := h.pool.Get(context.Background(), server, "/api/tags")
 	if err != nil {
-		return fmt.Errorf("failed to get models from Ollama: %v", err)
+		return nil, fmt.Errorf("failed to get models from Ollama: %v", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %v", err)
+		return nil, fmt.Errorf("failed to read response: %v", err)
 	}
 
 	var ollamaResp OllamaResponse
 	if err := json.Unmarshal(body, &ollamaResp); err != nil {
-		log.Printf("Failed to parse Ollama response: %s", string(body))
-		return fmt.Errorf("failed to parse response: %v", err)
+		log.Printf("Failed to parse Ollama response from %s: %s", server.Name, string(body))
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+	return ollamaResp.Models, nil
+}
+
+// fetchAllTags 从所有上游服务器获取模型列表并按名称去重合并
+func (h *ModelHandler) fetchAllTags() ([]OllamaModel, error) {
+	var merged []OllamaModel
+	var lastErr error
+	seen := make(map[string]bool)
+	succeeded := 0
+
+	for _, server := range h.pool.Servers() {
+		models, err := h.fetchTags(server)
+		if err != nil {
+			log.Printf("Failed to fetch models from %s: %v", server.Name, err)
+			lastErr = err
+			continue
+		}
+		succeeded++
+
+		names := make([]string, 0, len(models))
+		for _, model := range models {
+			modelName := strings.TrimSpace(model.Name)
+			names = append(names, modelName)
+			if !seen[modelName] {
+				seen[modelName] = true
+				merged = append(merged, model)
+			}
+		}
+		server.SetModels(names)
+	}
+
+	if succeeded == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return merged, nil
+}
+
+// refreshModels 从所有 Ollama 服务器获取最新的模型列表
+func (h *ModelHandler) refreshModels() error {
+	log.Printf("Fetching models from %d Ollama server(s)", len(h.pool.Servers()))
+	models, err := h.fetchAllTags()
+	if err != nil {
+		return err
 	}
 
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
 	// 更新模型列表
-	for _, model := range ollamaResp.Models {
+	for _, model := range models {
 		modelName := strings.TrimSpace(model.Name)
 		if _, exists := h.models[modelName]; !exists {
 			log.Printf("Found new model: %s (Family: %s, Parameters: %s)",
@@ -117,10 +164,23 @@ func (h *ModelHandler) refreshModels() error {
 		}
 	}
 
-	log.Printf("Successfully refreshed models, total count: %d", len(ollamaResp.Models))
+	log.Printf("Successfully refreshed models, total count: %d", len(models))
 	return nil
 }
 
+// Tags 处理 /api/tags 请求，返回所有上游服务器模型的合并列表
+func (h *ModelHandler) Tags(c *gin.Context) {
+	models, err := h.fetchAllTags()
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if models == nil {
+		models = []OllamaModel{}
+	}
+	c.JSON(http.StatusOK, OllamaResponse{Models: models})
+}
+
 // startRefreshLoop 定期刷新模型列表
 func (h *ModelHandler) startRefreshLoop() {
 	log.Printf("Starting model refresh loop with 30-second interval")
@@ -238,23 +298,9 @@ func getModelNames(stats map[string]*types.ModelStats) []string {
 
 // getModelsFromOllama retrieves the list of models from Ollama
 func (h *ModelHandler) getModelsFromOllama() ([]types.ModelInfo, error) {
-	// 发送请求到 Ollama API
-	resp, err := http.Get(h.ollamaURL + "/api/tags")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get models from Ollama: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// 读取响应
-	body, err := io.ReadAll(resp.Body)
+	ollamaModels, err := h.fetchAllTags()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
-	}
-
-	// 解析响应
-	var ollamaResp OllamaResponse
-	if err := json.Unmarshal(body, &ollamaResp); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %v", err)
+		return nil, err
 	}
 
 	// 转换为 ModelInfo 列表
@@ -262,7 +308,7 @@ func (h *ModelHandler) getModelsFromOllama() ([]types.ModelInfo, error) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	for _, m := range ollamaResp.Models {
+	for _, m := range ollamaModels {
 		modelInfo := types.ModelInfo{
 			Name:        m.Name,
 			Family:      m.Details.Family,
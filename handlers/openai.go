@@ -0,0 +1,383 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"llm-fw/auth"
+	"llm-fw/types"
+)
+
+// OpenAIChatRequest 定义了 OpenAI Chat Completions 请求的结构
+type OpenAIChatRequest struct {
+	Model               string              `json:"model"`
+	Messages            []OpenAIChatMessage `json:"messages"`
+	Temperature         *float64            `json:"temperature,omitempty"`
+	TopP                *float64            `json:"top_p,omitempty"`
+	MaxTokens           *int                `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int                `json:"max_completion_tokens,omitempty"`
+	Stop                StopSequences       `json:"stop,omitempty"`
+	Seed                *int                `json:"seed,omitempty"`
+	Stream              bool                `json:"stream"`
+	StreamOptions       *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+	N    int    `json:"n,omitempty"`
+	User string `json:"user,omitempty"`
+}
+
+// OpenAIChatMessage 定义了 OpenAI 聊天消息的结构
+type OpenAIChatMessage struct {
+	Role    string         `json:"role"`
+	Content MessageContent `json:"content"`
+}
+
+// MessageContent 表示消息内容，可以是字符串或内容块数组，解析后只保留文本
+// OpenAI 与 Anthropic 的文本内容块格式相同，均为 {"type":"text","text":"..."}
+type MessageContent string
+
+// UnmarshalJSON 同时支持字符串和 [{"type":"text","text":"..."}] 两种格式
+func (c *MessageContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = MessageContent(text)
+		return nil
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("content must be a string or an array of content parts")
+	}
+
+	var sb strings.Builder
+	for _, part := range parts {
+		if part.Type == "text" {
+			sb.WriteString(part.Text)
+		}
+	}
+	*c = MessageContent(sb.String())
+	return nil
+}
+
+// StopSequences 表示停止序列，可以是单个字符串或字符串数组
+type StopSequences []string
+
+// UnmarshalJSON 同时支持字符串和字符串数组两种格式
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*s = nil
+		return nil
+	}
+
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = StopSequences{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("stop must be a string or an array of strings")
+	}
+	*s = multiple
+	return nil
+}
+
+// OpenAIChatResponse 定义了 OpenAI Chat Completions 响应的结构
+type OpenAIChatResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []OpenAIChatChoice `json:"choices"`
+	Usage   Usage              `json:"usage"`
+}
+
+// OpenAIChatChoice 定义了非流式响应中的一个选择
+type OpenAIChatChoice struct {
+	Index        int         `json:"index"`
+	Message      ChatMessage `json:"message"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+// OpenAIChatChunk 定义了流式响应中的一个 chat.completion.chunk
+type OpenAIChatChunk struct {
+	ID      string              `json:"id"`
+	Object  string              `json:"object"`
+	Created int64               `json:"created"`
+	Model   string              `json:"model"`
+	Choices []OpenAIChunkChoice `json:"choices"`
+	Usage   *Usage              `json:"usage,omitempty"`
+}
+
+// OpenAIChunkChoice 定义了流式响应中的一个选择
+type OpenAIChunkChoice struct {
+	Index        int         `json:"index"`
+	Delta        OpenAIDelta `json:"delta"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+// OpenAIDelta 定义了流式响应中的增量内容
+type OpenAIDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// openAIError 以 OpenAI 的错误格式返回错误
+func openAIError(c *gin.Context, status int, message, errType string) {
+	c.JSON(status, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    errType,
+			"param":   nil,
+			"code":    nil,
+		},
+	})
+}
+
+// ollamaOptions 将 OpenAI 的采样参数转换为 Ollama 的 options
+func (r *OpenAIChatRequest) ollamaOptions() map[string]interface{} {
+	options := make(map[string]interface{})
+	if r.Temperature != nil {
+		options["temperature"] = *r.Temperature
+	}
+	if r.TopP != nil {
+		options["top_p"] = *r.TopP
+	}
+	if r.MaxCompletionTokens != nil {
+		options["num_predict"] = *r.MaxCompletionTokens
+	} else if r.MaxTokens != nil {
+		options["num_predict"] = *r.MaxTokens
+	}
+	if len(r.Stop) > 0 {
+		options["stop"] = []string(r.Stop)
+	}
+	if r.Seed != nil {
+		options["seed"] = *r.Seed
+	}
+	return options
+}
+
+// finishReason 将 Ollama 的 done_reason 转换为 OpenAI 的 finish_reason
+func finishReason(doneReason string) string {
+	if doneReason == "length" {
+		return "length"
+	}
+	return "stop"
+}
+
+// ChatCompletions 处理 OpenAI 兼容的 /v1/chat/completions 请求
+func (h *ChatHandler) ChatCompletions(c *gin.Context) {
+	var req OpenAIChatRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		openAIError(c, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err), "invalid_request_error")
+		return
+	}
+
+	// 验证请求
+	if req.Model == "" {
+		openAIError(c, http.StatusBadRequest, "model is required", "invalid_request_error")
+		return
+	}
+	if len(req.Messages) == 0 {
+		openAIError(c, http.StatusBadRequest, "messages must contain at least one message", "invalid_request_error")
+		return
+	}
+	if req.N == 0 {
+		req.N = 1
+	}
+	if req.N < 0 || req.N > 8 {
+		openAIError(c, http.StatusBadRequest, "n must be between 1 and 8", "invalid_request_error")
+		return
+	}
+	if req.Stream && req.N > 1 {
+		openAIError(c, http.StatusBadRequest, "n > 1 is not supported when stream is true", "invalid_request_error")
+		return
+	}
+
+	userID := auth.Identity(c)
+
+	messages := make([]ChatMessage, len(req.Messages))
+	for i, msg := range req.Messages {
+		messages[i] = ChatMessage{Role: msg.Role, Content: string(msg.Content)}
+	}
+	prompt := messages[len(messages)-1].Content
+	options := req.ollamaOptions()
+
+	id := "chatcmpl-" + uuid.New().String()
+	created := time.Now().Unix()
+
+	if req.Stream {
+		h.streamChatCompletion(c, &req, id, created, userID, prompt, messages, options)
+		return
+	}
+
+	response := OpenAIChatResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: created,
+		Model:   req.Model,
+		Choices: make([]OpenAIChatChoice, 0, req.N),
+	}
+
+	// Ollama 不支持 n，多个选择通过多次调用实现
+	for i := 0; i < req.N; i++ {
+		result, err := h.ollamaChat(c, req.Model, messages, options, nil)
+		h.recordChat(userID, req.Model, prompt, "api", result, err)
+		if err != nil {
+			log.Printf("Failed to call Ollama API: %v", err)
+			writeOpenAIError(c, err)
+			return
+		}
+
+		response.Model = result.ResponseModel
+		response.Choices = append(response.Choices, OpenAIChatChoice{
+			Index:        i,
+			Message:      ChatMessage{Role: "assistant", Content: result.Content},
+			FinishReason: finishReason(result.DoneReason),
+		})
+		// 每次调用的提示词相同，只统计一次输入 token
+		response.Usage.PromptTokens = result.PromptEvalCount
+		response.Usage.CompletionTokens += result.EvalCount
+	}
+	response.Usage.TotalTokens = response.Usage.PromptTokens + response.Usage.CompletionTokens
+
+	c.JSON(http.StatusOK, response)
+}
+
+// streamChatCompletion 以 SSE 的 chat.completion.chunk 格式返回流式响应
+func (h *ChatHandler) streamChatCompletion(c *gin.Context, req *OpenAIChatRequest, id string, created int64, userID, prompt string, messages []ChatMessage, options map[string]interface{}) {
+	started := false
+	writeChunk := func(chunk OpenAIChatChunk) {
+		if !started {
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+			c.Header("Connection", "keep-alive")
+			c.Status(http.StatusOK)
+			started = true
+		}
+		jsonData, _ := json.Marshal(chunk)
+		c.Writer.Write([]byte("data: " + string(jsonData) + "\n\n"))
+		c.Writer.Flush()
+	}
+	model := req.Model
+	newChunk := func(delta OpenAIDelta, finish *string) OpenAIChatChunk {
+		return OpenAIChatChunk{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   model,
+			Choices: []OpenAIChunkChoice{{Index: 0, Delta: delta, FinishReason: finish}},
+		}
+	}
+
+	result, err := h.ollamaChat(c, req.Model, messages, options, func(responseModel, content string) {
+		model = responseModel
+		if !started {
+			writeChunk(newChunk(OpenAIDelta{Role: "assistant"}, nil))
+		}
+		writeChunk(newChunk(OpenAIDelta{Content: content}, nil))
+	})
+	h.recordChat(userID, req.Model, prompt, "api", result, err)
+	model = result.ResponseModel
+	if err != nil {
+		log.Printf("Failed to call Ollama API: %v", err)
+		if !started {
+			writeOpenAIError(c, err)
+			return
+		}
+		// 流已经开始，以一个错误事件结束响应
+		errorJSON, _ := json.Marshal(openAIErrorBody(classifyError(err)))
+		c.Writer.Write([]byte("data: " + string(errorJSON) + "\n\n"))
+		c.Writer.Flush()
+		return
+	}
+
+	if !started {
+		writeChunk(newChunk(OpenAIDelta{Role: "assistant"}, nil))
+	}
+	reason := finishReason(result.DoneReason)
+	writeChunk(newChunk(OpenAIDelta{}, &reason))
+
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		usageChunk := newChunk(OpenAIDelta{}, nil)
+		usageChunk.Choices = []OpenAIChunkChoice{}
+		usageChunk.Usage = &Usage{
+			PromptTokens:     result.PromptEvalCount,
+			CompletionTokens: result.EvalCount,
+			TotalTokens:      result.PromptEvalCount + result.EvalCount,
+		}
+		writeChunk(usageChunk)
+	}
+
+	c.Writer.Write([]byte("data: [DONE]\n\n"))
+	c.Writer.Flush()
+}
+
+// OpenAIModel 定义了 OpenAI 模型列表中的一个模型
+type OpenAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// openAIModel 将模型信息转换为 OpenAI 格式
+func openAIModel(info *types.ModelInfo) OpenAIModel {
+	model := OpenAIModel{
+		ID:      info.Name,
+		Object:  "model",
+		OwnedBy: "ollama",
+	}
+	if info.LastUsed != nil && !info.LastUsed.IsZero() {
+		model.Created = info.LastUsed.Unix()
+	}
+	return model
+}
+
+// ListOpenAIModels 处理 OpenAI 兼容的 /v1/models 请求
+func (h *ModelHandler) ListOpenAIModels(c *gin.Context) {
+	h.mu.RLock()
+	data := make([]OpenAIModel, 0, len(h.models))
+	for _, info := range h.models {
+		if info.IsAvailable {
+			data = append(data, openAIModel(info))
+		}
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].ID < data[j].ID
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+// GetOpenAIModel 处理 OpenAI 兼容的 /v1/models/:model 请求
+func (h *ModelHandler) GetOpenAIModel(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("model"), "/")
+
+	h.mu.RLock()
+	info, exists := h.models[name]
+	h.mu.RUnlock()
+
+	if !exists || !info.IsAvailable {
+		openAIError(c, http.StatusNotFound, fmt.Sprintf("The model '%s' does not exist", name), "invalid_request_error")
+		return
+	}
+	c.JSON(http.StatusOK, openAIModel(info))
+}
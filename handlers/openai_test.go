@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/config"
+	"llm-fw/ollama"
+	"llm-fw/storage"
+	"llm-fw/types"
+)
+
+// upstreamCall 是模拟的 Ollama 服务器收到的一次请求
+type upstreamCall struct {
+	Path string
+	Body map[string]interface{}
+}
+
+// apiTest 是把原生、OpenAI、Anthropic 和嵌入接口挂到同一个模拟 Ollama 服务器上的测试环境
+type apiTest struct {
+	router *gin.Engine
+	store  types.Storage
+
+	mu    sync.Mutex
+	calls []upstreamCall
+}
+
+// newAPITest 创建测试环境，respond 按收到的请求写出模拟的 Ollama 响应
+func newAPITest(t *testing.T, respond func(w http.ResponseWriter, call upstreamCall)) *apiTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	at := &apiTest{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := upstreamCall{Path: r.URL.Path}
+		json.NewDecoder(r.Body).Decode(&call.Body)
+		at.mu.Lock()
+		at.calls = append(at.calls, call)
+		at.mu.Unlock()
+		respond(w, call)
+	}))
+	t.Cleanup(upstream.Close)
+
+	pool, err := ollama.NewPool([]config.OllamaServer{{Name: "default", URL: upstream.URL}}, config.BalanceRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 不重试，上游返回错误时每次请求只调用一次
+	pool.SetClient(ollama.NewHTTPClient(config.UpstreamTimeouts{}, nil, config.UpstreamRetry{MaxAttempts: 1}))
+	store, err := storage.NewFileStorageImpl(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	collector := &types.NoopMetricsCollector{}
+	chat := NewChatHandler(store, pool, collector)
+	at.router = gin.New()
+	at.router.POST("/api/chat", chat.Chat)
+	at.router.POST("/api/generate", NewGenerateHandler(pool, store, collector).Generate)
+	at.router.POST("/v1/chat/completions", chat.ChatCompletions)
+	at.router.POST("/v1/messages", chat.Messages)
+	at.router.POST("/v1/embeddings", NewEmbeddingHandler(pool, store, collector).Embeddings)
+	at.store = store
+	return at
+}
+
+// post 发送 JSON 请求
+func (at *apiTest) post(path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	at.router.ServeHTTP(w, req)
+	return w
+}
+
+// upstreamCalls 返回模拟的 Ollama 服务器收到的请求
+func (at *apiTest) upstreamCalls() []upstreamCall {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	return append([]upstreamCall(nil), at.calls...)
+}
+
+// lastRecord 返回最近保存的请求记录
+func (at *apiTest) lastRecord(t *testing.T) *types.Request {
+	t.Helper()
+	recent, err := at.store.GetRecentRequests(1)
+	if err != nil || len(recent) != 1 {
+		t.Fatalf("GetRecentRequests: %d records, %v", len(recent), err)
+	}
+	return recent[0]
+}
+
+// ollamaChatStream 以 Ollama 的 NDJSON 格式返回两段内容和最终统计
+func ollamaChatStream(w http.ResponseWriter, call upstreamCall) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Write([]byte(`{"model":"llama3","message":{"role":"assistant","content":"Hello"},"done":false}` + "\n"))
+	w.Write([]byte(`{"model":"llama3","message":{"role":"assistant","content":" world"},"done":false}` + "\n"))
+	w.Write([]byte(`{"model":"llama3","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":12,"eval_count":5}` + "\n"))
+}
+
+// ollamaStatus 返回以 Ollama 的 {"error": "..."} 格式应答指定状态码的模拟上游
+func ollamaStatus(status int, message string) func(http.ResponseWriter, upstreamCall) {
+	return func(w http.ResponseWriter, call upstreamCall) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": message})
+	}
+}
+
+// sseEvent 是 SSE 响应中的一个事件
+type sseEvent struct {
+	Event string
+	Data  string
+}
+
+// readSSE 按顺序解析 SSE 响应中的事件
+func readSSE(t *testing.T, body io.Reader) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if current.Data != "" {
+				events = append(events, current)
+			}
+			current = sseEvent{}
+		case strings.HasPrefix(line, "event: "):
+			current.Event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.Data = strings.TrimPrefix(line, "data: ")
+		default:
+			t.Fatalf("unexpected SSE line %q", line)
+		}
+	}
+	if current.Data != "" {
+		t.Fatalf("SSE event %q not terminated by a blank line", current.Data)
+	}
+	return events
+}
+
+// openAIErrorEnvelope 是 OpenAI 格式的错误响应
+type openAIErrorEnvelope struct {
+	Error struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Param   interface{} `json:"param"`
+		Code    interface{} `json:"code"`
+	} `json:"error"`
+}
+
+func TestChatCompletions(t *testing.T) {
+	at := newAPITest(t, ollamaChatStream)
+
+	w := at.post("/v1/chat/completions", `{
+		"model": "llama3",
+		"messages": [
+			{"role": "system", "content": "Be brief."},
+			{"role": "user", "content": [{"type": "text", "text": "Say "}, {"type": "text", "text": "hello"}]}
+		],
+		"temperature": 0.2,
+		"max_tokens": 64,
+		"stop": "\n\n",
+		"n": 2
+	}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp OpenAIChatResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Object != "chat.completion" || !strings.HasPrefix(resp.ID, "chatcmpl-") || resp.Model != "llama3" || resp.Created == 0 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	// n 通过多次调用实现，提示词只统计一次
+	if len(resp.Choices) != 2 {
+		t.Fatalf("%d choices, want 2", len(resp.Choices))
+	}
+	for i, choice := range resp.Choices {
+		if choice.Index != i || choice.Message.Role != "assistant" || choice.Message.Content != "Hello world" || choice.FinishReason != "stop" {
+			t.Fatalf("unexpected choice %d: %+v", i, choice)
+		}
+	}
+	if resp.Usage != (Usage{PromptTokens: 12, CompletionTokens: 10, TotalTokens: 22}) {
+		t.Fatalf("unexpected usage: %+v", resp.Usage)
+	}
+
+	// 请求转换为 Ollama 的消息和 options
+	calls := at.upstreamCalls()
+	if len(calls) != 2 || calls[0].Path != "/api/chat" {
+		t.Fatalf("upstream calls: %+v", calls)
+	}
+	messages, _ := json.Marshal(calls[0].Body["messages"])
+	if string(messages) != `[{"content":"Be brief.","role":"system"},{"content":"Say hello","role":"user"}]` {
+		t.Fatalf("upstream messages %s", messages)
+	}
+	options, _ := json.Marshal(calls[0].Body["options"])
+	if string(options) != `{"num_predict":64,"stop":["\n\n"],"temperature":0.2}` {
+		t.Fatalf("upstream options %s", options)
+	}
+
+	if rec := at.lastRecord(t); rec.Model != "llama3" || rec.Prompt != "Say hello" || rec.Response != "Hello world" || rec.TokensIn != 12 || rec.TokensOut != 5 {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+}
+
+func TestChatCompletionsStream(t *testing.T) {
+	at := newAPITest(t, ollamaChatStream)
+
+	w := at.post("/v1/chat/completions", `{"model":"llama3","messages":[{"role":"user","content":"hi"}],"stream":true,"stream_options":{"include_usage":true}}`)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	events := readSSE(t, w.Body)
+	if len(events) == 0 || events[len(events)-1].Data != "[DONE]" {
+		t.Fatalf("stream not terminated by [DONE]: %+v", events)
+	}
+	var chunks []OpenAIChatChunk
+	for _, event := range events[:len(events)-1] {
+		var chunk OpenAIChatChunk
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", event.Data, err)
+		}
+		// 所有块使用同一个 ID
+		if chunk.Object != "chat.completion.chunk" || !strings.HasPrefix(chunk.ID, "chatcmpl-") || (len(chunks) > 0 && chunk.ID != chunks[0].ID) {
+			t.Fatalf("unexpected chunk header: %+v", chunk)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	// 角色、两段内容、结束原因、用量
+	var got []string
+	for _, chunk := range chunks {
+		if chunk.Usage != nil {
+			if len(chunk.Choices) != 0 || *chunk.Usage != (Usage{PromptTokens: 12, CompletionTokens: 5, TotalTokens: 17}) {
+				t.Fatalf("unexpected usage chunk: %+v", chunk)
+			}
+			got = append(got, "usage")
+			continue
+		}
+		choice := chunk.Choices[0]
+		switch {
+		case choice.FinishReason != nil:
+			got = append(got, "finish:"+*choice.FinishReason)
+		case choice.Delta.Role != "":
+			got = append(got, "role:"+choice.Delta.Role)
+		default:
+			got = append(got, choice.Delta.Content)
+		}
+	}
+	if want := "role:assistant|Hello| world|finish:stop|usage"; strings.Join(got, "|") != want {
+		t.Fatalf("chunks %q, want %q", strings.Join(got, "|"), want)
+	}
+}
+
+func TestChatCompletionsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		respond  func(http.ResponseWriter, upstreamCall)
+		body     string
+		status   int
+		errType  string
+		code     interface{}
+		message  string
+		upstream bool // 是否调用了上游
+	}{
+		{
+			name:    "invalid json",
+			body:    `{"model":`,
+			status:  http.StatusBadRequest,
+			errType: "invalid_request_error",
+			message: "Invalid request body",
+		},
+		{
+			name:    "missing messages",
+			body:    `{"model":"llama3"}`,
+			status:  http.StatusBadRequest,
+			errType: "invalid_request_error",
+			message: "messages must contain at least one message",
+		},
+		{
+			name:    "n with stream",
+			body:    `{"model":"llama3","messages":[{"role":"user","content":"hi"}],"stream":true,"n":2}`,
+			status:  http.StatusBadRequest,
+			errType: "invalid_request_error",
+			message: "n > 1 is not supported when stream is true",
+		},
+		{
+			name:     "model not found",
+			respond:  ollamaStatus(http.StatusNotFound, `model "llama9" not found, try pulling it first`),
+			body:     `{"model":"llama9","messages":[{"role":"user","content":"hi"}]}`,
+			status:   http.StatusNotFound,
+			errType:  "invalid_request_error",
+			code:     types.ErrorTypeModelNotFound,
+			message:  `model "llama9" not found, try pulling it first`,
+			upstream: true,
+		},
+		{
+			name:     "upstream failure before the stream starts",
+			respond:  ollamaStatus(http.StatusInternalServerError, "CUDA out of memory"),
+			body:     `{"model":"llama3","messages":[{"role":"user","content":"hi"}],"stream":true}`,
+			status:   http.StatusInternalServerError,
+			errType:  "api_error",
+			code:     types.ErrorTypeUpstream5xx,
+			message:  "CUDA out of memory",
+			upstream: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			respond := tt.respond
+			if respond == nil {
+				respond = ollamaChatStream
+			}
+			at := newAPITest(t, respond)
+
+			w := at.post("/v1/chat/completions", tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			var envelope openAIErrorEnvelope
+			if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+				t.Fatal(err)
+			}
+			if envelope.Error.Type != tt.errType || envelope.Error.Code != tt.code || !strings.Contains(envelope.Error.Message, tt.message) {
+				t.Fatalf("unexpected error: %+v", envelope.Error)
+			}
+			if called := len(at.upstreamCalls()) > 0; called != tt.upstream {
+				t.Fatalf("upstream called: %v", called)
+			}
+		})
+	}
+}
+
+func TestChatCompletionsStreamError(t *testing.T) {
+	// 流已经开始后上游返回错误，以一个错误事件结束响应，不再发送 [DONE]
+	at := newAPITest(t, func(w http.ResponseWriter, call upstreamCall) {
+		w.Write([]byte(`{"message":{"role":"assistant","content":"Hel"},"done":false}` + "\n"))
+		w.Write([]byte(`{"error":"model runner has unexpectedly stopped"}` + "\n"))
+	})
+
+	w := at.post("/v1/chat/completions", `{"model":"llama3","messages":[{"role":"user","content":"hi"}],"stream":true}`)
+	events := readSSE(t, w.Body)
+	if w.Code != http.StatusOK || len(events) != 3 {
+		t.Fatalf("status %d, events %+v", w.Code, events)
+	}
+	var envelope openAIErrorEnvelope
+	if err := json.Unmarshal([]byte(events[2].Data), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Error.Message != "model runner has unexpectedly stopped" || envelope.Error.Code != types.ErrorTypeUpstream5xx {
+		t.Fatalf("unexpected error event: %s", events[2].Data)
+	}
+	if rec := at.lastRecord(t); rec.Status != 1 || rec.Response != "Hel" {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+}
+
+func TestMessageContentAndStop(t *testing.T) {
+	tests := []struct {
+		data    string
+		content string
+		err     bool
+	}{
+		{data: `"hello"`, content: "hello"},
+		{data: `[{"type":"text","text":"a"},{"type":"image_url","image_url":{"url":"x"}},{"type":"text","text":"b"}]`, content: "ab"},
+		{data: `42`, err: true},
+	}
+	for _, tt := range tests {
+		var content MessageContent
+		err := json.Unmarshal([]byte(tt.data), &content)
+		if (err != nil) != tt.err || string(content) != tt.content {
+			t.Errorf("MessageContent(%s) = %q, %v", tt.data, content, err)
+		}
+	}
+
+	for data, want := range map[string]string{`"END"`: "END", `["a","b"]`: "a,b", `null`: ""} {
+		var stop StopSequences
+		if err := json.Unmarshal([]byte(data), &stop); err != nil || strings.Join(stop, ",") != want {
+			t.Errorf("StopSequences(%s) = %v, %v", data, stop, err)
+		}
+	}
+	var stop StopSequences
+	if err := json.Unmarshal([]byte(`1`), &stop); err == nil {
+		t.Error("expected an error for a numeric stop")
+	}
+	if got := fmt.Sprintf("%s %s %s", finishReason("length"), finishReason("stop"), finishReason("")); got != "length stop stop" {
+		t.Errorf("finish reasons %s", got)
+	}
+}
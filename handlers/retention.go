@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/retention"
+)
+
+// RetentionHandler 处理数据保留相关的管理请求
+type RetentionHandler struct {
+	job     *retention.Job
+	enabled bool
+}
+
+// NewRetentionHandler 创建一个新的数据保留处理器，enabled 表示后台清理是否启用
+func NewRetentionHandler(job *retention.Job, enabled bool) *RetentionHandler {
+	return &RetentionHandler{job: job, enabled: enabled}
+}
+
+// durationString 把时长格式化为配置文件中的写法，0 表示不限制
+func durationString(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// GetReport 演练一次清理并返回将被删除的记录数，同时返回最近一次实际执行的结果
+func (h *RetentionHandler) GetReport(c *gin.Context) {
+	report, err := h.job.DryRun()
+	if err != nil {
+		log.Printf("Retention dry run failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate retention policy"})
+		return
+	}
+
+	policy := h.job.Policy()
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":  h.enabled,
+		"interval": h.job.Interval().String(),
+		"policy": gin.H{
+			"max_age":        durationString(policy.MaxAge),
+			"max_rows":       policy.MaxRows,
+			"max_size_bytes": policy.MaxSizeBytes,
+			"body_max_age":   durationString(policy.BodyMaxAge),
+		},
+		"dry_run":  report,
+		"last_run": h.job.LastRun(),
+	})
+}
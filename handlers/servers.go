@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/ollama"
+)
+
+// ServersHandler 展示上游服务器的健康和熔断状态
+type ServersHandler struct {
+	pool *ollama.Pool
+}
+
+// NewServersHandler 创建一个新的上游服务器状态处理器
+func NewServersHandler(pool *ollama.Pool) *ServersHandler {
+	return &ServersHandler{pool: pool}
+}
+
+// ListServers 返回每个上游服务器的熔断状态、最近一次错误和探测延迟
+func (h *ServersHandler) ListServers(c *gin.Context) {
+	servers := h.pool.Servers()
+	statuses := make([]ollama.ServerStatus, 0, len(servers))
+	for _, server := range servers {
+		statuses = append(statuses, server.Status())
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"strategy": h.pool.Strategy(),
+		"servers":  statuses,
+	})
+}
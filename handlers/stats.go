@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"llm-fw/metrics"
 	"llm-fw/types"
 	"net/http"
 
@@ -10,10 +9,10 @@ import (
 
 type StatsHandler struct {
 	storage          types.Storage
-	metricsCollector *metrics.Metrics
+	metricsCollector types.MetricsCollector
 }
 
-func NewStatsHandler(storage types.Storage, metricsCollector *metrics.Metrics) *StatsHandler {
+func NewStatsHandler(storage types.Storage, metricsCollector types.MetricsCollector) *StatsHandler {
 	return &StatsHandler{
 		storage:          storage,
 		metricsCollector: metricsCollector,
@@ -40,6 +39,15 @@ func (h *StatsHandler) GetStats(c *gin.Context) {
 		return
 	}
 
+	// 按模型和失败原因统计失败请求
+	errorsByModel, err := h.storage.CountErrorsByType()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "获取错误统计失败",
+		})
+		return
+	}
+
 	// 获取指标数据
 	metrics := h.metricsCollector.GetMetrics()
 
@@ -47,10 +55,13 @@ func (h *StatsHandler) GetStats(c *gin.Context) {
 		"model_stats":      modelStats,
 		"recent_requests":  recentRequests,
 		"server_health":    metrics.ServerHealth,
+		"queue_depth":      metrics.QueueDepth,
+		"server_stats":     metrics.ServerStats,
 		"total_requests":   metrics.TotalRequests,
 		"total_tokens_in":  metrics.TotalTokensIn,
 		"total_tokens_out": metrics.TotalTokensOut,
 		"failed_requests":  metrics.FailedRequests,
+		"errors_by_model":  errorsByModel,
 	})
 }
 
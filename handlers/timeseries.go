@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/timeseries"
+	"llm-fw/types"
+)
+
+// maxTimeseriesPoints 限制一次查询返回的时间桶数量
+const maxTimeseriesPoints = 10000
+
+// defaultTimeseriesSpan 是未指定 from 时各粒度默认查询的时长
+var defaultTimeseriesSpan = map[string]time.Duration{
+	types.BucketMinute: time.Hour,
+	types.BucketHour:   24 * time.Hour,
+	types.BucketDay:    30 * 24 * time.Hour,
+}
+
+// TimeseriesHandler 提供模型统计时间序列，供仪表盘绘制图表
+type TimeseriesHandler struct {
+	storage types.Storage
+}
+
+// NewTimeseriesHandler 创建一个新的时间序列处理器
+func NewTimeseriesHandler(storage types.Storage) *TimeseriesHandler {
+	return &TimeseriesHandler{storage: storage}
+}
+
+// parseTime 解析 RFC3339 时间或 Unix 秒级时间戳
+func parseTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// GetTimeseries 返回模型在 [from, to) 内按 bucket 粒度划分的统计，没有请求的时间桶补零
+func (h *TimeseriesHandler) GetTimeseries(c *gin.Context) {
+	model := c.Param("model")
+
+	granularity := c.DefaultQuery("bucket", types.BucketHour)
+	size, ok := timeseries.BucketDuration(granularity)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid bucket %q, expected minute, hour or day", granularity)})
+		return
+	}
+
+	to := time.Now()
+	if value := c.Query("to"); value != "" {
+		t, err := parseTime(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to parameter"})
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultTimeseriesSpan[granularity])
+	if value := c.Query("from"); value != "" {
+		t, err := parseTime(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from parameter"})
+			return
+		}
+		from = t
+	}
+
+	// 对齐到时间桶边界，包含 to 所在的未结束时间桶
+	from = from.UTC().Truncate(size)
+	to = to.UTC().Truncate(size).Add(size)
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+	if to.Sub(from)/size > maxTimeseriesPoints {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Time range too large, at most %d buckets", maxTimeseriesPoints)})
+		return
+	}
+
+	buckets, err := h.storage.ListModelStatsBuckets(model, granularity, from, to)
+	if err != nil {
+		log.Printf("Failed to list model stats buckets: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get model timeseries"})
+		return
+	}
+
+	byStart := make(map[int64]*types.ModelStatsBucket, len(buckets))
+	for _, bucket := range buckets {
+		byStart[bucket.Start.Unix()] = bucket
+	}
+	points := make([]*types.ModelStatsBucket, 0, to.Sub(from)/size)
+	for start := from; start.Before(to); start = start.Add(size) {
+		bucket, exists := byStart[start.Unix()]
+		if !exists {
+			bucket = &types.ModelStatsBucket{Model: model, Granularity: granularity, Start: start}
+		}
+		points = append(points, bucket)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"model":  model,
+		"bucket": granularity,
+		"from":   from,
+		"to":     to,
+		"points": points,
+	})
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/auth"
+	"llm-fw/config"
+	"llm-fw/ollama"
+	"llm-fw/types"
+)
+
+// queueContext 标记请求需要经过调度器排队，按请求的身份、来源和优先级排队。
+// API 密钥没有指定优先级时由调度器按来源确定优先级。X-Priority 请求头只能降低优先级，
+// 由调度器与密钥或来源的优先级比较；无效的请求头被忽略。上游请求与客户端请求绑定，客户端断开时取消上游生成
+func queueContext(c *gin.Context, source string) context.Context {
+	req := ollama.QueueRequest{User: auth.Identity(c), Source: source}
+	if key := auth.APIKeyFromContext(c); key != nil {
+		req.Priority = config.Priority(key.Priority)
+	}
+	if priority := config.Priority(strings.ToLower(c.GetHeader("X-Priority"))); priority.Valid() {
+		req.Requested = priority
+	}
+	return ollama.WithQueue(c.Request.Context(), req)
+}
+
+// failedServer 返回上游请求失败前最后尝试的服务器，没有选中服务器时返回空
+func failedServer(err error) string {
+	var reqErr *ollama.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.Server
+	}
+	return ""
+}
+
+// contextError 在 ctx 已结束时把 err 归因于 ctx 的取消原因，使失败原因记为 cancelled、timeout 或 interrupted
+// 而不是连接或解析错误
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %v", context.Cause(ctx), err)
+	}
+	return err
+}
+
+// canFallback 判断失败原因是否允许降级到下一个模型：模型不存在、服务器不可用或过载、连接失败和超时
+func canFallback(upstreamErr *upstreamError) bool {
+	switch upstreamErr.Type {
+	case types.ErrorTypeConnection, types.ErrorTypeUnavailable, types.ErrorTypeOverloaded, types.ErrorTypeModelNotFound, types.ErrorTypeTimeout:
+		return true
+	}
+	return upstreamErr.Status == http.StatusServiceUnavailable
+}
+
+// postWithFallback 向目标模型发起请求，只返回状态码为 200 的响应。还没有收到可用响应且失败原因允许降级时，
+// 按配置的降级链依次尝试下一个模型。ollamaReq 的 model 字段会改写为每次尝试的模型，
+// 返回的目标为最后一次尝试的目标
+func postWithFallback(ctx context.Context, pool *ollama.Pool, collector MetricsCollector, target ollama.Target, path string, ollamaReq map[string]interface{}) (*ollama.Response, ollama.Target, error) {
+	chain := pool.Fallbacks(target.Model)
+	tried := map[string]bool{target.Model: true}
+	for {
+		resp, err := postModel(ctx, pool, target, path, ollamaReq)
+		if err == nil {
+			return resp, target, nil
+		}
+
+		upstreamErr := classifyError(contextError(ctx, err))
+		if ctx.Err() != nil || !canFallback(upstreamErr) {
+			return nil, target, err
+		}
+		for len(chain) > 0 && tried[chain[0]] {
+			chain = chain[1:]
+		}
+		if len(chain) == 0 {
+			return nil, target, err
+		}
+
+		next := target.Fallback(chain[0])
+		tried[next.Model] = true
+		chain = chain[1:]
+		log.Printf("Falling back from %s to %s: %v", target.Model, next.Model, err)
+		collector.ObserveFallback(target.Model, next.Model, upstreamErr.Type)
+		target = next
+	}
+}
+
+// postModel 向目标模型发起一次请求，非 200 响应读取错误信息后关闭，错误中带有返回该响应的服务器
+func postModel(ctx context.Context, pool *ollama.Pool, target ollama.Target, path string, ollamaReq map[string]interface{}) (*ollama.Response, error) {
+	ollamaReq["model"] = target.Model
+	body, err := json.Marshal(ollamaReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	resp, err := pool.Post(ctx, target, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, &ollama.RequestError{Server: resp.Server.Name, Attempts: resp.Attempts, Err: upstreamStatusError(resp.Response)}
+	}
+	return resp, nil
+}
+
+// responseModel 返回应答中报告给客户端的模型名，降级时为实际使用的模型，否则为客户端请求的模型名
+func responseModel(requested string, target ollama.Target) string {
+	if target.FallbackFrom != "" {
+		return target.Model
+	}
+	return requested
+}
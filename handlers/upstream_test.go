@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"llm-fw/config"
+	"llm-fw/ollama"
+	"llm-fw/types"
+)
+
+// fallbackCollector 记录模型降级，其余指标丢弃
+type fallbackCollector struct {
+	types.NoopMetricsCollector
+
+	mu        sync.Mutex
+	fallbacks []string
+}
+
+func (c *fallbackCollector) ObserveFallback(from, to, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fallbacks = append(c.fallbacks, fmt.Sprintf("%s->%s:%s", from, to, reason))
+}
+
+// modelUpstream 模拟按模型名返回不同结果的 Ollama 服务器，models 中没有的模型返回 200，
+// requested 按顺序记录收到的模型名
+func modelUpstream(t *testing.T, models map[string]int, requested *[]string) *ollama.Pool {
+	t.Helper()
+
+	var mu sync.Mutex
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		*requested = append(*requested, req.Model)
+		mu.Unlock()
+
+		switch status := models[req.Model]; status {
+		case 0:
+			w.Write([]byte(`{"done":true}`))
+		case http.StatusNotFound:
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("model %q not found, try pulling it first", req.Model)})
+		default:
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error":"upstream failure"}`))
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	pool, err := ollama.NewPool([]config.OllamaServer{{Name: "default", URL: upstream.URL}}, config.BalanceRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 不在同一模型上重试，每次请求上游只收到一次
+	pool.SetClient(ollama.NewHTTPClient(config.UpstreamTimeouts{}, nil, config.UpstreamRetry{MaxAttempts: 1}))
+	return pool
+}
+
+func TestCanFallback(t *testing.T) {
+	tests := []struct {
+		err  *upstreamError
+		want bool
+	}{
+		{err: &upstreamError{Type: types.ErrorTypeModelNotFound, Status: http.StatusNotFound}, want: true},
+		{err: &upstreamError{Type: types.ErrorTypeConnection}, want: true},
+		{err: &upstreamError{Type: types.ErrorTypeUnavailable}, want: true},
+		{err: &upstreamError{Type: types.ErrorTypeOverloaded}, want: true},
+		{err: &upstreamError{Type: types.ErrorTypeTimeout}, want: true},
+		{err: &upstreamError{Type: types.ErrorTypeUpstream5xx, Status: http.StatusServiceUnavailable}, want: true},
+		{err: &upstreamError{Type: types.ErrorTypeUpstream5xx, Status: http.StatusInternalServerError}, want: false},
+		{err: &upstreamError{Type: types.ErrorTypeUpstream4xx, Status: http.StatusBadRequest}, want: false},
+		{err: &upstreamError{Type: types.ErrorTypeCancelled}, want: false},
+		{err: &upstreamError{Type: types.ErrorTypeInterrupted}, want: false},
+		{err: &upstreamError{Type: types.ErrorTypeInternal}, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := canFallback(tt.err); got != tt.want {
+			t.Errorf("canFallback(%s, %d) = %v, want %v", tt.err.Type, tt.err.Status, got, tt.want)
+		}
+	}
+}
+
+func TestPostWithFallback(t *testing.T) {
+	tests := []struct {
+		name      string
+		models    map[string]int // 上游对各模型返回的状态码
+		fallbacks map[string][]string
+		target    ollama.Target
+		requested []string // 依次请求的模型
+		served    ollama.Target
+		fallback  []string // 记录的降级
+		errType   string   // 全部失败时最后一次的失败原因
+	}{
+		{
+			name:      "no fallback needed",
+			fallbacks: map[string][]string{"llama3:70b": {"llama3"}},
+			target:    ollama.Target{Model: "llama3:70b"},
+			requested: []string{"llama3:70b"},
+			served:    ollama.Target{Model: "llama3:70b"},
+		},
+		{
+			name:      "model not found",
+			models:    map[string]int{"llama3:70b": http.StatusNotFound},
+			fallbacks: map[string][]string{"llama3:70b": {"llama3"}},
+			target:    ollama.Target{Model: "llama3:70b", Alias: "gpt-4o"},
+			requested: []string{"llama3:70b", "llama3"},
+			served:    ollama.Target{Model: "llama3", Alias: "gpt-4o", FallbackFrom: "llama3:70b"},
+			fallback:  []string{"llama3:70b->llama3:" + types.ErrorTypeModelNotFound},
+		},
+		{
+			// 降级链只取首个模型的配置，已经尝试过的模型跳过
+			name:      "chain skips tried models",
+			models:    map[string]int{"a": http.StatusNotFound, "b": http.StatusServiceUnavailable},
+			fallbacks: map[string][]string{"a": {"b", "a", "c"}, "b": {"d"}},
+			target:    ollama.Target{Model: "a"},
+			requested: []string{"a", "b", "c"},
+			served:    ollama.Target{Model: "c", Alias: "a", FallbackFrom: "b"},
+			fallback:  []string{"a->b:" + types.ErrorTypeModelNotFound, "b->c:" + types.ErrorTypeUpstream5xx},
+		},
+		{
+			name:      "error that does not allow fallback",
+			models:    map[string]int{"a": http.StatusBadRequest},
+			fallbacks: map[string][]string{"a": {"b"}},
+			target:    ollama.Target{Model: "a"},
+			requested: []string{"a"},
+			served:    ollama.Target{Model: "a"},
+			errType:   types.ErrorTypeUpstream4xx,
+		},
+		{
+			name:      "chain exhausted",
+			models:    map[string]int{"a": http.StatusNotFound, "b": http.StatusNotFound},
+			fallbacks: map[string][]string{"a": {"b"}},
+			target:    ollama.Target{Model: "a"},
+			requested: []string{"a", "b"},
+			served:    ollama.Target{Model: "b", Alias: "a", FallbackFrom: "a"},
+			fallback:  []string{"a->b:" + types.ErrorTypeModelNotFound},
+			errType:   types.ErrorTypeModelNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requested []string
+			pool := modelUpstream(t, tt.models, &requested)
+			pool.SetFallbacks(tt.fallbacks)
+			collector := &fallbackCollector{}
+
+			ollamaReq := map[string]interface{}{"model": tt.target.Model, "stream": false}
+			resp, served, err := postWithFallback(context.Background(), pool, collector, tt.target, "/api/chat", ollamaReq)
+			if tt.errType == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK || ollamaReq["model"] != served.Model {
+					t.Fatalf("status %d, request model %v", resp.StatusCode, ollamaReq["model"])
+				}
+			} else if got := classifyError(err); got.Type != tt.errType {
+				t.Fatalf("error type %s, want %s: %v", got.Type, tt.errType, err)
+			}
+
+			if served != tt.served {
+				t.Fatalf("served by %+v, want %+v", served, tt.served)
+			}
+			if strings.Join(requested, ",") != strings.Join(tt.requested, ",") {
+				t.Fatalf("requested models %v, want %v", requested, tt.requested)
+			}
+			if strings.Join(collector.fallbacks, ",") != strings.Join(tt.fallback, ",") {
+				t.Fatalf("recorded fallbacks %v, want %v", collector.fallbacks, tt.fallback)
+			}
+		})
+	}
+}
+
+func TestPostWithFallbackStopsWhenCancelled(t *testing.T) {
+	var requested []string
+	pool := modelUpstream(t, map[string]int{"a": http.StatusNotFound}, &requested)
+	pool.SetFallbacks(map[string][]string{"a": {"b"}})
+
+	// 客户端已经断开时不再尝试降级模型
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := postWithFallback(ctx, pool, &fallbackCollector{}, ollama.Target{Model: "a"}, "/api/chat", map[string]interface{}{})
+	if !errors.Is(err, context.Canceled) || len(requested) != 0 {
+		t.Fatalf("expected the cancellation without upstream requests, got %v after %v", err, requested)
+	}
+}
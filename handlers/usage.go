@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/auth"
+	"llm-fw/ratelimit"
+)
+
+// UsageHandler 处理用量查询请求
+type UsageHandler struct {
+	limiter *ratelimit.Limiter
+}
+
+// NewUsageHandler 创建一个新的用量处理器，limiter 为 nil 表示未启用限流
+func NewUsageHandler(limiter *ratelimit.Limiter) *UsageHandler {
+	return &UsageHandler{limiter: limiter}
+}
+
+// GetUsage 返回当前身份的限流规则和本日、本月用量
+func (h *UsageHandler) GetUsage(c *gin.Context) {
+	identity := auth.Identity(c)
+	if h.limiter == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"identity":   identity,
+			"rate_limit": nil,
+		})
+		return
+	}
+
+	limits := h.limiter.Limits(identity)
+	day, month := h.limiter.Usage(identity)
+
+	c.JSON(http.StatusOK, gin.H{
+		"identity": identity,
+		"rate_limit": gin.H{
+			"requests_per_minute": limits.RequestsPerMinute,
+			"max_concurrent":      limits.MaxConcurrent,
+			"daily_tokens":        limits.DailyTokens,
+			"monthly_tokens":      limits.MonthlyTokens,
+		},
+		"day":   day,
+		"month": month,
+	})
+}
@@ -1,25 +1,99 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"llm-fw/config"
+	"llm-fw/handlers"
 	"llm-fw/metrics"
+	"llm-fw/ollama"
 	"llm-fw/routes"
 	"llm-fw/storage"
 )
 
+const (
+	// defaultConfigPath 是未指定 --config 时读取的配置文件
+	defaultConfigPath = "config.yaml"
+	// defaultShutdownTimeout 是未配置时关闭服务等待进行中的请求完成的最长时间
+	defaultShutdownTimeout = 30 * time.Second
+	// interruptGracePeriod 是中断剩余请求后等待它们写入请求记录的最长时间
+	interruptGracePeriod = 5 * time.Second
+)
+
 func main() {
-	// 初始化配置
-	cfg := config.NewConfig()
-
-	// 尝试加载配置文件
-	if loadedCfg, err := config.LoadConfig("config.yaml"); err == nil {
-		cfg = loadedCfg
-		log.Println("已加载配置文件 config.yaml")
-	} else {
+	configPath := flag.String("config", defaultConfigPath, "配置文件路径")
+	flag.Usage = usage
+	flag.Parse()
+
+	if args := flag.Args(); len(args) > 0 {
+		os.Exit(runCommand(args, *configPath))
+	}
+	serve(*configPath)
+}
+
+// usage 输出命令行用法
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "用法:\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  %s [--config 路径]                  启动服务\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "  %s config validate [--config 路径]  校验配置文件\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// runCommand 执行子命令并返回退出码
+func runCommand(args []string, configPath string) int {
+	if len(args) < 2 || args[0] != "config" || args[1] != "validate" {
+		usage()
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	path := fs.String("config", configPath, "配置文件路径")
+	if err := fs.Parse(args[2:]); err != nil {
+		return 2
+	}
+
+	if _, err := config.LoadConfig(*path); err != nil {
+		fmt.Fprintf(os.Stderr, "配置文件 %s 无效:\n%v\n", *path, err)
+		return 1
+	}
+	fmt.Printf("配置文件 %s 有效\n", *path)
+	return 0
+}
+
+// explicitConfig 判断是否通过 --config 指定了配置文件
+func explicitConfig() bool {
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicit = true
+		}
+	})
+	return explicit
+}
+
+// serve 加载配置并启动服务
+func serve(configPath string) {
+	// 初始化配置。默认路径的配置文件不存在时使用默认配置，其他加载错误都直接退出
+	cfg, err := config.LoadConfig(configPath)
+	watch := err == nil
+	switch {
+	case err == nil:
+		log.Printf("已加载配置文件 %s", configPath)
+	case errors.Is(err, os.ErrNotExist) && !explicitConfig():
+		cfg = config.NewConfig()
 		log.Printf("未找到配置文件，使用默认配置: %v", err)
+	default:
+		log.Fatalf("加载配置文件 %s 失败:\n%v", configPath, err)
 	}
 
 	// 初始化存储
@@ -32,16 +106,74 @@ func main() {
 	// 初始化指标收集器
 	metricsCollector := metrics.NewMetrics(store)
 
+	// 初始化上游服务器池
+	pool, err := ollama.NewPoolFromConfig(cfg)
+	if err != nil {
+		log.Fatalf("初始化上游服务器池失败: %v", err)
+	}
+	log.Printf("已加载 %d 个上游服务器，负载均衡策略: %s", len(pool.Servers()), pool.Strategy())
+
 	// 设置路由
-	router, err := routes.SetupRouter(cfg.Ollama.URL, store, metricsCollector)
+	app, err := routes.SetupRouter(cfg, pool, store, metricsCollector)
 	if err != nil {
 		log.Fatalf("设置路由失败: %v", err)
 	}
 
-	// 启动服务器
+	// 收到 SIGHUP 或配置文件变化时重新加载配置
+	if watch {
+		watcher := config.NewWatcher(configPath, cfg, app.Reload)
+		watcher.Start()
+		defer watcher.Stop()
+		log.Printf("配置文件 %s 修改或收到 SIGHUP 时自动重新加载", configPath)
+	}
+
+	// 启动服务器。所有请求的 context 都派生自 base，关闭超时后取消 base 以中断剩余的请求
+	base, interrupt := context.WithCancelCause(context.Background())
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
+	server := &http.Server{
+		Addr:        addr,
+		Handler:     app.Router,
+		BaseContext: func(net.Listener) context.Context { return base },
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
 	log.Printf("服务器启动在 %s", addr)
-	if err := router.Run(addr); err != nil {
+
+	// 收到 SIGINT 或 SIGTERM 后停止接受新请求，等待进行中的请求（包括流式响应）完成。再次收到信号时直接退出
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-serveErr:
 		log.Fatalf("服务器启动失败: %v", err)
+	case sig := <-signals:
+		signal.Stop(signals)
+		shutdown(cfg, server, app, interrupt, sig)
 	}
 }
+
+// shutdown 优雅关闭服务：等待进行中的请求完成，超过 server.shutdown_timeout 时以 handlers.ErrInterrupted
+// 中断剩余的请求并等待它们写入请求记录，最后停止后台任务并写回统计信息。存储由调用方随后关闭
+func shutdown(cfg *config.Config, server *http.Server, app *routes.App, interrupt context.CancelCauseFunc, sig os.Signal) {
+	timeout := cfg.Server.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	log.Printf("收到信号 %s，停止接受新请求，最多等待 %s 让进行中的请求完成", sig, timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("进行中的请求未能在 %s 内完成，中断剩余的请求", timeout)
+		interrupt(handlers.ErrInterrupted)
+	}
+	if !app.Wait(interruptGracePeriod) {
+		log.Printf("仍有请求未返回，强制关闭连接")
+	}
+	server.Close()
+	interrupt(context.Canceled)
+
+	app.Close()
+	log.Printf("服务已关闭")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/config"
+	"llm-fw/handlers"
+	"llm-fw/metrics"
+	"llm-fw/ollama"
+	"llm-fw/routes"
+	"llm-fw/storage"
+	"llm-fw/types"
+)
+
+// orderedStorage 按顺序记录请求记录的写入和存储的关闭
+type orderedStorage struct {
+	types.Storage
+
+	mu     sync.Mutex
+	events []string
+	saved  []*types.Request
+}
+
+func (s *orderedStorage) SaveRequest(req *types.Request) error {
+	s.mu.Lock()
+	s.events = append(s.events, "save")
+	s.saved = append(s.saved, req)
+	s.mu.Unlock()
+	return s.Storage.SaveRequest(req)
+}
+
+func (s *orderedStorage) Close() error {
+	s.mu.Lock()
+	s.events = append(s.events, "close")
+	s.mu.Unlock()
+	return s.Storage.Close()
+}
+
+func TestShutdownInterruptsSlowRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// 上游返回第一段内容后一直不结束，直到请求被取消
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{"message":{"role":"assistant","content":"Once upon "},"done":false}` + "\n"))
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer upstream.Close()
+
+	cfg := config.NewConfig()
+	cfg.Ollama.URL = upstream.URL
+	cfg.Storage.Path = t.TempDir()
+	cfg.Server.ShutdownTimeout = 100 * time.Millisecond
+
+	base, err := storage.NewStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &orderedStorage{Storage: base}
+	pool, err := ollama.NewPoolFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := routes.SetupRouter(cfg, pool, store, metrics.NewMetrics(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 与 serve 相同，请求的 context 派生自可以按原因取消的 base context
+	baseCtx, interrupt := context.WithCancelCause(context.Background())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		Handler:     app.Router,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	go server.Serve(listener)
+
+	body := `{"model":"llama3","messages":[{"role":"user","content":"Tell me a story"}]}`
+	resp, err := http.Post("http://"+listener.Addr().String()+"/api/chat", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if line, err := bufio.NewReader(resp.Body).ReadString('\n'); err != nil || !strings.Contains(line, "Once upon") {
+		t.Fatalf("first chunk %q: %v", line, err)
+	}
+	<-started
+
+	done := make(chan struct{})
+	go func() {
+		shutdown(cfg, server, app, interrupt, syscall.SIGTERM)
+		store.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not return")
+	}
+
+	// 超过关闭超时后上游请求被取消
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("upstream request not cancelled")
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if strings.Join(store.events, ",") != "save,close" {
+		t.Fatalf("events %v, want the request record saved before the storage is closed", store.events)
+	}
+	rec := store.saved[0]
+	if rec.ErrorType != types.ErrorTypeInterrupted || !strings.Contains(rec.Error, handlers.ErrInterrupted.Error()) {
+		t.Fatalf("record error %q (%s), want %s", rec.Error, rec.ErrorType, types.ErrorTypeInterrupted)
+	}
+	if rec.Response != "Once upon " {
+		t.Fatalf("partial response %q not saved", rec.Response)
+	}
+}
@@ -20,11 +20,14 @@ type Metrics struct {
 	mu             sync.RWMutex
 	ModelStats     map[string]*types.ModelStats
 	serverHealth   map[string]bool
+	queueDepth     map[string]int
+	serverStats    map[string]*types.ServerStats
 	totalRequests  int64
 	totalTokensIn  int64
 	totalTokensOut int64
 	failedRequests int64
 	storage        ModelStatsStorage
+	prometheus     *Prometheus
 }
 
 // NewMetrics 创建一个新的指标收集器
@@ -32,7 +35,10 @@ func NewMetrics(storage ModelStatsStorage) *Metrics {
 	m := &Metrics{
 		ModelStats:   make(map[string]*types.ModelStats),
 		serverHealth: make(map[string]bool),
+		queueDepth:   make(map[string]int),
+		serverStats:  make(map[string]*types.ServerStats),
 		storage:      storage,
+		prometheus:   NewPrometheus(),
 	}
 
 	// 从存储中加载统计信息
@@ -81,6 +87,9 @@ func (m *Metrics) RecordRequest(model, server string, tokensIn, tokensOut int64,
 		stats.FailedRequests++
 	}
 
+	// 更新服务器统计信息
+	m.recordServer(server, tokensIn, tokensOut, latency, isSuccess)
+
 	// 更新总体统计信息
 	m.totalRequests++
 	m.totalTokensIn += tokensIn
@@ -97,6 +106,60 @@ func (m *Metrics) RecordRequest(model, server string, tokensIn, tokensOut int64,
 	}
 }
 
+// Flush 把所有模型的统计信息写回存储，关闭服务前调用
+func (m *Metrics) Flush() {
+	if m.storage == nil {
+		return
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for model, stats := range m.ModelStats {
+		if err := m.storage.SaveModelStats(model, stats); err != nil {
+			log.Printf("Failed to save model stats for %s: %v", model, err)
+		}
+	}
+}
+
+// ObserveRequest 记录一次完成的上游调用，同时更新汇总统计和 Prometheus 指标
+func (m *Metrics) ObserveRequest(obs *types.RequestObservation) {
+	m.RecordRequest(obs.Model, obs.Server, obs.TokensIn, obs.TokensOut, obs.LatencyMs, obs.Status != types.RequestStatusError)
+	m.prometheus.Observe(obs)
+}
+
+// ObserveFallback 记录一次模型降级
+func (m *Metrics) ObserveFallback(from, to, reason string) {
+	m.prometheus.ObserveFallback(from, to, reason)
+}
+
+// ObserveCache 记录一次响应缓存查询
+func (m *Metrics) ObserveCache(model, result, tier string) {
+	m.prometheus.ObserveCache(model, result, tier)
+}
+
+// Prometheus 返回 Prometheus 导出器
+func (m *Metrics) Prometheus() *Prometheus {
+	return m.prometheus
+}
+
+// recordServer 更新单个上游服务器的统计信息，调用方需持有写锁
+func (m *Metrics) recordServer(server string, tokensIn, tokensOut int64, latency int64, isSuccess bool) {
+	stats, exists := m.serverStats[server]
+	if !exists {
+		stats = &types.ServerStats{}
+		m.serverStats[server] = stats
+	}
+
+	stats.TotalRequests++
+	stats.TotalTokensIn += tokensIn
+	stats.TotalTokensOut += tokensOut
+	stats.LastUsed = time.Now()
+	stats.AverageLatency = (stats.AverageLatency*float64(stats.TotalRequests-1) + float64(latency)) / float64(stats.TotalRequests)
+	if !isSuccess {
+		stats.FailedRequests++
+	}
+}
+
 // GetMetrics 获取所有指标
 func (m *Metrics) GetMetrics() *types.Metrics {
 	m.mu.RLock()
@@ -107,11 +170,22 @@ func (m *Metrics) GetMetrics() *types.Metrics {
 		TotalTokensIn:  m.totalTokensIn,
 		TotalTokensOut: m.totalTokensOut,
 		FailedRequests: m.failedRequests,
-		ServerHealth:   m.serverHealth,
+		ServerHealth:   m.copyServerHealth(),
+		QueueDepth:     m.copyQueueDepth(),
+		ServerStats:    m.copyServerStats(),
 		ModelStats:     m.ModelStats,
 	}
 }
 
+// copyServerHealth 复制服务器健康状态，避免调用方在锁外读取时与更新并发，调用方需持有读锁
+func (m *Metrics) copyServerHealth() map[string]bool {
+	health := make(map[string]bool, len(m.serverHealth))
+	for server, healthy := range m.serverHealth {
+		health[server] = healthy
+	}
+	return health
+}
+
 // UpdateServerHealth 更新服务器健康状态
 func (m *Metrics) UpdateServerHealth(server string, isHealthy bool) {
 	m.mu.Lock()
@@ -119,6 +193,32 @@ func (m *Metrics) UpdateServerHealth(server string, isHealthy bool) {
 	m.serverHealth[server] = isHealthy
 }
 
+// copyQueueDepth 复制每个模型的排队请求数，调用方需持有读锁
+func (m *Metrics) copyQueueDepth() map[string]int {
+	depth := make(map[string]int, len(m.queueDepth))
+	for model, n := range m.queueDepth {
+		depth[model] = n
+	}
+	return depth
+}
+
+// UpdateQueueDepth 更新模型的排队请求数
+func (m *Metrics) UpdateQueueDepth(model string, depth int) {
+	m.mu.Lock()
+	if depth > 0 {
+		m.queueDepth[model] = depth
+	} else {
+		delete(m.queueDepth, model)
+	}
+	m.mu.Unlock()
+	m.prometheus.SetQueueDepth(model, depth)
+}
+
+// ObserveQueueWait 记录一次排队的等待时间，priority 为请求的优先级，result 为排队的结果
+func (m *Metrics) ObserveQueueWait(model, priority string, wait time.Duration, result string) {
+	m.prometheus.ObserveQueueWait(model, priority, wait, result)
+}
+
 // GetModelStats 获取指定模型的统计信息
 func (m *Metrics) GetModelStats(model string) *types.ModelStats {
 	m.mu.RLock()
@@ -145,6 +245,23 @@ func (m *Metrics) GetAllModelStats() map[string]*types.ModelStats {
 	return stats
 }
 
+// GetAllServerStats 获取所有上游服务器的统计信息
+func (m *Metrics) GetAllServerStats() map[string]*types.ServerStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.copyServerStats()
+}
+
+// copyServerStats 复制服务器统计信息，调用方需持有读锁
+func (m *Metrics) copyServerStats() map[string]*types.ServerStats {
+	stats := make(map[string]*types.ServerStats, len(m.serverStats))
+	for server, serverStats := range m.serverStats {
+		statsCopy := *serverStats
+		stats[server] = &statsCopy
+	}
+	return stats
+}
+
 // CleanupSystemStats 清理系统统计信息
 func (m *Metrics) CleanupSystemStats() {
 	m.mu.Lock()
@@ -155,5 +272,6 @@ func (m *Metrics) CleanupSystemStats() {
 	m.totalTokensOut = 0
 	m.failedRequests = 0
 	m.serverHealth = make(map[string]bool)
+	m.serverStats = make(map[string]*types.ServerStats)
 	m.ModelStats = make(map[string]*types.ModelStats)
 }
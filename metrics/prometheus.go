@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"llm-fw/ollama"
+	"llm-fw/types"
+)
+
+// namespace 是所有导出指标的前缀
+const namespace = "llmfw"
+
+// Prometheus 以 Prometheus 文本格式导出请求指标
+type Prometheus struct {
+	registry *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	errors           *prometheus.CounterVec
+	fallbacks        *prometheus.CounterVec
+	cacheRequests    *prometheus.CounterVec
+	tokens           *prometheus.CounterVec
+	latency          *prometheus.HistogramVec
+	timeToFirstToken *prometheus.HistogramVec
+	tokensPerSecond  *prometheus.HistogramVec
+	httpInFlight     *prometheus.GaugeVec
+	queueDepth       *prometheus.GaugeVec
+	queueWait        *prometheus.HistogramVec
+}
+
+// NewPrometheus 创建一个新的 Prometheus 导出器
+func NewPrometheus() *Prometheus {
+	p := &Prometheus{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Upstream model requests by model, server, source and status.",
+		}, []string{"model", "server", "source", "status"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "request_errors_total",
+			Help:      "Failed upstream model requests by model, server and error type.",
+		}, []string{"model", "server", "type"}),
+		fallbacks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "model_fallbacks_total",
+			Help:      "Requests moved to the next model of a fallback chain by original model, fallback model and error type.",
+		}, []string{"from", "to", "reason"}),
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_requests_total",
+			Help:      "Response cache lookups for deterministic requests by model, result (hit, miss or bypass) and tier.",
+		}, []string{"model", "result", "tier"}),
+		tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tokens_total",
+			Help:      "Tokens processed by model, server and type (prompt or completion).",
+		}, []string{"model", "server", "type"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "End-to-end upstream request latency.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+		}, []string{"model", "server", "source"}),
+		timeToFirstToken: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "time_to_first_token_seconds",
+			Help:      "Time until the first streamed content arrived from upstream.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30},
+		}, []string{"model", "server"}),
+		tokensPerSecond: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "tokens_per_second",
+			Help:      "Completion tokens generated per second after the first token.",
+			Buckets:   []float64{1, 5, 10, 20, 30, 50, 75, 100, 150, 250},
+		}, []string{"model", "server"}),
+		httpInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "http_in_flight_requests",
+			Help:      "HTTP requests currently being served by route.",
+		}, []string{"route"}),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "queue_depth",
+			Help:      "Chat and generate requests waiting in the scheduler queue by model.",
+		}, []string{"model"}),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "queue_wait_seconds",
+			Help:      "Time requests spent in the scheduler queue by model, priority and result (admitted, timeout, cancelled or evicted).",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"model", "priority", "result"}),
+	}
+
+	p.registry.MustRegister(
+		p.requests,
+		p.errors,
+		p.fallbacks,
+		p.cacheRequests,
+		p.tokens,
+		p.latency,
+		p.timeToFirstToken,
+		p.tokensPerSecond,
+		p.httpInFlight,
+		p.queueDepth,
+		p.queueWait,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return p
+}
+
+// Observe 记录一次完成的上游调用
+func (p *Prometheus) Observe(obs *types.RequestObservation) {
+	status := obs.Status
+	if status == "" {
+		status = types.RequestStatusSuccess
+	}
+	p.requests.WithLabelValues(obs.Model, obs.Server, obs.Source, status).Inc()
+	if status == types.RequestStatusError {
+		errorType := obs.ErrorType
+		if errorType == "" {
+			errorType = types.ErrorTypeInternal
+		}
+		p.errors.WithLabelValues(obs.Model, obs.Server, errorType).Inc()
+	}
+	p.tokens.WithLabelValues(obs.Model, obs.Server, "prompt").Add(float64(obs.TokensIn))
+	p.tokens.WithLabelValues(obs.Model, obs.Server, "completion").Add(float64(obs.TokensOut))
+	p.latency.WithLabelValues(obs.Model, obs.Server, obs.Source).Observe(float64(obs.LatencyMs) / 1000)
+
+	if obs.FirstTokenMs > 0 {
+		p.timeToFirstToken.WithLabelValues(obs.Model, obs.Server).Observe(float64(obs.FirstTokenMs) / 1000)
+	}
+
+	// 生成速度不计入首 token 之前的排队和 prompt 处理时间
+	generationMs := obs.LatencyMs - obs.FirstTokenMs
+	if obs.TokensOut > 0 && generationMs > 0 {
+		p.tokensPerSecond.WithLabelValues(obs.Model, obs.Server).Observe(float64(obs.TokensOut) * 1000 / float64(generationMs))
+	}
+}
+
+// ObserveFallback 记录一次从 from 降级到 to 的请求，reason 为触发降级的失败原因
+func (p *Prometheus) ObserveFallback(from, to, reason string) {
+	p.fallbacks.WithLabelValues(from, to, reason).Inc()
+}
+
+// ObserveCache 记录一次响应缓存查询，tier 为命中的层级，未命中时为空
+func (p *Prometheus) ObserveCache(model, result, tier string) {
+	p.cacheRequests.WithLabelValues(model, result, tier).Inc()
+}
+
+// SetQueueDepth 更新模型在调度器中排队的请求数
+func (p *Prometheus) SetQueueDepth(model string, depth int) {
+	p.queueDepth.WithLabelValues(model).Set(float64(depth))
+}
+
+// ObserveQueueWait 记录一次排队的等待时间
+func (p *Prometheus) ObserveQueueWait(model, priority string, wait time.Duration, result string) {
+	p.queueWait.WithLabelValues(model, priority, result).Observe(wait.Seconds())
+}
+
+// RegisterPool 导出上游服务器池中每个服务器正在进行的请求数和健康状态
+func (p *Prometheus) RegisterPool(pool *ollama.Pool) {
+	p.registry.MustRegister(&poolCollector{
+		pool: pool,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "upstream", "in_flight_requests"),
+			"Requests currently in flight to each upstream server.",
+			[]string{"server"}, nil,
+		),
+		healthyDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "upstream", "healthy"),
+			"Whether each upstream server accepts requests (1) or its circuit breaker is open or half-open (0).",
+			[]string{"server"}, nil,
+		),
+	})
+}
+
+// Middleware 统计每个路由正在处理的 HTTP 请求数
+func (p *Prometheus) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			c.Next()
+			return
+		}
+
+		gauge := p.httpInFlight.WithLabelValues(route)
+		gauge.Inc()
+		defer gauge.Dec()
+		c.Next()
+	}
+}
+
+// Handler 返回 /metrics 的 HTTP 处理器
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+// poolCollector 在采集时读取上游服务器的并发数和健康状态
+type poolCollector struct {
+	pool        *ollama.Pool
+	desc        *prometheus.Desc
+	healthyDesc *prometheus.Desc
+}
+
+// Describe 实现了 prometheus.Collector 接口
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+	ch <- c.healthyDesc
+}
+
+// Collect 实现了 prometheus.Collector 接口
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, server := range c.pool.Servers() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(server.InFlight()), server.Name)
+
+		healthy := 0.0
+		if server.Healthy() {
+			healthy = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.healthyDesc, prometheus.GaugeValue, healthy, server.Name)
+	}
+}
@@ -0,0 +1,183 @@
+package ollama
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"llm-fw/config"
+)
+
+const (
+	defaultFailureThreshold = 3
+	defaultOpenDuration     = 30 * time.Second
+)
+
+// BreakerState 是上游服务器熔断器的状态
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"    // 正常转发请求
+	BreakerOpen     BreakerState = "open"      // 连续失败后熔断，不再转发请求
+	BreakerHalfOpen BreakerState = "half_open" // 熔断时间结束，等待探测结果决定恢复或继续熔断
+)
+
+// ServerStatus 描述上游服务器的健康状态，用于 /api/servers
+type ServerStatus struct {
+	Name                string       `json:"name"`
+	URL                 string       `json:"url"`
+	State               BreakerState `json:"state"`
+	Healthy             bool         `json:"healthy"`
+	InFlight            int64        `json:"in_flight"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	LastError           string       `json:"last_error,omitempty"`
+	LastCheck           *time.Time   `json:"last_check,omitempty"`
+	LatencyMs           float64      `json:"latency_ms"`
+	OpenedAt            *time.Time   `json:"opened_at,omitempty"`
+}
+
+// breaker 是单个上游服务器的熔断器，主动探测和实际请求的结果都会计入
+type breaker struct {
+	threshold    int
+	openDuration time.Duration
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	lastError string
+	lastCheck time.Time
+	latency   time.Duration
+}
+
+// newBreaker 创建一个关闭状态的熔断器，未配置的参数使用默认值
+func newBreaker(cfg config.HealthCheck) *breaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = defaultFailureThreshold
+	}
+	if cfg.OpenDuration <= 0 {
+		cfg.OpenDuration = defaultOpenDuration
+	}
+	return &breaker{
+		threshold:    cfg.FailureThreshold,
+		openDuration: cfg.OpenDuration,
+		state:        BreakerClosed,
+	}
+}
+
+// Healthy 判断服务器当前是否可以接收请求，未启用健康检查时总是可以
+func (s *Server) Healthy() bool {
+	if s.breaker == nil {
+		return true
+	}
+	s.breaker.mu.Lock()
+	defer s.breaker.mu.Unlock()
+	return s.breaker.state == BreakerClosed
+}
+
+// State 返回服务器的熔断器状态
+func (s *Server) State() BreakerState {
+	if s.breaker == nil {
+		return BreakerClosed
+	}
+	s.breaker.mu.Lock()
+	defer s.breaker.mu.Unlock()
+	return s.breaker.state
+}
+
+// Status 返回服务器的健康状态快照
+func (s *Server) Status() ServerStatus {
+	status := ServerStatus{
+		Name:     s.Name,
+		URL:      s.URL,
+		State:    BreakerClosed,
+		Healthy:  true,
+		InFlight: s.InFlight(),
+	}
+	if s.breaker == nil {
+		return status
+	}
+
+	b := s.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status.State = b.state
+	status.Healthy = b.state == BreakerClosed
+	status.ConsecutiveFailures = b.failures
+	status.LastError = b.lastError
+	status.LatencyMs = float64(b.latency.Microseconds()) / 1000
+	if !b.lastCheck.IsZero() {
+		lastCheck := b.lastCheck
+		status.LastCheck = &lastCheck
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+// shouldProbe 判断健康检查是否需要探测该服务器，熔断时间结束时进入半开状态
+func (s *Server) shouldProbe(now time.Time) bool {
+	b := s.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		if now.Sub(b.openedAt) < b.openDuration {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		log.Printf("Upstream %s circuit half-open, probing", s.Name)
+	}
+	return true
+}
+
+// recordProbe 记录一次健康检查的结果
+func (s *Server) recordProbe(now time.Time, latency time.Duration, err error) {
+	if s.breaker == nil {
+		return
+	}
+	s.breaker.mu.Lock()
+	s.breaker.lastCheck = now
+	s.breaker.latency = latency
+	s.breaker.mu.Unlock()
+
+	if err != nil {
+		s.recordFailure(err)
+	} else {
+		s.recordSuccess()
+	}
+}
+
+// recordSuccess 清零连续失败次数，半开或熔断状态下恢复转发
+func (s *Server) recordSuccess() {
+	b := s.breaker
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.lastError = ""
+	if b.state != BreakerClosed && b.state != BreakerOpen {
+		b.state = BreakerClosed
+		log.Printf("Upstream %s circuit closed", s.Name)
+	}
+}
+
+// recordFailure 记录一次失败，连续失败达到阈值或半开状态下失败时熔断
+func (s *Server) recordFailure(err error) {
+	b := s.breaker
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastError = err.Error()
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+		log.Printf("Upstream %s circuit open after %d consecutive failures: %v", s.Name, b.failures, err)
+	}
+}
@@ -0,0 +1,213 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"llm-fw/config"
+)
+
+// testReporter 记录健康检查上报的最近一次结果
+type testReporter struct {
+	mu      sync.Mutex
+	healthy map[string]bool
+}
+
+func (r *testReporter) UpdateServerHealth(server string, isHealthy bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.healthy == nil {
+		r.healthy = make(map[string]bool)
+	}
+	r.healthy[server] = isHealthy
+}
+
+func (r *testReporter) get(server string) (healthy, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	healthy, ok = r.healthy[server]
+	return healthy, ok
+}
+
+func TestNewBreakerDefaults(t *testing.T) {
+	b := newBreaker(config.HealthCheck{})
+	if b.threshold != defaultFailureThreshold || b.openDuration != defaultOpenDuration || b.state != BreakerClosed {
+		t.Fatalf("unexpected defaults: threshold %d, open duration %s, state %s", b.threshold, b.openDuration, b.state)
+	}
+
+	b = newBreaker(config.HealthCheck{FailureThreshold: 5, OpenDuration: time.Minute})
+	if b.threshold != 5 || b.openDuration != time.Minute {
+		t.Fatalf("configured values ignored: threshold %d, open duration %s", b.threshold, b.openDuration)
+	}
+}
+
+func TestBreakerStateMachine(t *testing.T) {
+	server := &Server{Name: "gpu-1", breaker: newBreaker(config.HealthCheck{FailureThreshold: 2, OpenDuration: time.Minute})}
+	failure := errors.New("connection refused")
+
+	expect := func(step string, state BreakerState, failures int) {
+		t.Helper()
+		status := server.Status()
+		if status.State != state || server.State() != state || status.ConsecutiveFailures != failures {
+			t.Fatalf("%s: state %s with %d failures, want %s with %d", step, status.State, status.ConsecutiveFailures, state, failures)
+		}
+		if healthy := state == BreakerClosed; server.Healthy() != healthy || status.Healthy != healthy {
+			t.Fatalf("%s: healthy %v in state %s", step, server.Healthy(), state)
+		}
+		if (status.OpenedAt != nil) != (state != BreakerClosed) {
+			t.Fatalf("%s: opened_at %v in state %s", step, status.OpenedAt, state)
+		}
+	}
+
+	expect("new", BreakerClosed, 0)
+
+	// 阈值以下的失败不熔断，成功清零连续失败次数
+	server.recordFailure(failure)
+	expect("first failure", BreakerClosed, 1)
+	server.recordSuccess()
+	expect("success", BreakerClosed, 0)
+	server.recordFailure(failure)
+	expect("failure after success", BreakerClosed, 1)
+
+	server.recordFailure(failure)
+	expect("threshold reached", BreakerOpen, 2)
+	if status := server.Status(); status.LastError != failure.Error() {
+		t.Fatalf("last error %q", status.LastError)
+	}
+
+	// 熔断前已经发出的请求成功返回不会提前恢复
+	server.recordSuccess()
+	expect("late success while open", BreakerOpen, 0)
+
+	// 熔断时间结束前不探测，结束后进入半开状态
+	if server.shouldProbe(time.Now()) {
+		t.Fatal("probed before the open duration elapsed")
+	}
+	expect("open duration not elapsed", BreakerOpen, 0)
+	later := time.Now().Add(time.Minute)
+	if !server.shouldProbe(later) {
+		t.Fatal("not probed after the open duration elapsed")
+	}
+	expect("open duration elapsed", BreakerHalfOpen, 0)
+
+	// 半开状态下一次失败立即重新熔断
+	server.recordProbe(later, 5*time.Millisecond, failure)
+	expect("half-open probe failed", BreakerOpen, 1)
+
+	if !server.shouldProbe(time.Now().Add(time.Minute)) {
+		t.Fatal("not probed after reopening")
+	}
+	server.recordProbe(later, 5*time.Millisecond, nil)
+	expect("half-open probe succeeded", BreakerClosed, 0)
+
+	status := server.Status()
+	if status.LastError != "" || status.LastCheck == nil || !status.LastCheck.Equal(later) || status.LatencyMs != 5 {
+		t.Fatalf("unexpected status after recovery: %+v", status)
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	server := &Server{Name: "gpu-1"}
+	for i := 0; i < 10; i++ {
+		server.recordFailure(errors.New("boom"))
+	}
+	server.recordProbe(time.Now(), time.Millisecond, errors.New("boom"))
+	if !server.Healthy() || server.State() != BreakerClosed || server.Status().ConsecutiveFailures != 0 {
+		t.Fatalf("server without a breaker became unhealthy: %+v", server.Status())
+	}
+}
+
+func TestHealthCheckerOpensAndRecovers(t *testing.T) {
+	var hits, failing int32
+	atomic.StoreInt32(&failing, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if r.URL.Path != "/api/version" {
+			t.Errorf("health check requested %s", r.URL.Path)
+		}
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"version":"0.1.0"}`))
+	}))
+	defer upstream.Close()
+
+	health := config.HealthCheck{FailureThreshold: 1, OpenDuration: 50 * time.Millisecond, Timeout: time.Second}
+	pool := newTestPool(t, config.UpstreamTimeouts{}, config.UpstreamRetry{}, upstream.URL)
+	pool.EnableCircuitBreakers(health)
+	reporter := &testReporter{}
+	checker := NewHealthChecker(pool, health, reporter)
+	server := pool.Servers()[0]
+
+	checker.Run()
+	if server.State() != BreakerOpen {
+		t.Fatalf("state %s after a failed probe", server.State())
+	}
+	if healthy, ok := reporter.get(server.Name); !ok || healthy {
+		t.Fatalf("reported healthy=%v (reported %v) after a failed probe", healthy, ok)
+	}
+
+	// 熔断期间跳过探测，但仍然上报状态
+	checker.Run()
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Fatalf("probed %d times while the circuit was open", n)
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	time.Sleep(health.OpenDuration)
+	checker.Run()
+	if server.State() != BreakerClosed || atomic.LoadInt32(&hits) != 2 {
+		t.Fatalf("state %s after %d probes", server.State(), hits)
+	}
+	if healthy, _ := reporter.get(server.Name); !healthy {
+		t.Fatal("recovered server reported as unhealthy")
+	}
+}
+
+func TestPoolSkipsOpenServers(t *testing.T) {
+	var brokenHits, healthyHits int32
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&brokenHits, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&healthyHits, 1)
+		w.Write([]byte(`{"done":true}`))
+	}))
+	defer healthy.Close()
+
+	pool := newTestPool(t, config.UpstreamTimeouts{}, config.UpstreamRetry{}, broken.URL, healthy.URL)
+	pool.EnableCircuitBreakers(config.HealthCheck{FailureThreshold: 1, OpenDuration: time.Minute})
+
+	// 实际请求的 5xx 响应也计入熔断器，熔断后只选择另一台服务器
+	for i := 0; i < 6; i++ {
+		resp, err := pool.Post(context.Background(), Target{Model: "llama3"}, "/api/chat", []byte(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if b, h := atomic.LoadInt32(&brokenHits), atomic.LoadInt32(&healthyHits); b != 1 || h != 5 {
+		t.Fatalf("broken server got %d requests, healthy server got %d", b, h)
+	}
+	if pool.Servers()[0].State() != BreakerOpen {
+		t.Fatalf("broken server is %s", pool.Servers()[0].State())
+	}
+
+	// 指定了熔断的服务器或所有服务器都熔断时没有可用的服务器
+	if _, err := pool.Post(context.Background(), Target{Model: "llama3", Server: "server-a"}, "/api/chat", []byte(`{}`)); !errors.Is(err, ErrNoServer) {
+		t.Fatalf("expected ErrNoServer for an open target server, got %v", err)
+	}
+	pool.Servers()[1].recordFailure(errors.New("boom"))
+	if _, err := pool.Post(context.Background(), Target{Model: "llama3"}, "/api/chat", []byte(`{}`)); !errors.Is(err, ErrNoServer) {
+		t.Fatalf("expected ErrNoServer when every server is open, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"llm-fw/config"
+)
+
+const (
+	defaultHealthInterval = 10 * time.Second
+	defaultHealthTimeout  = 5 * time.Second
+)
+
+// HealthReporter 接收健康检查的结果，由指标收集器实现
+type HealthReporter interface {
+	UpdateServerHealth(server string, isHealthy bool)
+}
+
+// HealthChecker 定期探测上游服务器的 /api/version，结果计入各服务器的熔断器
+type HealthChecker struct {
+	pool     *Pool
+	reporter HealthReporter
+	interval time.Duration
+	timeout  time.Duration
+
+	done chan struct{}
+	stop sync.Once
+}
+
+// NewHealthChecker 创建一个新的健康检查任务，需要调用 Start 才会定期执行
+func NewHealthChecker(pool *Pool, cfg config.HealthCheck, reporter HealthReporter) *HealthChecker {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultHealthInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultHealthTimeout
+	}
+	return &HealthChecker{
+		pool:     pool,
+		reporter: reporter,
+		interval: cfg.Interval,
+		timeout:  cfg.Timeout,
+		done:     make(chan struct{}),
+	}
+}
+
+// Interval 返回健康检查的执行间隔
+func (h *HealthChecker) Interval() time.Duration {
+	return h.interval
+}
+
+// Start 启动后台健康检查，启动时立即执行一次
+func (h *HealthChecker) Start() {
+	go func() {
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+
+		for {
+			h.Run()
+
+			select {
+			case <-ticker.C:
+			case <-h.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止后台健康检查
+func (h *HealthChecker) Stop() {
+	h.stop.Do(func() { close(h.done) })
+}
+
+// Run 并发探测所有服务器，熔断时间未结束的服务器跳过
+func (h *HealthChecker) Run() {
+	var wg sync.WaitGroup
+	for _, server := range h.pool.Servers() {
+		if server.breaker == nil || !server.shouldProbe(time.Now()) {
+			h.reporter.UpdateServerHealth(server.Name, server.Healthy())
+			continue
+		}
+
+		wg.Add(1)
+		go func(server *Server) {
+			defer wg.Done()
+			start := time.Now()
+			err := h.probe(server)
+			server.recordProbe(start, time.Since(start), err)
+			if err != nil {
+				log.Printf("Health check for %s failed: %v", server.Name, err)
+			}
+			h.reporter.UpdateServerHealth(server.Name, server.Healthy())
+		}(server)
+	}
+	wg.Wait()
+}
+
+// probe 请求服务器的 /api/version，非 200 响应视为失败
+func (h *HealthChecker) probe(server *Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	resp, err := h.pool.Get(ctx, server, "/api/version")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
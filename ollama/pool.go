@@ -0,0 +1,365 @@
+package ollama
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"llm-fw/config"
+)
+
+// ErrNoServer 表示没有能够提供该模型的上游服务器
+var ErrNoServer = errors.New("no ollama server available")
+
+// Server 表示上游服务器池中的一个 Ollama 服务器
+type Server struct {
+	Name string
+	URL  string
+
+	staticModels  map[string]bool // 配置文件中声明的模型
+	inflight      int64
+	maxConcurrent int64  // 经调度器放行的请求的并发上限，0 表示不限制
+	released      func() // 释放请求名额后调用，启用调度器时用于放行排队的请求
+
+	mu     sync.RWMutex
+	models map[string]bool // 从 /api/tags 发现的模型
+
+	breaker *breaker // 未启用健康检查时为空
+}
+
+// InFlight 返回该服务器当前未完成的请求数
+func (s *Server) InFlight() int64 {
+	return atomic.LoadInt64(&s.inflight)
+}
+
+// Release 释放 Pool.Acquire 占用的请求名额
+func (s *Server) Release() {
+	atomic.AddInt64(&s.inflight, -1)
+	if s.released != nil {
+		s.released()
+	}
+}
+
+// full 判断服务器是否已达到并发上限
+func (s *Server) full() bool {
+	return s.maxConcurrent > 0 && s.InFlight() >= s.maxConcurrent
+}
+
+// HasModel 判断该服务器是否提供指定模型
+func (s *Server) HasModel(model string) bool {
+	if s.staticModels[model] {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.models[model]
+}
+
+// SetModels 更新从上游发现的模型列表
+func (s *Server) SetModels(models []string) {
+	discovered := make(map[string]bool, len(models))
+	for _, model := range models {
+		discovered[strings.TrimSpace(model)] = true
+	}
+
+	s.mu.Lock()
+	s.models = discovered
+	s.mu.Unlock()
+}
+
+// Pool 管理一组上游服务器并按策略选择
+type Pool struct {
+	next uint64
+
+	// 服务器、策略、客户端和路由规则可以通过 Reload 整体替换
+	mu        sync.RWMutex
+	servers   []*Server
+	strategy  config.BalanceStrategy
+	client    *HTTPClient
+	routes    []config.ModelRoute
+	fallbacks map[string][]string
+
+	health    *config.HealthCheck // 未启用熔断时为空
+	scheduler *Scheduler          // 未启用调度器时为空
+}
+
+// NewPool 创建一个新的上游服务器池，上游请求使用默认的超时和重试策略
+func NewPool(servers []config.OllamaServer, strategy config.BalanceStrategy) (*Pool, error) {
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("at least one ollama server is required")
+	}
+
+	p := &Pool{
+		strategy: strategy,
+		client:   NewHTTPClient(config.UpstreamTimeouts{}, nil, config.UpstreamRetry{}),
+	}
+	for _, sc := range servers {
+		p.servers = append(p.servers, newServer(sc))
+	}
+	return p, nil
+}
+
+// newServer 按配置创建服务器
+func newServer(sc config.OllamaServer) *Server {
+	server := &Server{
+		Name:          sc.Name,
+		URL:           strings.TrimRight(sc.URL, "/"),
+		staticModels:  make(map[string]bool),
+		models:        make(map[string]bool),
+		maxConcurrent: int64(sc.MaxConcurrent),
+	}
+	for _, model := range sc.Models {
+		server.staticModels[model] = true
+	}
+	return server
+}
+
+// matches 判断服务器的地址、声明的模型和并发上限是否与配置一致
+func (s *Server) matches(sc config.OllamaServer) bool {
+	if s.URL != strings.TrimRight(sc.URL, "/") || s.maxConcurrent != int64(sc.MaxConcurrent) {
+		return false
+	}
+	models := make(map[string]bool, len(sc.Models))
+	for _, model := range sc.Models {
+		models[model] = true
+	}
+	if len(models) != len(s.staticModels) {
+		return false
+	}
+	for model := range models {
+		if !s.staticModels[model] {
+			return false
+		}
+	}
+	return true
+}
+
+// NewPoolFromConfig 根据配置创建上游服务器池
+func NewPoolFromConfig(cfg *config.Config) (*Pool, error) {
+	servers, err := cfg.OllamaServers()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := NewPool(servers, cfg.Ollama.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	pool.SetClient(NewHTTPClient(cfg.Ollama.Timeouts, cfg.Ollama.ModelTimeouts, cfg.Ollama.Retry))
+	if cfg.Ollama.HealthCheck.Enabled {
+		pool.EnableCircuitBreakers(cfg.Ollama.HealthCheck)
+	}
+	if err := pool.SetRoutes(cfg.Ollama.Routes); err != nil {
+		return nil, err
+	}
+	pool.SetFallbacks(cfg.Ollama.Fallbacks)
+	return pool, nil
+}
+
+// Reload 按新配置替换上游服务器、负载均衡策略、超时和重试策略、路由规则和降级链，以及调度器的并发上限和队列参数。
+// 名称、地址、声明的模型和并发上限都未变化的服务器沿用原有对象，保留熔断状态和已发现的模型；
+// 正在进行的请求继续使用原来的服务器和连接直到完成
+func (p *Pool) Reload(cfg *config.Config) error {
+	reload, err := p.PrepareReload(cfg)
+	if err != nil {
+		return err
+	}
+	reload.Apply()
+	return nil
+}
+
+// PoolReload 是已经构建和校验、尚未生效的服务器池配置
+type PoolReload struct {
+	pool    *Pool
+	cfg     *config.Config
+	servers []*Server
+	client  *HTTPClient
+}
+
+// PrepareReload 按新配置构建服务器和客户端并校验路由规则，不修改池的状态。
+// 配置无效时返回错误，调用 Apply 之后新配置才生效
+func (p *Pool) PrepareReload(cfg *config.Config) (*PoolReload, error) {
+	configs, err := cfg.OllamaServers()
+	if err != nil {
+		return nil, err
+	}
+
+	current := p.Servers()
+	servers := make([]*Server, 0, len(configs))
+	for _, sc := range configs {
+		if server := findServer(current, sc.Name); server != nil && server.matches(sc) {
+			servers = append(servers, server)
+			continue
+		}
+		server := newServer(sc)
+		if p.health != nil {
+			server.breaker = newBreaker(*p.health)
+		}
+		if p.scheduler != nil {
+			server.released = p.scheduler.wake
+		}
+		servers = append(servers, server)
+	}
+	if err := validateRoutes(cfg.Ollama.Routes, servers); err != nil {
+		return nil, err
+	}
+
+	return &PoolReload{
+		pool:    p,
+		cfg:     cfg,
+		servers: servers,
+		client:  NewHTTPClient(cfg.Ollama.Timeouts, cfg.Ollama.ModelTimeouts, cfg.Ollama.Retry),
+	}, nil
+}
+
+// Apply 使新配置生效，不会失败
+func (r *PoolReload) Apply() {
+	p, cfg := r.pool, r.cfg
+	p.mu.Lock()
+	previous := p.client
+	p.servers, p.strategy, p.client = r.servers, cfg.Ollama.Strategy, r.client
+	p.routes, p.fallbacks = cfg.Ollama.Routes, cfg.Ollama.Fallbacks
+	p.mu.Unlock()
+	previous.closeIdleConnections()
+
+	if p.scheduler != nil {
+		p.scheduler.update(cfg.Ollama.Scheduler)
+		p.scheduler.wake()
+	}
+}
+
+// EnableCircuitBreakers 为每个服务器启用熔断器，熔断的服务器不再被选中
+func (p *Pool) EnableCircuitBreakers(cfg config.HealthCheck) {
+	p.health = &cfg
+	for _, server := range p.Servers() {
+		server.breaker = newBreaker(cfg)
+	}
+}
+
+// Servers 返回池中的所有服务器
+func (p *Pool) Servers() []*Server {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.servers
+}
+
+// Strategy 返回当前的负载均衡策略
+func (p *Pool) Strategy() config.BalanceStrategy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.strategy
+}
+
+// Acquire 为指定模型选择一个服务器并占用一个请求名额
+// 调用方在请求结束后必须调用 Server.Release
+func (p *Pool) Acquire(model string) (*Server, error) {
+	return p.acquire(Target{Model: model})
+}
+
+// acquire 为路由目标选择一个服务器并占用一个请求名额，目标指定了服务器时只使用该服务器
+func (p *Pool) acquire(target Target) (*Server, error) {
+	candidates, err := p.targetCandidates(target)
+	if err != nil {
+		return nil, err
+	}
+	server := p.choose(target.Model, candidates)
+	atomic.AddInt64(&server.inflight, 1)
+	return server, nil
+}
+
+// targetCandidates 返回路由目标可以使用的服务器，目标指定了服务器时只包含该服务器
+func (p *Pool) targetCandidates(target Target) ([]*Server, error) {
+	if target.Server != "" {
+		server := p.server(target.Server)
+		if server == nil || !server.Healthy() {
+			return nil, fmt.Errorf("%w for model %s on server %s", ErrNoServer, target.Model, target.Server)
+		}
+		return []*Server{server}, nil
+	}
+
+	candidates := p.candidates(target.Model)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w for model %s", ErrNoServer, target.Model)
+	}
+	return candidates, nil
+}
+
+// choose 按负载均衡策略从候选服务器中选择一个
+func (p *Pool) choose(model string, candidates []*Server) *Server {
+	switch p.Strategy() {
+	case config.BalanceLeastOutstanding:
+		return p.leastOutstanding(candidates)
+	case config.BalanceModelAffinity:
+		return p.affinity(model, candidates)
+	default:
+		return p.roundRobin(candidates)
+	}
+}
+
+// server 按名称查找服务器，不存在时返回 nil
+func (p *Pool) server(name string) *Server {
+	return findServer(p.Servers(), name)
+}
+
+// findServer 在服务器列表中按名称查找服务器，不存在时返回 nil
+func findServer(servers []*Server, name string) *Server {
+	for _, server := range servers {
+		if server.Name == name {
+			return server
+		}
+	}
+	return nil
+}
+
+// candidates 返回能够提供该模型且未熔断的服务器，若没有服务器声明该模型则从全部服务器中选择
+func (p *Pool) candidates(model string) []*Server {
+	all := p.Servers()
+	servers := all
+	if model != "" {
+		var matched []*Server
+		for _, server := range all {
+			if server.HasModel(model) {
+				matched = append(matched, server)
+			}
+		}
+		if len(matched) > 0 {
+			servers = matched
+		}
+	}
+
+	healthy := make([]*Server, 0, len(servers))
+	for _, server := range servers {
+		if server.Healthy() {
+			healthy = append(healthy, server)
+		}
+	}
+	return healthy
+}
+
+// roundRobin 轮询选择服务器
+func (p *Pool) roundRobin(candidates []*Server) *Server {
+	n := atomic.AddUint64(&p.next, 1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// leastOutstanding 选择未完成请求最少的服务器，相同时轮询打散
+func (p *Pool) leastOutstanding(candidates []*Server) *Server {
+	offset := int(atomic.AddUint64(&p.next, 1) % uint64(len(candidates)))
+	best := candidates[offset]
+	for i := 1; i < len(candidates); i++ {
+		server := candidates[(offset+i)%len(candidates)]
+		if server.InFlight() < best.InFlight() {
+			best = server
+		}
+	}
+	return best
+}
+
+// affinity 按模型名哈希固定选择服务器，使同一模型尽量落在同一台机器上以复用已加载的模型
+func (p *Pool) affinity(model string, candidates []*Server) *Server {
+	h := fnv.New32a()
+	h.Write([]byte(model))
+	return candidates[h.Sum32()%uint32(len(candidates))]
+}
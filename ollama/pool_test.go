@@ -0,0 +1,178 @@
+package ollama
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"llm-fw/config"
+)
+
+// strategyPool 创建使用指定策略的服务器池，服务器依次命名为 a、b、c……
+func strategyPool(t *testing.T, strategy config.BalanceStrategy, servers ...config.OllamaServer) *Pool {
+	t.Helper()
+	for i := range servers {
+		servers[i].Name = string(rune('a' + i))
+		servers[i].URL = "http://" + servers[i].Name + ".invalid"
+	}
+	pool, err := NewPool(servers, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+// acquireNames 依次为 models 占用名额（不释放），返回选中的服务器名
+func acquireNames(t *testing.T, pool *Pool, models ...string) []string {
+	t.Helper()
+	names := make([]string, 0, len(models))
+	for _, model := range models {
+		server, err := pool.Acquire(model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, server.Name)
+	}
+	return names
+}
+
+func TestPoolStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy config.BalanceStrategy
+		servers  []config.OllamaServer
+		inflight map[string]int64 // 开始前各服务器未完成的请求数
+		broken   []string         // 已熔断的服务器
+		models   []string         // 依次请求的模型
+		want     []string
+	}{
+		{
+			name:     "round robin",
+			strategy: config.BalanceRoundRobin,
+			servers:  make([]config.OllamaServer, 3),
+			models:   []string{"llama3", "llama3", "mistral", "llama3"},
+			want:     []string{"a", "b", "c", "a"},
+		},
+		{
+			name:     "round robin over servers declaring the model",
+			strategy: config.BalanceRoundRobin,
+			servers:  []config.OllamaServer{{Models: []string{"mistral"}}, {Models: []string{"llama3"}}, {Models: []string{"llama3"}}},
+			models:   []string{"llama3", "llama3", "llama3", "mistral"},
+			want:     []string{"b", "c", "b", "a"},
+		},
+		{
+			// 没有服务器声明的模型可以发往任意服务器
+			name:     "undeclared model",
+			strategy: config.BalanceRoundRobin,
+			servers:  []config.OllamaServer{{Models: []string{"mistral"}}, {}},
+			models:   []string{"phi3", "phi3"},
+			want:     []string{"a", "b"},
+		},
+		{
+			name:     "round robin skips open servers",
+			strategy: config.BalanceRoundRobin,
+			servers:  make([]config.OllamaServer, 3),
+			broken:   []string{"a"},
+			models:   []string{"llama3", "llama3", "llama3"},
+			want:     []string{"b", "c", "b"},
+		},
+		{
+			// 未完成请求数相同时从轮询位置开始比较
+			name:     "least outstanding",
+			strategy: config.BalanceLeastOutstanding,
+			servers:  make([]config.OllamaServer, 3),
+			inflight: map[string]int64{"a": 2, "c": 1},
+			models:   []string{"llama3", "llama3", "llama3", "llama3"},
+			want:     []string{"b", "c", "b", "b"},
+		},
+		{
+			name:     "least outstanding skips open servers",
+			strategy: config.BalanceLeastOutstanding,
+			servers:  make([]config.OllamaServer, 3),
+			inflight: map[string]int64{"a": 2, "c": 1},
+			broken:   []string{"b"},
+			models:   []string{"llama3", "llama3"},
+			want:     []string{"c", "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := strategyPool(t, tt.strategy, tt.servers...)
+			pool.EnableCircuitBreakers(config.HealthCheck{FailureThreshold: 1})
+			for name, n := range tt.inflight {
+				pool.server(name).inflight = n
+			}
+			for _, name := range tt.broken {
+				pool.server(name).recordFailure(errors.New("connection refused"))
+			}
+
+			if got := acquireNames(t, pool, tt.models...); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoolModelAffinity(t *testing.T) {
+	pool := strategyPool(t, config.BalanceModelAffinity, make([]config.OllamaServer, 3)...)
+	pool.EnableCircuitBreakers(config.HealthCheck{FailureThreshold: 1})
+
+	// 同一模型总是落在同一台服务器上，与负载无关
+	chosen := make(map[string]string)
+	used := make(map[string]bool)
+	for _, model := range []string{"llama3", "mistral", "phi3", "qwen2:7b", "gemma:2b", "codellama"} {
+		names := acquireNames(t, pool, model, model, model)
+		if names[0] != names[1] || names[1] != names[2] {
+			t.Fatalf("%s spread over %v", model, names)
+		}
+		chosen[model] = names[0]
+		used[names[0]] = true
+	}
+	if len(used) < 2 {
+		t.Fatalf("every model mapped to the same server: %v", chosen)
+	}
+
+	// 选中的服务器熔断后改用其余服务器，恢复后回到原来的服务器
+	server := pool.server(chosen["llama3"])
+	server.recordFailure(errors.New("connection refused"))
+	if names := acquireNames(t, pool, "llama3", "llama3"); names[0] == server.Name || names[0] != names[1] {
+		t.Fatalf("selected %v while %s is open", names, server.Name)
+	}
+	later := server.Status().OpenedAt.Add(server.breaker.openDuration)
+	if !server.shouldProbe(later) {
+		t.Fatal("not probed after the open duration elapsed")
+	}
+	server.recordProbe(later, 0, nil)
+	if names := acquireNames(t, pool, "llama3"); names[0] != server.Name {
+		t.Fatalf("selected %s after %s recovered", names[0], server.Name)
+	}
+}
+
+func TestPoolNoServer(t *testing.T) {
+	pool := strategyPool(t, config.BalanceRoundRobin, make([]config.OllamaServer, 2)...)
+	pool.EnableCircuitBreakers(config.HealthCheck{FailureThreshold: 1})
+	for _, server := range pool.Servers() {
+		server.recordFailure(errors.New("connection refused"))
+	}
+	if _, err := pool.Acquire("llama3"); !errors.Is(err, ErrNoServer) {
+		t.Fatalf("expected ErrNoServer when every server is open, got %v", err)
+	}
+}
+
+func TestSchedulerSkipsFullServers(t *testing.T) {
+	pool, _ := newTestScheduler(t, config.Scheduler{},
+		config.OllamaServer{Name: "a", URL: "http://a.invalid", MaxConcurrent: 1},
+		config.OllamaServer{Name: "b", URL: "http://b.invalid"},
+		config.OllamaServer{Name: "c", URL: "http://c.invalid"},
+	)
+
+	// a 占满后只在 b 和 c 之间轮询
+	counts := make(map[string]int)
+	for i := 0; i < 5; i++ {
+		counts[mustAcquire(t, pool, "llama3", QueueRequest{User: "alice"}).server.Name]++
+	}
+	if counts["a"] != 1 || counts["b"] != 2 || counts["c"] != 2 {
+		t.Fatalf("unexpected distribution: %v", counts)
+	}
+}
@@ -0,0 +1,113 @@
+package ollama
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+
+	"llm-fw/config"
+)
+
+// Target 是客户端请求的模型按路由规则解析后的上游目标
+type Target struct {
+	Model  string // 实际转发的模型
+	Server string // 指定的服务器，为空时按负载均衡策略选择
+	Alias  string // 命中路由规则或降级时客户端请求的模型名
+
+	FallbackFrom string // 降级前的模型，没有降级时为空
+}
+
+// Fallback 返回降级到 model 后的目标，降级后不再固定服务器
+func (t Target) Fallback(model string) Target {
+	fallback := Target{Model: model, Alias: t.Alias, FallbackFrom: t.Model}
+	if fallback.Alias == "" {
+		fallback.Alias = t.Model
+	}
+	return fallback
+}
+
+// SetRoutes 替换模型路由规则，目标中指定的服务器必须存在于池中
+func (p *Pool) SetRoutes(routes []config.ModelRoute) error {
+	if err := validateRoutes(routes, p.Servers()); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.routes = routes
+	p.mu.Unlock()
+	return nil
+}
+
+// validateRoutes 检查路由目标中指定的服务器是否都存在
+func validateRoutes(routes []config.ModelRoute, servers []*Server) error {
+	for _, route := range routes {
+		for _, target := range route.Targets {
+			if target.Server != "" && findServer(servers, target.Server) == nil {
+				return fmt.Errorf("route %q: unknown ollama server %q", route.Match, target.Server)
+			}
+		}
+	}
+	return nil
+}
+
+// SetFallbacks 替换模型的降级链
+func (p *Pool) SetFallbacks(fallbacks map[string][]string) {
+	p.mu.Lock()
+	p.fallbacks = fallbacks
+	p.mu.Unlock()
+}
+
+// Fallbacks 返回模型的降级链，没有配置时返回空
+func (p *Pool) Fallbacks(model string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.fallbacks[model]
+}
+
+// Resolve 按顺序匹配路由规则，返回第一条命中规则按权重选出的目标，没有命中时原样转发
+func (p *Pool) Resolve(model string) Target {
+	p.mu.RLock()
+	routes := p.routes
+	p.mu.RUnlock()
+
+	for _, route := range routes {
+		if matched, _ := path.Match(route.Match, model); !matched {
+			continue
+		}
+		target := pickTarget(route.Targets)
+		resolved := Target{Model: target.Model, Server: target.Server, Alias: model}
+		if resolved.Model == "" {
+			resolved.Model = model
+		}
+		return resolved
+	}
+	return Target{Model: model}
+}
+
+// pickTarget 按权重随机选择一个目标，未设置权重的目标按 1 计算
+func pickTarget(targets []config.RouteTarget) config.RouteTarget {
+	total := 0
+	for _, target := range targets {
+		total += routeWeight(target)
+	}
+	if total == 0 {
+		return targets[0]
+	}
+
+	n := rand.Intn(total)
+	for _, target := range targets {
+		n -= routeWeight(target)
+		if n < 0 {
+			return target
+		}
+	}
+	return targets[len(targets)-1]
+}
+
+// routeWeight 返回目标的分流权重
+func routeWeight(target config.RouteTarget) int {
+	if target.Weight == 0 {
+		return 1
+	}
+	return target.Weight
+}
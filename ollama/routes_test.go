@@ -0,0 +1,100 @@
+package ollama
+
+import (
+	"strings"
+	"testing"
+
+	"llm-fw/config"
+)
+
+func TestResolve(t *testing.T) {
+	pool := newTestPool(t, config.UpstreamTimeouts{}, config.UpstreamRetry{}, "http://a.invalid", "http://b.invalid")
+	err := pool.SetRoutes([]config.ModelRoute{
+		{Match: "gpt-4*", Targets: []config.RouteTarget{{Model: "llama3:70b", Server: "server-b"}}},
+		{Match: "gpt-*", Targets: []config.RouteTarget{{Model: "llama3"}}},
+		{Match: "coder", Targets: []config.RouteTarget{{Server: "server-a"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		model string
+		want  Target
+	}{
+		// 按顺序匹配，第一条命中的规则生效
+		{model: "gpt-4o", want: Target{Model: "llama3:70b", Server: "server-b", Alias: "gpt-4o"}},
+		{model: "gpt-3.5-turbo", want: Target{Model: "llama3", Alias: "gpt-3.5-turbo"}},
+		// 目标没有指定模型时使用请求的模型名，只固定服务器
+		{model: "coder", want: Target{Model: "coder", Server: "server-a", Alias: "coder"}},
+		// 没有命中时原样转发，不记录别名
+		{model: "mistral", want: Target{Model: "mistral"}},
+		{model: "coder:7b", want: Target{Model: "coder:7b"}},
+	}
+	for _, tt := range tests {
+		if got := pool.Resolve(tt.model); got != tt.want {
+			t.Errorf("Resolve(%q) = %+v, want %+v", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestSetRoutesRejectsUnknownServer(t *testing.T) {
+	pool := newTestPool(t, config.UpstreamTimeouts{}, config.UpstreamRetry{}, "http://a.invalid")
+	routes := []config.ModelRoute{{Match: "gpt-*", Targets: []config.RouteTarget{{Model: "llama3"}}}}
+	if err := pool.SetRoutes(routes); err != nil {
+		t.Fatal(err)
+	}
+
+	err := pool.SetRoutes([]config.ModelRoute{{Match: "*", Targets: []config.RouteTarget{{Server: "missing"}}}})
+	if err == nil || !strings.Contains(err.Error(), `unknown ollama server "missing"`) {
+		t.Fatalf("expected an unknown server error, got %v", err)
+	}
+	// 校验失败时保留原来的规则
+	if got := pool.Resolve("gpt-4o"); got.Model != "llama3" {
+		t.Fatalf("routes replaced by an invalid configuration: %+v", got)
+	}
+}
+
+func TestPickTargetWeights(t *testing.T) {
+	targets := []config.RouteTarget{
+		{Model: "small", Weight: 3},
+		{Model: "large"}, // 未设置权重按 1 计算
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		counts[pickTarget(targets).Model]++
+	}
+	// 期望 3:1，放宽到足以避免随机波动导致失败
+	if counts["small"] < 2700 || counts["small"] > 3300 || counts["small"]+counts["large"] != 4000 {
+		t.Fatalf("unexpected distribution: %v", counts)
+	}
+
+	single := []config.RouteTarget{{Model: "only"}}
+	if got := pickTarget(single); got.Model != "only" {
+		t.Fatalf("picked %s from a single target", got.Model)
+	}
+}
+
+func TestTargetFallback(t *testing.T) {
+	routed := Target{Model: "llama3:70b", Server: "server-b", Alias: "gpt-4o"}
+	fallback := routed.Fallback("llama3")
+	// 降级后保留客户端请求的模型名，不再固定服务器
+	if want := (Target{Model: "llama3", Alias: "gpt-4o", FallbackFrom: "llama3:70b"}); fallback != want {
+		t.Fatalf("fallback of a routed target: %+v, want %+v", fallback, want)
+	}
+
+	direct := Target{Model: "llama3:70b"}
+	if want := (Target{Model: "llama3", Alias: "llama3:70b", FallbackFrom: "llama3:70b"}); direct.Fallback("llama3") != want {
+		t.Fatalf("fallback of a direct target: %+v, want %+v", direct.Fallback("llama3"), want)
+	}
+
+	pool := newTestPool(t, config.UpstreamTimeouts{}, config.UpstreamRetry{}, "http://a.invalid")
+	pool.SetFallbacks(map[string][]string{"llama3:70b": {"llama3", "phi3"}})
+	if chain := pool.Fallbacks("llama3:70b"); len(chain) != 2 || chain[0] != "llama3" {
+		t.Fatalf("fallback chain %v", chain)
+	}
+	if chain := pool.Fallbacks("phi3"); chain != nil {
+		t.Fatalf("fallback chain of an unconfigured model: %v", chain)
+	}
+}
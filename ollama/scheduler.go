@@ -0,0 +1,479 @@
+package ollama
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"llm-fw/config"
+)
+
+const (
+	defaultQueueSize    = 100
+	defaultQueueTimeout = 30 * time.Second
+)
+
+var (
+	// ErrQueueFull 表示排队的请求已达到上限
+	ErrQueueFull = errors.New("request queue is full")
+	// ErrQueueTimeout 表示请求在队列中等待超时
+	ErrQueueTimeout = errors.New("timed out waiting in request queue")
+)
+
+// 排队的结果，用于等待时间指标
+const (
+	QueueAdmitted  = "admitted"
+	QueueTimedOut  = "timeout"
+	QueueCancelled = "cancelled"
+	QueueEvicted   = "evicted"
+)
+
+// QueueReporter 接收调度器的队列长度和等待时间，由指标收集器实现
+type QueueReporter interface {
+	UpdateQueueDepth(model string, depth int)
+	ObserveQueueWait(model, priority string, wait time.Duration, result string)
+}
+
+// QueueRequest 描述一个需要经过调度器排队的请求
+type QueueRequest struct {
+	User     string          // 用于在用户之间公平放行
+	Source   string          // 请求来源，Priority 为空时按来源确定优先级
+	Priority config.Priority // 为空时按来源或默认优先级
+
+	Requested config.Priority // 客户端请求的优先级，只能低于按以上规则确定的优先级
+}
+
+// queueRequestKey 是 WithQueue 在 context 中保存排队信息的键
+type queueRequestKey struct{}
+
+// WithQueue 标记请求需要经过调度器排队。
+// 未标记的请求（例如嵌入和健康检查）不受调度器的并发上限限制
+func WithQueue(ctx context.Context, req QueueRequest) context.Context {
+	return context.WithValue(ctx, queueRequestKey{}, req)
+}
+
+// queueRequest 返回 WithQueue 标记的排队信息
+func queueRequest(ctx context.Context) (QueueRequest, bool) {
+	req, ok := ctx.Value(queueRequestKey{}).(QueueRequest)
+	return req, ok
+}
+
+// Scheduler 限制每个模型和每个服务器的并发请求数，超出时请求进入有界队列等待。
+// 高优先级的请求总是先于低优先级的请求放行，队列已满时挤出低优先级的请求。
+// 同一优先级内同一用户的请求按先进先出放行，用户之间按权重轮询放行
+type Scheduler struct {
+	pool             *Pool
+	reporter         QueueReporter
+	maxConcurrent    int
+	modelConcurrency map[string]int
+	queueSize        int
+	queueTimeout     time.Duration
+	weights          map[string]int
+	defaultPriority  config.Priority
+	sourcePriorities map[string]config.Priority
+
+	mu      sync.Mutex
+	active  map[string]int   // 每个模型正在进行的请求数
+	depth   map[string]int   // 每个模型排队的请求数
+	classes []*priorityClass // 按优先级从高到低排列
+	queued  int
+	seq     uint64 // 请求的到达顺序
+}
+
+// priorityClass 是一个优先级的排队请求
+type priorityClass struct {
+	priority config.Priority
+	queues   map[string]*userQueue // 有请求排队的用户
+	order    []*userQueue          // 轮询顺序
+	cursor   int                   // 当前轮到的用户在 order 中的位置
+	served   int                   // 当前用户在本轮已放行的请求数
+}
+
+// userQueue 是一个用户在某个优先级的排队请求，按到达顺序排列
+type userQueue struct {
+	user    string
+	class   *priorityClass
+	waiters *list.List
+}
+
+// waiter 是一个排队等待放行的请求
+type waiter struct {
+	target Target
+	queue  *userQueue
+	seq    uint64
+	elem   *list.Element // 已放行或已离开队列时为空
+	ready  chan grant
+}
+
+// grant 是放行的结果，err 不为空时表示请求无法继续
+type grant struct {
+	server *Server
+	err    error
+}
+
+// EnableScheduler 启用调度器，经 WithQueue 标记的请求按并发上限排队
+func (p *Pool) EnableScheduler(cfg config.Scheduler, reporter QueueReporter) {
+	s := &Scheduler{
+		pool:     p,
+		reporter: reporter,
+		active:   make(map[string]int),
+		depth:    make(map[string]int),
+	}
+	s.update(cfg)
+	for _, priority := range []config.Priority{config.PriorityHigh, config.PriorityNormal, config.PriorityLow} {
+		s.classes = append(s.classes, &priorityClass{priority: priority, queues: make(map[string]*userQueue)})
+	}
+	for _, server := range p.Servers() {
+		server.released = s.wake
+	}
+	p.scheduler = s
+}
+
+// update 替换并发上限、队列参数、用户权重和优先级，未配置的队列参数使用默认值。
+// 已经排队的请求保留原来的优先级，新的上限在下一次放行时生效
+func (s *Scheduler) update(cfg config.Scheduler) {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.QueueTimeout <= 0 {
+		cfg.QueueTimeout = defaultQueueTimeout
+	}
+	if cfg.DefaultPriority == "" {
+		cfg.DefaultPriority = config.PriorityNormal
+	}
+
+	s.mu.Lock()
+	s.maxConcurrent = cfg.MaxConcurrent
+	s.modelConcurrency = cfg.ModelConcurrency
+	s.queueSize = cfg.QueueSize
+	s.queueTimeout = cfg.QueueTimeout
+	s.weights = cfg.UserWeights
+	s.defaultPriority = cfg.DefaultPriority
+	s.sourcePriorities = cfg.SourcePriorities
+	s.mu.Unlock()
+}
+
+// acquireFor 为一次 Post 请求占用服务器，返回释放名额的函数。
+// 启用调度器时，经 WithQueue 标记的请求在达到并发上限时排队等待
+func (p *Pool) acquireFor(ctx context.Context, target Target) (*Server, func(), error) {
+	req, queued := queueRequest(ctx)
+	if p.scheduler == nil || !queued {
+		server, err := p.acquire(target)
+		if err != nil {
+			return nil, nil, err
+		}
+		return server, server.Release, nil
+	}
+
+	server, err := p.scheduler.acquire(ctx, target, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return server, func() { p.scheduler.release(server, target.Model) }, nil
+}
+
+// limit 返回模型的并发上限，0 表示不限制
+func (s *Scheduler) limit(model string) int {
+	if limit, ok := s.modelConcurrency[model]; ok {
+		return limit
+	}
+	return s.maxConcurrent
+}
+
+// weight 返回用户在轮询中每轮最多放行的请求数
+func (s *Scheduler) weight(user string) int {
+	if weight, ok := s.weights[user]; ok {
+		return weight
+	}
+	return 1
+}
+
+// priorityOf 返回请求的优先级：请求指定的优先级、来源对应的优先级或默认优先级。
+// 客户端请求的优先级更低时使用客户端请求的优先级，客户端不能借此提高优先级。调用方需持有锁
+func (s *Scheduler) priorityOf(req QueueRequest) config.Priority {
+	priority := req.Priority
+	if !priority.Valid() {
+		var ok bool
+		if priority, ok = s.sourcePriorities[req.Source]; !ok {
+			priority = s.defaultPriority
+		}
+	}
+	if req.Requested.Valid() && req.Requested.Level() < priority.Level() {
+		return req.Requested
+	}
+	return priority
+}
+
+// classOf 返回优先级对应的队列
+func (s *Scheduler) classOf(priority config.Priority) *priorityClass {
+	for _, class := range s.classes {
+		if class.priority == priority {
+			return class
+		}
+	}
+	return s.classes[len(s.classes)-1]
+}
+
+// acquire 在模型和服务器都未达到并发上限时立即放行，否则排队等待。队列已满时挤出一个
+// 更低优先级的请求，没有可以挤出的请求时返回 ErrQueueFull，等待超过 queueTimeout 时返回 ErrQueueTimeout
+func (s *Scheduler) acquire(ctx context.Context, target Target, req QueueRequest) (*Server, error) {
+	start := time.Now()
+	var priority config.Priority
+	observe := func(result string) {
+		s.reporter.ObserveQueueWait(target.Model, string(priority), time.Since(start), result)
+	}
+
+	s.mu.Lock()
+	priority = s.priorityOf(req)
+	// 同一模型已有请求排队时新请求排在后面，由 dispatch 按公平顺序放行
+	if s.depth[target.Model] == 0 {
+		server, err := s.tryGrant(target)
+		if err != nil || server != nil {
+			s.mu.Unlock()
+			return server, err
+		}
+	} else if _, err := s.pool.targetCandidates(target); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	if s.queued >= s.queueSize && !s.evict(priority) {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w for model %s", ErrQueueFull, target.Model)
+	}
+	w := s.enqueue(target, req.User, priority)
+	s.dispatch()
+	timeout := s.queueTimeout
+	s.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var result string
+	var err error
+	select {
+	case g := <-w.ready:
+		if g.err == nil {
+			observe(QueueAdmitted)
+		} else if errors.Is(g.err, ErrQueueFull) {
+			observe(QueueEvicted)
+		}
+		return g.server, g.err
+	case <-timer.C:
+		result, err = QueueTimedOut, fmt.Errorf("%w for model %s after %s", ErrQueueTimeout, target.Model, timeout)
+	case <-ctx.Done():
+		result, err = QueueCancelled, ctx.Err()
+	}
+
+	s.mu.Lock()
+	if w.elem != nil {
+		s.remove(w)
+		s.mu.Unlock()
+		observe(result)
+		return nil, err
+	}
+	s.mu.Unlock()
+
+	// 离开队列之前已经被放行或挤出：超时的请求照常使用，取消的请求归还名额
+	g := <-w.ready
+	if g.err != nil {
+		if errors.Is(g.err, ErrQueueFull) {
+			observe(QueueEvicted)
+		}
+		return nil, g.err
+	}
+	if result == QueueCancelled {
+		s.release(g.server, target.Model)
+		observe(result)
+		return nil, err
+	}
+	observe(QueueAdmitted)
+	return g.server, nil
+}
+
+// release 归还放行时占用的模型和服务器名额
+func (s *Scheduler) release(server *Server, model string) {
+	s.mu.Lock()
+	if s.active[model]--; s.active[model] <= 0 {
+		delete(s.active, model)
+	}
+	s.mu.Unlock()
+	server.Release()
+}
+
+// wake 在服务器释放名额后放行排队的请求
+func (s *Scheduler) wake() {
+	s.mu.Lock()
+	s.dispatch()
+	s.mu.Unlock()
+}
+
+// tryGrant 在模型未达到并发上限且有服务器未满时占用名额，否则返回 nil。调用方需持有锁
+func (s *Scheduler) tryGrant(target Target) (*Server, error) {
+	if limit := s.limit(target.Model); limit > 0 && s.active[target.Model] >= limit {
+		return nil, nil
+	}
+	candidates, err := s.pool.targetCandidates(target)
+	if err != nil {
+		return nil, err
+	}
+
+	available := make([]*Server, 0, len(candidates))
+	for _, server := range candidates {
+		if !server.full() {
+			available = append(available, server)
+		}
+	}
+	if len(available) == 0 {
+		return nil, nil
+	}
+
+	server := s.pool.choose(target.Model, available)
+	atomic.AddInt64(&server.inflight, 1)
+	s.active[target.Model]++
+	return server, nil
+}
+
+// enqueue 把请求加入对应优先级中用户的队列，调用方需持有锁
+func (s *Scheduler) enqueue(target Target, user string, priority config.Priority) *waiter {
+	class := s.classOf(priority)
+	q, exists := class.queues[user]
+	if !exists {
+		q = &userQueue{user: user, class: class, waiters: list.New()}
+		class.queues[user] = q
+		class.order = append(class.order, q)
+	}
+
+	s.seq++
+	w := &waiter{target: target, queue: q, seq: s.seq, ready: make(chan grant, 1)}
+	w.elem = q.waiters.PushBack(w)
+	s.queued++
+	s.depth[target.Model]++
+	s.reporter.UpdateQueueDepth(target.Model, s.depth[target.Model])
+	return w
+}
+
+// evict 在队列已满时为 priority 的请求让出位置：挤出比它低的最低优先级中最后到达的请求，
+// 被挤出的请求返回 ErrQueueFull。没有更低优先级的请求时返回 false。调用方需持有锁
+func (s *Scheduler) evict(priority config.Priority) bool {
+	for i := len(s.classes) - 1; i >= 0; i-- {
+		class := s.classes[i]
+		if class.priority.Level() >= priority.Level() {
+			return false
+		}
+
+		var newest *waiter
+		for _, q := range class.order {
+			if w := q.waiters.Back().Value.(*waiter); newest == nil || w.seq > newest.seq {
+				newest = w
+			}
+		}
+		if newest == nil {
+			continue
+		}
+		s.remove(newest)
+		newest.ready <- grant{err: fmt.Errorf("%w for model %s: evicted by a %s priority request", ErrQueueFull, newest.target.Model, priority)}
+		return true
+	}
+	return false
+}
+
+// remove 把请求移出队列，用户的队列为空时移出轮询顺序。调用方需持有锁
+func (s *Scheduler) remove(w *waiter) {
+	q := w.queue
+	q.waiters.Remove(w.elem)
+	w.elem = nil
+	s.queued--
+	model := w.target.Model
+	if s.depth[model]--; s.depth[model] <= 0 {
+		delete(s.depth, model)
+	}
+	s.reporter.UpdateQueueDepth(model, s.depth[model])
+
+	if q.waiters.Len() > 0 {
+		return
+	}
+	class := q.class
+	delete(class.queues, q.user)
+	for i, other := range class.order {
+		if other != q {
+			continue
+		}
+		class.order = append(class.order[:i], class.order[i+1:]...)
+		if i < class.cursor {
+			class.cursor--
+		} else if i == class.cursor {
+			class.served = 0
+		}
+		if class.cursor >= len(class.order) {
+			class.cursor = 0
+		}
+		break
+	}
+}
+
+// dispatch 放行所有可以放行的请求。每次放行都从最高优先级开始查找，
+// 高优先级没有可以放行的请求（例如所需模型已达到并发上限）时才放行低优先级的请求。调用方需持有锁
+func (s *Scheduler) dispatch() {
+	for s.queued > 0 {
+		granted := false
+		for _, class := range s.classes {
+			if s.dispatchClass(class) {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			return
+		}
+	}
+}
+
+// dispatchClass 按权重在优先级内的用户之间轮询，放行一个请求，没有可以放行的请求时返回 false。
+// 轮到的用户没有可以放行的请求时跳过该用户，但不改变轮询的位置。调用方需持有锁
+func (s *Scheduler) dispatchClass(class *priorityClass) bool {
+	for tried := 0; tried < len(class.order); tried++ {
+		i := (class.cursor + tried) % len(class.order)
+		q := class.order[i]
+		w, g, ok := s.grantNext(q)
+		if !ok {
+			continue
+		}
+
+		if i != class.cursor {
+			class.cursor, class.served = i, 0
+		}
+		class.served++
+		// 用户的队列因此变空时 remove 已经轮到下一个用户
+		s.remove(w)
+		w.ready <- g
+		if _, exists := class.queues[q.user]; exists && class.served >= s.weight(q.user) {
+			class.advance()
+		}
+		return true
+	}
+	return false
+}
+
+// grantNext 为用户队列中第一个可以放行的请求占用名额，没有可以放行的请求时返回 false。
+// 不同模型的请求互不阻塞，同一模型的请求按到达顺序放行
+func (s *Scheduler) grantNext(q *userQueue) (*waiter, grant, bool) {
+	for elem := q.waiters.Front(); elem != nil; elem = elem.Next() {
+		w := elem.Value.(*waiter)
+		server, err := s.tryGrant(w.target)
+		if err == nil && server == nil {
+			continue
+		}
+		return w, grant{server: server, err: err}, true
+	}
+	return nil, grant{}, false
+}
+
+// advance 轮到下一个用户
+func (c *priorityClass) advance() {
+	c.cursor = (c.cursor + 1) % len(c.order)
+	c.served = 0
+}
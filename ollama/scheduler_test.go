@@ -0,0 +1,370 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"llm-fw/config"
+)
+
+// testQueueReporter 记录调度器上报的排队结果
+type testQueueReporter struct {
+	mu      sync.Mutex
+	results []string
+}
+
+func (r *testQueueReporter) UpdateQueueDepth(model string, depth int) {}
+
+func (r *testQueueReporter) ObserveQueueWait(model, priority string, wait time.Duration, result string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, priority+":"+result)
+}
+
+func (r *testQueueReporter) observed() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.results...)
+}
+
+// newTestScheduler 创建启用了调度器的服务器池，servers 为空时使用一台不限并发的服务器
+func newTestScheduler(t *testing.T, cfg config.Scheduler, servers ...config.OllamaServer) (*Pool, *testQueueReporter) {
+	t.Helper()
+
+	if len(servers) == 0 {
+		servers = []config.OllamaServer{{Name: "default", URL: "http://default.invalid"}}
+	}
+	pool, err := NewPool(servers, config.BalanceRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter := &testQueueReporter{}
+	pool.EnableScheduler(cfg, reporter)
+	return pool, reporter
+}
+
+// acquired 是一次排队占用名额的结果
+type acquired struct {
+	server  *Server
+	release func()
+	err     error
+}
+
+// acquireAsync 在后台经调度器为 model 占用名额
+func acquireAsync(ctx context.Context, p *Pool, model string, req QueueRequest) <-chan acquired {
+	ch := make(chan acquired, 1)
+	go func() {
+		server, release, err := p.acquireFor(WithQueue(ctx, req), Target{Model: model})
+		ch <- acquired{server: server, release: release, err: err}
+	}()
+	return ch
+}
+
+// mustAcquire 经调度器占用名额，需要排队时测试失败
+func mustAcquire(t *testing.T, p *Pool, model string, req QueueRequest) acquired {
+	t.Helper()
+	select {
+	case a := <-acquireAsync(context.Background(), p, model, req):
+		if a.err != nil {
+			t.Fatal(a.err)
+		}
+		return a
+	case <-time.After(time.Second):
+		t.Fatalf("request for %s was queued", model)
+		return acquired{}
+	}
+}
+
+// receive 等待排队的请求返回
+func receive(t *testing.T, ch <-chan acquired) acquired {
+	t.Helper()
+	select {
+	case a := <-ch:
+		return a
+	case <-time.After(2 * time.Second):
+		t.Fatal("queued request was not admitted")
+		return acquired{}
+	}
+}
+
+// queued 返回调度器中排队的请求数
+func queued(p *Pool) int {
+	p.scheduler.mu.Lock()
+	defer p.scheduler.mu.Unlock()
+	return p.scheduler.queued
+}
+
+// waitQueued 等待排队的请求数达到 n，保证后台请求按启动的顺序入队
+func waitQueued(t *testing.T, p *Pool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for queued(p) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests queued, want %d", queued(p), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSchedulerModelConcurrency(t *testing.T) {
+	pool, _ := newTestScheduler(t, config.Scheduler{MaxConcurrent: 1, ModelConcurrency: map[string]int{"big": 2}})
+
+	llama := mustAcquire(t, pool, "llama3", QueueRequest{User: "alice"})
+	waiting := acquireAsync(context.Background(), pool, "llama3", QueueRequest{User: "alice"})
+	waitQueued(t, pool, 1)
+
+	// 按模型覆盖的上限和其他模型互不影响
+	mustAcquire(t, pool, "big", QueueRequest{User: "alice"})
+	mustAcquire(t, pool, "big", QueueRequest{User: "alice"})
+	mustAcquire(t, pool, "phi3", QueueRequest{User: "alice"})
+	acquireAsync(context.Background(), pool, "big", QueueRequest{User: "alice"})
+	waitQueued(t, pool, 2)
+
+	llama.release()
+	if a := receive(t, waiting); a.err != nil {
+		t.Fatal(a.err)
+	}
+	if n := queued(pool); n != 1 {
+		t.Fatalf("%d requests queued after releasing llama3, want only the third big request", n)
+	}
+}
+
+func TestSchedulerServerConcurrency(t *testing.T) {
+	pool, _ := newTestScheduler(t, config.Scheduler{},
+		config.OllamaServer{Name: "gpu-1", URL: "http://gpu-1.invalid", MaxConcurrent: 1},
+		config.OllamaServer{Name: "gpu-2", URL: "http://gpu-2.invalid", MaxConcurrent: 1},
+	)
+
+	first := mustAcquire(t, pool, "llama3", QueueRequest{User: "alice"})
+	second := mustAcquire(t, pool, "mistral", QueueRequest{User: "bob"})
+	if first.server == second.server {
+		t.Fatalf("both requests were sent to %s", first.server.Name)
+	}
+
+	// 所有服务器都已满时，不同模型的请求同样排队
+	waiting := acquireAsync(context.Background(), pool, "phi3", QueueRequest{User: "carol"})
+	waitQueued(t, pool, 1)
+
+	second.release()
+	a := receive(t, waiting)
+	if a.err != nil || a.server != second.server {
+		t.Fatalf("admitted to %v with %v, want the released %s", a.server, a.err, second.server.Name)
+	}
+	if first.server.InFlight() != 1 || second.server.InFlight() != 1 {
+		t.Fatalf("in flight: %s=%d %s=%d", first.server.Name, first.server.InFlight(), second.server.Name, second.server.InFlight())
+	}
+}
+
+func TestSchedulerUserFairness(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+		want    []string
+	}{
+		{name: "round robin", want: []string{"alice", "bob", "carol", "alice", "alice"}},
+		{name: "weighted", weights: map[string]int{"alice": 2}, want: []string{"alice", "alice", "bob", "carol", "alice"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, _ := newTestScheduler(t, config.Scheduler{MaxConcurrent: 1, UserWeights: tt.weights})
+			holder := mustAcquire(t, pool, "llama3", QueueRequest{User: "holder"})
+
+			// alice 先排了三个请求，bob 和 carol 之后各排一个
+			type admission struct {
+				user string
+				a    acquired
+			}
+			admitted := make(chan admission, 5)
+			for i, user := range []string{"alice", "alice", "alice", "bob", "carol"} {
+				ch := acquireAsync(context.Background(), pool, "llama3", QueueRequest{User: user})
+				go func(user string) { admitted <- admission{user, <-ch} }(user)
+				waitQueued(t, pool, i+1)
+			}
+
+			release := holder.release
+			var order []string
+			for range tt.want {
+				release()
+				select {
+				case next := <-admitted:
+					if next.a.err != nil {
+						t.Fatal(next.a.err)
+					}
+					order = append(order, next.user)
+					release = next.a.release
+				case <-time.After(2 * time.Second):
+					t.Fatalf("nothing admitted after %v", order)
+				}
+			}
+			release()
+
+			for i := range tt.want {
+				if order[i] != tt.want[i] {
+					t.Fatalf("admission order %v, want %v", order, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSchedulerQueueLimits(t *testing.T) {
+	pool, reporter := newTestScheduler(t, config.Scheduler{MaxConcurrent: 1, QueueSize: 1, QueueTimeout: 50 * time.Millisecond})
+	holder := mustAcquire(t, pool, "llama3", QueueRequest{User: "alice"})
+	defer holder.release()
+
+	waiting := acquireAsync(context.Background(), pool, "llama3", QueueRequest{User: "alice"})
+	waitQueued(t, pool, 1)
+
+	// 同一优先级的请求不能挤出已经排队的请求
+	full := receive(t, acquireAsync(context.Background(), pool, "llama3", QueueRequest{User: "bob"}))
+	if !errors.Is(full.err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", full.err)
+	}
+
+	timedOut := receive(t, waiting)
+	if !errors.Is(timedOut.err, ErrQueueTimeout) {
+		t.Fatalf("expected ErrQueueTimeout, got %v", timedOut.err)
+	}
+	if n := queued(pool); n != 0 {
+		t.Fatalf("%d requests left in the queue", n)
+	}
+	if got := reporter.observed(); len(got) != 1 || got[0] != "normal:"+QueueTimedOut {
+		t.Fatalf("observed queue waits %v", got)
+	}
+}
+
+func TestSchedulerReleasesOnCancel(t *testing.T) {
+	pool, reporter := newTestScheduler(t, config.Scheduler{MaxConcurrent: 1})
+	holder := mustAcquire(t, pool, "llama3", QueueRequest{User: "alice"})
+
+	// 排队时取消的请求离开队列，不占用名额
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := acquireAsync(ctx, pool, "llama3", QueueRequest{User: "bob"})
+	waitQueued(t, pool, 1)
+	cancel()
+	if a := receive(t, waiting); !errors.Is(a.err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", a.err)
+	}
+	if n := queued(pool); n != 0 {
+		t.Fatalf("%d requests left in the queue after cancelling", n)
+	}
+	if got := reporter.observed(); len(got) != 1 || got[0] != "normal:"+QueueCancelled {
+		t.Fatalf("observed queue waits %v", got)
+	}
+
+	holder.release()
+	mustAcquire(t, pool, "llama3", QueueRequest{User: "carol"}).release()
+}
+
+func TestSchedulerReleasesCancelledResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+	pool, _ := newTestScheduler(t, config.Scheduler{MaxConcurrent: 1}, config.OllamaServer{Name: "default", URL: upstream.URL})
+
+	// 客户端断开后关闭响应体，名额归还给排队的请求
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := pool.Post(WithQueue(ctx, QueueRequest{User: "alice"}), Target{Model: "llama3"}, "/api/chat", []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiting := acquireAsync(context.Background(), pool, "llama3", QueueRequest{User: "bob"})
+	waitQueued(t, pool, 1)
+
+	cancel()
+	resp.Body.Close()
+	a := receive(t, waiting)
+	if a.err != nil {
+		t.Fatal(a.err)
+	}
+	a.release()
+	if n := resp.Server.InFlight(); n != 0 {
+		t.Fatalf("%d requests still in flight", n)
+	}
+}
+
+func TestSchedulerPriorityOf(t *testing.T) {
+	pool, _ := newTestScheduler(t, config.Scheduler{
+		SourcePriorities: map[string]config.Priority{"external_ui": config.PriorityHigh, "api": config.PriorityLow},
+	})
+
+	tests := []struct {
+		name string
+		req  QueueRequest
+		want config.Priority
+	}{
+		{name: "source", req: QueueRequest{Source: "api"}, want: config.PriorityLow},
+		{name: "interactive source", req: QueueRequest{Source: "external_ui"}, want: config.PriorityHigh},
+		{name: "default", req: QueueRequest{Source: "other"}, want: config.PriorityNormal},
+		{name: "key overrides source", req: QueueRequest{Source: "api", Priority: config.PriorityNormal}, want: config.PriorityNormal},
+		// 请求头只能降低优先级
+		{name: "header cannot raise source", req: QueueRequest{Source: "api", Requested: config.PriorityHigh}, want: config.PriorityLow},
+		{name: "header cannot raise default", req: QueueRequest{Source: "other", Requested: config.PriorityHigh}, want: config.PriorityNormal},
+		{name: "header cannot raise key", req: QueueRequest{Priority: config.PriorityLow, Requested: config.PriorityNormal}, want: config.PriorityLow},
+		{name: "header lowers source", req: QueueRequest{Source: "external_ui", Requested: config.PriorityLow}, want: config.PriorityLow},
+		{name: "header lowers key", req: QueueRequest{Priority: config.PriorityHigh, Requested: config.PriorityNormal}, want: config.PriorityNormal},
+		{name: "invalid header", req: QueueRequest{Source: "external_ui", Requested: "urgent"}, want: config.PriorityHigh},
+	}
+	for _, tt := range tests {
+		if got := pool.scheduler.priorityOf(tt.req); got != tt.want {
+			t.Errorf("%s: priority %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSchedulerHighPriorityPreemptsBatch(t *testing.T) {
+	pool, reporter := newTestScheduler(t, config.Scheduler{
+		MaxConcurrent:    1,
+		QueueSize:        3,
+		SourcePriorities: map[string]config.Priority{"external_ui": config.PriorityHigh, "api": config.PriorityLow},
+	})
+	holder := mustAcquire(t, pool, "llama3", QueueRequest{User: "batch", Source: "api"})
+
+	// 批量任务先排满队列
+	var batch []<-chan acquired
+	for i := 0; i < 3; i++ {
+		batch = append(batch, acquireAsync(context.Background(), pool, "llama3", QueueRequest{User: "batch", Source: "api"}))
+		waitQueued(t, pool, i+1)
+	}
+
+	// 交互请求挤出最后到达的批量请求，并先于其他批量请求放行
+	interactive := acquireAsync(context.Background(), pool, "llama3", QueueRequest{User: "ui", Source: "external_ui"})
+	if evicted := receive(t, batch[2]); !errors.Is(evicted.err, ErrQueueFull) {
+		t.Fatalf("expected the newest batch request to be evicted, got %v", evicted.err)
+	}
+	waitQueued(t, pool, 3)
+
+	holder.release()
+	first := receive(t, interactive)
+	if first.err != nil {
+		t.Fatal(first.err)
+	}
+	select {
+	case <-batch[0]:
+		t.Fatal("batch request admitted while the interactive request holds the slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	first.release()
+	next := receive(t, batch[0])
+	if next.err != nil {
+		t.Fatal(next.err)
+	}
+	next.release()
+	receive(t, batch[1]).release()
+
+	want := []string{"low:" + QueueEvicted, "high:" + QueueAdmitted, "low:" + QueueAdmitted, "low:" + QueueAdmitted}
+	if got := reporter.observed(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("observed queue waits %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,343 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"llm-fw/config"
+)
+
+const (
+	defaultConnectTimeout   = 10 * time.Second
+	defaultFirstByteTimeout = 5 * time.Minute
+	defaultIdleTimeout      = 2 * time.Minute
+	defaultMaxAttempts      = 3
+	defaultInitialBackoff   = 200 * time.Millisecond
+	defaultMaxBackoff       = 5 * time.Second
+)
+
+// TimeoutError 表示上游请求超过了配置的超时，Kind 为 connect、first_byte、idle 或 total
+type TimeoutError struct {
+	Kind    string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("upstream %s timeout after %s", e.Kind, e.Timeout)
+}
+
+// Unwrap 使 errors.Is(err, context.DeadlineExceeded) 成立，调用方按超时处理
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// RequestError 表示在收到上游响应之前失败的请求，Server 为最后一次尝试的服务器
+type RequestError struct {
+	Server   string
+	Attempts int
+	Err      error
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// Response 是上游返回的响应，关闭 Body 时释放服务器的请求名额
+type Response struct {
+	*http.Response
+	Server   *Server
+	Attempts int
+}
+
+// HTTPClient 是所有上游调用共享的 HTTP 客户端，按模型应用超时并在安全时重试
+type HTTPClient struct {
+	client        *http.Client
+	timeouts      config.UpstreamTimeouts
+	modelTimeouts map[string]config.UpstreamTimeouts
+	retry         config.UpstreamRetry
+}
+
+// connectTimeoutKey 用于把单个请求的连接超时传递给 DialContext
+type connectTimeoutKey struct{}
+
+// NewHTTPClient 创建共享的上游 HTTP 客户端，未配置的超时和重试参数使用默认值
+func NewHTTPClient(timeouts config.UpstreamTimeouts, modelTimeouts map[string]config.UpstreamTimeouts, retry config.UpstreamRetry) *HTTPClient {
+	if timeouts.Connect <= 0 {
+		timeouts.Connect = defaultConnectTimeout
+	}
+	if timeouts.FirstByte <= 0 {
+		timeouts.FirstByte = defaultFirstByteTimeout
+	}
+	if timeouts.Idle <= 0 {
+		timeouts.Idle = defaultIdleTimeout
+	}
+	if retry.MaxAttempts <= 0 {
+		retry.MaxAttempts = defaultMaxAttempts
+	}
+	if retry.InitialBackoff <= 0 {
+		retry.InitialBackoff = defaultInitialBackoff
+	}
+	if retry.MaxBackoff <= 0 {
+		retry.MaxBackoff = defaultMaxBackoff
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 32
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialer := &net.Dialer{KeepAlive: 30 * time.Second}
+		timeout, _ := ctx.Value(connectTimeoutKey{}).(time.Duration)
+		if timeout > 0 {
+			dialer.Timeout = timeout
+		}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		var netErr net.Error
+		if err != nil && errors.As(err, &netErr) && netErr.Timeout() && ctx.Err() == nil {
+			return nil, fmt.Errorf("%w: %v", &TimeoutError{Kind: "connect", Timeout: timeout}, err)
+		}
+		return conn, err
+	}
+
+	return &HTTPClient{
+		client:        &http.Client{Transport: transport},
+		timeouts:      timeouts,
+		modelTimeouts: modelTimeouts,
+		retry:         retry,
+	}
+}
+
+// Timeouts 返回模型生效的超时，按模型的配置只覆盖非 0 的字段
+func (c *HTTPClient) Timeouts(model string) config.UpstreamTimeouts {
+	timeouts := c.timeouts
+	override, exists := c.modelTimeouts[model]
+	if !exists {
+		return timeouts
+	}
+	if override.Connect > 0 {
+		timeouts.Connect = override.Connect
+	}
+	if override.FirstByte > 0 {
+		timeouts.FirstByte = override.FirstByte
+	}
+	if override.Idle > 0 {
+		timeouts.Idle = override.Idle
+	}
+	if override.Total > 0 {
+		timeouts.Total = override.Total
+	}
+	return timeouts
+}
+
+// do 向 server 发起一次请求，返回的响应体在读取时应用空闲超时。release 不为空时关闭响应体会调用它
+// 释放请求名额，出错时 do 不会释放，由调用方处理
+func (c *HTTPClient) do(ctx context.Context, server *Server, release func(), method, path string, body []byte, timeouts config.UpstreamTimeouts) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	ctx = context.WithValue(ctx, connectTimeoutKey{}, timeouts.Connect)
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, server.URL+path, reader)
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	var total *time.Timer
+	if timeouts.Total > 0 {
+		total = time.AfterFunc(timeouts.Total, func() {
+			cancel(&TimeoutError{Kind: "total", Timeout: timeouts.Total})
+		})
+	}
+	firstByte := time.AfterFunc(timeouts.FirstByte, func() {
+		cancel(&TimeoutError{Kind: "first_byte", Timeout: timeouts.FirstByte})
+	})
+
+	resp, err := c.client.Do(req)
+	firstByte.Stop()
+	if err != nil {
+		if total != nil {
+			total.Stop()
+		}
+		err = causeError(ctx, err)
+		cancel(nil)
+		return nil, err
+	}
+
+	b := &responseBody{
+		body:    resp.Body,
+		ctx:     ctx,
+		cancel:  cancel,
+		total:   total,
+		timeout: timeouts.Idle,
+		release: release,
+	}
+	b.idle = time.AfterFunc(timeouts.Idle, func() {
+		cancel(&TimeoutError{Kind: "idle", Timeout: timeouts.Idle})
+	})
+	resp.Body = b
+	return resp, nil
+}
+
+// causeError 在请求因超时被取消时返回超时错误，而不是 http.Client 报告的 context canceled
+func causeError(ctx context.Context, err error) error {
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return err
+	}
+	if errors.As(context.Cause(ctx), &timeoutErr) {
+		return fmt.Errorf("%w: %v", timeoutErr, err)
+	}
+	return err
+}
+
+// responseBody 在每次读到数据时重置空闲超时，关闭时停止计时器并释放服务器
+type responseBody struct {
+	body    io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	idle    *time.Timer
+	total   *time.Timer
+	timeout time.Duration
+	release func() // 为空时关闭不释放请求名额
+	once    sync.Once
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.idle.Reset(b.timeout)
+	}
+	if err != nil && err != io.EOF {
+		err = causeError(b.ctx, err)
+	}
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	err := b.body.Close()
+	b.once.Do(func() {
+		b.idle.Stop()
+		if b.total != nil {
+			b.total.Stop()
+		}
+		b.cancel(nil)
+		if b.release != nil {
+			b.release()
+		}
+	})
+	return err
+}
+
+// retryableStatus 判断上游状态码是否表示该服务器暂时不可用，可以换一台服务器重试
+func retryableStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// retryableError 判断请求错误是否可以重试。客户端取消、总超时和首字节超时不重试：
+// 首字节超时时上游已经接受请求并可能仍在生成，重试会在另一台服务器上再生成一次
+func retryableError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return timeoutErr.Kind == "connect"
+	}
+	return true
+}
+
+// backoff 返回第 attempt 次重试前的等待时间，指数增长并加入随机抖动
+func (c *HTTPClient) backoff(attempt int) time.Duration {
+	d := c.retry.InitialBackoff << (attempt - 1)
+	if d <= 0 || d > c.retry.MaxBackoff {
+		d = c.retry.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// SetClient 替换池使用的上游 HTTP 客户端
+func (p *Pool) SetClient(client *HTTPClient) {
+	p.mu.Lock()
+	p.client = client
+	p.mu.Unlock()
+}
+
+// httpClient 返回池当前使用的上游 HTTP 客户端
+func (p *Pool) httpClient() *HTTPClient {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.client
+}
+
+// closeIdleConnections 关闭客户端的空闲连接，正在使用的连接不受影响
+func (c *HTTPClient) closeIdleConnections() {
+	c.client.CloseIdleConnections()
+}
+
+// Post 为路由目标选择服务器并发起 JSON POST 请求。在收到响应之前失败或上游返回 502、503、504 时，
+// 按退避策略换服务器重试；一旦返回响应，调用方已经可以向客户端输出数据，不再重试。
+// ctx 经 WithQueue 标记时，每次选择服务器都经过调度器排队。调用方必须关闭 Response.Body
+func (p *Pool) Post(ctx context.Context, target Target, path string, body []byte) (*Response, error) {
+	// 整个请求使用同一个客户端，重新加载配置不影响已经开始的请求
+	client := p.httpClient()
+	timeouts := client.Timeouts(target.Model)
+	for attempt := 1; ; attempt++ {
+		server, release, err := p.acquireFor(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.do(ctx, server, release, http.MethodPost, path, body, timeouts)
+		last := attempt >= client.retry.MaxAttempts
+		switch {
+		case err != nil && ctx.Err() == nil:
+			server.recordFailure(err)
+		case err == nil && resp.StatusCode >= http.StatusInternalServerError:
+			server.recordFailure(fmt.Errorf("upstream returned status %d", resp.StatusCode))
+		case err == nil:
+			server.recordSuccess()
+		}
+		if err != nil {
+			release()
+			if last || !retryableError(ctx, err) {
+				return nil, &RequestError{Server: server.Name, Attempts: attempt, Err: err}
+			}
+			log.Printf("Upstream request to %s failed (attempt %d/%d): %v", server.Name, attempt, client.retry.MaxAttempts, err)
+		} else if retryableStatus(resp.StatusCode) && !last {
+			log.Printf("Upstream %s returned status %d (attempt %d/%d)", server.Name, resp.StatusCode, attempt, client.retry.MaxAttempts)
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		} else {
+			return &Response{Response: resp, Server: server, Attempts: attempt}, nil
+		}
+
+		select {
+		case <-time.After(client.backoff(attempt)):
+		case <-ctx.Done():
+			return nil, &RequestError{Server: server.Name, Attempts: attempt, Err: ctx.Err()}
+		}
+	}
+}
+
+// Get 向指定服务器发起一次 GET 请求，使用全局超时且不重试，调用方必须关闭响应体
+func (p *Pool) Get(ctx context.Context, server *Server, path string) (*http.Response, error) {
+	client := p.httpClient()
+	return client.do(ctx, server, nil, http.MethodGet, path, nil, client.timeouts)
+}
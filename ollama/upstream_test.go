@@ -0,0 +1,245 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"llm-fw/config"
+)
+
+// newTestPool 创建按顺序轮询 urls 的服务器池，使用指定的超时和重试策略
+func newTestPool(t *testing.T, timeouts config.UpstreamTimeouts, retry config.UpstreamRetry, urls ...string) *Pool {
+	t.Helper()
+
+	servers := make([]config.OllamaServer, len(urls))
+	for i, url := range urls {
+		servers[i] = config.OllamaServer{Name: "server-" + string(rune('a'+i)), URL: url}
+	}
+	pool, err := NewPool(servers, config.BalanceRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.SetClient(NewHTTPClient(timeouts, nil, retry))
+	return pool
+}
+
+// timeoutKind 返回错误中的超时类型，不是超时错误时返回空字符串
+func timeoutKind(err error) string {
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return timeoutErr.Kind
+	}
+	return ""
+}
+
+func TestBackoffBounds(t *testing.T) {
+	c := NewHTTPClient(config.UpstreamTimeouts{}, nil, config.UpstreamRetry{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	})
+
+	tests := []struct {
+		attempt int
+		max     time.Duration // 抖动前的等待时间，实际等待在 [max/2, max] 之间
+	}{
+		{attempt: 1, max: 100 * time.Millisecond},
+		{attempt: 2, max: 200 * time.Millisecond},
+		{attempt: 3, max: 400 * time.Millisecond},
+		{attempt: 4, max: 800 * time.Millisecond},
+		{attempt: 5, max: time.Second},
+		{attempt: 64, max: time.Second}, // 移位溢出时使用最长等待时间
+	}
+
+	for _, tt := range tests {
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 200; i++ {
+			d := c.backoff(tt.attempt)
+			if d < tt.max/2 || d > tt.max {
+				t.Fatalf("attempt %d: backoff %s outside [%s, %s]", tt.attempt, d, tt.max/2, tt.max)
+			}
+			seen[d] = true
+		}
+		if len(seen) < 10 {
+			t.Fatalf("attempt %d: only %d distinct backoffs in 200 samples, jitter is missing", tt.attempt, len(seen))
+		}
+	}
+}
+
+func TestTimeoutKinds(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts config.UpstreamTimeouts
+		handler  http.HandlerFunc
+		kind     string
+		inBody   bool // 超时发生在读取响应体时
+	}{
+		{
+			name:     "first_byte",
+			timeouts: config.UpstreamTimeouts{FirstByte: 100 * time.Millisecond},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+			kind: "first_byte",
+		},
+		{
+			name:     "idle",
+			timeouts: config.UpstreamTimeouts{Idle: 100 * time.Millisecond},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("{}\n"))
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+			},
+			kind:   "idle",
+			inBody: true,
+		},
+		{
+			name:     "total",
+			timeouts: config.UpstreamTimeouts{Idle: time.Second, Total: 200 * time.Millisecond},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				for {
+					select {
+					case <-r.Context().Done():
+						return
+					case <-time.After(20 * time.Millisecond):
+						w.Write([]byte("{}\n"))
+						w.(http.Flusher).Flush()
+					}
+				}
+			},
+			kind:   "total",
+			inBody: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits, 1)
+				// 读完请求体后服务器才能察觉客户端断开连接
+				io.Copy(io.Discard, r.Body)
+				tt.handler(w, r)
+			}))
+			defer upstream.Close()
+
+			pool := newTestPool(t, tt.timeouts, config.UpstreamRetry{MaxAttempts: 3, InitialBackoff: time.Millisecond}, upstream.URL)
+			start := time.Now()
+			resp, err := pool.Post(context.Background(), Target{Model: "llama3"}, "/api/chat", []byte(`{}`))
+			if !tt.inBody {
+				var reqErr *RequestError
+				if !errors.As(err, &reqErr) || timeoutKind(err) != tt.kind {
+					t.Fatalf("expected a %s timeout, got %v", tt.kind, err)
+				}
+				// 首字节超时时上游可能仍在生成，不换服务器重试
+				if reqErr.Attempts != 1 || atomic.LoadInt32(&hits) != 1 {
+					t.Fatalf("%s timeout was retried: %d attempts, %d upstream hits", tt.kind, reqErr.Attempts, hits)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if timeoutKind(err) != tt.kind {
+					t.Fatalf("expected a %s timeout while reading, got %v", tt.kind, err)
+				}
+			}
+
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("timeout error does not match context.DeadlineExceeded: %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("timeout took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestTimeoutOverridesPerModel(t *testing.T) {
+	c := NewHTTPClient(
+		config.UpstreamTimeouts{FirstByte: time.Minute, Idle: 30 * time.Second},
+		map[string]config.UpstreamTimeouts{"big": {FirstByte: 10 * time.Minute}},
+		config.UpstreamRetry{},
+	)
+
+	if got := c.Timeouts("small"); got.FirstByte != time.Minute || got.Connect != defaultConnectTimeout {
+		t.Fatalf("default timeouts: %+v", got)
+	}
+	if got := c.Timeouts("big"); got.FirstByte != 10*time.Minute || got.Idle != 30*time.Second {
+		t.Fatalf("model timeouts only override non-zero fields: %+v", got)
+	}
+}
+
+func TestPostRetriesRetryableStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts int
+		failures    int32 // 上游前几次返回 503
+		status      int
+		attempts    int
+	}{
+		{name: "recovers", maxAttempts: 3, failures: 2, status: http.StatusOK, attempts: 3},
+		{name: "exhausted", maxAttempts: 2, failures: 5, status: http.StatusServiceUnavailable, attempts: 2},
+		{name: "disabled", maxAttempts: 1, failures: 1, status: http.StatusServiceUnavailable, attempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&hits, 1) <= tt.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Write([]byte(`{"done":true}`))
+			}))
+			defer upstream.Close()
+
+			pool := newTestPool(t, config.UpstreamTimeouts{}, config.UpstreamRetry{
+				MaxAttempts:    tt.maxAttempts,
+				InitialBackoff: time.Millisecond,
+				MaxBackoff:     5 * time.Millisecond,
+			}, upstream.URL)
+			resp, err := pool.Post(context.Background(), Target{Model: "llama3"}, "/api/chat", []byte(`{}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status || resp.Attempts != tt.attempts || int(atomic.LoadInt32(&hits)) != tt.attempts {
+				t.Fatalf("status %d after %d attempts (%d upstream hits), want %d after %d", resp.StatusCode, resp.Attempts, hits, tt.status, tt.attempts)
+			}
+			if inflight := resp.Server.InFlight(); inflight != 0 {
+				t.Fatalf("%d requests still in flight after closing the response", inflight)
+			}
+		})
+	}
+}
+
+func TestPostDoesNotRetryCancelledRequest(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	pool := newTestPool(t, config.UpstreamTimeouts{}, config.UpstreamRetry{MaxAttempts: 3, InitialBackoff: time.Millisecond}, upstream.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := pool.Post(ctx, Target{Model: "llama3"}, "/api/chat", []byte(`{}`))
+	if err == nil || timeoutKind(err) != "" {
+		t.Fatalf("expected the caller's cancellation, got %v", err)
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Fatalf("cancelled request was retried: %d upstream hits", n)
+	}
+}
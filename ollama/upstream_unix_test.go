@@ -0,0 +1,78 @@
+//go:build unix
+
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"llm-fw/config"
+)
+
+// unreachableURL 返回一个连接会一直挂起的地址：监听 socket 的 backlog 为 0 且从不 accept，
+// 填满等待队列后新的连接得不到响应。无法构造时跳过测试
+func unreachableURL(t *testing.T) string {
+	t.Helper()
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Skipf("socket: %v", err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Skipf("bind: %v", err)
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Skipf("listen: %v", err)
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Skipf("getsockname: %v", err)
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", sa.(*syscall.SockaddrInet4).Port)
+
+	for i := 0; i < 16; i++ {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err != nil {
+			return "http://" + addr
+		}
+		t.Cleanup(func() { conn.Close() })
+	}
+	t.Skip("could not fill the listen backlog")
+	return ""
+}
+
+func TestPostRetriesConnectTimeout(t *testing.T) {
+	unreachable := unreachableURL(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"done":true}`))
+	}))
+	defer upstream.Close()
+
+	timeouts := config.UpstreamTimeouts{Connect: 100 * time.Millisecond}
+	retry := config.UpstreamRetry{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+
+	// 轮询先选中无法连接的服务器，连接超时后换到另一台服务器
+	pool := newTestPool(t, timeouts, retry, unreachable, upstream.URL)
+	resp, err := pool.Post(context.Background(), Target{Model: "llama3"}, "/api/chat", []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Attempts != 2 || resp.Server.URL != upstream.URL {
+		t.Fatalf("served by %s after %d attempts", resp.Server.URL, resp.Attempts)
+	}
+
+	// 只有一台服务器时耗尽重试次数后返回连接超时
+	pool = newTestPool(t, timeouts, retry, unreachable)
+	_, err = pool.Post(context.Background(), Target{Model: "llama3"}, "/api/chat", []byte(`{}`))
+	if timeoutKind(err) != "connect" {
+		t.Fatalf("expected a connect timeout, got %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"llm-fw/config"
+	"llm-fw/types"
+)
+
+// flushInterval 是用量计数器写回存储的间隔
+const flushInterval = 10 * time.Second
+
+// Decision 表示一次限流判断的结果
+type Decision struct {
+	Allowed    bool
+	Reason     string
+	RetryAfter time.Duration
+
+	// 每分钟请求数窗口
+	Limit     int
+	Remaining int
+	Reset     time.Time
+
+	// 每日 token 预算，TokenLimit 为 0 表示不限制
+	TokenLimit     int64
+	TokenRemaining int64
+}
+
+// subjectState 保存一个身份的限流状态
+type subjectState struct {
+	windowStart time.Time
+	windowCount int
+	inflight    int
+
+	day   *types.UsageCounter
+	month *types.UsageCounter
+}
+
+// Limiter 按身份执行请求频率、并发数和 token 预算限制
+type Limiter struct {
+	storage types.Storage
+
+	limitsMu sync.RWMutex
+	defaults config.RateLimit
+	users    map[string]config.RateLimit
+
+	mu       sync.Mutex
+	subjects map[string]*subjectState
+	dirty    map[*types.UsageCounter]bool
+	now      func() time.Time
+	done     chan struct{}
+}
+
+// NewLimiter 创建一个新的限流器并从存储中恢复当前周期的用量
+func NewLimiter(storage types.Storage, defaults config.RateLimit, users map[string]config.RateLimit) (*Limiter, error) {
+	l := &Limiter{
+		storage:  storage,
+		defaults: defaults,
+		users:    users,
+		subjects: make(map[string]*subjectState),
+		dirty:    make(map[*types.UsageCounter]bool),
+		now:      time.Now,
+		done:     make(chan struct{}),
+	}
+
+	if err := l.load(); err != nil {
+		return nil, fmt.Errorf("failed to load usage counters: %v", err)
+	}
+
+	go l.flushLoop()
+	return l, nil
+}
+
+// dayPeriod 返回时间所在的日周期
+func dayPeriod(t time.Time) string {
+	return "day:" + t.UTC().Format("2006-01-02")
+}
+
+// monthPeriod 返回时间所在的月周期
+func monthPeriod(t time.Time) string {
+	return "month:" + t.UTC().Format("2006-01")
+}
+
+// load 从存储中加载当前日和月的用量计数器
+func (l *Limiter) load() error {
+	now := l.now()
+	for _, period := range []string{dayPeriod(now), monthPeriod(now)} {
+		counters, err := l.storage.ListUsageCounters(period)
+		if err != nil {
+			return err
+		}
+		for _, counter := range counters {
+			state := l.state(counter.Subject)
+			if period == dayPeriod(now) {
+				state.day = counter
+			} else {
+				state.month = counter
+			}
+		}
+	}
+	return nil
+}
+
+// SetLimits 替换限流规则，已经计入的请求数和 token 用量保持不变
+func (l *Limiter) SetLimits(defaults config.RateLimit, users map[string]config.RateLimit) {
+	l.limitsMu.Lock()
+	l.defaults, l.users = defaults, users
+	l.limitsMu.Unlock()
+}
+
+// Limits 返回身份适用的限流规则
+func (l *Limiter) Limits(subject string) config.RateLimit {
+	l.limitsMu.RLock()
+	defer l.limitsMu.RUnlock()
+	if limits, ok := l.users[subject]; ok {
+		return limits
+	}
+	return l.defaults
+}
+
+// restore 在身份不在内存中时从存储恢复它当前日、月的用量，调用方不能持有锁
+func (l *Limiter) restore(subject string) {
+	l.mu.Lock()
+	_, exists := l.subjects[subject]
+	l.mu.Unlock()
+	if exists {
+		return
+	}
+
+	now := l.now()
+	day, err := l.storage.GetUsageCounter(subject, dayPeriod(now))
+	if err != nil {
+		log.Printf("Failed to load usage counter %s %s: %v", subject, dayPeriod(now), err)
+		return
+	}
+	month, err := l.storage.GetUsageCounter(subject, monthPeriod(now))
+	if err != nil {
+		log.Printf("Failed to load usage counter %s %s: %v", subject, monthPeriod(now), err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, exists := l.subjects[subject]; !exists {
+		l.subjects[subject] = &subjectState{day: day, month: month}
+	}
+}
+
+// state 返回身份的限流状态，调用方需持有锁
+func (l *Limiter) state(subject string) *subjectState {
+	state, exists := l.subjects[subject]
+	if !exists {
+		state = &subjectState{}
+		l.subjects[subject] = state
+	}
+	return state
+}
+
+// roll 在跨越周期时重置计数，调用方需持有锁
+func (l *Limiter) roll(subject string, state *subjectState, now time.Time) {
+	if now.Sub(state.windowStart) >= time.Minute {
+		state.windowStart = now.Truncate(time.Minute)
+		state.windowCount = 0
+	}
+	if state.day == nil || state.day.Period != dayPeriod(now) {
+		state.day = &types.UsageCounter{Subject: subject, Period: dayPeriod(now)}
+	}
+	if state.month == nil || state.month.Period != monthPeriod(now) {
+		state.month = &types.UsageCounter{Subject: subject, Period: monthPeriod(now)}
+	}
+}
+
+// nextDay 返回下一个 UTC 日的开始时间
+func nextDay(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// nextMonth 返回下一个 UTC 月的开始时间
+func nextMonth(now time.Time) time.Time {
+	y, m, _ := now.UTC().Date()
+	return time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Allow 判断身份是否可以发起一个新请求，允许时占用一个并发名额，调用方必须随后调用 Done
+func (l *Limiter) Allow(subject string) Decision {
+	l.restore(subject)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	limits := l.Limits(subject)
+	state := l.state(subject)
+	l.roll(subject, state, now)
+
+	decision := Decision{
+		Limit:      limits.RequestsPerMinute,
+		Reset:      state.windowStart.Add(time.Minute),
+		TokenLimit: limits.DailyTokens,
+	}
+	if limits.RequestsPerMinute > 0 {
+		decision.Remaining = limits.RequestsPerMinute - state.windowCount
+	}
+	if limits.DailyTokens > 0 {
+		decision.TokenRemaining = limits.DailyTokens - state.day.Tokens
+		if decision.TokenRemaining < 0 {
+			decision.TokenRemaining = 0
+		}
+	}
+
+	switch {
+	case limits.MaxConcurrent > 0 && state.inflight >= limits.MaxConcurrent:
+		decision.Reason = fmt.Sprintf("too many concurrent requests (limit %d)", limits.MaxConcurrent)
+		decision.RetryAfter = time.Second
+	case limits.RequestsPerMinute > 0 && state.windowCount >= limits.RequestsPerMinute:
+		decision.Reason = fmt.Sprintf("request rate limit exceeded (%d per minute)", limits.RequestsPerMinute)
+		decision.RetryAfter = decision.Reset.Sub(now)
+	case limits.DailyTokens > 0 && state.day.Tokens >= limits.DailyTokens:
+		decision.Reason = fmt.Sprintf("daily token budget exhausted (%d tokens)", limits.DailyTokens)
+		decision.RetryAfter = nextDay(now).Sub(now)
+	case limits.MonthlyTokens > 0 && state.month.Tokens >= limits.MonthlyTokens:
+		decision.Reason = fmt.Sprintf("monthly token budget exhausted (%d tokens)", limits.MonthlyTokens)
+		decision.RetryAfter = nextMonth(now).Sub(now)
+	default:
+		decision.Allowed = true
+		state.windowCount++
+		state.inflight++
+		state.day.Requests++
+		state.month.Requests++
+		l.dirty[state.day] = true
+		l.dirty[state.month] = true
+		if decision.Remaining > 0 {
+			decision.Remaining--
+		}
+	}
+
+	return decision
+}
+
+// Done 释放 Allow 占用的并发名额
+func (l *Limiter) Done(subject string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if state, exists := l.subjects[subject]; exists && state.inflight > 0 {
+		state.inflight--
+	}
+}
+
+// Charge 把一次请求消耗的 token 计入身份的日、月预算
+func (l *Limiter) Charge(subject string, tokens int64) {
+	if tokens <= 0 {
+		return
+	}
+
+	l.restore(subject)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	state := l.state(subject)
+	l.roll(subject, state, now)
+
+	state.day.Tokens += tokens
+	state.month.Tokens += tokens
+	l.dirty[state.day] = true
+	l.dirty[state.month] = true
+}
+
+// Usage 返回身份当前日、月的用量
+func (l *Limiter) Usage(subject string) (day, month types.UsageCounter) {
+	l.restore(subject)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.state(subject)
+	l.roll(subject, state, l.now())
+	return *state.day, *state.month
+}
+
+// Flush 把有变化的用量计数器写回存储，然后移除空闲的身份
+func (l *Limiter) Flush() {
+	l.mu.Lock()
+	counters := make(map[*types.UsageCounter]types.UsageCounter, len(l.dirty))
+	now := l.now()
+	for counter := range l.dirty {
+		counter.UpdatedAt = now
+		counters[counter] = *counter
+	}
+	l.dirty = make(map[*types.UsageCounter]bool)
+	l.mu.Unlock()
+
+	var failed []*types.UsageCounter
+	for counter, saved := range counters {
+		saved := saved
+		if err := l.storage.SaveUsageCounter(&saved); err != nil {
+			log.Printf("Failed to save usage counter %s %s: %v", saved.Subject, saved.Period, err)
+			failed = append(failed, counter)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// 写回失败的计数器留到下次重试，在此之前对应的身份不会被移除
+	for _, counter := range failed {
+		l.dirty[counter] = true
+	}
+	l.evict(now)
+}
+
+// evict 移除没有进行中的请求、每分钟窗口已过期且用量已写回存储的身份，它们下次请求时从存储恢复。
+// 调用方需持有锁
+func (l *Limiter) evict(now time.Time) {
+	for subject, state := range l.subjects {
+		if state.inflight > 0 || now.Sub(state.windowStart) < time.Minute {
+			continue
+		}
+		if l.dirty[state.day] || l.dirty[state.month] {
+			continue
+		}
+		delete(l.subjects, subject)
+	}
+}
+
+// flushLoop 定期写回用量计数器
+func (l *Limiter) flushLoop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.Flush()
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// Close 停止后台写回并立即写回剩余的计数器
+func (l *Limiter) Close() {
+	close(l.done)
+	l.Flush()
+}
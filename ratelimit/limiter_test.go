@@ -0,0 +1,192 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"llm-fw/config"
+	"llm-fw/storage"
+)
+
+// newTestLimiter 创建使用文件存储和可控时钟的限流器，返回的 now 指向当前时间
+func newTestLimiter(t *testing.T, limits config.RateLimit) (*Limiter, *time.Time) {
+	t.Helper()
+
+	store, err := storage.NewFileStorageImpl(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	l, err := NewLimiter(store, limits, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(l.Close)
+
+	now := time.Date(2024, 3, 31, 12, 0, 30, 0, time.UTC)
+	l.mu.Lock()
+	l.now = func() time.Time { return now }
+	l.mu.Unlock()
+	return l, &now
+}
+
+func TestLimiterRequestsPerMinute(t *testing.T) {
+	l, now := newTestLimiter(t, config.RateLimit{RequestsPerMinute: 2})
+
+	for i, remaining := range []int{1, 0} {
+		d := l.Allow("alice")
+		if !d.Allowed || d.Remaining != remaining || d.Limit != 2 {
+			t.Fatalf("request %d: allowed=%v remaining=%d limit=%d", i, d.Allowed, d.Remaining, d.Limit)
+		}
+		l.Done("alice")
+	}
+
+	d := l.Allow("alice")
+	if d.Allowed {
+		t.Fatal("third request in the same minute was allowed")
+	}
+	if want := time.Date(2024, 3, 31, 12, 1, 0, 0, time.UTC); !d.Reset.Equal(want) || d.RetryAfter != 30*time.Second {
+		t.Fatalf("reset=%s retry_after=%s, want %s and 30s", d.Reset, d.RetryAfter, want)
+	}
+
+	// 其他身份不受影响
+	if d := l.Allow("bob"); !d.Allowed {
+		t.Fatalf("other subject was limited: %s", d.Reason)
+	}
+
+	*now = now.Add(30 * time.Second)
+	if d := l.Allow("alice"); !d.Allowed || d.Remaining != 1 {
+		t.Fatalf("request in the next minute: allowed=%v remaining=%d", d.Allowed, d.Remaining)
+	}
+}
+
+func TestLimiterMaxConcurrent(t *testing.T) {
+	l, _ := newTestLimiter(t, config.RateLimit{MaxConcurrent: 1})
+
+	if d := l.Allow("alice"); !d.Allowed {
+		t.Fatalf("first request was limited: %s", d.Reason)
+	}
+	d := l.Allow("alice")
+	if d.Allowed || d.RetryAfter != time.Second {
+		t.Fatalf("concurrent request: allowed=%v retry_after=%s", d.Allowed, d.RetryAfter)
+	}
+
+	l.Done("alice")
+	if d := l.Allow("alice"); !d.Allowed {
+		t.Fatalf("request after Done was limited: %s", d.Reason)
+	}
+}
+
+func TestLimiterTokenBudgets(t *testing.T) {
+	tests := []struct {
+		name       string
+		limits     config.RateLimit
+		retryAfter time.Duration // 距离 2024-03-31 12:00:30 UTC 之后的周期开始
+		next       time.Duration // 前进到下一个周期的时长
+	}{
+		{
+			name:       "daily",
+			limits:     config.RateLimit{DailyTokens: 100},
+			retryAfter: 11*time.Hour + 59*time.Minute + 30*time.Second,
+			next:       12 * time.Hour,
+		},
+		{
+			name:       "monthly",
+			limits:     config.RateLimit{MonthlyTokens: 100},
+			retryAfter: 11*time.Hour + 59*time.Minute + 30*time.Second,
+			next:       12 * time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, now := newTestLimiter(t, tt.limits)
+
+			l.Charge("alice", 60)
+			if d := l.Allow("alice"); !d.Allowed {
+				t.Fatalf("request under budget was limited: %s", d.Reason)
+			}
+			l.Done("alice")
+			l.Charge("alice", 40)
+
+			d := l.Allow("alice")
+			if d.Allowed || d.RetryAfter != tt.retryAfter {
+				t.Fatalf("request over budget: allowed=%v retry_after=%s, want retry after %s", d.Allowed, d.RetryAfter, tt.retryAfter)
+			}
+
+			*now = now.Add(tt.next)
+			if d := l.Allow("alice"); !d.Allowed {
+				t.Fatalf("request in the next period was limited: %s", d.Reason)
+			}
+		})
+	}
+}
+
+func TestLimiterMonthlyBudgetSpansDays(t *testing.T) {
+	l, now := newTestLimiter(t, config.RateLimit{DailyTokens: 100, MonthlyTokens: 150})
+	*now = time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	l.Charge("alice", 100)
+	if d := l.Allow("alice"); d.Allowed || d.TokenRemaining != 0 {
+		t.Fatalf("daily budget: allowed=%v token_remaining=%d", d.Allowed, d.TokenRemaining)
+	}
+
+	// 第二天日预算重置，月预算继续累计
+	*now = now.Add(24 * time.Hour)
+	d := l.Allow("alice")
+	if !d.Allowed || d.TokenRemaining != 100 {
+		t.Fatalf("next day: allowed=%v token_remaining=%d", d.Allowed, d.TokenRemaining)
+	}
+	l.Done("alice")
+	l.Charge("alice", 50)
+	if d := l.Allow("alice"); d.Allowed {
+		t.Fatal("request over the monthly budget was allowed")
+	}
+
+	day, month := l.Usage("alice")
+	if day.Tokens != 50 || month.Tokens != 150 || month.Requests != 1 {
+		t.Fatalf("usage: day=%+v month=%+v", day, month)
+	}
+}
+
+func TestLimiterEvictsIdleSubjects(t *testing.T) {
+	l, now := newTestLimiter(t, config.RateLimit{DailyTokens: 100})
+
+	if d := l.Allow("alice"); !d.Allowed {
+		t.Fatalf("request was limited: %s", d.Reason)
+	}
+	l.Charge("alice", 100)
+	l.Allow("bob")
+	l.Done("bob")
+
+	// 进行中的请求和未过期的窗口都会保留身份
+	l.Flush()
+	if len(l.subjects) != 2 {
+		t.Fatalf("subjects evicted too early: %d left", len(l.subjects))
+	}
+
+	*now = now.Add(time.Minute)
+	l.Flush()
+	if _, exists := l.subjects["bob"]; exists {
+		t.Fatal("idle subject was not evicted")
+	}
+	if _, exists := l.subjects["alice"]; !exists {
+		t.Fatal("subject with an in-flight request was evicted")
+	}
+
+	l.Done("alice")
+	l.Flush()
+	if len(l.subjects) != 0 {
+		t.Fatalf("expected all subjects to be evicted, %d left", len(l.subjects))
+	}
+
+	// 被移除的身份从存储恢复用量，预算不会因此重置
+	if d := l.Allow("alice"); d.Allowed {
+		t.Fatal("evicted subject got a fresh token budget")
+	}
+	day, _ := l.Usage("alice")
+	if day.Tokens != 100 || day.Requests != 1 {
+		t.Fatalf("restored usage: %+v", day)
+	}
+}
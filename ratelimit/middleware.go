@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/auth"
+	"llm-fw/types"
+)
+
+// Middleware 按请求身份执行限流，必须放在 auth.Middleware 之后
+func Middleware(l *Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subject := auth.Identity(c)
+		decision := l.Allow(subject)
+		setHeaders(c, decision)
+
+		if !decision.Allowed {
+			retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": decision.Reason})
+			return
+		}
+
+		defer l.Done(subject)
+		c.Next()
+	}
+}
+
+// setHeaders 写入 X-RateLimit-* 响应头
+func setHeaders(c *gin.Context, decision Decision) {
+	if decision.Limit > 0 {
+		c.Header("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(decision.Reset.Unix(), 10))
+	}
+	if decision.TokenLimit > 0 {
+		c.Header("X-RateLimit-Limit-Tokens", strconv.FormatInt(decision.TokenLimit, 10))
+		c.Header("X-RateLimit-Remaining-Tokens", strconv.FormatInt(decision.TokenRemaining, 10))
+	}
+}
+
+// usageStorage 包装存储，在保存请求记录时把 token 用量计入限流器
+type usageStorage struct {
+	types.Storage
+	limiter *Limiter
+}
+
+// TrackUsage 返回一个在 SaveRequest 时按 Request.UserID 计入 token 用量的存储
+func TrackUsage(storage types.Storage, l *Limiter) types.Storage {
+	return &usageStorage{Storage: storage, limiter: l}
+}
+
+// SaveRequest 保存请求并计入 TokensIn + TokensOut
+func (s *usageStorage) SaveRequest(req *types.Request) error {
+	s.limiter.Charge(req.UserID, int64(req.TokensIn+req.TokensOut))
+	return s.Storage.SaveRequest(req)
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/config"
+)
+
+func TestMiddlewareHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	l, _ := newTestLimiter(t, config.RateLimit{RequestsPerMinute: 1, DailyTokens: 1000})
+	l.Charge("anonymous", 400)
+
+	router := gin.New()
+	router.Use(Middleware(l))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		status     int
+		remaining  string
+		retryAfter string
+	}{
+		{status: http.StatusOK, remaining: "0"},
+		{status: http.StatusTooManyRequests, remaining: "0", retryAfter: "30"},
+	}
+
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if w.Code != tt.status {
+			t.Fatalf("request %d: status %d, want %d", i, w.Code, tt.status)
+		}
+		headers := map[string]string{
+			"X-RateLimit-Limit":            "1",
+			"X-RateLimit-Remaining":        tt.remaining,
+			"X-RateLimit-Reset":            "1711886460",
+			"X-RateLimit-Limit-Tokens":     "1000",
+			"X-RateLimit-Remaining-Tokens": "600",
+			"Retry-After":                  tt.retryAfter,
+		}
+		for name, want := range headers {
+			if got := w.Header().Get(name); got != want {
+				t.Errorf("request %d: %s = %q, want %q", i, name, got, want)
+			}
+		}
+	}
+}
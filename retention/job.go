@@ -0,0 +1,116 @@
+package retention
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"llm-fw/config"
+	"llm-fw/types"
+)
+
+// defaultInterval 是未配置时清理任务的执行间隔
+const defaultInterval = time.Hour
+
+// PolicyFromConfig 根据配置生成请求记录保留策略
+func PolicyFromConfig(cfg *config.Config) types.RetentionPolicy {
+	return types.RetentionPolicy{
+		MaxAge:       cfg.Retention.MaxAge,
+		MaxRows:      cfg.Retention.MaxRows,
+		MaxSizeBytes: cfg.Retention.MaxSizeMB << 20,
+		BodyMaxAge:   cfg.Retention.BodyMaxAge,
+	}
+}
+
+// Job 定期按保留策略清理请求记录和过期的模型统计历史
+type Job struct {
+	storage  types.Storage
+	policy   types.RetentionPolicy
+	interval time.Duration
+
+	mu      sync.Mutex
+	lastRun *types.RetentionReport
+	done    chan struct{}
+	stop    sync.Once
+}
+
+// NewJob 创建一个新的清理任务，需要调用 Start 才会定期执行
+func NewJob(storage types.Storage, policy types.RetentionPolicy, interval time.Duration) *Job {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	return &Job{
+		storage:  storage,
+		policy:   policy,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+// Policy 返回清理任务使用的保留策略
+func (j *Job) Policy() types.RetentionPolicy {
+	return j.policy
+}
+
+// Interval 返回清理任务的执行间隔
+func (j *Job) Interval() time.Duration {
+	return j.interval
+}
+
+// Start 启动后台清理，启动时立即执行一次
+func (j *Job) Start() {
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := j.Run(); err != nil {
+				log.Printf("Retention run failed: %v", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-j.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止后台清理
+func (j *Job) Stop() {
+	j.stop.Do(func() { close(j.done) })
+}
+
+// Run 立即执行一次清理
+func (j *Job) Run() (*types.RetentionReport, error) {
+	report, err := j.storage.ApplyRetention(j.policy, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := j.storage.Cleanup(); err != nil {
+		log.Printf("Failed to clean up model stats history: %v", err)
+	}
+
+	if report.Deleted() > 0 || report.BodiesStripped > 0 {
+		log.Printf("Retention removed %d requests (age %d, rows %d, size %d) and stripped %d bodies",
+			report.Deleted(), report.DeletedByAge, report.DeletedByRows, report.DeletedBySize, report.BodiesStripped)
+	}
+
+	j.mu.Lock()
+	j.lastRun = report
+	j.mu.Unlock()
+	return report, nil
+}
+
+// DryRun 统计按当前策略将被清理的记录，不修改数据
+func (j *Job) DryRun() (*types.RetentionReport, error) {
+	return j.storage.ApplyRetention(j.policy, true)
+}
+
+// LastRun 返回最近一次实际执行的结果，尚未执行时返回 nil
+func (j *Job) LastRun() *types.RetentionReport {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.lastRun
+}
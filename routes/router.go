@@ -2,19 +2,149 @@ package routes
 
 import (
 	"log"
-	"net/http/httputil"
-	"net/url"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"llm-fw/auth"
+	"llm-fw/cache"
+	"llm-fw/config"
 	"llm-fw/handlers"
+	"llm-fw/metrics"
+	"llm-fw/ollama"
+	"llm-fw/ratelimit"
+	"llm-fw/retention"
+	"llm-fw/timeseries"
 	"llm-fw/types"
 )
 
+// App 是组装好的路由器和重新加载配置时需要更新的组件
+type App struct {
+	Router *gin.Engine
+
+	pool     *ollama.Pool
+	policy   *auth.Policy
+	keyStore *auth.KeyStore
+	limiter  *ratelimit.Limiter // 未启用限流时为空
+	metrics  *metrics.Metrics
+
+	// 关闭时需要停止的后台任务
+	cache         *cache.Cache // 未启用响应缓存时为空
+	retention     *retention.Job
+	aggregator    *timeseries.Aggregator
+	healthChecker *ollama.HealthChecker // 未启用健康检查时为空
+
+	inflight sync.WaitGroup // 正在处理的请求，包括写入请求记录
+}
+
+// Reload 按新配置替换上游服务器池、路由规则、调度器参数、鉴权配置和限流规则，并从存储中重新加载 API 密钥。
+// 先完成所有可能失败的步骤再统一生效，任何一步失败时保留当前配置。正在进行的请求和流式响应不受影响
+func (a *App) Reload(cfg *config.Config) error {
+	poolReload, err := a.pool.PrepareReload(cfg)
+	if err != nil {
+		return err
+	}
+	keyReload, err := a.keyStore.PrepareReload()
+	if err != nil {
+		return err
+	}
+
+	poolReload.Apply()
+	keyReload.Apply()
+	a.policy.Set(cfg.Auth.Enabled, cfg.Auth.AdminKey)
+	if a.limiter != nil {
+		a.limiter.SetLimits(cfg.RateLimits.Default, cfg.RateLimits.Users)
+	}
+	return nil
+}
+
+// track 记录正在处理的请求，使关闭时可以等待它们写入请求记录
+func (a *App) track(c *gin.Context) {
+	a.inflight.Add(1)
+	defer a.inflight.Done()
+	c.Next()
+}
+
+// Wait 等待所有正在处理的请求返回，超过 timeout 时返回 false
+func (a *App) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		a.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Close 停止后台任务，写回限流用量、模型统计和统计时间桶，并关闭响应缓存。
+// 必须在 HTTP 服务停止、请求都已返回之后调用，调用之后才能关闭存储
+func (a *App) Close() {
+	if a.healthChecker != nil {
+		a.healthChecker.Stop()
+	}
+	a.retention.Stop()
+	a.aggregator.Stop()
+	if a.limiter != nil {
+		a.limiter.Close()
+	}
+	a.metrics.Flush()
+	if err := a.aggregator.Run(time.Now()); err != nil {
+		log.Printf("Final stats aggregation failed: %v", err)
+	}
+	if a.cache != nil {
+		if err := a.cache.Close(); err != nil {
+			log.Printf("Failed to close response cache: %v", err)
+		}
+	}
+}
+
 // SetupRouter 设置路由器
-func SetupRouter(ollamaURL string, storage types.Storage, metricsCollector types.MetricsCollector) (*gin.Engine, error) {
-	log.Printf("Setting up router with Ollama URL: %s", ollamaURL)
+func SetupRouter(cfg *config.Config, pool *ollama.Pool, storage types.Storage, metricsCollector *metrics.Metrics) (*App, error) {
+	for _, server := range pool.Servers() {
+		log.Printf("Setting up router with Ollama server %s: %s", server.Name, server.URL)
+	}
 	router := gin.Default()
+	app := &App{Router: router, pool: pool, metrics: metricsCollector}
+	router.Use(app.track)
+
+	// Prometheus 指标
+	prom := metricsCollector.Prometheus()
+	prom.RegisterPool(pool)
+	router.Use(prom.Middleware())
+
+	// 创建 API 密钥存储
+	keyStore, err := auth.NewKeyStore(storage)
+	if err != nil {
+		return nil, err
+	}
+	keysHandler := handlers.NewKeysHandler(keyStore)
+	policy := auth.NewPolicy(cfg.Auth.Enabled, cfg.Auth.AdminKey)
+	authMiddleware := auth.Middleware(keyStore, policy)
+	if cfg.Auth.Enabled {
+		log.Printf("API key authentication is required")
+	}
+	router.GET("/metrics", authMiddleware, gin.WrapH(prom.Handler()))
+
+	// 模型调用接口的中间件：鉴权，启用时再限流
+	modelMiddleware := []gin.HandlerFunc{authMiddleware}
+	var limiter *ratelimit.Limiter
+	if cfg.RateLimits.Enabled {
+		limiter, err = ratelimit.NewLimiter(storage, cfg.RateLimits.Default, cfg.RateLimits.Users)
+		if err != nil {
+			return nil, err
+		}
+		// 保存请求记录时计入 token 用量
+		storage = ratelimit.TrackUsage(storage, limiter)
+		modelMiddleware = append(modelMiddleware, ratelimit.Middleware(limiter))
+		log.Printf("Rate limiting enabled")
+	}
+	usageHandler := handlers.NewUsageHandler(limiter)
 
 	// 创建历史记录管理器
 	historyManager := storage.NewHistoryManager(100) // 保存最近100条记录
@@ -22,45 +152,121 @@ func SetupRouter(ollamaURL string, storage types.Storage, metricsCollector types
 
 	// 创建模型处理器
 	log.Printf("Initializing model handler...")
-	modelHandler := handlers.NewModelHandler(ollamaURL, storage, metricsCollector)
+	modelHandler := handlers.NewModelHandler(pool, storage, metricsCollector)
 	log.Printf("Model handler initialized successfully")
 
 	// 创建生成处理器
-	generateHandler := handlers.NewGenerateHandler(ollamaURL, storage, metricsCollector)
+	generateHandler := handlers.NewGenerateHandler(pool, storage, metricsCollector)
 
 	// 创建聊天处理器
-	chatHandler := handlers.NewChatHandler(storage, ollamaURL, metricsCollector)
+	chatHandler := handlers.NewChatHandler(storage, pool, metricsCollector)
 
-	// 设置 Ollama 代理
-	ollamaTarget, err := url.Parse(ollamaURL)
-	if err != nil {
-		return nil, err
+	// 确定性请求的响应缓存，启用时生成和聊天请求先查询缓存
+	if cfg.Cache.Enabled {
+		responseCache, err := cache.New(cfg.Cache.TTL, cfg.Cache.MaxEntries, cfg.Cache.Path)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Cache.Semantic.Enabled {
+			if err := responseCache.EnableSemantic(cfg.Cache.Semantic); err != nil {
+				return nil, err
+			}
+			log.Printf("Semantic cache enabled with embedding model %s", responseCache.SemanticModel())
+		}
+		responseCache.Start()
+		app.cache = responseCache
+		generateHandler.Cache = responseCache
+		chatHandler.Cache = responseCache
+		log.Printf("Response cache enabled, entries expire after %s", responseCache.TTL())
+	}
+
+	// 创建嵌入处理器
+	embeddingHandler := handlers.NewEmbeddingHandler(pool, storage, metricsCollector)
+
+	// 创建统计处理器
+	statsHandler := handlers.NewStatsHandler(storage, metricsCollector)
+
+	// 创建数据保留任务，启用时在后台定期清理
+	retentionJob := retention.NewJob(storage, retention.PolicyFromConfig(cfg), cfg.Retention.Interval)
+	if cfg.Retention.Enabled {
+		retentionJob.Start()
+		log.Printf("Retention enabled, running every %s", retentionJob.Interval())
+	}
+	retentionHandler := handlers.NewRetentionHandler(retentionJob, cfg.Retention.Enabled)
+
+	// 创建统计时间桶聚合任务
+	aggregator := timeseries.NewAggregator(storage, cfg.Stats.Interval, cfg.Stats.SnapshotInterval)
+	aggregator.Start()
+	app.retention, app.aggregator = retentionJob, aggregator
+	timeseriesHandler := handlers.NewTimeseriesHandler(storage)
+
+	// 上游服务器健康检查，启用时熔断的服务器不再被选中
+	if cfg.Ollama.HealthCheck.Enabled {
+		healthChecker := ollama.NewHealthChecker(pool, cfg.Ollama.HealthCheck, metricsCollector)
+		healthChecker.Start()
+		app.healthChecker = healthChecker
+		log.Printf("Upstream health checks enabled, running every %s", healthChecker.Interval())
+	}
+	serversHandler := handlers.NewServersHandler(pool)
+
+	// 聊天和生成请求的并发限制，超出时按用户公平排队
+	if cfg.Ollama.Scheduler.Enabled {
+		pool.EnableScheduler(cfg.Ollama.Scheduler, metricsCollector)
+		log.Printf("Request scheduler enabled")
 	}
-	ollamaProxy := httputil.NewSingleHostReverseProxy(ollamaTarget)
 
 	// API 路由组
 	api := router.Group("/api")
 	{
 		// 生成相关路由
-		api.POST("/generate", generateHandler.Generate)
+		api.POST("/generate", append(modelMiddleware, generateHandler.Generate)...)
 
 		// 聊天相关路由
-		api.POST("/chat", chatHandler.Chat)
+		api.POST("/chat", append(modelMiddleware, chatHandler.Chat)...)
+
+		// 当前身份的用量
+		api.GET("/usage", authMiddleware, usageHandler.GetUsage)
 
 		// 模型相关路由
 		api.GET("/models", gin.WrapF(modelHandler.ListModels))
-		api.GET("/history", historyHandler.GetHistory)
+		api.GET("/models/:model/timeseries", authMiddleware, timeseriesHandler.GetTimeseries)
+		api.GET("/stats", authMiddleware, statsHandler.GetStats)
+		api.GET("/servers", authMiddleware, serversHandler.ListServers)
+
+		// 请求历史包含提示词和回复，非管理员只能看到自己的请求
+		api.GET("/history", authMiddleware, historyHandler.GetHistory)
 
-		// Ollama API 代理路由
-		api.Any("/tags", func(c *gin.Context) {
-			ollamaProxy.ServeHTTP(c.Writer, c.Request)
-		})
+		// Ollama 模型列表（合并所有上游服务器）
+		api.GET("/tags", modelHandler.Tags)
+	}
+
+	// 管理路由
+	admin := router.Group("/api/admin", auth.RequireAdmin(keyStore, policy))
+	{
+		admin.GET("/keys", keysHandler.ListKeys)
+		admin.POST("/keys", keysHandler.CreateKey)
+		admin.DELETE("/keys/:id", keysHandler.RevokeKey)
+		admin.POST("/keys/:id/rotate", keysHandler.RotateKey)
+
+		// 数据保留演练报告
+		admin.GET("/retention", retentionHandler.GetReport)
+	}
+
+	// OpenAI / Anthropic 兼容路由
+	v1 := router.Group("/v1", modelMiddleware...)
+	{
+		v1.POST("/chat/completions", chatHandler.ChatCompletions)
+		v1.POST("/messages", chatHandler.Messages)
+		v1.POST("/embeddings", embeddingHandler.Embeddings)
+		v1.GET("/models", modelHandler.ListOpenAIModels)
+		v1.GET("/models/*model", modelHandler.GetOpenAIModel)
 	}
 
 	// 静态文件
 	router.Static("/static", "templates/static")   // 静态资源（CSS、JS等）
 	router.StaticFile("/", "templates/index.html") // 主页
 
+	app.policy, app.keyStore, app.limiter = policy, keyStore, limiter
 	log.Printf("Router setup completed")
-	return router, nil
+	return app, nil
 }
@@ -0,0 +1,216 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"llm-fw/config"
+	"llm-fw/metrics"
+	"llm-fw/ollama"
+	"llm-fw/storage"
+	"llm-fw/types"
+)
+
+// reloadStorage 在 failKeys 为 true 时读取 API 密钥失败，用于模拟重新加载时存储不可用
+type reloadStorage struct {
+	types.Storage
+	failKeys atomic.Bool
+}
+
+func (s *reloadStorage) ListAPIKeys() ([]*types.APIKey, error) {
+	if s.failKeys.Load() {
+		return nil, errors.New("database is locked")
+	}
+	return s.Storage.ListAPIKeys()
+}
+
+// routerTest 是按配置组装好的路由器和它使用的存储
+type routerTest struct {
+	app   *App
+	store *reloadStorage
+}
+
+// newRouterTest 按 cfg 组装路由器，上游是一个只返回空结果的模拟 Ollama 服务器
+func newRouterTest(t *testing.T, cfg *config.Config) *routerTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(upstream.Close)
+	cfg.Ollama.URL = upstream.URL
+	cfg.Storage.Path = t.TempDir()
+
+	backend, err := storage.NewStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &reloadStorage{Storage: backend}
+	pool, err := ollama.NewPoolFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := SetupRouter(cfg, pool, store, metrics.NewMetrics(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		app.Close()
+		store.Close()
+	})
+	return &routerTest{app: app, store: store}
+}
+
+// get 发送 GET 请求，key 不为空时以 Bearer 方式携带
+func (rt *routerTest) get(path, key string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	rt.app.Router.ServeHTTP(w, req)
+	return w
+}
+
+// createKey 创建一个 API 密钥并返回明文
+func (rt *routerTest) createKey(t *testing.T, user string, admin bool) string {
+	t.Helper()
+	plaintext, _, err := rt.app.keyStore.Create(user, user, "", admin, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plaintext
+}
+
+func TestStatsEndpointsRequireKey(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Auth.Enabled = true
+	rt := newRouterTest(t, cfg)
+	key := rt.createKey(t, "alice", false)
+
+	for _, path := range []string{"/api/history", "/api/stats", "/api/servers", "/api/models/llama3/timeseries", "/metrics"} {
+		if w := rt.get(path, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without a key: status %d", path, w.Code)
+		}
+		if w := rt.get(path, "sk-llmfw-invalid"); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s with an invalid key: status %d", path, w.Code)
+		}
+		if w := rt.get(path, key); w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden {
+			t.Errorf("GET %s with a valid key: status %d", path, w.Code)
+		}
+	}
+}
+
+func TestHistoryVisibility(t *testing.T) {
+	tests := []struct {
+		name  string
+		auth  bool
+		key   string // 使用哪个身份的密钥，为空表示不携带密钥
+		users []string
+	}{
+		{name: "own requests", auth: true, key: "alice", users: []string{"alice"}},
+		{name: "admin sees every request", auth: true, key: "root", users: []string{"alice", "anonymous", "bob"}},
+		// 未启用鉴权时不带密钥的请求只能看到匿名请求
+		{name: "anonymous", users: []string{"anonymous"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewConfig()
+			cfg.Auth.Enabled = tt.auth
+			rt := newRouterTest(t, cfg)
+			keys := map[string]string{
+				"alice": rt.createKey(t, "alice", false),
+				"root":  rt.createKey(t, "root", true),
+			}
+			for i, user := range []string{"alice", "bob", "anonymous"} {
+				req := &types.Request{ID: user, UserID: user, Model: "llama3", Prompt: "secret of " + user, Timestamp: time.Now().Add(time.Duration(i) * time.Second)}
+				if err := rt.store.SaveRequest(req); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			w := rt.get("/api/history?limit=10", keys[tt.key])
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body.String())
+			}
+			var resp struct {
+				Requests []*types.Request `json:"requests"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var users []string
+			for _, req := range resp.Requests {
+				users = append(users, req.UserID)
+			}
+			sort.Strings(users)
+			if strings.Join(users, ",") != strings.Join(tt.users, ",") {
+				t.Fatalf("visible requests of %v, want %v", users, tt.users)
+			}
+		})
+	}
+}
+
+func TestReloadKeepsConfigOnFailure(t *testing.T) {
+	cfg := config.NewConfig()
+	rt := newRouterTest(t, cfg)
+	servers := rt.app.pool.Servers()
+
+	// reloaded 启用鉴权并修改负载均衡策略
+	reloaded := func() *config.Config {
+		next := config.NewConfig()
+		next.Ollama.URL = cfg.Ollama.URL
+		next.Ollama.Strategy = config.BalanceLeastOutstanding
+		next.Auth.Enabled = true
+		return next
+	}
+	unchanged := func(t *testing.T) {
+		t.Helper()
+		if rt.app.pool.Strategy() != config.BalanceRoundRobin || len(rt.app.pool.Servers()) != len(servers) || rt.app.pool.Servers()[0] != servers[0] {
+			t.Fatalf("pool changed by a failed reload: %s", rt.app.pool.Strategy())
+		}
+		if w := rt.get("/api/stats", ""); w.Code != http.StatusOK {
+			t.Fatalf("auth policy changed by a failed reload: status %d", w.Code)
+		}
+	}
+
+	t.Run("invalid route", func(t *testing.T) {
+		next := reloaded()
+		next.Ollama.Routes = []config.ModelRoute{{Match: "llama3", Targets: []config.RouteTarget{{Server: "missing"}}}}
+		if err := rt.app.Reload(next); err == nil {
+			t.Fatal("reload with a route to an unknown server succeeded")
+		}
+		unchanged(t)
+	})
+
+	t.Run("api keys unavailable", func(t *testing.T) {
+		rt.store.failKeys.Store(true)
+		defer rt.store.failKeys.Store(false)
+		if err := rt.app.Reload(reloaded()); err == nil {
+			t.Fatal("reload without api keys succeeded")
+		}
+		unchanged(t)
+	})
+
+	// 存储恢复后重新加载成功，所有配置同时生效
+	if err := rt.app.Reload(reloaded()); err != nil {
+		t.Fatal(err)
+	}
+	if rt.app.pool.Strategy() != config.BalanceLeastOutstanding {
+		t.Fatalf("strategy %s after reload", rt.app.pool.Strategy())
+	}
+	if w := rt.get("/api/stats", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d without a key after enabling auth", w.Code)
+	}
+}
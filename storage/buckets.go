@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"time"
+
+	"llm-fw/types"
+)
+
+// How long statistics buckets are kept by Cleanup; day buckets are kept forever
+var bucketRetention = map[string]time.Duration{
+	types.BucketMinute: 7 * 24 * time.Hour,
+	types.BucketHour:   90 * 24 * time.Hour,
+}
+
+// bucketColumns is the column list used by all model_stats_buckets queries
+const bucketColumns = `model, granularity, bucket_start, requests, errors, tokens_in, tokens_out,
+	avg_latency_ms, p50_latency_ms, p90_latency_ms, p99_latency_ms, error_rate, requests_per_second, tokens_per_second`
+
+// bucketArgs returns the values of a bucket in bucketColumns order
+func bucketArgs(b *types.ModelStatsBucket) []interface{} {
+	return []interface{}{
+		b.Model,
+		b.Granularity,
+		b.Start.UTC(),
+		b.Requests,
+		b.Errors,
+		b.TokensIn,
+		b.TokensOut,
+		b.AvgLatencyMs,
+		b.P50LatencyMs,
+		b.P90LatencyMs,
+		b.P99LatencyMs,
+		b.ErrorRate,
+		b.RequestsPerSecond,
+		b.TokensPerSecond,
+	}
+}
+
+// scanModelStatsBucket scans a model_stats_buckets row selected with bucketColumns
+func scanModelStatsBucket(row interface{ Scan(...interface{}) error }) (*types.ModelStatsBucket, error) {
+	var b types.ModelStatsBucket
+	err := row.Scan(
+		&b.Model,
+		&b.Granularity,
+		&b.Start,
+		&b.Requests,
+		&b.Errors,
+		&b.TokensIn,
+		&b.TokensOut,
+		&b.AvgLatencyMs,
+		&b.P50LatencyMs,
+		&b.P90LatencyMs,
+		&b.P99LatencyMs,
+		&b.ErrorRate,
+		&b.RequestsPerSecond,
+		&b.TokensPerSecond,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
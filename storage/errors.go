@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"database/sql"
+
+	"llm-fw/types"
+)
+
+// errorTypeOrInternal maps failures recorded without a type, e.g. before error
+// types were stored, to types.ErrorTypeInternal
+func errorTypeOrInternal(errorType string) string {
+	if errorType == "" {
+		return types.ErrorTypeInternal
+	}
+	return errorType
+}
+
+// countSQLErrors counts failed requests by model and error type, the query is portable
+// between SQLite and PostgreSQL
+func countSQLErrors(db *sql.DB) (map[string]map[string]int64, error) {
+	rows, err := db.Query(`
+		SELECT model, error_type, COUNT(*)
+		FROM requests
+		WHERE status <> 0
+		GROUP BY model, error_type
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]map[string]int64)
+	for rows.Next() {
+		var model, errorType string
+		var count int64
+		if err := rows.Scan(&model, &errorType, &count); err != nil {
+			return nil, err
+		}
+		if counts[model] == nil {
+			counts[model] = make(map[string]int64)
+		}
+		counts[model][errorTypeOrInternal(errorType)] += count
+	}
+	return counts, rows.Err()
+}
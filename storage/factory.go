@@ -11,9 +11,15 @@ import (
 func NewStorage(cfg *config.Config) (types.Storage, error) {
 	switch cfg.Storage.Type {
 	case config.StorageTypeFile:
-		return NewFileStorageImpl(cfg.Storage.Path)
+		return NewFileStorageImpl(cfg.Storage.Path, int64(cfg.Storage.MaxSegmentMB)<<20)
 	case config.StorageTypeSQLite:
 		return NewSQLiteStorage(cfg.Storage.Path)
+	case config.StorageTypePostgres:
+		return NewPostgresStorage(cfg.Storage.DSN, PostgresOptions{
+			MaxOpenConns:    cfg.Storage.MaxOpenConns,
+			MaxIdleConns:    cfg.Storage.MaxIdleConns,
+			ConnMaxLifetime: cfg.Storage.ConnMaxLifetime,
+		})
 	default:
 		return nil, fmt.Errorf("unsupported storage type: %s", cfg.Storage.Type)
 	}
@@ -18,10 +18,16 @@ type FileStorageImpl struct {
 	mu           sync.RWMutex
 	modelStats   map[string]*types.ModelStats
 	modelHistory map[string][]*types.ModelStatsHistory
+	apiKeys      map[string]*types.APIKey
+	usage        map[string]*types.UsageCounter
+	buckets      map[string]*types.ModelStatsBucket
+	requests     *requestLog
 }
 
-// NewFileStorageImpl creates a new FileStorage instance
-func NewFileStorageImpl(baseDir string) (*FileStorageImpl, error) {
+// NewFileStorageImpl creates a new FileStorage instance.
+// Requests are kept in JSONL segments under baseDir/requests, rotated daily or
+// when a segment reaches segmentSize bytes (DefaultSegmentSize if <= 0).
+func NewFileStorageImpl(baseDir string, segmentSize int64) (*FileStorageImpl, error) {
 	if err := os.MkdirAll(baseDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create base directory: %v", err)
 	}
@@ -30,6 +36,9 @@ func NewFileStorageImpl(baseDir string) (*FileStorageImpl, error) {
 		baseDir:      baseDir,
 		modelStats:   make(map[string]*types.ModelStats),
 		modelHistory: make(map[string][]*types.ModelStatsHistory),
+		apiKeys:      make(map[string]*types.APIKey),
+		usage:        make(map[string]*types.UsageCounter),
+		buckets:      make(map[string]*types.ModelStatsBucket),
 	}
 
 	if err := fs.loadModelStats(); err != nil {
@@ -40,6 +49,24 @@ func NewFileStorageImpl(baseDir string) (*FileStorageImpl, error) {
 		return nil, fmt.Errorf("failed to load model history: %w", err)
 	}
 
+	if err := fs.loadAPIKeys(); err != nil {
+		return nil, fmt.Errorf("failed to load api keys: %w", err)
+	}
+
+	if err := fs.loadUsageCounters(); err != nil {
+		return nil, fmt.Errorf("failed to load usage counters: %w", err)
+	}
+
+	if err := fs.loadBuckets(); err != nil {
+		return nil, fmt.Errorf("failed to load model stats buckets: %w", err)
+	}
+
+	requests, err := openRequestLog(filepath.Join(baseDir, "requests"), segmentSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open request log: %w", err)
+	}
+	fs.requests = requests
+
 	return fs, nil
 }
 
@@ -103,26 +130,22 @@ func (fs *FileStorageImpl) saveModelHistory() error {
 
 // SaveRequest saves a request
 func (fs *FileStorageImpl) SaveRequest(req *types.Request) error {
-	// Not implemented
-	return nil
+	return fs.requests.Append(req)
 }
 
 // GetRequest retrieves a request by ID
 func (fs *FileStorageImpl) GetRequest(id string) (*types.Request, error) {
-	// Not implemented
-	return nil, nil
+	return fs.requests.Get(id)
 }
 
 // ListRequests retrieves requests with limit
 func (fs *FileStorageImpl) ListRequests(limit int) ([]*types.Request, error) {
-	// Not implemented
-	return nil, nil
+	return fs.requests.List(limit)
 }
 
 // DeleteRequest deletes a request by ID
 func (fs *FileStorageImpl) DeleteRequest(id string) error {
-	// Not implemented
-	return nil
+	return fs.requests.Delete(id)
 }
 
 // SaveModelStats saves model statistics
@@ -185,7 +208,6 @@ func (fs *FileStorageImpl) DeleteModelStats(model string) error {
 // SaveModelStatsHistory saves model statistics history
 func (fs *FileStorageImpl) SaveModelStatsHistory(history *types.ModelStatsHistory) error {
 	fs.mu.Lock()
-	defer fs.mu.Unlock()
 
 	// Initialize history slice if not exists
 	if _, exists := fs.modelHistory[history.Model]; !exists {
@@ -194,15 +216,9 @@ func (fs *FileStorageImpl) SaveModelStatsHistory(history *types.ModelStatsHistor
 
 	// Add new history entry
 	fs.modelHistory[history.Model] = append(fs.modelHistory[history.Model], history)
+	fs.mu.Unlock()
 
-	// Save to file
-	historyFile := filepath.Join(fs.baseDir, fmt.Sprintf("%s_history.json", history.Model))
-	data, err := json.MarshalIndent(fs.modelHistory[history.Model], "", "  ")
-	if err != nil {
-		return err
-	}
-
-	return os.WriteFile(historyFile, data, 0644)
+	return fs.saveModelHistory()
 }
 
 // GetModelStatsHistory retrieves model statistics history
@@ -247,16 +263,16 @@ func (fs *FileStorageImpl) ListModelStatsHistory(limit int) ([]*types.ModelStats
 // DeleteModelStatsHistory deletes model statistics history
 func (fs *FileStorageImpl) DeleteModelStatsHistory(model string) error {
 	fs.mu.Lock()
-	defer fs.mu.Unlock()
 
 	delete(fs.modelHistory, model)
+	fs.mu.Unlock()
+
 	return fs.saveModelHistory()
 }
 
 // Cleanup removes old data
 func (fs *FileStorageImpl) Cleanup() error {
 	fs.mu.Lock()
-	defer fs.mu.Unlock()
 
 	// Remove history entries older than 30 days
 	thirtyDaysAgo := time.Now().Add(-30 * 24 * time.Hour)
@@ -270,38 +286,319 @@ func (fs *FileStorageImpl) Cleanup() error {
 		fs.modelHistory[model] = validHistory
 	}
 
+	// Remove expired statistics buckets
+	removed := 0
+	for key, bucket := range fs.buckets {
+		if retention, ok := bucketRetention[bucket.Granularity]; ok && time.Since(bucket.Start) > retention {
+			delete(fs.buckets, key)
+			removed++
+		}
+	}
+	var err error
+	if removed > 0 {
+		err = fs.saveBuckets()
+	}
+	fs.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
 	return fs.saveModelHistory()
 }
 
 // Close closes the storage
 func (fs *FileStorageImpl) Close() error {
-	return nil
+	return fs.requests.Close()
 }
 
 // NewHistoryManager creates a new history manager
 func (fs *FileStorageImpl) NewHistoryManager(size int) types.HistoryManager {
-	return nil
+	return NewHistoryManager(fs, size)
 }
 
 // GetAllRequests 获取所有请求
 func (fs *FileStorageImpl) GetAllRequests() ([]*types.Request, error) {
-	// Not implemented
-	return nil, nil
+	return fs.requests.List(0)
 }
 
 // GetRequests 获取指定用户的所有请求
 func (fs *FileStorageImpl) GetRequests(userID string) ([]*types.Request, error) {
-	// Not implemented
-	return nil, nil
+	return fs.requests.ListByUser(userID)
 }
 
 // GetRecentRequests 获取最近的请求记录
 func (fs *FileStorageImpl) GetRecentRequests(limit int) ([]*types.Request, error) {
-	// Not implemented
-	return nil, nil
+	return fs.requests.List(limit)
 }
 
 // GetRequestByID 根据ID获取请求
 func (fs *FileStorageImpl) GetRequestByID(requestID string) (*types.Request, error) {
 	return fs.GetRequest(requestID)
 }
+
+// apiKeyRecord is the on-disk form of an API key; APIKey hides the hash from JSON
+type apiKeyRecord struct {
+	*types.APIKey
+	KeyHash string `json:"key_hash"`
+}
+
+// loadAPIKeys loads API keys from file
+func (fs *FileStorageImpl) loadAPIKeys() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(fs.baseDir, "api_keys.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var records []apiKeyRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return err
+	}
+	for _, record := range records {
+		record.APIKey.KeyHash = record.KeyHash
+		fs.apiKeys[record.ID] = record.APIKey
+	}
+	return nil
+}
+
+// saveAPIKeys saves API keys to file, caller must hold the lock
+func (fs *FileStorageImpl) saveAPIKeys() error {
+	records := make([]apiKeyRecord, 0, len(fs.apiKeys))
+	for _, key := range fs.apiKeys {
+		records = append(records, apiKeyRecord{APIKey: key, KeyHash: key.KeyHash})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(fs.baseDir, "api_keys.json"), data, 0600)
+}
+
+// SaveAPIKey saves an API key
+func (fs *FileStorageImpl) SaveAPIKey(key *types.APIKey) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	keyCopy := *key
+	fs.apiKeys[key.ID] = &keyCopy
+	return fs.saveAPIKeys()
+}
+
+// UpdateAPIKeyLastUsed updates only the last used time of an API key,
+// leaving fields changed concurrently (revocation, rotation) untouched
+func (fs *FileStorageImpl) UpdateAPIKeyLastUsed(id string, lastUsedAt time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	key, exists := fs.apiKeys[id]
+	if !exists {
+		return nil
+	}
+	keyCopy := *key
+	keyCopy.LastUsedAt = &lastUsedAt
+	fs.apiKeys[id] = &keyCopy
+	return fs.saveAPIKeys()
+}
+
+// GetAPIKey retrieves an API key by ID
+func (fs *FileStorageImpl) GetAPIKey(id string) (*types.APIKey, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key, exists := fs.apiKeys[id]
+	if !exists {
+		return nil, nil
+	}
+	keyCopy := *key
+	return &keyCopy, nil
+}
+
+// ListAPIKeys retrieves all API keys
+func (fs *FileStorageImpl) ListAPIKeys() ([]*types.APIKey, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	keys := make([]*types.APIKey, 0, len(fs.apiKeys))
+	for _, key := range fs.apiKeys {
+		keyCopy := *key
+		keys = append(keys, &keyCopy)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// usageKey returns the map key of a usage counter
+func usageKey(subject, period string) string {
+	return period + "|" + subject
+}
+
+// loadUsageCounters loads usage counters from file
+func (fs *FileStorageImpl) loadUsageCounters() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(fs.baseDir, "usage_counters.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var counters []*types.UsageCounter
+	if err := json.Unmarshal(data, &counters); err != nil {
+		return err
+	}
+	for _, counter := range counters {
+		fs.usage[usageKey(counter.Subject, counter.Period)] = counter
+	}
+	return nil
+}
+
+// SaveUsageCounter saves a usage counter
+func (fs *FileStorageImpl) SaveUsageCounter(counter *types.UsageCounter) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	counterCopy := *counter
+	fs.usage[usageKey(counter.Subject, counter.Period)] = &counterCopy
+
+	counters := make([]*types.UsageCounter, 0, len(fs.usage))
+	for _, c := range fs.usage {
+		counters = append(counters, c)
+	}
+	data, err := json.MarshalIndent(counters, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(fs.baseDir, "usage_counters.json"), data, 0644)
+}
+
+// GetUsageCounter retrieves the usage counter of a subject in a period
+func (fs *FileStorageImpl) GetUsageCounter(subject, period string) (*types.UsageCounter, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	counter, exists := fs.usage[usageKey(subject, period)]
+	if !exists {
+		return nil, nil
+	}
+	counterCopy := *counter
+	return &counterCopy, nil
+}
+
+// ListUsageCounters retrieves all usage counters of a period
+func (fs *FileStorageImpl) ListUsageCounters(period string) ([]*types.UsageCounter, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	var counters []*types.UsageCounter
+	for _, counter := range fs.usage {
+		if counter.Period == period {
+			counterCopy := *counter
+			counters = append(counters, &counterCopy)
+		}
+	}
+	return counters, nil
+}
+
+// ApplyRetention applies a retention policy to stored requests
+func (fs *FileStorageImpl) ApplyRetention(policy types.RetentionPolicy, dryRun bool) (*types.RetentionReport, error) {
+	return fs.requests.Retain(policy, dryRun)
+}
+
+// bucketKey returns the map key of a statistics bucket
+func bucketKey(b *types.ModelStatsBucket) string {
+	return b.Model + "|" + b.Granularity + "|" + b.Start.UTC().Format(time.RFC3339)
+}
+
+// loadBuckets loads statistics buckets from file
+func (fs *FileStorageImpl) loadBuckets() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(fs.baseDir, "model_stats_buckets.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var buckets []*types.ModelStatsBucket
+	if err := json.Unmarshal(data, &buckets); err != nil {
+		return err
+	}
+	for _, bucket := range buckets {
+		fs.buckets[bucketKey(bucket)] = bucket
+	}
+	return nil
+}
+
+// saveBuckets saves statistics buckets to file, caller must hold the lock
+func (fs *FileStorageImpl) saveBuckets() error {
+	buckets := make([]*types.ModelStatsBucket, 0, len(fs.buckets))
+	for _, bucket := range fs.buckets {
+		buckets = append(buckets, bucket)
+	}
+
+	data, err := json.Marshal(buckets)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(fs.baseDir, "model_stats_buckets.json"), data, 0644)
+}
+
+// ListRequestSamples retrieves the statistics fields of requests in [from, to)
+func (fs *FileStorageImpl) ListRequestSamples(from, to time.Time) ([]*types.RequestSample, error) {
+	return fs.requests.Samples(from, to), nil
+}
+
+// SaveModelStatsBuckets saves statistics buckets, replacing existing ones
+func (fs *FileStorageImpl) SaveModelStatsBuckets(buckets []*types.ModelStatsBucket) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, bucket := range buckets {
+		bucketCopy := *bucket
+		fs.buckets[bucketKey(bucket)] = &bucketCopy
+	}
+	return fs.saveBuckets()
+}
+
+// ListModelStatsBuckets retrieves the buckets of a model in [from, to) ordered by time
+func (fs *FileStorageImpl) ListModelStatsBuckets(model, granularity string, from, to time.Time) ([]*types.ModelStatsBucket, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	var buckets []*types.ModelStatsBucket
+	for _, bucket := range fs.buckets {
+		if bucket.Model == model && bucket.Granularity == granularity && !bucket.Start.Before(from) && bucket.Start.Before(to) {
+			bucketCopy := *bucket
+			buckets = append(buckets, &bucketCopy)
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Start.Before(buckets[j].Start)
+	})
+	return buckets, nil
+}
+
+// CountErrorsByType counts failed requests by model and error type
+func (fs *FileStorageImpl) CountErrorsByType() (map[string]map[string]int64, error) {
+	return fs.requests.CountErrors(), nil
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// migration is one versioned step of a database schema
+type migration struct {
+	version int
+	name    string
+	up      string
+}
+
+// migrationDialect holds the backend specific statements used by applyMigrations
+type migrationDialect struct {
+	name          string
+	createTable   string // creates schema_version if it does not exist
+	insertVersion string // inserts (version, name, applied_at)
+}
+
+// applyMigrations brings the schema up to the last migration inside tx.
+// It refuses to touch a database whose schema is newer than this build knows about.
+// Migrations must be ordered by version; append new steps and never edit released ones.
+func applyMigrations(tx *sql.Tx, dialect migrationDialect, migrations []migration) error {
+	if _, err := tx.Exec(dialect.createTable); err != nil {
+		return fmt.Errorf("failed to create schema_version table: %v", err)
+	}
+
+	var current int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than supported version %d, refusing to start", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		log.Printf("Applying %s migration %d: %s", dialect.name, m.version, m.name)
+		if _, err := tx.Exec(m.up); err != nil {
+			return fmt.Errorf("migration %d (%s): %v", m.version, m.name, err)
+		}
+		if _, err := tx.Exec(dialect.insertVersion, m.version, m.name, time.Now()); err != nil {
+			return fmt.Errorf("failed to record migration %d: %v", m.version, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,790 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"llm-fw/types"
+
+	_ "github.com/lib/pq"
+)
+
+// postgresMigrationLock is the advisory lock key that serializes migrations across replicas
+const postgresMigrationLock = 7413020801
+
+// postgresDialect holds the PostgreSQL statements used by applyMigrations
+var postgresDialect = migrationDialect{
+	name: "PostgreSQL",
+	createTable: `
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL
+		)
+	`,
+	insertVersion: "INSERT INTO schema_version (version, name, applied_at) VALUES ($1, $2, $3)",
+}
+
+// postgresMigrations lists the PostgreSQL schema migrations in the order they are applied
+var postgresMigrations = []migration{
+	{
+		version: 1,
+		name:    "initial schema",
+		up: `
+			CREATE TABLE requests (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				model TEXT NOT NULL,
+				prompt TEXT NOT NULL,
+				response TEXT NOT NULL,
+				tokens_in INTEGER NOT NULL,
+				tokens_out INTEGER NOT NULL,
+				server TEXT NOT NULL,
+				latency_ms DOUBLE PRECISION NOT NULL,
+				status INTEGER NOT NULL,
+				error TEXT NOT NULL DEFAULT '',
+				timestamp TIMESTAMPTZ NOT NULL,
+				source TEXT NOT NULL
+			);
+			CREATE INDEX idx_requests_timestamp ON requests (timestamp);
+			CREATE INDEX idx_requests_model ON requests (model);
+			CREATE INDEX idx_requests_user_id ON requests (user_id);
+
+			CREATE TABLE model_stats (
+				model TEXT PRIMARY KEY,
+				total_requests BIGINT NOT NULL DEFAULT 0,
+				failed_requests BIGINT NOT NULL DEFAULT 0,
+				total_tokens_in BIGINT NOT NULL DEFAULT 0,
+				total_tokens_out BIGINT NOT NULL DEFAULT 0,
+				average_latency DOUBLE PRECISION NOT NULL DEFAULT 0,
+				last_used TIMESTAMPTZ NOT NULL
+			);
+
+			CREATE TABLE model_stats_history (
+				id TEXT PRIMARY KEY,
+				model TEXT NOT NULL,
+				total_requests BIGINT NOT NULL,
+				failed_requests BIGINT NOT NULL,
+				total_tokens_in BIGINT NOT NULL,
+				total_tokens_out BIGINT NOT NULL,
+				average_latency DOUBLE PRECISION NOT NULL,
+				timestamp TIMESTAMPTZ NOT NULL
+			);
+			CREATE INDEX idx_model_stats_history_model_timestamp ON model_stats_history (model, timestamp);
+
+			CREATE TABLE api_keys (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				user_id TEXT NOT NULL,
+				team TEXT NOT NULL DEFAULT '',
+				prefix TEXT NOT NULL,
+				key_hash TEXT NOT NULL UNIQUE,
+				admin BOOLEAN NOT NULL DEFAULT FALSE,
+				created_at TIMESTAMPTZ NOT NULL,
+				last_used_at TIMESTAMPTZ,
+				revoked_at TIMESTAMPTZ
+			);
+
+			CREATE TABLE usage_counters (
+				subject TEXT NOT NULL,
+				period TEXT NOT NULL,
+				requests BIGINT NOT NULL DEFAULT 0,
+				tokens BIGINT NOT NULL DEFAULT 0,
+				updated_at TIMESTAMPTZ NOT NULL,
+				PRIMARY KEY (subject, period)
+			);
+		`,
+	},
+	{
+		version: 2,
+		name:    "model stats buckets",
+		up: `
+			CREATE TABLE model_stats_buckets (
+				model TEXT NOT NULL,
+				granularity TEXT NOT NULL,
+				bucket_start TIMESTAMPTZ NOT NULL,
+				requests BIGINT NOT NULL,
+				errors BIGINT NOT NULL,
+				tokens_in BIGINT NOT NULL,
+				tokens_out BIGINT NOT NULL,
+				avg_latency_ms DOUBLE PRECISION NOT NULL,
+				p50_latency_ms DOUBLE PRECISION NOT NULL,
+				p90_latency_ms DOUBLE PRECISION NOT NULL,
+				p99_latency_ms DOUBLE PRECISION NOT NULL,
+				error_rate DOUBLE PRECISION NOT NULL,
+				requests_per_second DOUBLE PRECISION NOT NULL,
+				tokens_per_second DOUBLE PRECISION NOT NULL,
+				PRIMARY KEY (model, granularity, bucket_start)
+			);
+		`,
+	},
+	{
+		version: 3,
+		name:    "request error type",
+		up: `
+			ALTER TABLE requests ADD COLUMN error_type TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version: 4,
+		name:    "request alias",
+		up: `
+			ALTER TABLE requests ADD COLUMN alias TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version: 5,
+		name:    "request cache source",
+		up: `
+			ALTER TABLE requests ADD COLUMN cache_source TEXT NOT NULL DEFAULT '';
+			ALTER TABLE requests ADD COLUMN cache_similarity DOUBLE PRECISION NOT NULL DEFAULT 0;
+		`,
+	},
+	{
+		version: 6,
+		name:    "api key priority",
+		up: `
+			ALTER TABLE api_keys ADD COLUMN priority TEXT NOT NULL DEFAULT '';
+		`,
+	},
+}
+
+// PostgresOptions configures the PostgreSQL connection pool
+type PostgresOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// PostgresStorage implements the types.Storage interface using PostgreSQL.
+// Several forwarder instances can share one database.
+type PostgresStorage struct {
+	db *sql.DB
+}
+
+// NewPostgresStorage connects to PostgreSQL and applies pending migrations
+func NewPostgresStorage(dsn string, opts PostgresOptions) (*PostgresStorage, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	if opts.MaxOpenConns <= 0 {
+		opts.MaxOpenConns = 10
+	}
+	if opts.MaxIdleConns <= 0 {
+		opts.MaxIdleConns = 5
+	}
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	db.SetMaxIdleConns(opts.MaxIdleConns)
+	if opts.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+
+	s := &PostgresStorage{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+
+	return s, nil
+}
+
+// migrate applies pending migrations in one transaction, holding an advisory
+// lock so that replicas starting together do not race
+func (s *PostgresStorage) migrate() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", postgresMigrationLock); err != nil {
+		return err
+	}
+
+	if err := applyMigrations(tx, postgresDialect, postgresMigrations); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// requestColumns is the column list used by all request queries
+const requestColumns = "id, user_id, model, prompt, response, tokens_in, tokens_out, server, latency_ms, status, error, error_type, alias, cache_source, cache_similarity, timestamp, source"
+
+// scanRequest scans a requests row selected with requestColumns
+func scanRequest(row interface{ Scan(...interface{}) error }) (*types.Request, error) {
+	var req types.Request
+	err := row.Scan(
+		&req.ID,
+		&req.UserID,
+		&req.Model,
+		&req.Prompt,
+		&req.Response,
+		&req.TokensIn,
+		&req.TokensOut,
+		&req.Server,
+		&req.LatencyMs,
+		&req.Status,
+		&req.Error,
+		&req.ErrorType,
+		&req.Alias,
+		&req.CacheSource,
+		&req.CacheSimilarity,
+		&req.Timestamp,
+		&req.Source,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// queryRequests runs a request query and scans all rows
+func (s *PostgresStorage) queryRequests(query string, args ...interface{}) ([]*types.Request, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []*types.Request
+	for rows.Next() {
+		req, err := scanRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
+// SaveRequest saves a request to the database
+func (s *PostgresStorage) SaveRequest(req *types.Request) error {
+	_, err := s.db.Exec(`
+		INSERT INTO requests (`+requestColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+	`,
+		req.ID,
+		req.UserID,
+		req.Model,
+		req.Prompt,
+		req.Response,
+		req.TokensIn,
+		req.TokensOut,
+		req.Server,
+		req.LatencyMs,
+		req.Status,
+		req.Error,
+		req.ErrorType,
+		req.Alias,
+		req.CacheSource,
+		req.CacheSimilarity,
+		req.Timestamp,
+		req.Source,
+	)
+	return err
+}
+
+// GetRequest retrieves a request by ID
+func (s *PostgresStorage) GetRequest(id string) (*types.Request, error) {
+	req, err := scanRequest(s.db.QueryRow("SELECT "+requestColumns+" FROM requests WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return req, err
+}
+
+// ListRequests retrieves requests with limit
+func (s *PostgresStorage) ListRequests(limit int) ([]*types.Request, error) {
+	return s.queryRequests("SELECT "+requestColumns+" FROM requests ORDER BY timestamp DESC LIMIT $1", limit)
+}
+
+// DeleteRequest deletes a request by ID
+func (s *PostgresStorage) DeleteRequest(id string) error {
+	_, err := s.db.Exec("DELETE FROM requests WHERE id = $1", id)
+	return err
+}
+
+// GetAllRequests retrieves all requests
+func (s *PostgresStorage) GetAllRequests() ([]*types.Request, error) {
+	return s.queryRequests("SELECT " + requestColumns + " FROM requests ORDER BY timestamp DESC")
+}
+
+// GetRequests retrieves all requests for a specific user
+func (s *PostgresStorage) GetRequests(userID string) ([]*types.Request, error) {
+	return s.queryRequests("SELECT "+requestColumns+" FROM requests WHERE user_id = $1 ORDER BY timestamp DESC", userID)
+}
+
+// GetRecentRequests retrieves the most recent requests
+func (s *PostgresStorage) GetRecentRequests(limit int) ([]*types.Request, error) {
+	return s.ListRequests(limit)
+}
+
+// GetRequestByID retrieves a specific request by ID
+func (s *PostgresStorage) GetRequestByID(requestID string) (*types.Request, error) {
+	return s.GetRequest(requestID)
+}
+
+// SaveModelStats saves model statistics to the database
+func (s *PostgresStorage) SaveModelStats(model string, stats *types.ModelStats) error {
+	_, err := s.db.Exec(`
+		INSERT INTO model_stats (
+			model, total_requests, failed_requests, total_tokens_in, total_tokens_out, average_latency, last_used
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (model) DO UPDATE SET
+			total_requests = EXCLUDED.total_requests,
+			failed_requests = EXCLUDED.failed_requests,
+			total_tokens_in = EXCLUDED.total_tokens_in,
+			total_tokens_out = EXCLUDED.total_tokens_out,
+			average_latency = EXCLUDED.average_latency,
+			last_used = EXCLUDED.last_used
+	`,
+		model,
+		stats.TotalRequests,
+		stats.FailedRequests,
+		stats.TotalTokensIn,
+		stats.TotalTokensOut,
+		stats.AverageLatency,
+		stats.LastUsed,
+	)
+	return err
+}
+
+// GetModelStats retrieves model statistics from the database
+func (s *PostgresStorage) GetModelStats(model string) (*types.ModelStats, error) {
+	var stats types.ModelStats
+	err := s.db.QueryRow(`
+		SELECT total_requests, failed_requests, total_tokens_in, total_tokens_out, average_latency, last_used
+		FROM model_stats
+		WHERE model = $1
+	`, model).Scan(
+		&stats.TotalRequests,
+		&stats.FailedRequests,
+		&stats.TotalTokensIn,
+		&stats.TotalTokensOut,
+		&stats.AverageLatency,
+		&stats.LastUsed,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// GetAllModelStats retrieves statistics for all models
+func (s *PostgresStorage) GetAllModelStats() (map[string]*types.ModelStats, error) {
+	rows, err := s.db.Query(`
+		SELECT model, total_requests, failed_requests, total_tokens_in, total_tokens_out, average_latency, last_used
+		FROM model_stats
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]*types.ModelStats)
+	for rows.Next() {
+		var stat types.ModelStats
+		var model string
+		err := rows.Scan(&model, &stat.TotalRequests, &stat.FailedRequests, &stat.TotalTokensIn, &stat.TotalTokensOut, &stat.AverageLatency, &stat.LastUsed)
+		if err != nil {
+			return nil, err
+		}
+		stats[model] = &stat
+	}
+	return stats, rows.Err()
+}
+
+// ListModelStats retrieves all model statistics
+func (s *PostgresStorage) ListModelStats() (map[string]*types.ModelStats, error) {
+	return s.GetAllModelStats()
+}
+
+// DeleteModelStats deletes model statistics
+func (s *PostgresStorage) DeleteModelStats(model string) error {
+	_, err := s.db.Exec("DELETE FROM model_stats WHERE model = $1", model)
+	return err
+}
+
+// SaveModelStatsHistory saves model statistics history
+func (s *PostgresStorage) SaveModelStatsHistory(history *types.ModelStatsHistory) error {
+	_, err := s.db.Exec(`
+		INSERT INTO model_stats_history (
+			id, model, total_requests, failed_requests, total_tokens_in, total_tokens_out, average_latency, timestamp
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`,
+		history.ID,
+		history.Model,
+		history.TotalRequests,
+		history.FailedRequests,
+		history.TotalTokensIn,
+		history.TotalTokensOut,
+		history.AverageLatency,
+		history.Timestamp,
+	)
+	return err
+}
+
+// queryModelStatsHistory runs a model_stats_history query and scans all rows
+func (s *PostgresStorage) queryModelStatsHistory(query string, args ...interface{}) ([]*types.ModelStatsHistory, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []*types.ModelStatsHistory
+	for rows.Next() {
+		var h types.ModelStatsHistory
+		err := rows.Scan(
+			&h.ID,
+			&h.Model,
+			&h.TotalRequests,
+			&h.FailedRequests,
+			&h.TotalTokensIn,
+			&h.TotalTokensOut,
+			&h.AverageLatency,
+			&h.Timestamp,
+		)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, &h)
+	}
+	return history, rows.Err()
+}
+
+// GetModelStatsHistory retrieves model statistics history
+func (s *PostgresStorage) GetModelStatsHistory(model string, limit int) ([]*types.ModelStatsHistory, error) {
+	return s.queryModelStatsHistory(`
+		SELECT id, model, total_requests, failed_requests, total_tokens_in, total_tokens_out, average_latency, timestamp
+		FROM model_stats_history
+		WHERE model = $1
+		ORDER BY timestamp DESC
+		LIMIT $2
+	`, model, limit)
+}
+
+// ListModelStatsHistory retrieves all model statistics history
+func (s *PostgresStorage) ListModelStatsHistory(limit int) ([]*types.ModelStatsHistory, error) {
+	return s.queryModelStatsHistory(`
+		SELECT id, model, total_requests, failed_requests, total_tokens_in, total_tokens_out, average_latency, timestamp
+		FROM model_stats_history
+		ORDER BY timestamp DESC
+		LIMIT $1
+	`, limit)
+}
+
+// DeleteModelStatsHistory deletes model statistics history
+func (s *PostgresStorage) DeleteModelStatsHistory(model string) error {
+	_, err := s.db.Exec("DELETE FROM model_stats_history WHERE model = $1", model)
+	return err
+}
+
+// Cleanup removes old data
+func (s *PostgresStorage) Cleanup() error {
+	_, err := s.db.Exec(`
+		DELETE FROM model_stats_history
+		WHERE timestamp < NOW() - INTERVAL '30 days'
+	`)
+	if err != nil {
+		return err
+	}
+
+	for granularity, retention := range bucketRetention {
+		_, err := s.db.Exec(
+			"DELETE FROM model_stats_buckets WHERE granularity = $1 AND bucket_start < $2",
+			granularity, time.Now().Add(-retention),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewHistoryManager creates a new history manager
+func (s *PostgresStorage) NewHistoryManager(size int) types.HistoryManager {
+	return NewHistoryManager(s, size)
+}
+
+// Close closes the connection pool
+func (s *PostgresStorage) Close() error {
+	return s.db.Close()
+}
+
+// SaveAPIKey saves an API key, replacing an existing key with the same ID
+func (s *PostgresStorage) SaveAPIKey(key *types.APIKey) error {
+	_, err := s.db.Exec(`
+		INSERT INTO api_keys (
+			id, name, user_id, team, prefix, key_hash, admin, priority, created_at, last_used_at, revoked_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name,
+			user_id = EXCLUDED.user_id,
+			team = EXCLUDED.team,
+			prefix = EXCLUDED.prefix,
+			key_hash = EXCLUDED.key_hash,
+			admin = EXCLUDED.admin,
+			priority = EXCLUDED.priority,
+			created_at = EXCLUDED.created_at,
+			last_used_at = EXCLUDED.last_used_at,
+			revoked_at = EXCLUDED.revoked_at
+	`,
+		key.ID,
+		key.Name,
+		key.UserID,
+		key.Team,
+		key.Prefix,
+		key.KeyHash,
+		key.Admin,
+		key.Priority,
+		key.CreatedAt,
+		key.LastUsedAt,
+		key.RevokedAt,
+	)
+	return err
+}
+
+// UpdateAPIKeyLastUsed updates only the last used time of an API key
+func (s *PostgresStorage) UpdateAPIKeyLastUsed(id string, lastUsedAt time.Time) error {
+	_, err := s.db.Exec(`UPDATE api_keys SET last_used_at = $1 WHERE id = $2`, lastUsedAt, id)
+	return err
+}
+
+// GetAPIKey retrieves an API key by ID
+func (s *PostgresStorage) GetAPIKey(id string) (*types.APIKey, error) {
+	row := s.db.QueryRow(`
+		SELECT id, name, user_id, team, prefix, key_hash, admin, priority, created_at, last_used_at, revoked_at
+		FROM api_keys
+		WHERE id = $1
+	`, id)
+	key, err := scanAPIKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+// ListAPIKeys retrieves all API keys
+func (s *PostgresStorage) ListAPIKeys() ([]*types.APIKey, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, user_id, team, prefix, key_hash, admin, priority, created_at, last_used_at, revoked_at
+		FROM api_keys
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*types.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// SaveUsageCounter saves a usage counter, replacing the existing value
+func (s *PostgresStorage) SaveUsageCounter(counter *types.UsageCounter) error {
+	_, err := s.db.Exec(`
+		INSERT INTO usage_counters (
+			subject, period, requests, tokens, updated_at
+		) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (subject, period) DO UPDATE SET
+			requests = EXCLUDED.requests,
+			tokens = EXCLUDED.tokens,
+			updated_at = EXCLUDED.updated_at
+	`,
+		counter.Subject,
+		counter.Period,
+		counter.Requests,
+		counter.Tokens,
+		counter.UpdatedAt,
+	)
+	return err
+}
+
+// GetUsageCounter retrieves the usage counter of a subject in a period
+func (s *PostgresStorage) GetUsageCounter(subject, period string) (*types.UsageCounter, error) {
+	var counter types.UsageCounter
+	err := s.db.QueryRow(`
+		SELECT subject, period, requests, tokens, updated_at
+		FROM usage_counters
+		WHERE subject = $1 AND period = $2
+	`, subject, period).Scan(
+		&counter.Subject,
+		&counter.Period,
+		&counter.Requests,
+		&counter.Tokens,
+		&counter.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &counter, nil
+}
+
+// ListUsageCounters retrieves all usage counters of a period
+func (s *PostgresStorage) ListUsageCounters(period string) ([]*types.UsageCounter, error) {
+	rows, err := s.db.Query(`
+		SELECT subject, period, requests, tokens, updated_at
+		FROM usage_counters
+		WHERE period = $1
+	`, period)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counters []*types.UsageCounter
+	for rows.Next() {
+		var counter types.UsageCounter
+		err := rows.Scan(
+			&counter.Subject,
+			&counter.Period,
+			&counter.Requests,
+			&counter.Tokens,
+			&counter.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		counters = append(counters, &counter)
+	}
+	return counters, rows.Err()
+}
+
+// postgresRetention measures the live rows of the requests table. The relation size
+// only shrinks after VACUUM FULL, so it would keep every run deleting more rows
+var postgresRetention = retentionDialect{
+	bind: bindDollar,
+	sizeBytes: func(tx *sql.Tx) (int64, error) {
+		var size int64
+		err := tx.QueryRow("SELECT COALESCE(SUM(pg_column_size(requests.*)), 0) FROM requests").Scan(&size)
+		return size, err
+	},
+}
+
+// ApplyRetention applies a retention policy to stored requests
+func (s *PostgresStorage) ApplyRetention(policy types.RetentionPolicy, dryRun bool) (*types.RetentionReport, error) {
+	return applySQLRetention(s.db, postgresRetention, policy, dryRun)
+}
+
+// ListRequestSamples retrieves the statistics fields of requests in [from, to)
+func (s *PostgresStorage) ListRequestSamples(from, to time.Time) ([]*types.RequestSample, error) {
+	rows, err := s.db.Query(`
+		SELECT model, timestamp, latency_ms, tokens_in, tokens_out, status
+		FROM requests
+		WHERE timestamp >= $1 AND timestamp < $2
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []*types.RequestSample
+	for rows.Next() {
+		var sample types.RequestSample
+		err := rows.Scan(
+			&sample.Model,
+			&sample.Timestamp,
+			&sample.LatencyMs,
+			&sample.TokensIn,
+			&sample.TokensOut,
+			&sample.Status,
+		)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, &sample)
+	}
+	return samples, rows.Err()
+}
+
+// SaveModelStatsBuckets saves statistics buckets, replacing existing ones
+func (s *PostgresStorage) SaveModelStatsBuckets(buckets []*types.ModelStatsBucket) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO model_stats_buckets (` + bucketColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (model, granularity, bucket_start) DO UPDATE SET
+			requests = EXCLUDED.requests,
+			errors = EXCLUDED.errors,
+			tokens_in = EXCLUDED.tokens_in,
+			tokens_out = EXCLUDED.tokens_out,
+			avg_latency_ms = EXCLUDED.avg_latency_ms,
+			p50_latency_ms = EXCLUDED.p50_latency_ms,
+			p90_latency_ms = EXCLUDED.p90_latency_ms,
+			p99_latency_ms = EXCLUDED.p99_latency_ms,
+			error_rate = EXCLUDED.error_rate,
+			requests_per_second = EXCLUDED.requests_per_second,
+			tokens_per_second = EXCLUDED.tokens_per_second
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, bucket := range buckets {
+		if _, err := stmt.Exec(bucketArgs(bucket)...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListModelStatsBuckets retrieves the buckets of a model in [from, to) ordered by time
+func (s *PostgresStorage) ListModelStatsBuckets(model, granularity string, from, to time.Time) ([]*types.ModelStatsBucket, error) {
+	rows, err := s.db.Query(`
+		SELECT `+bucketColumns+`
+		FROM model_stats_buckets
+		WHERE model = $1 AND granularity = $2 AND bucket_start >= $3 AND bucket_start < $4
+		ORDER BY bucket_start ASC
+	`, model, granularity, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []*types.ModelStatsBucket
+	for rows.Next() {
+		bucket, err := scanModelStatsBucket(rows)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
+
+// CountErrorsByType counts failed requests by model and error type
+func (s *PostgresStorage) CountErrorsByType() (map[string]map[string]int64, error) {
+	return countSQLErrors(s.db)
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postgresTestDSN 是 PostgreSQL 存储测试使用的连接串环境变量，未设置时跳过这些测试。
+// 每个测试在独立的 schema 中运行，结束后删除该 schema
+const postgresTestDSN = "LLM_FW_TEST_POSTGRES_DSN"
+
+// withSearchPath 返回把 search_path 设置为 schema 的连接串，支持 URL 和 key=value 两种格式
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err == nil {
+			q := u.Query()
+			q.Set("search_path", schema)
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+	}
+	return dsn + " search_path=" + schema
+}
+
+// newTestPostgres 在新建的 schema 中打开 PostgreSQL 存储，返回存储和它使用的连接串
+func newTestPostgres(t *testing.T) (*PostgresStorage, string) {
+	t.Helper()
+
+	dsn := os.Getenv(postgresTestDSN)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresTestDSN)
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("llm_fw_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	dsn = withSearchPath(dsn, schema)
+	s, err := NewPostgresStorage(dsn, PostgresOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, dsn
+}
+
+func TestPostgresMigrations(t *testing.T) {
+	s, dsn := newTestPostgres(t)
+
+	latest := postgresMigrations[len(postgresMigrations)-1].version
+	var count, version int
+	if err := s.db.QueryRow("SELECT COUNT(*), MAX(version) FROM schema_version").Scan(&count, &version); err != nil {
+		t.Fatal(err)
+	}
+	if count != len(postgresMigrations) || version != latest {
+		t.Fatalf("schema_version has %d rows up to %d, want %d up to %d", count, version, len(postgresMigrations), latest)
+	}
+
+	// 再次打开时没有需要执行的迁移
+	again, err := NewPostgresStorage(dsn, PostgresOptions{})
+	if err != nil {
+		t.Fatalf("reopening a migrated database: %v", err)
+	}
+	again.Close()
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&count); err != nil || count != len(postgresMigrations) {
+		t.Fatalf("schema_version has %d rows after reopening: %v", count, err)
+	}
+
+	// 拒绝打开由更新版本迁移过的数据库
+	if _, err := s.db.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES ($1, 'future', NOW())", latest+1); err != nil {
+		t.Fatal(err)
+	}
+	if newer, err := NewPostgresStorage(dsn, PostgresOptions{}); err == nil {
+		newer.Close()
+		t.Fatal("opened a database with a newer schema version")
+	}
+}
+
+func TestPostgresRequests(t *testing.T) {
+	s, _ := newTestPostgres(t)
+	testRequestRoundTrip(t, s)
+}
+
+func TestPostgresModelStats(t *testing.T) {
+	s, _ := newTestPostgres(t)
+	testModelStatsRoundTrip(t, s)
+}
+
+func TestPostgresModelStatsBuckets(t *testing.T) {
+	s, _ := newTestPostgres(t)
+	testModelStatsBucketsRoundTrip(t, s)
+}
+
+func TestPostgresAPIKeyLastUsed(t *testing.T) {
+	s, _ := newTestPostgres(t)
+	testAPIKeyLastUsed(t, s)
+}
@@ -0,0 +1,614 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"llm-fw/types"
+)
+
+// DefaultSegmentSize is the size at which the request log rotates to a new segment
+const DefaultSegmentSize = 32 << 20
+
+const (
+	segmentPrefix = "requests-"
+	segmentSuffix = ".jsonl"
+)
+
+// logEntry locates one request inside a segment file and keeps the fields
+// needed for filtering and statistics so they can be served without reading it
+type logEntry struct {
+	ID        string
+	UserID    string
+	Timestamp time.Time
+	sample    types.RequestSample
+	errorType string
+	hasBody   bool // prompt or response is not empty
+	segment   string
+	offset    int64
+	length    int64
+}
+
+// newLogEntry creates the index entry of a request stored at offset in segment
+func newLogEntry(req *types.Request, segment string, offset, length int64) *logEntry {
+	return &logEntry{
+		ID:        req.ID,
+		UserID:    req.UserID,
+		Timestamp: req.Timestamp,
+		sample: types.RequestSample{
+			Model:     req.Model,
+			Timestamp: req.Timestamp,
+			LatencyMs: req.LatencyMs,
+			TokensIn:  req.TokensIn,
+			TokensOut: req.TokensOut,
+			Status:    req.Status,
+		},
+		errorType: req.ErrorType,
+		hasBody:   req.Prompt != "" || req.Response != "",
+		segment:   segment,
+		offset:    offset,
+		length:    length,
+	}
+}
+
+// requestLog is an append-only JSONL request log split into segments by day and size.
+// All records are indexed in memory by ID and user; deleting a record compacts its segment.
+type requestLog struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.RWMutex
+	entries map[string]*logEntry
+	order   []*logEntry // in append order
+	byUser  map[string][]*logEntry
+
+	active     *os.File
+	activeName string
+	activeSize int64
+	activeDay  string
+}
+
+// openRequestLog opens the request log in dir, rebuilding the index from existing segments
+func openRequestLog(dir string, maxSize int64) (*requestLog, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create request log directory: %v", err)
+	}
+
+	l := &requestLog{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: make(map[string]*logEntry),
+		byUser:  make(map[string][]*logEntry),
+	}
+
+	names, err := l.segmentNames()
+	if err != nil {
+		return nil, err
+	}
+	var end int64
+	for _, name := range names {
+		if end, err = l.loadSegment(name); err != nil {
+			return nil, fmt.Errorf("failed to load segment %s: %v", name, err)
+		}
+	}
+
+	// Keep appending to the last segment if it is from today and not full,
+	// dropping a torn last line first so new records start on a line of their own
+	if len(names) > 0 {
+		last := names[len(names)-1]
+		path := filepath.Join(dir, last)
+		info, err := os.Stat(path)
+		if err == nil && segmentDay(last) == time.Now().Format("20060102") && end < maxSize {
+			if info.Size() > end {
+				if err := os.Truncate(path, end); err != nil {
+					return nil, fmt.Errorf("failed to truncate segment %s: %v", last, err)
+				}
+			}
+			if err := l.openActive(last); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return l, nil
+}
+
+// segmentNames returns all segment files sorted chronologically
+func (l *requestLog) segmentNames() ([]string, error) {
+	files, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, f := range files {
+		name := f.Name()
+		if !f.IsDir() && strings.HasPrefix(name, segmentPrefix) && strings.HasSuffix(name, segmentSuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// segmentDay returns the YYYYMMDD day part of a segment name
+func segmentDay(name string) string {
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), "-")
+	return parts[0]
+}
+
+// loadSegment indexes every record of a segment and returns the offset just past
+// its last complete line; a torn last line is skipped
+func (l *requestLog) loadSegment(name string) (int64, error) {
+	f, err := os.Open(filepath.Join(l.dir, name))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("Skipping torn record in %s at offset %d", name, offset)
+			}
+			return offset, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		length := int64(len(line))
+		var req types.Request
+		if jsonErr := json.Unmarshal(bytes.TrimSpace(line), &req); jsonErr != nil || req.ID == "" {
+			log.Printf("Skipping invalid record in %s at offset %d", name, offset)
+		} else {
+			l.index(newLogEntry(&req, name, offset, length))
+		}
+		offset += length
+	}
+}
+
+// index adds an entry to the in-memory indexes, caller must hold the lock
+func (l *requestLog) index(e *logEntry) {
+	if old, exists := l.entries[e.ID]; exists {
+		l.unindex(old)
+	}
+	l.entries[e.ID] = e
+	l.order = append(l.order, e)
+	l.byUser[e.UserID] = append(l.byUser[e.UserID], e)
+}
+
+// unindex removes an entry from the in-memory indexes, caller must hold the lock
+func (l *requestLog) unindex(e *logEntry) {
+	delete(l.entries, e.ID)
+	l.order = removeEntry(l.order, e)
+	l.byUser[e.UserID] = removeEntry(l.byUser[e.UserID], e)
+	if len(l.byUser[e.UserID]) == 0 {
+		delete(l.byUser, e.UserID)
+	}
+}
+
+// removeEntry removes e from entries keeping the order
+func removeEntry(entries []*logEntry, e *logEntry) []*logEntry {
+	for i, entry := range entries {
+		if entry == e {
+			return append(entries[:i], entries[i+1:]...)
+		}
+	}
+	return entries
+}
+
+// openActive opens a segment for appending, caller must hold the lock
+func (l *requestLog) openActive(name string) error {
+	f, err := os.OpenFile(filepath.Join(l.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if l.active != nil {
+		l.active.Close()
+	}
+	l.active = f
+	l.activeName = name
+	l.activeSize = info.Size()
+	l.activeDay = segmentDay(name)
+	return nil
+}
+
+// rotate starts a new segment when the day changes or the active segment is full, caller must hold the lock
+func (l *requestLog) rotate(now time.Time, size int64) error {
+	day := now.Format("20060102")
+	if l.active != nil && l.activeDay == day && l.activeSize+size <= l.maxSize {
+		return nil
+	}
+
+	// Continue after the highest sequence of the day, earlier segments may have been removed
+	seq := 0
+	names, err := l.segmentNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if segmentDay(name) != day {
+			continue
+		}
+		var n int
+		if _, err := fmt.Sscanf(name, segmentPrefix+day+"-%04d"+segmentSuffix, &n); err == nil && n >= seq {
+			seq = n + 1
+		}
+	}
+	return l.openActive(fmt.Sprintf("%s%s-%04d%s", segmentPrefix, day, seq, segmentSuffix))
+}
+
+// Append writes a request to the active segment
+func (l *requestLog) Append(req *types.Request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.rotate(time.Now(), int64(len(data))); err != nil {
+		return fmt.Errorf("failed to rotate request log: %v", err)
+	}
+	if _, err := l.active.Write(data); err != nil {
+		return err
+	}
+
+	l.index(newLogEntry(req, l.activeName, l.activeSize, int64(len(data))))
+	l.activeSize += int64(len(data))
+	return nil
+}
+
+// read loads the records of entries, caller must hold the read lock
+func (l *requestLog) read(entries []*logEntry) ([]*types.Request, error) {
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	requests := make([]*types.Request, 0, len(entries))
+	for _, e := range entries {
+		f, ok := files[e.segment]
+		if !ok {
+			var err error
+			f, err = os.Open(filepath.Join(l.dir, e.segment))
+			if err != nil {
+				return nil, err
+			}
+			files[e.segment] = f
+		}
+
+		buf := make([]byte, e.length)
+		if _, err := f.ReadAt(buf, e.offset); err != nil {
+			return nil, fmt.Errorf("failed to read request %s: %v", e.ID, err)
+		}
+		var req types.Request
+		if err := json.Unmarshal(bytes.TrimSpace(buf), &req); err != nil {
+			return nil, fmt.Errorf("failed to decode request %s: %v", e.ID, err)
+		}
+		requests = append(requests, &req)
+	}
+	return requests, nil
+}
+
+// newestFirst returns up to limit entries ordered by timestamp descending; limit <= 0 returns all
+func newestFirst(entries []*logEntry, limit int) []*logEntry {
+	sorted := make([]*logEntry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.After(sorted[j].Timestamp)
+	})
+	if limit > 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
+
+// Get returns a request by ID, or nil if it does not exist
+func (l *requestLog) Get(id string) (*types.Request, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	e, exists := l.entries[id]
+	if !exists {
+		return nil, nil
+	}
+	requests, err := l.read([]*logEntry{e})
+	if err != nil {
+		return nil, err
+	}
+	return requests[0], nil
+}
+
+// List returns the most recent requests, limit <= 0 returns all
+func (l *requestLog) List(limit int) ([]*types.Request, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.read(newestFirst(l.order, limit))
+}
+
+// ListByUser returns all requests of a user, newest first
+func (l *requestLog) ListByUser(userID string) ([]*types.Request, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.read(newestFirst(l.byUser[userID], 0))
+}
+
+// Samples returns the statistics fields of requests in [from, to)
+func (l *requestLog) Samples(from, to time.Time) []*types.RequestSample {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var samples []*types.RequestSample
+	for _, e := range l.order {
+		if !e.Timestamp.Before(from) && e.Timestamp.Before(to) {
+			sample := e.sample
+			samples = append(samples, &sample)
+		}
+	}
+	return samples
+}
+
+// CountErrors counts failed requests by model and error type
+func (l *requestLog) CountErrors() map[string]map[string]int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	counts := make(map[string]map[string]int64)
+	for _, e := range l.order {
+		if e.sample.Status == 0 {
+			continue
+		}
+		if counts[e.sample.Model] == nil {
+			counts[e.sample.Model] = make(map[string]int64)
+		}
+		counts[e.sample.Model][errorTypeOrInternal(e.errorType)]++
+	}
+	return counts
+}
+
+// Delete removes a request and compacts the segment that contained it
+func (l *requestLog) Delete(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, exists := l.entries[id]
+	if !exists {
+		return nil
+	}
+	l.unindex(e)
+	return l.rewrite(e.segment, nil)
+}
+
+// rewrite compacts a segment keeping only indexed records and clearing the
+// prompt and response of entries in strip, caller must hold the lock
+func (l *requestLog) rewrite(segment string, strip map[*logEntry]bool) error {
+	path := filepath.Join(l.dir, segment)
+
+	var live []*logEntry
+	for _, e := range l.order {
+		if e.segment == segment {
+			live = append(live, e)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].offset < live[j].offset
+	})
+
+	// Inactive segments without live records are removed entirely
+	if len(live) == 0 && segment != l.activeName {
+		return os.Remove(path)
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpPath := path + ".tmp"
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	offsets := make([]int64, len(live))
+	lengths := make([]int64, len(live))
+	var offset int64
+	for i, e := range live {
+		buf := make([]byte, e.length)
+		if _, err := src.ReadAt(buf, e.offset); err != nil {
+			dst.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		if strip[e] {
+			if buf, err = stripBody(buf); err != nil {
+				dst.Close()
+				os.Remove(tmpPath)
+				return err
+			}
+		}
+		if _, err := dst.Write(buf); err != nil {
+			dst.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		offsets[i] = offset
+		lengths[i] = int64(len(buf))
+		offset += lengths[i]
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	for i, e := range live {
+		e.offset = offsets[i]
+		e.length = lengths[i]
+		if strip[e] {
+			e.hasBody = false
+		}
+	}
+
+	// The active segment was replaced, reopen the append handle
+	if segment == l.activeName {
+		return l.openActive(segment)
+	}
+	return nil
+}
+
+// stripBody clears the prompt and response of an encoded record
+func stripBody(line []byte) ([]byte, error) {
+	var req types.Request
+	if err := json.Unmarshal(bytes.TrimSpace(line), &req); err != nil {
+		return nil, err
+	}
+	req.Prompt = ""
+	req.Response = ""
+	data, err := json.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Retain applies a retention policy, see applySQLRetention for the order of the rules.
+// Affected segments are rewritten once no matter how many of their records change.
+func (l *requestLog) Retain(policy types.RetentionPolicy, dryRun bool) (*types.RetentionReport, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	report := &types.RetentionReport{DryRun: dryRun, RunAt: now}
+
+	// Oldest first, so every deletion rule removes a prefix
+	entries := newestFirst(l.order, 0)
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	report.TotalRequests = int64(len(entries))
+	for _, e := range entries {
+		report.SizeBytes += e.length
+	}
+
+	size := report.SizeBytes
+	n := 0
+	if policy.MaxAge > 0 {
+		cutoff := now.Add(-policy.MaxAge)
+		for n < len(entries) && entries[n].Timestamp.Before(cutoff) {
+			size -= entries[n].length
+			report.DeletedByAge++
+			n++
+		}
+	}
+	for policy.MaxRows > 0 && len(entries)-n > policy.MaxRows {
+		size -= entries[n].length
+		report.DeletedByRows++
+		n++
+	}
+	for policy.MaxSizeBytes > 0 && size > policy.MaxSizeBytes && n < len(entries) {
+		size -= entries[n].length
+		report.DeletedBySize++
+		n++
+	}
+	removed, kept := entries[:n], entries[n:]
+	report.RemainingRequests = int64(len(kept))
+
+	strip := make(map[*logEntry]bool)
+	if policy.BodyMaxAge > 0 {
+		cutoff := now.Add(-policy.BodyMaxAge)
+		for _, e := range kept {
+			if e.hasBody && e.Timestamp.Before(cutoff) {
+				strip[e] = true
+			}
+		}
+	}
+	report.BodiesStripped = int64(len(strip))
+
+	if dryRun || (len(removed) == 0 && len(strip) == 0) {
+		return report, nil
+	}
+
+	segments := make(map[string]bool)
+	deleted := make(map[*logEntry]bool, len(removed))
+	for _, e := range removed {
+		deleted[e] = true
+		segments[e.segment] = true
+	}
+	for e := range strip {
+		segments[e.segment] = true
+	}
+
+	// Rebuild the indexes from the kept entries instead of removing entries one by one
+	l.entries = make(map[string]*logEntry, len(kept))
+	l.byUser = make(map[string][]*logEntry)
+	order := make([]*logEntry, 0, len(kept))
+	for _, e := range l.order {
+		if !deleted[e] {
+			l.entries[e.ID] = e
+			l.byUser[e.UserID] = append(l.byUser[e.UserID], e)
+			order = append(order, e)
+		}
+	}
+	l.order = order
+
+	for segment := range segments {
+		if err := l.rewrite(segment, strip); err != nil {
+			return nil, fmt.Errorf("failed to rewrite segment %s: %v", segment, err)
+		}
+	}
+	return report, nil
+}
+
+// Close closes the active segment
+func (l *requestLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active == nil {
+		return nil
+	}
+	err := l.active.Close()
+	l.active = nil
+	l.activeName = ""
+	return err
+}
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"llm-fw/types"
+)
+
+// testRequest 创建一条时间戳为 ts 的请求记录
+func testRequest(id string, ts time.Time) *types.Request {
+	return &types.Request{
+		ID:        id,
+		UserID:    "alice",
+		Model:     "llama3",
+		Prompt:    "prompt of " + id,
+		Response:  "response of " + id,
+		Timestamp: ts,
+		LatencyMs: 100,
+		TokensIn:  10,
+		TokensOut: 20,
+	}
+}
+
+// readLines 返回文件中的所有行
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// appendRequests 向请求日志依次追加 n 条记录，第 i 条的时间戳为 base 之后 i 秒
+func appendRequests(t *testing.T, l *requestLog, n int, base time.Time) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := l.Append(testRequest(fmt.Sprintf("req-%d", i), base.Add(time.Duration(i)*time.Second))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRequestLogRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	l, err := openRequestLog(dir, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	appendRequests(t, l, 6, time.Now())
+
+	names, err := l.segmentNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) < 2 {
+		t.Fatalf("expected the log to rotate into several segments, got %v", names)
+	}
+	for i, name := range names {
+		want := fmt.Sprintf("%s%s-%04d%s", segmentPrefix, time.Now().Format("20060102"), i, segmentSuffix)
+		if name != want {
+			t.Fatalf("segment %d is %s, want %s", i, name, want)
+		}
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 400 {
+			t.Fatalf("segment %s is %d bytes, larger than the segment size", name, info.Size())
+		}
+	}
+
+	requests, err := l.List(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 6 || requests[0].ID != "req-5" || requests[5].ID != "req-0" {
+		t.Fatalf("unexpected requests across segments: %d", len(requests))
+	}
+}
+
+func TestRequestLogDeleteCompactsSegment(t *testing.T) {
+	dir := t.TempDir()
+	l, err := openRequestLog(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	appendRequests(t, l, 3, time.Now())
+	if err := l.Delete("req-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, filepath.Join(dir, l.activeName))
+	if len(lines) != 2 || !strings.Contains(lines[0], "req-0") || !strings.Contains(lines[1], "req-2") {
+		t.Fatalf("segment was not compacted: %q", lines)
+	}
+
+	// 压缩后的偏移量仍然指向正确的记录，追加继续写入同一个分段
+	if req, err := l.Get("req-2"); err != nil || req == nil || req.Response != "response of req-2" {
+		t.Fatalf("read after compaction: %+v, %v", req, err)
+	}
+	if req, _ := l.Get("req-1"); req != nil {
+		t.Fatal("deleted request is still readable")
+	}
+	if err := l.Append(testRequest("req-3", time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	if lines := readLines(t, filepath.Join(dir, l.activeName)); len(lines) != 3 {
+		t.Fatalf("expected 3 records after appending, got %d", len(lines))
+	}
+}
+
+func TestRequestLogDeleteRemovesEmptySegment(t *testing.T) {
+	dir := t.TempDir()
+	l, err := openRequestLog(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// 昨天的分段不是当前分段，删除最后一条记录后整个文件被删除
+	yesterday := time.Now().AddDate(0, 0, -1)
+	old := fmt.Sprintf("%s%s-0000%s", segmentPrefix, yesterday.Format("20060102"), segmentSuffix)
+	l.index(newLogEntry(testRequest("old", yesterday), old, 0, 0))
+	if err := os.WriteFile(filepath.Join(dir, old), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Delete("old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, old)); !os.IsNotExist(err) {
+		t.Fatalf("empty inactive segment was not removed: %v", err)
+	}
+}
+
+func TestRequestLogReopen(t *testing.T) {
+	dir := t.TempDir()
+	l, err := openRequestLog(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendRequests(t, l, 3, time.Now())
+	if err := l.Delete("req-0"); err != nil {
+		t.Fatal(err)
+	}
+	segment := l.activeName
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟写入一半时进程退出留下的不完整的行
+	f, err := os.OpenFile(filepath.Join(dir, segment), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":"torn","model":"lla`)
+	f.Close()
+
+	l, err = openRequestLog(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if l.activeName != segment {
+		t.Fatalf("reopened log appends to %q, want today's segment %q", l.activeName, segment)
+	}
+	requests, err := l.List(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || requests[0].ID != "req-2" || requests[1].ID != "req-1" {
+		t.Fatalf("unexpected requests after reopening: %d", len(requests))
+	}
+	if byUser, _ := l.ListByUser("alice"); len(byUser) != 2 {
+		t.Fatalf("user index was not rebuilt: %d requests", len(byUser))
+	}
+	if samples := l.Samples(time.Time{}, time.Now().Add(time.Minute)); len(samples) != 2 || samples[0].TokensOut != 20 {
+		t.Fatalf("unexpected samples after reopening: %d", len(samples))
+	}
+
+	// 新记录不会接在不完整的行后面
+	if err := l.Append(testRequest("req-3", time.Now().Add(time.Minute))); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	l, err = openRequestLog(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if req, err := l.Get("req-3"); err != nil || req == nil {
+		t.Fatalf("record appended after a torn line was lost: %v", err)
+	}
+}
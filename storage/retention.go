@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"llm-fw/types"
+)
+
+// retentionDialect holds the backend specific parts of applySQLRetention
+type retentionDialect struct {
+	// bind rewrites ? placeholders for the backend
+	bind func(query string) string
+	// sizeBytes returns the space used by the requests table
+	sizeBytes func(tx *sql.Tx) (int64, error)
+}
+
+// bindQuestion keeps ? placeholders (SQLite)
+func bindQuestion(query string) string {
+	return query
+}
+
+// bindDollar rewrites ? placeholders to $1, $2, ... (PostgreSQL)
+func bindDollar(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// applySQLRetention applies a retention policy to the requests table inside one transaction.
+// Deletions run oldest first in the order age, rows, size; bodies are stripped from what remains.
+// The affected rows are counted with SELECT COUNT(*) using the same predicates as the
+// statements, so a dry run reports what a real run would change without writing anything.
+func applySQLRetention(db *sql.DB, dialect retentionDialect, policy types.RetentionPolicy, dryRun bool) (*types.RetentionReport, error) {
+	now := time.Now()
+	report := &types.RetentionReport{DryRun: dryRun, RunAt: now}
+	ageCutoff := now.Add(-policy.MaxAge).Round(0)
+	bodyCutoff := now.Add(-policy.BodyMaxAge).Round(0)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	count := func(query string, args ...interface{}) (int64, error) {
+		var n int64
+		err := tx.QueryRow(dialect.bind(query), args...).Scan(&n)
+		return n, err
+	}
+	exec := func(query string, args ...interface{}) error {
+		_, err := tx.Exec(dialect.bind(query), args...)
+		return err
+	}
+
+	if report.TotalRequests, err = count("SELECT COUNT(*) FROM requests"); err != nil {
+		return nil, err
+	}
+	if report.SizeBytes, err = dialect.sizeBytes(tx); err != nil {
+		return nil, fmt.Errorf("failed to measure requests table: %v", err)
+	}
+
+	remaining := report.TotalRequests
+	if policy.MaxAge > 0 {
+		if report.DeletedByAge, err = count("SELECT COUNT(*) FROM requests WHERE timestamp < ?", ageCutoff); err != nil {
+			return nil, fmt.Errorf("failed to count expired requests: %v", err)
+		}
+		remaining -= report.DeletedByAge
+	}
+
+	if policy.MaxRows > 0 && remaining > int64(policy.MaxRows) {
+		report.DeletedByRows = remaining - int64(policy.MaxRows)
+		remaining -= report.DeletedByRows
+	}
+
+	// 表空间在删除后不会立即收缩，按平均行大小估算需要删除的行数
+	if policy.MaxSizeBytes > 0 && report.TotalRequests > 0 {
+		bytesPerRow := float64(report.SizeBytes) / float64(report.TotalRequests)
+		estimated := int64(float64(remaining) * bytesPerRow)
+		if estimated > policy.MaxSizeBytes {
+			report.DeletedBySize = int64(float64(estimated-policy.MaxSizeBytes)/bytesPerRow) + 1
+			if report.DeletedBySize > remaining {
+				report.DeletedBySize = remaining
+			}
+			remaining -= report.DeletedBySize
+		}
+	}
+	report.RemainingRequests = remaining
+
+	// 按行数和空间删除的都是最旧的记录，保留下来的是最新的 remaining 条
+	if policy.BodyMaxAge > 0 && remaining > 0 {
+		report.BodiesStripped, err = count(`
+			SELECT COUNT(*) FROM (
+				SELECT timestamp, prompt, response FROM requests ORDER BY timestamp DESC, id DESC LIMIT ?
+			) kept
+			WHERE timestamp < ? AND (prompt <> '' OR response <> '')
+		`, remaining, bodyCutoff)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count request bodies to strip: %v", err)
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	if report.DeletedByAge > 0 {
+		if err := exec("DELETE FROM requests WHERE timestamp < ?", ageCutoff); err != nil {
+			return nil, fmt.Errorf("failed to delete expired requests: %v", err)
+		}
+	}
+	if excess := report.DeletedByRows + report.DeletedBySize; excess > 0 {
+		err := exec("DELETE FROM requests WHERE id IN (SELECT id FROM requests ORDER BY timestamp ASC, id ASC LIMIT ?)", excess)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete excess requests: %v", err)
+		}
+	}
+	if report.BodiesStripped > 0 {
+		err := exec("UPDATE requests SET prompt = '', response = '' WHERE timestamp < ? AND (prompt <> '' OR response <> '')", bodyCutoff)
+		if err != nil {
+			return nil, fmt.Errorf("failed to strip request bodies: %v", err)
+		}
+	}
+	return report, tx.Commit()
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"llm-fw/types"
+)
+
+// seedRetention 保存 n 条请求记录，第 i 条（从 1 开始）是 i 小时前的请求
+func seedRetention(t *testing.T, s types.Storage, n int) {
+	t.Helper()
+	now := time.Now()
+	for i := 1; i <= n; i++ {
+		req := testRequest(fmt.Sprintf("req-%02d", i), now.Add(-time.Duration(i)*time.Hour))
+		if err := s.SaveRequest(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// retentionCounts 返回报告中与执行时间无关的字段
+func retentionCounts(r *types.RetentionReport) [6]int64 {
+	return [6]int64{r.TotalRequests, r.DeletedByAge, r.DeletedByRows, r.DeletedBySize, r.BodiesStripped, r.RemainingRequests}
+}
+
+// testRetention 检查演练不修改数据且与实际执行的结果一致，供各个存储后端的测试共用
+func testRetention(t *testing.T, open func(t *testing.T) types.Storage) {
+	t.Helper()
+
+	tests := []struct {
+		name   string
+		policy func(size int64) types.RetentionPolicy
+		check  func(t *testing.T, r *types.RetentionReport)
+	}{
+		{
+			name: "age rows and bodies",
+			policy: func(int64) types.RetentionPolicy {
+				return types.RetentionPolicy{MaxAge: 8*time.Hour + 30*time.Minute, MaxRows: 6, BodyMaxAge: 3*time.Hour + 30*time.Minute}
+			},
+			check: func(t *testing.T, r *types.RetentionReport) {
+				if want := [6]int64{10, 2, 2, 0, 3, 6}; retentionCounts(r) != want {
+					t.Fatalf("report %v, want %v", retentionCounts(r), want)
+				}
+			},
+		},
+		{
+			name: "size",
+			policy: func(size int64) types.RetentionPolicy {
+				return types.RetentionPolicy{MaxSizeBytes: size / 2}
+			},
+			check: func(t *testing.T, r *types.RetentionReport) {
+				if r.DeletedBySize < 5 || r.DeletedBySize > 6 || r.RemainingRequests != 10-r.DeletedBySize {
+					t.Fatalf("unexpected size based deletions: %+v", r)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := open(t)
+			seedRetention(t, s, 10)
+
+			measured, err := s.ApplyRetention(types.RetentionPolicy{}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if measured.SizeBytes <= 0 {
+				t.Fatalf("requests table size %d", measured.SizeBytes)
+			}
+			policy := tt.policy(measured.SizeBytes)
+
+			dry, err := s.ApplyRetention(policy, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !dry.DryRun {
+				t.Fatal("dry run report is not marked as a dry run")
+			}
+			tt.check(t, dry)
+
+			// 演练不修改数据
+			all, err := s.GetAllRequests()
+			if err != nil || len(all) != 10 {
+				t.Fatalf("dry run changed the requests: %d left, %v", len(all), err)
+			}
+			for _, req := range all {
+				if req.Prompt == "" {
+					t.Fatalf("dry run stripped the body of %s", req.ID)
+				}
+			}
+
+			real, err := s.ApplyRetention(policy, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if real.DryRun || retentionCounts(real) != retentionCounts(dry) {
+				t.Fatalf("real run %v differs from dry run %v", retentionCounts(real), retentionCounts(dry))
+			}
+
+			// 保留下来的是最新的记录，超过 body_max_age 的只清空了 prompt 和 response
+			all, err = s.GetAllRequests()
+			if err != nil || int64(len(all)) != real.RemainingRequests {
+				t.Fatalf("%d requests left, report says %d: %v", len(all), real.RemainingRequests, err)
+			}
+			stripped := int64(0)
+			for i, req := range all {
+				if want := fmt.Sprintf("req-%02d", i+1); req.ID != want {
+					t.Fatalf("request %d is %s, want %s", i, req.ID, want)
+				}
+				if req.Prompt == "" && req.Response == "" {
+					stripped++
+				}
+				if req.TokensOut != 20 {
+					t.Fatalf("request %s lost its statistics", req.ID)
+				}
+			}
+			if stripped != real.BodiesStripped {
+				t.Fatalf("%d bodies stripped, report says %d", stripped, real.BodiesStripped)
+			}
+
+			// 再次执行没有需要清理的记录
+			again, err := s.ApplyRetention(policy, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.Deleted() != 0 || again.BodiesStripped != 0 {
+				t.Fatalf("second run changed requests again: %+v", again)
+			}
+		})
+	}
+}
+
+func TestFileRetention(t *testing.T) {
+	testRetention(t, func(t *testing.T) types.Storage {
+		s, err := NewFileStorageImpl(t.TempDir(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestSQLiteRetention(t *testing.T) {
+	testRetention(t, func(t *testing.T) types.Storage {
+		s, _ := newTestSQLite(t)
+		return s
+	})
+}
+
+func TestPostgresRetention(t *testing.T) {
+	testRetention(t, func(t *testing.T) types.Storage {
+		s, _ := newTestPostgres(t)
+		return s
+	})
+}
+
+func TestSQLiteRetentionMeasuresRequestsTable(t *testing.T) {
+	s, _ := newTestSQLite(t)
+	seedRetention(t, s, 10)
+
+	// 其他表的数据不计入请求记录的占用空间
+	before, err := s.ApplyRetention(types.RetentionPolicy{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		err := s.SaveModelStatsHistory(&types.ModelStatsHistory{
+			ID:        fmt.Sprintf("history-%d", i),
+			Model:     fmt.Sprintf("model-with-a-long-name-%d", i),
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	after, err := s.ApplyRetention(types.RetentionPolicy{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.SizeBytes != before.SizeBytes {
+		t.Fatalf("requests table size changed from %d to %d when another table grew", before.SizeBytes, after.SizeBytes)
+	}
+}
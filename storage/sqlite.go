@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"sync"
+	"time"
 
 	"llm-fw/types"
 
@@ -16,6 +17,149 @@ type Request = types.Request
 // ModelStats represents statistics for a model
 type ModelStats = types.ModelStats
 
+// sqliteDialect holds the SQLite statements used by applyMigrations
+var sqliteDialect = migrationDialect{
+	name: "SQLite",
+	createTable: `
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME NOT NULL
+		)
+	`,
+	insertVersion: "INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+}
+
+// sqliteMigrations lists the SQLite schema migrations in the order they are applied.
+// Version 1 keeps IF NOT EXISTS so databases created before versioning are adopted as-is.
+var sqliteMigrations = []migration{
+	{
+		version: 1,
+		name:    "initial schema",
+		up: `
+			CREATE TABLE IF NOT EXISTS requests (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				model TEXT NOT NULL,
+				prompt TEXT NOT NULL,
+				response TEXT NOT NULL,
+				tokens_in INTEGER NOT NULL,
+				tokens_out INTEGER NOT NULL,
+				server TEXT NOT NULL,
+				latency_ms REAL NOT NULL,
+				status INTEGER NOT NULL,
+				error TEXT,
+				timestamp DATETIME NOT NULL,
+				source TEXT NOT NULL
+			);
+
+			CREATE TABLE IF NOT EXISTS model_stats (
+				model TEXT PRIMARY KEY,
+				total_requests INTEGER NOT NULL DEFAULT 0,
+				failed_requests INTEGER NOT NULL DEFAULT 0,
+				total_tokens_in INTEGER NOT NULL DEFAULT 0,
+				total_tokens_out INTEGER NOT NULL DEFAULT 0,
+				average_latency REAL NOT NULL DEFAULT 0,
+				last_used DATETIME NOT NULL
+			);
+
+			CREATE TABLE IF NOT EXISTS model_stats_history (
+				id TEXT PRIMARY KEY,
+				model TEXT NOT NULL,
+				total_requests INTEGER NOT NULL,
+				failed_requests INTEGER NOT NULL,
+				total_tokens_in INTEGER NOT NULL,
+				total_tokens_out INTEGER NOT NULL,
+				average_latency REAL NOT NULL,
+				timestamp DATETIME NOT NULL
+			);
+
+			CREATE TABLE IF NOT EXISTS api_keys (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				user_id TEXT NOT NULL,
+				team TEXT NOT NULL DEFAULT '',
+				prefix TEXT NOT NULL,
+				key_hash TEXT NOT NULL UNIQUE,
+				admin INTEGER NOT NULL DEFAULT 0,
+				created_at DATETIME NOT NULL,
+				last_used_at DATETIME,
+				revoked_at DATETIME
+			);
+
+			CREATE TABLE IF NOT EXISTS usage_counters (
+				subject TEXT NOT NULL,
+				period TEXT NOT NULL,
+				requests INTEGER NOT NULL DEFAULT 0,
+				tokens INTEGER NOT NULL DEFAULT 0,
+				updated_at DATETIME NOT NULL,
+				PRIMARY KEY (subject, period)
+			);
+		`,
+	},
+	{
+		version: 2,
+		name:    "request indexes",
+		up: `
+			CREATE INDEX IF NOT EXISTS idx_requests_timestamp ON requests (timestamp);
+			CREATE INDEX IF NOT EXISTS idx_requests_model ON requests (model);
+			CREATE INDEX IF NOT EXISTS idx_requests_user_id ON requests (user_id);
+		`,
+	},
+	{
+		version: 3,
+		name:    "model stats buckets",
+		up: `
+			CREATE TABLE model_stats_buckets (
+				model TEXT NOT NULL,
+				granularity TEXT NOT NULL,
+				bucket_start DATETIME NOT NULL,
+				requests INTEGER NOT NULL,
+				errors INTEGER NOT NULL,
+				tokens_in INTEGER NOT NULL,
+				tokens_out INTEGER NOT NULL,
+				avg_latency_ms REAL NOT NULL,
+				p50_latency_ms REAL NOT NULL,
+				p90_latency_ms REAL NOT NULL,
+				p99_latency_ms REAL NOT NULL,
+				error_rate REAL NOT NULL,
+				requests_per_second REAL NOT NULL,
+				tokens_per_second REAL NOT NULL,
+				PRIMARY KEY (model, granularity, bucket_start)
+			);
+		`,
+	},
+	{
+		version: 4,
+		name:    "request error type",
+		up: `
+			ALTER TABLE requests ADD COLUMN error_type TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version: 5,
+		name:    "request alias",
+		up: `
+			ALTER TABLE requests ADD COLUMN alias TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version: 6,
+		name:    "request cache source",
+		up: `
+			ALTER TABLE requests ADD COLUMN cache_source TEXT NOT NULL DEFAULT '';
+			ALTER TABLE requests ADD COLUMN cache_similarity REAL NOT NULL DEFAULT 0;
+		`,
+	},
+	{
+		version: 7,
+		name:    "api key priority",
+		up: `
+			ALTER TABLE api_keys ADD COLUMN priority TEXT NOT NULL DEFAULT '';
+		`,
+	},
+}
+
 // SQLiteStorage implements the types.Storage interface using SQLite
 type SQLiteStorage struct {
 	db *sql.DB
@@ -30,9 +174,9 @@ func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
 	}
 
 	s := &SQLiteStorage{db: db}
-	if err := s.initDB(); err != nil {
+	if err := s.migrate(); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("failed to initialize database: %v", err)
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
 	}
 
 	return s, nil
@@ -42,8 +186,8 @@ func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
 func (s *SQLiteStorage) SaveRequest(req *types.Request) error {
 	_, err := s.db.Exec(`
 		INSERT INTO requests (
-			id, user_id, model, prompt, response, tokens_in, tokens_out, server, latency_ms, status, error, timestamp, source
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			id, user_id, model, prompt, response, tokens_in, tokens_out, server, latency_ms, status, error, error_type, alias, cache_source, cache_similarity, timestamp, source
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		req.ID,
 		req.UserID,
@@ -56,6 +200,10 @@ func (s *SQLiteStorage) SaveRequest(req *types.Request) error {
 		req.LatencyMs,
 		req.Status,
 		req.Error,
+		req.ErrorType,
+		req.Alias,
+		req.CacheSource,
+		req.CacheSimilarity,
 		req.Timestamp,
 		req.Source,
 	)
@@ -66,7 +214,7 @@ func (s *SQLiteStorage) SaveRequest(req *types.Request) error {
 func (s *SQLiteStorage) GetRequest(id string) (*types.Request, error) {
 	var req types.Request
 	err := s.db.QueryRow(`
-		SELECT id, user_id, model, prompt, response, tokens_in, tokens_out, server, latency_ms, status, error, timestamp, source
+		SELECT id, user_id, model, prompt, response, tokens_in, tokens_out, server, latency_ms, status, error, error_type, alias, cache_source, cache_similarity, timestamp, source
 		FROM requests
 		WHERE id = ?
 	`, id).Scan(
@@ -81,6 +229,10 @@ func (s *SQLiteStorage) GetRequest(id string) (*types.Request, error) {
 		&req.LatencyMs,
 		&req.Status,
 		&req.Error,
+		&req.ErrorType,
+		&req.Alias,
+		&req.CacheSource,
+		&req.CacheSimilarity,
 		&req.Timestamp,
 		&req.Source,
 	)
@@ -329,7 +481,20 @@ func (s *SQLiteStorage) Cleanup() error {
 		DELETE FROM model_stats_history
 		WHERE timestamp < datetime('now', '-30 days')
 	`)
-	return err
+	if err != nil {
+		return err
+	}
+
+	for granularity, retention := range bucketRetention {
+		_, err := s.db.Exec(
+			"DELETE FROM model_stats_buckets WHERE granularity = ? AND bucket_start < ?",
+			granularity, time.Now().UTC().Add(-retention),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // NewHistoryManager creates a new history manager
@@ -342,53 +507,25 @@ func (s *SQLiteStorage) Close() error {
 	return s.db.Close()
 }
 
-// initDB initializes the database
-func (s *SQLiteStorage) initDB() error {
-	_, err := s.db.Exec(`
-		CREATE TABLE IF NOT EXISTS requests (
-			id TEXT PRIMARY KEY,
-			user_id TEXT NOT NULL,
-			model TEXT NOT NULL,
-			prompt TEXT NOT NULL,
-			response TEXT NOT NULL,
-			tokens_in INTEGER NOT NULL,
-			tokens_out INTEGER NOT NULL,
-			server TEXT NOT NULL,
-			latency_ms REAL NOT NULL,
-			status INTEGER NOT NULL,
-			error TEXT,
-			timestamp DATETIME NOT NULL,
-			source TEXT NOT NULL
-		);
-
-		CREATE TABLE IF NOT EXISTS model_stats (
-			model TEXT PRIMARY KEY,
-			total_requests INTEGER NOT NULL DEFAULT 0,
-			failed_requests INTEGER NOT NULL DEFAULT 0,
-			total_tokens_in INTEGER NOT NULL DEFAULT 0,
-			total_tokens_out INTEGER NOT NULL DEFAULT 0,
-			average_latency REAL NOT NULL DEFAULT 0,
-			last_used DATETIME NOT NULL
-		);
-
-		CREATE TABLE IF NOT EXISTS model_stats_history (
-			id TEXT PRIMARY KEY,
-			model TEXT NOT NULL,
-			total_requests INTEGER NOT NULL,
-			failed_requests INTEGER NOT NULL,
-			total_tokens_in INTEGER NOT NULL,
-			total_tokens_out INTEGER NOT NULL,
-			average_latency REAL NOT NULL,
-			timestamp DATETIME NOT NULL
-		);
-	`)
-	return err
+// migrate applies pending schema migrations
+func (s *SQLiteStorage) migrate() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := applyMigrations(tx, sqliteDialect, sqliteMigrations); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // GetAllRequests retrieves all requests
 func (s *SQLiteStorage) GetAllRequests() ([]*types.Request, error) {
 	rows, err := s.db.Query(`
-		SELECT id, user_id, model, prompt, response, tokens_in, tokens_out, server, latency_ms, status, error, timestamp, source
+		SELECT id, user_id, model, prompt, response, tokens_in, tokens_out, server, latency_ms, status, error, error_type, alias, cache_source, cache_similarity, timestamp, source
 		FROM requests
 		ORDER BY timestamp DESC
 	`)
@@ -412,6 +549,10 @@ func (s *SQLiteStorage) GetAllRequests() ([]*types.Request, error) {
 			&req.LatencyMs,
 			&req.Status,
 			&req.Error,
+			&req.ErrorType,
+			&req.Alias,
+			&req.CacheSource,
+			&req.CacheSimilarity,
 			&req.Timestamp,
 			&req.Source,
 		)
@@ -436,7 +577,7 @@ func (s *SQLiteStorage) GetRequestByID(requestID string) (*types.Request, error)
 // GetRequests retrieves all requests for a specific user
 func (s *SQLiteStorage) GetRequests(userID string) ([]*types.Request, error) {
 	rows, err := s.db.Query(`
-		SELECT id, user_id, model, prompt, response, tokens_in, tokens_out, server, latency_ms, status, error, timestamp, source
+		SELECT id, user_id, model, prompt, response, tokens_in, tokens_out, server, latency_ms, status, error, error_type, alias, cache_source, cache_similarity, timestamp, source
 		FROM requests
 		WHERE user_id = ?
 		ORDER BY timestamp DESC
@@ -461,6 +602,10 @@ func (s *SQLiteStorage) GetRequests(userID string) ([]*types.Request, error) {
 			&req.LatencyMs,
 			&req.Status,
 			&req.Error,
+			&req.ErrorType,
+			&req.Alias,
+			&req.CacheSource,
+			&req.CacheSimilarity,
 			&req.Timestamp,
 			&req.Source,
 		)
@@ -471,3 +616,271 @@ func (s *SQLiteStorage) GetRequests(userID string) ([]*types.Request, error) {
 	}
 	return requests, nil
 }
+
+// SaveAPIKey saves an API key, replacing an existing key with the same ID
+func (s *SQLiteStorage) SaveAPIKey(key *types.APIKey) error {
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO api_keys (
+			id, name, user_id, team, prefix, key_hash, admin, priority, created_at, last_used_at, revoked_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		key.ID,
+		key.Name,
+		key.UserID,
+		key.Team,
+		key.Prefix,
+		key.KeyHash,
+		key.Admin,
+		key.Priority,
+		key.CreatedAt,
+		key.LastUsedAt,
+		key.RevokedAt,
+	)
+	return err
+}
+
+// UpdateAPIKeyLastUsed updates only the last used time of an API key
+func (s *SQLiteStorage) UpdateAPIKeyLastUsed(id string, lastUsedAt time.Time) error {
+	_, err := s.db.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, lastUsedAt, id)
+	return err
+}
+
+// GetAPIKey retrieves an API key by ID
+func (s *SQLiteStorage) GetAPIKey(id string) (*types.APIKey, error) {
+	row := s.db.QueryRow(`
+		SELECT id, name, user_id, team, prefix, key_hash, admin, priority, created_at, last_used_at, revoked_at
+		FROM api_keys
+		WHERE id = ?
+	`, id)
+	key, err := scanAPIKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+// ListAPIKeys retrieves all API keys
+func (s *SQLiteStorage) ListAPIKeys() ([]*types.APIKey, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, user_id, team, prefix, key_hash, admin, priority, created_at, last_used_at, revoked_at
+		FROM api_keys
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*types.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// scanAPIKey scans an api_keys row
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*types.APIKey, error) {
+	var key types.APIKey
+	var lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(
+		&key.ID,
+		&key.Name,
+		&key.UserID,
+		&key.Team,
+		&key.Prefix,
+		&key.KeyHash,
+		&key.Admin,
+		&key.Priority,
+		&key.CreatedAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return &key, nil
+}
+
+// SaveUsageCounter saves a usage counter, replacing the existing value
+func (s *SQLiteStorage) SaveUsageCounter(counter *types.UsageCounter) error {
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO usage_counters (
+			subject, period, requests, tokens, updated_at
+		) VALUES (?, ?, ?, ?, ?)
+	`,
+		counter.Subject,
+		counter.Period,
+		counter.Requests,
+		counter.Tokens,
+		counter.UpdatedAt,
+	)
+	return err
+}
+
+// GetUsageCounter retrieves the usage counter of a subject in a period
+func (s *SQLiteStorage) GetUsageCounter(subject, period string) (*types.UsageCounter, error) {
+	var counter types.UsageCounter
+	err := s.db.QueryRow(`
+		SELECT subject, period, requests, tokens, updated_at
+		FROM usage_counters
+		WHERE subject = ? AND period = ?
+	`, subject, period).Scan(
+		&counter.Subject,
+		&counter.Period,
+		&counter.Requests,
+		&counter.Tokens,
+		&counter.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &counter, nil
+}
+
+// ListUsageCounters retrieves all usage counters of a period
+func (s *SQLiteStorage) ListUsageCounters(period string) ([]*types.UsageCounter, error) {
+	rows, err := s.db.Query(`
+		SELECT subject, period, requests, tokens, updated_at
+		FROM usage_counters
+		WHERE period = ?
+	`, period)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counters []*types.UsageCounter
+	for rows.Next() {
+		var counter types.UsageCounter
+		err := rows.Scan(
+			&counter.Subject,
+			&counter.Period,
+			&counter.Requests,
+			&counter.Tokens,
+			&counter.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		counters = append(counters, &counter)
+	}
+	return counters, rows.Err()
+}
+
+// sqliteRetention measures the bytes used on the pages of the requests table and its
+// indexes; space freed by deletions is reused by later inserts into any table
+var sqliteRetention = retentionDialect{
+	bind: bindQuestion,
+	sizeBytes: func(tx *sql.Tx) (int64, error) {
+		var size int64
+		err := tx.QueryRow(`
+			SELECT COALESCE(SUM(pgsize - unused), 0) FROM dbstat
+			WHERE name IN (SELECT name FROM sqlite_master WHERE tbl_name = 'requests')
+		`).Scan(&size)
+		return size, err
+	},
+}
+
+// ApplyRetention applies a retention policy to stored requests
+func (s *SQLiteStorage) ApplyRetention(policy types.RetentionPolicy, dryRun bool) (*types.RetentionReport, error) {
+	return applySQLRetention(s.db, sqliteRetention, policy, dryRun)
+}
+
+// ListRequestSamples retrieves the statistics fields of requests in [from, to)
+func (s *SQLiteStorage) ListRequestSamples(from, to time.Time) ([]*types.RequestSample, error) {
+	// Timestamps are stored as text in local time, compare in the same zone
+	rows, err := s.db.Query(`
+		SELECT model, timestamp, latency_ms, tokens_in, tokens_out, status
+		FROM requests
+		WHERE timestamp >= ? AND timestamp < ?
+	`, from.Local(), to.Local())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []*types.RequestSample
+	for rows.Next() {
+		var sample types.RequestSample
+		err := rows.Scan(
+			&sample.Model,
+			&sample.Timestamp,
+			&sample.LatencyMs,
+			&sample.TokensIn,
+			&sample.TokensOut,
+			&sample.Status,
+		)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, &sample)
+	}
+	return samples, rows.Err()
+}
+
+// SaveModelStatsBuckets saves statistics buckets, replacing existing ones
+func (s *SQLiteStorage) SaveModelStatsBuckets(buckets []*types.ModelStatsBucket) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT OR REPLACE INTO model_stats_buckets (` + bucketColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, bucket := range buckets {
+		if _, err := stmt.Exec(bucketArgs(bucket)...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListModelStatsBuckets retrieves the buckets of a model in [from, to) ordered by time
+func (s *SQLiteStorage) ListModelStatsBuckets(model, granularity string, from, to time.Time) ([]*types.ModelStatsBucket, error) {
+	rows, err := s.db.Query(`
+		SELECT `+bucketColumns+`
+		FROM model_stats_buckets
+		WHERE model = ? AND granularity = ? AND bucket_start >= ? AND bucket_start < ?
+		ORDER BY bucket_start ASC
+	`, model, granularity, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []*types.ModelStatsBucket
+	for rows.Next() {
+		bucket, err := scanModelStatsBucket(rows)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
+
+// CountErrorsByType counts failed requests by model and error type
+func (s *SQLiteStorage) CountErrorsByType() (map[string]map[string]int64, error) {
+	return countSQLErrors(s.db)
+}
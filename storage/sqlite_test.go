@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSQLite 在临时目录中打开 SQLite 存储，返回存储和数据库文件路径
+func newTestSQLite(t *testing.T) (*SQLiteStorage, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, path
+}
+
+// schemaVersions 返回 schema_version 中记录的所有版本
+func schemaVersions(t *testing.T, db *sql.DB) []int {
+	t.Helper()
+
+	rows, err := db.Query("SELECT version FROM schema_version ORDER BY version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+// migrateTo 把 path 处的数据库迁移到 migrations 中的最后一个版本
+func migrateTo(t *testing.T, path string, migrations []migration) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := applyMigrations(tx, sqliteDialect, migrations); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	latest := sqliteMigrations[len(sqliteMigrations)-1].version
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, path string) // 打开存储之前准备数据库
+	}{
+		{
+			name:  "fresh database",
+			setup: func(t *testing.T, path string) {},
+		},
+		{
+			name: "partially migrated",
+			setup: func(t *testing.T, path string) {
+				migrateTo(t, path, sqliteMigrations[:3])
+			},
+		},
+		{
+			name: "created before versioning",
+			setup: func(t *testing.T, path string) {
+				db, err := sql.Open("sqlite", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer db.Close()
+				// 引入版本号之前创建的数据库只有表，没有 schema_version
+				if _, err := db.Exec(sqliteMigrations[0].up); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			tt.setup(t, path)
+
+			s, err := NewSQLiteStorage(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			versions := schemaVersions(t, s.db)
+			if len(versions) != len(sqliteMigrations) || versions[len(versions)-1] != latest {
+				t.Fatalf("schema versions %v, want 1..%d", versions, latest)
+			}
+
+			// 迁移后的表可以使用所有列
+			testRequestRoundTrip(t, s)
+			if key, err := s.GetAPIKey("missing"); err != nil || key != nil {
+				t.Fatalf("GetAPIKey on migrated schema: %+v, %v", key, err)
+			}
+		})
+	}
+}
+
+func TestSQLiteMigrationsKeepExistingRows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	migrateTo(t, path, sqliteMigrations[:1])
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+		INSERT INTO requests (id, user_id, model, prompt, response, tokens_in, tokens_out, server, latency_ms, status, error, timestamp, source)
+		VALUES ('old', 'alice', 'llama3', 'hi', 'hello', 1, 2, 'default', 10, 0, '', ?, 'api')
+	`, time.Now())
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	req, err := s.GetRequestByID("old")
+	if err != nil || req == nil {
+		t.Fatalf("existing request lost by migrations: %v", err)
+	}
+	if req.Response != "hello" || req.ErrorType != "" || req.Alias != "" || req.CacheSource != "" {
+		t.Fatalf("unexpected migrated request: %+v", req)
+	}
+}
+
+func TestSQLiteMigrationsRefuseNewerSchema(t *testing.T) {
+	s, path := newTestSQLite(t)
+
+	latest := sqliteMigrations[len(sqliteMigrations)-1].version
+	if _, err := s.db.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'future', ?)", latest+1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	newer, err := NewSQLiteStorage(path)
+	if err == nil {
+		newer.Close()
+		t.Fatal("opened a database with a newer schema version")
+	}
+	if !strings.Contains(err.Error(), "newer than supported") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSQLiteFailedMigrationRollsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	migrateTo(t, path, sqliteMigrations[:2])
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	broken := append(append([]migration{}, sqliteMigrations[:3]...), migration{
+		version: 4,
+		name:    "broken",
+		up:      "ALTER TABLE missing ADD COLUMN x TEXT",
+	})
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = applyMigrations(tx, sqliteDialect, broken)
+	tx.Rollback()
+	if err == nil || !strings.Contains(err.Error(), "migration 4 (broken)") {
+		t.Fatalf("expected the broken migration to fail, got %v", err)
+	}
+
+	// 同一事务中已经执行的迁移 3 也被回滚
+	if versions := schemaVersions(t, db); len(versions) != 2 {
+		t.Fatalf("schema versions after a failed migration: %v", versions)
+	}
+	var tables int
+	db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'model_stats_buckets'").Scan(&tables)
+	if tables != 0 {
+		t.Fatal("table created by a rolled back migration exists")
+	}
+}
+
+func TestSQLiteModelStats(t *testing.T) {
+	s, _ := newTestSQLite(t)
+	testModelStatsRoundTrip(t, s)
+}
+
+func TestSQLiteModelStatsBuckets(t *testing.T) {
+	s, _ := newTestSQLite(t)
+	testModelStatsBucketsRoundTrip(t, s)
+}
+
+func TestSQLiteAPIKeyLastUsed(t *testing.T) {
+	s, _ := newTestSQLite(t)
+	testAPIKeyLastUsed(t, s)
+}
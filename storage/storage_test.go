@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"llm-fw/types"
+)
+
+// testRequestRoundTrip 检查请求记录的保存、查询、统计和删除，供各个存储后端的测试共用
+func testRequestRoundTrip(t *testing.T, s types.Storage) {
+	t.Helper()
+
+	base := time.Now().UTC().Truncate(time.Millisecond).Add(-time.Hour)
+	requests := []*types.Request{
+		{
+			ID: "req-1", UserID: "alice", Model: "llama3", Alias: "chat", Prompt: "hi", Response: "hello",
+			TokensIn: 3, TokensOut: 5, Server: "gpu-1", LatencyMs: 120.5, Timestamp: base, Source: "api",
+		},
+		{
+			ID: "req-2", UserID: "bob", Model: "llama3", Prompt: "hi", Response: "hello", TokensIn: 3,
+			TokensOut: 5, Server: "gpu-2", LatencyMs: 2, Timestamp: base.Add(time.Minute), Source: "api",
+			CacheSource: "req-1", CacheSimilarity: 0.97,
+		},
+		{
+			ID: "req-3", UserID: "alice", Model: "mistral", Prompt: "hi", Server: "gpu-1", LatencyMs: 30,
+			Status: 1, Error: "upstream returned 502", ErrorType: types.ErrorTypeUpstream5xx,
+			Timestamp: base.Add(2 * time.Minute), Source: "internal_ui",
+		},
+	}
+	for _, req := range requests {
+		if err := s.SaveRequest(req); err != nil {
+			t.Fatalf("SaveRequest(%s): %v", req.ID, err)
+		}
+	}
+
+	for _, want := range requests {
+		got, err := s.GetRequestByID(want.ID)
+		if err != nil || got == nil {
+			t.Fatalf("GetRequestByID(%s): %+v, %v", want.ID, got, err)
+		}
+		if !got.Timestamp.Equal(want.Timestamp) {
+			t.Fatalf("request %s timestamp %s, want %s", want.ID, got.Timestamp, want.Timestamp)
+		}
+		got.Timestamp = want.Timestamp
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("request %s read back as\n%+v\nwant\n%+v", want.ID, got, want)
+		}
+	}
+
+	byUser, err := s.GetRequests("alice")
+	if err != nil || len(byUser) != 2 || byUser[0].ID != "req-3" || byUser[1].ID != "req-1" {
+		t.Fatalf("GetRequests(alice): %d requests, %v", len(byUser), err)
+	}
+	recent, err := s.GetRecentRequests(2)
+	if err != nil || len(recent) != 2 || recent[0].ID != "req-3" || recent[1].ID != "req-2" {
+		t.Fatalf("GetRecentRequests(2): %d requests, %v", len(recent), err)
+	}
+
+	samples, err := s.ListRequestSamples(base.Add(time.Minute), base.Add(time.Hour))
+	if err != nil || len(samples) != 2 {
+		t.Fatalf("ListRequestSamples: %d samples, %v", len(samples), err)
+	}
+	errors, err := s.CountErrorsByType()
+	if err != nil || len(errors) != 1 || errors["mistral"][types.ErrorTypeUpstream5xx] != 1 {
+		t.Fatalf("CountErrorsByType: %v, %v", errors, err)
+	}
+
+	if err := s.DeleteRequest("req-2"); err != nil {
+		t.Fatal(err)
+	}
+	if req, err := s.GetRequestByID("req-2"); err != nil || req != nil {
+		t.Fatalf("deleted request read back as %+v, %v", req, err)
+	}
+	if all, err := s.GetAllRequests(); err != nil || len(all) != 2 {
+		t.Fatalf("GetAllRequests after delete: %d requests, %v", len(all), err)
+	}
+}
+
+// testModelStatsRoundTrip 检查模型统计和统计历史的保存与查询，供各个存储后端的测试共用
+func testModelStatsRoundTrip(t *testing.T, s types.Storage) {
+	t.Helper()
+
+	lastUsed := time.Now().UTC().Truncate(time.Millisecond)
+	stats := &types.ModelStats{
+		TotalRequests:  10,
+		TotalTokensIn:  100,
+		TotalTokensOut: 250,
+		AverageLatency: 42.5,
+		FailedRequests: 2,
+		LastUsed:       lastUsed,
+	}
+	if err := s.SaveModelStats("llama3", stats); err != nil {
+		t.Fatal(err)
+	}
+	// 再次保存覆盖原有的统计
+	stats.TotalRequests = 11
+	if err := s.SaveModelStats("llama3", stats); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetModelStats("llama3")
+	if err != nil || got == nil {
+		t.Fatalf("GetModelStats: %+v, %v", got, err)
+	}
+	if !got.LastUsed.Equal(lastUsed) {
+		t.Fatalf("last_used %s, want %s", got.LastUsed, lastUsed)
+	}
+	got.LastUsed = lastUsed
+	if !reflect.DeepEqual(got, stats) {
+		t.Fatalf("model stats read back as %+v, want %+v", got, stats)
+	}
+	all, err := s.GetAllModelStats()
+	if err != nil || len(all) != 1 || all["llama3"].TotalRequests != 11 {
+		t.Fatalf("GetAllModelStats: %v, %v", all, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		err := s.SaveModelStatsHistory(&types.ModelStatsHistory{
+			ID:            "history-" + string(rune('a'+i)),
+			Model:         "llama3",
+			TotalRequests: int64(i + 1),
+			Timestamp:     lastUsed.Add(time.Duration(i) * time.Minute),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	history, err := s.GetModelStatsHistory("llama3", 2)
+	if err != nil || len(history) != 2 || history[0].TotalRequests != 3 || history[1].TotalRequests != 2 {
+		t.Fatalf("GetModelStatsHistory: %d entries, %v", len(history), err)
+	}
+}
+
+// testModelStatsBucketsRoundTrip 检查统计时间桶按 (model, granularity, start) 覆盖保存并按时间范围读回，
+// 供各个存储后端的测试共用
+func testModelStatsBucketsRoundTrip(t *testing.T, s types.Storage) {
+	t.Helper()
+
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	bucket := func(model, granularity string, start time.Time, requests int64) *types.ModelStatsBucket {
+		return &types.ModelStatsBucket{
+			Model:             model,
+			Granularity:       granularity,
+			Start:             start,
+			Requests:          requests,
+			Errors:            1,
+			TokensIn:          requests * 10,
+			TokensOut:         requests * 30,
+			AvgLatencyMs:      120.5,
+			P50LatencyMs:      100,
+			P90LatencyMs:      250.25,
+			P99LatencyMs:      400,
+			ErrorRate:         1 / float64(requests),
+			RequestsPerSecond: float64(requests) / 60,
+			TokensPerSecond:   float64(requests*30) / 60,
+		}
+	}
+	saved := []*types.ModelStatsBucket{
+		bucket("llama3", types.BucketMinute, start, 4),
+		bucket("llama3", types.BucketMinute, start.Add(time.Minute), 5),
+		bucket("llama3", types.BucketMinute, start.Add(2*time.Minute), 6),
+		bucket("llama3", types.BucketHour, start, 15),
+		bucket("mistral", types.BucketMinute, start, 7),
+	}
+	if err := s.SaveModelStatsBuckets(saved); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(model, granularity string, from, to time.Time) []*types.ModelStatsBucket {
+		t.Helper()
+		buckets, err := s.ListModelStatsBuckets(model, granularity, from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 数据库驱动读回的时间可能带有本地时区，只比较时刻
+		for _, b := range buckets {
+			b.Start = b.Start.UTC()
+		}
+		return buckets
+	}
+
+	// 读回的时间桶与保存的完全一致，范围是左闭右开的
+	got := list("llama3", types.BucketMinute, start, start.Add(2*time.Minute))
+	if want := saved[:2]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("minute buckets read back as %+v, want %+v", got, want)
+	}
+	if got := list("llama3", types.BucketHour, start, start.Add(time.Hour)); !reflect.DeepEqual(got, saved[3:4]) {
+		t.Fatalf("hour buckets read back as %+v", got)
+	}
+	if got := list("mistral", types.BucketMinute, start, start.Add(time.Hour)); !reflect.DeepEqual(got, saved[4:]) {
+		t.Fatalf("buckets of another model read back as %+v", got)
+	}
+	// 查询的时间范围不限于 UTC
+	local := time.FixedZone("UTC+8", 8*3600)
+	if got := list("llama3", types.BucketMinute, start.In(local), start.Add(time.Hour).In(local)); len(got) != 3 {
+		t.Fatalf("%d minute buckets in a range given in another time zone", len(got))
+	}
+
+	// 重新聚合时覆盖同一时间桶，而不是新增一行
+	replaced := bucket("llama3", types.BucketMinute, start.Add(time.Minute), 8)
+	if err := s.SaveModelStatsBuckets([]*types.ModelStatsBucket{replaced}); err != nil {
+		t.Fatal(err)
+	}
+	got = list("llama3", types.BucketMinute, start, start.Add(time.Hour))
+	if want := []*types.ModelStatsBucket{saved[0], replaced, saved[2]}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("minute buckets after replacing one: %+v", got)
+	}
+}
+
+// testAPIKeyLastUsed 检查只更新最后使用时间时不覆盖密钥的其他字段，供各个存储后端的测试共用
+func testAPIKeyLastUsed(t *testing.T, s types.Storage) {
+	created := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	key := &types.APIKey{ID: "key-1", Name: "ci", UserID: "alice", Prefix: "sk-llmfw-abcdef", KeyHash: "old-hash", CreatedAt: created}
+	if err := s.SaveAPIKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	// 在写回最后使用时间之前密钥已被轮换和吊销
+	revoked := created.Add(time.Hour)
+	updated := *key
+	updated.KeyHash, updated.RevokedAt = "new-hash", &revoked
+	if err := s.SaveAPIKey(&updated); err != nil {
+		t.Fatal(err)
+	}
+	lastUsed := created.Add(30 * time.Minute)
+	if err := s.UpdateAPIKeyLastUsed(key.ID, lastUsed); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetAPIKey(key.ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetAPIKey: %+v, %v", got, err)
+	}
+	if got.KeyHash != "new-hash" || got.RevokedAt == nil || !got.RevokedAt.Equal(revoked) {
+		t.Fatalf("rotation or revocation overwritten: %+v", got)
+	}
+	if got.LastUsedAt == nil || !got.LastUsedAt.Equal(lastUsed) {
+		t.Fatalf("last used at %v, want %v", got.LastUsedAt, lastUsed)
+	}
+
+	// 不存在的密钥不会被创建
+	if err := s.UpdateAPIKeyLastUsed("missing", lastUsed); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := s.ListAPIKeys(); err != nil || len(keys) != 1 {
+		t.Fatalf("ListAPIKeys: %d keys, %v", len(keys), err)
+	}
+}
+
+func TestFileAPIKeyLastUsed(t *testing.T) {
+	s, err := NewFileStorageImpl(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	testAPIKeyLastUsed(t, s)
+}
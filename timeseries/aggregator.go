@@ -0,0 +1,239 @@
+package timeseries
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"llm-fw/types"
+)
+
+const (
+	// defaultInterval 是未配置时聚合任务的执行间隔
+	defaultInterval = time.Minute
+	// defaultSnapshotInterval 是未配置时写入模型统计历史快照的间隔
+	defaultSnapshotInterval = time.Hour
+)
+
+// Granularities 是支持的时间桶粒度，从细到粗
+var Granularities = []string{types.BucketMinute, types.BucketHour, types.BucketDay}
+
+// BucketDuration 返回时间桶粒度对应的时长，不支持的粒度返回 false
+func BucketDuration(granularity string) (time.Duration, bool) {
+	switch granularity {
+	case types.BucketMinute:
+		return time.Minute, true
+	case types.BucketHour:
+		return time.Hour, true
+	case types.BucketDay:
+		return 24 * time.Hour, true
+	}
+	return 0, false
+}
+
+// Aggregator 定期把请求记录聚合为按分钟、小时、天划分的模型统计时间桶，
+// 并定期写入模型统计历史快照
+type Aggregator struct {
+	storage          types.Storage
+	interval         time.Duration
+	snapshotInterval time.Duration
+
+	mu           sync.Mutex
+	lastRun      time.Time // 上次聚合的截止时间，之后的时间桶需要重新计算
+	lastSnapshot time.Time
+	done         chan struct{}
+	stop         sync.Once
+}
+
+// NewAggregator 创建一个新的聚合任务，需要调用 Start 才会定期执行
+func NewAggregator(storage types.Storage, interval, snapshotInterval time.Duration) *Aggregator {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	if snapshotInterval <= 0 {
+		snapshotInterval = defaultSnapshotInterval
+	}
+	return &Aggregator{
+		storage:          storage,
+		interval:         interval,
+		snapshotInterval: snapshotInterval,
+		done:             make(chan struct{}),
+	}
+}
+
+// Interval 返回聚合任务的执行间隔
+func (a *Aggregator) Interval() time.Duration {
+	return a.interval
+}
+
+// Start 启动后台聚合，启动时立即执行一次
+func (a *Aggregator) Start() {
+	go func() {
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := a.Run(time.Now()); err != nil {
+				log.Printf("Stats aggregation failed: %v", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-a.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止后台聚合
+func (a *Aggregator) Stop() {
+	a.stop.Do(func() { close(a.done) })
+}
+
+// Run 重新计算上次执行以来变化的时间桶，首次执行时从当天开始计算
+func (a *Aggregator) Run(now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	since := a.lastRun
+	if since.IsZero() {
+		since = now.Truncate(24 * time.Hour)
+	}
+
+	// 每种粒度从 since 所在的时间桶开始重算，一次读取覆盖最粗粒度的请求
+	from := since.Truncate(24 * time.Hour)
+	samples, err := a.storage.ListRequestSamples(from, now)
+	if err != nil {
+		return fmt.Errorf("failed to list request samples: %w", err)
+	}
+
+	var buckets []*types.ModelStatsBucket
+	for _, granularity := range Granularities {
+		size, _ := BucketDuration(granularity)
+		buckets = append(buckets, Aggregate(samples, granularity, since.Truncate(size), now)...)
+	}
+	if len(buckets) > 0 {
+		if err := a.storage.SaveModelStatsBuckets(buckets); err != nil {
+			return fmt.Errorf("failed to save model stats buckets: %w", err)
+		}
+	}
+	a.lastRun = now
+
+	if now.Sub(a.lastSnapshot) >= a.snapshotInterval {
+		if err := a.snapshot(now); err != nil {
+			return fmt.Errorf("failed to save model stats history: %w", err)
+		}
+	}
+	return nil
+}
+
+// snapshot 为上次快照以来有新请求的模型写入一条统计历史，调用方需持有锁
+func (a *Aggregator) snapshot(now time.Time) error {
+	modelStats, err := a.storage.GetAllModelStats()
+	if err != nil {
+		return err
+	}
+
+	for model, stats := range modelStats {
+		if !a.lastSnapshot.IsZero() && !stats.LastUsed.After(a.lastSnapshot) {
+			continue
+		}
+		history := &types.ModelStatsHistory{
+			ID:             fmt.Sprintf("%s-%d", model, now.UnixNano()),
+			Model:          model,
+			TotalRequests:  stats.TotalRequests,
+			FailedRequests: stats.FailedRequests,
+			TotalTokensIn:  stats.TotalTokensIn,
+			TotalTokensOut: stats.TotalTokensOut,
+			AverageLatency: stats.AverageLatency,
+			Timestamp:      now,
+		}
+		if err := a.storage.SaveModelStatsHistory(history); err != nil {
+			return err
+		}
+	}
+	a.lastSnapshot = now
+	return nil
+}
+
+// Aggregate 把 [from, now) 内的请求按模型和时间桶汇总，from 需要对齐到粒度。
+// 尚未结束的时间桶按已经过去的时长计算吞吐
+func Aggregate(samples []*types.RequestSample, granularity string, from, now time.Time) []*types.ModelStatsBucket {
+	size, ok := BucketDuration(granularity)
+	if !ok {
+		return nil
+	}
+
+	type bucketKey struct {
+		model string
+		start time.Time
+	}
+	latencies := make(map[bucketKey][]float64)
+	buckets := make(map[bucketKey]*types.ModelStatsBucket)
+	for _, sample := range samples {
+		if sample.Timestamp.Before(from) || !sample.Timestamp.Before(now) {
+			continue
+		}
+		key := bucketKey{model: sample.Model, start: sample.Timestamp.UTC().Truncate(size)}
+		bucket, exists := buckets[key]
+		if !exists {
+			bucket = &types.ModelStatsBucket{
+				Model:       sample.Model,
+				Granularity: granularity,
+				Start:       key.start,
+			}
+			buckets[key] = bucket
+		}
+
+		bucket.Requests++
+		if sample.Status != 0 {
+			bucket.Errors++
+		}
+		bucket.TokensIn += int64(sample.TokensIn)
+		bucket.TokensOut += int64(sample.TokensOut)
+		latencies[key] = append(latencies[key], sample.LatencyMs)
+	}
+
+	result := make([]*types.ModelStatsBucket, 0, len(buckets))
+	for key, bucket := range buckets {
+		values := latencies[key]
+		sort.Float64s(values)
+
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		bucket.AvgLatencyMs = sum / float64(len(values))
+		bucket.P50LatencyMs = percentile(values, 50)
+		bucket.P90LatencyMs = percentile(values, 90)
+		bucket.P99LatencyMs = percentile(values, 99)
+		bucket.ErrorRate = float64(bucket.Errors) / float64(bucket.Requests)
+
+		elapsed := size
+		if end := bucket.Start.Add(size); end.After(now) {
+			elapsed = now.Sub(bucket.Start)
+		}
+		if seconds := elapsed.Seconds(); seconds > 0 {
+			bucket.RequestsPerSecond = float64(bucket.Requests) / seconds
+			bucket.TokensPerSecond = float64(bucket.TokensOut) / seconds
+		}
+		result = append(result, bucket)
+	}
+	return result
+}
+
+// percentile 按最近秩法计算已排序数据的百分位数
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,144 @@
+package timeseries
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"llm-fw/storage"
+	"llm-fw/types"
+)
+
+func TestPercentile(t *testing.T) {
+	sequence := func(n int) []float64 {
+		values := make([]float64, n)
+		for i := range values {
+			values[i] = float64(i + 1)
+		}
+		return values
+	}
+
+	tests := []struct {
+		name          string
+		values        []float64
+		p50, p90, p99 float64
+	}{
+		{name: "empty"},
+		{name: "single", values: []float64{42}, p50: 42, p90: 42, p99: 42},
+		{name: "ten", values: sequence(10), p50: 5, p90: 9, p99: 10},
+		{name: "hundred", values: sequence(100), p50: 50, p90: 90, p99: 99},
+		{name: "outlier", values: []float64{10, 10, 10, 10, 1000}, p50: 10, p90: 1000, p99: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := [3]float64{percentile(tt.values, 50), percentile(tt.values, 90), percentile(tt.values, 99)}
+			if want := [3]float64{tt.p50, tt.p90, tt.p99}; got != want {
+				t.Fatalf("p50/p90/p99 = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	samples := []*types.RequestSample{
+		{Model: "llama3", Timestamp: start.Add(5 * time.Second), LatencyMs: 100, TokensIn: 10, TokensOut: 30},
+		{Model: "llama3", Timestamp: start.Add(20 * time.Second), LatencyMs: 300, TokensIn: 10, TokensOut: 30, Status: 1},
+		{Model: "llama3", Timestamp: start.Add(40 * time.Second), LatencyMs: 200, TokensIn: 10, TokensOut: 60},
+		{Model: "llama3", Timestamp: start.Add(70 * time.Second), LatencyMs: 50, TokensIn: 5, TokensOut: 15},
+		{Model: "mistral", Timestamp: start.Add(10 * time.Second), LatencyMs: 80, TokensIn: 1, TokensOut: 2},
+		// 超出 [from, now) 的请求不计入
+		{Model: "llama3", Timestamp: start.Add(-time.Second), LatencyMs: 999},
+		{Model: "llama3", Timestamp: start.Add(90 * time.Second), LatencyMs: 999},
+	}
+	now := start.Add(90 * time.Second)
+
+	buckets := make(map[string]*types.ModelStatsBucket)
+	for _, b := range Aggregate(samples, types.BucketMinute, start, now) {
+		buckets[fmt.Sprintf("%s@%s", b.Model, b.Start.Format("15:04"))] = b
+	}
+	if len(buckets) != 3 {
+		t.Fatalf("expected 3 buckets, got %v", buckets)
+	}
+
+	full := buckets["llama3@10:00"]
+	if full == nil || full.Requests != 3 || full.Errors != 1 || full.TokensIn != 30 || full.TokensOut != 120 {
+		t.Fatalf("unexpected full bucket: %+v", full)
+	}
+	if full.AvgLatencyMs != 200 || full.P50LatencyMs != 200 || full.P90LatencyMs != 300 || full.P99LatencyMs != 300 {
+		t.Fatalf("unexpected latencies: %+v", full)
+	}
+	if full.ErrorRate != 1.0/3 || full.RequestsPerSecond != 3.0/60 || full.TokensPerSecond != 2 {
+		t.Fatalf("unexpected rates: %+v", full)
+	}
+
+	// 尚未结束的时间桶按已经过去的 30 秒计算吞吐
+	partial := buckets["llama3@10:01"]
+	if partial == nil || partial.Requests != 1 || partial.RequestsPerSecond != 1.0/30 || partial.TokensPerSecond != 0.5 {
+		t.Fatalf("unexpected partial bucket: %+v", partial)
+	}
+	if other := buckets["mistral@10:00"]; other == nil || other.Requests != 1 || other.ErrorRate != 0 {
+		t.Fatalf("unexpected bucket of another model: %+v", other)
+	}
+
+	if Aggregate(samples, "week", start, now) != nil {
+		t.Fatal("unsupported granularity produced buckets")
+	}
+}
+
+func TestAggregatorReaggregatesDay(t *testing.T) {
+	store, err := storage.NewFileStorageImpl(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	day := time.Now().UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour)
+	save := func(id string, ts time.Time, latency float64) {
+		t.Helper()
+		err := store.SaveRequest(&types.Request{ID: id, Model: "llama3", Timestamp: ts, LatencyMs: latency, TokensOut: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	buckets := func(granularity string) []*types.ModelStatsBucket {
+		t.Helper()
+		list, err := store.ListModelStatsBuckets("llama3", granularity, day, day.Add(24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+
+	save("a", day.Add(9*time.Hour), 100)
+	save("b", day.Add(10*time.Hour+30*time.Second), 300)
+
+	a := NewAggregator(store, time.Minute, time.Hour)
+	first := day.Add(10*time.Hour + time.Minute)
+	if err := a.Run(first); err != nil {
+		t.Fatal(err)
+	}
+	if days := buckets(types.BucketDay); len(days) != 1 || days[0].Requests != 2 || days[0].P50LatencyMs != 100 {
+		t.Fatalf("day buckets after the first run: %+v", days)
+	}
+
+	// 第二次执行重新计算整天的时间桶，而不是只累加新的请求
+	save("c", day.Add(10*time.Hour+90*time.Second), 200)
+	if err := a.Run(first.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	days := buckets(types.BucketDay)
+	if len(days) != 1 || days[0].Requests != 3 || days[0].AvgLatencyMs != 200 || days[0].P50LatencyMs != 200 || days[0].P99LatencyMs != 300 {
+		t.Fatalf("day buckets after the second run: %+v", days)
+	}
+	hours := buckets(types.BucketHour)
+	if len(hours) != 2 || hours[0].Requests != 1 || hours[1].Requests != 2 {
+		t.Fatalf("hour buckets: %+v", hours)
+	}
+	minutes := buckets(types.BucketMinute)
+	if len(minutes) != 3 || minutes[2].Start != day.Add(10*time.Hour+time.Minute) || minutes[2].Requests != 1 {
+		t.Fatalf("minute buckets: %+v", minutes)
+	}
+}
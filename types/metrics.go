@@ -8,10 +8,27 @@ func (c *NoopMetricsCollector) RecordRequest(model string, server string, tokens
 	// 什么都不做
 }
 
+// ObserveRequest 实现了 MetricsCollector 接口
+func (c *NoopMetricsCollector) ObserveRequest(obs *RequestObservation) {
+	// 什么都不做
+}
+
+// ObserveFallback 实现了 MetricsCollector 接口
+func (c *NoopMetricsCollector) ObserveFallback(from, to, reason string) {
+	// 什么都不做
+}
+
+// ObserveCache 实现了 MetricsCollector 接口
+func (c *NoopMetricsCollector) ObserveCache(model, result, tier string) {
+	// 什么都不做
+}
+
 // GetMetrics 实现了 MetricsCollector 接口
 func (c *NoopMetricsCollector) GetMetrics() *Metrics {
 	return &Metrics{
 		ServerHealth: make(map[string]bool),
+		QueueDepth:   make(map[string]int),
+		ServerStats:  make(map[string]*ServerStats),
 		ModelStats:   make(map[string]*ModelStats),
 	}
 }
@@ -8,6 +8,9 @@ import (
 // ModelStats 存储模型的统计信息
 type ModelStats = common.ModelStats
 
+// ServerStats 存储上游服务器的统计信息
+type ServerStats = common.ServerStats
+
 // ModelInfo 表示模型的完整信息
 type ModelInfo struct {
 	Name        string             `json:"name"`
@@ -32,6 +35,12 @@ type Request = common.Request
 // ModelStatsHistory 表示模型统计历史记录
 type ModelStatsHistory = common.ModelStatsHistory
 
+// APIKey 表示一个 API 密钥
+type APIKey = common.APIKey
+
+// UsageCounter 记录某个身份在一个周期内的用量
+type UsageCounter = common.UsageCounter
+
 // Metrics 表示指标数据
 type Metrics struct {
 	TotalRequests  int64
@@ -40,14 +49,62 @@ type Metrics struct {
 	TotalLatencyMs int64
 	FailedRequests int64
 	ServerHealth   map[string]bool
+	QueueDepth     map[string]int // 启用调度器时每个模型排队的请求数
+	ServerStats    map[string]*ServerStats
 	ModelStats     map[string]*ModelStats
 }
 
+// RequestObservation 描述一次完成的上游调用
+type RequestObservation = common.RequestObservation
+
+// 请求结果，用作指标的 status 标签
+const (
+	RequestStatusSuccess = common.RequestStatusSuccess
+	RequestStatusError   = common.RequestStatusError
+)
+
 // MetricsCollector 定义了指标收集器的接口
 type MetricsCollector interface {
 	RecordRequest(model, server string, tokensIn, tokensOut int64, latency int64, isSuccess bool)
+	ObserveRequest(obs *RequestObservation)
+	ObserveFallback(from, to, reason string)
+	ObserveCache(model, result, tier string)
 	GetMetrics() *Metrics
 	UpdateServerHealth(server string, isHealthy bool)
 	CleanupSystemStats()
 	GetAllModelStats() map[string]*ModelStats
 }
+
+// RetentionPolicy 定义请求记录的保留策略
+type RetentionPolicy = common.RetentionPolicy
+
+// RetentionReport 描述一次保留策略执行的结果
+type RetentionReport = common.RetentionReport
+
+// 统计时间桶的粒度
+const (
+	BucketMinute = common.BucketMinute
+	BucketHour   = common.BucketHour
+	BucketDay    = common.BucketDay
+)
+
+// 失败请求的原因分类
+const (
+	ErrorTypeConnection    = common.ErrorTypeConnection
+	ErrorTypeUnavailable   = common.ErrorTypeUnavailable
+	ErrorTypeOverloaded    = common.ErrorTypeOverloaded
+	ErrorTypeModelNotFound = common.ErrorTypeModelNotFound
+	ErrorTypeUpstream4xx   = common.ErrorTypeUpstream4xx
+	ErrorTypeUpstream5xx   = common.ErrorTypeUpstream5xx
+	ErrorTypeDecode        = common.ErrorTypeDecode
+	ErrorTypeCancelled     = common.ErrorTypeCancelled
+	ErrorTypeInterrupted   = common.ErrorTypeInterrupted
+	ErrorTypeTimeout       = common.ErrorTypeTimeout
+	ErrorTypeInternal      = common.ErrorTypeInternal
+)
+
+// RequestSample 是计算时间桶统计所需的请求字段
+type RequestSample = common.RequestSample
+
+// ModelStatsBucket 是模型在一个时间桶内的统计信息
+type ModelStatsBucket = common.ModelStatsBucket
@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 // Storage 定义了存储接口
 type Storage interface {
 	// SaveRequest 保存请求记录
@@ -46,6 +48,45 @@ type Storage interface {
 
 	// ListRequests 获取请求列表
 	ListRequests(limit int) ([]*Request, error)
+
+	// SaveAPIKey 保存 API 密钥，已存在时更新
+	SaveAPIKey(key *APIKey) error
+
+	// UpdateAPIKeyLastUsed 只更新 API 密钥的最后使用时间，密钥不存在时不做任何事
+	UpdateAPIKeyLastUsed(id string, lastUsedAt time.Time) error
+
+	// GetAPIKey 根据ID获取 API 密钥
+	GetAPIKey(id string) (*APIKey, error)
+
+	// ListAPIKeys 获取所有 API 密钥
+	ListAPIKeys() ([]*APIKey, error)
+
+	// SaveUsageCounter 保存用量计数器，已存在时覆盖
+	SaveUsageCounter(counter *UsageCounter) error
+
+	// GetUsageCounter 获取身份在指定周期的用量计数器，不存在时返回 nil
+	GetUsageCounter(subject, period string) (*UsageCounter, error)
+
+	// ListUsageCounters 获取指定周期的所有用量计数器
+	ListUsageCounters(period string) ([]*UsageCounter, error)
+
+	// ApplyRetention 按保留策略清理请求记录，dryRun 为 true 时只统计将被清理的记录
+	ApplyRetention(policy RetentionPolicy, dryRun bool) (*RetentionReport, error)
+
+	// Cleanup 清理过期的模型统计历史和统计时间桶
+	Cleanup() error
+
+	// ListRequestSamples 获取 [from, to) 时间范围内请求的统计字段
+	ListRequestSamples(from, to time.Time) ([]*RequestSample, error)
+
+	// SaveModelStatsBuckets 保存统计时间桶，已存在时覆盖
+	SaveModelStatsBuckets(buckets []*ModelStatsBucket) error
+
+	// ListModelStatsBuckets 获取模型在 [from, to) 时间范围内指定粒度的统计时间桶，按时间升序
+	ListModelStatsBuckets(model, granularity string, from, to time.Time) ([]*ModelStatsBucket, error)
+
+	// CountErrorsByType 按模型和失败原因统计失败的请求数
+	CountErrorsByType() (map[string]map[string]int64, error)
 }
 
 // HistoryManager 定义了历史记录管理器的接口